@@ -87,6 +87,8 @@ func (m *Node) GetTags() []string {
 type Way struct {
 	Tags             []string `protobuf:"bytes,1,rep,name=tags" json:"tags,omitempty"`
 	Refs             []int64  `protobuf:"varint,2,rep,packed,name=refs" json:"refs,omitempty"`
+	Longs            []int64  `protobuf:"zigzag64,3,rep,packed,name=longs" json:"longs,omitempty"`
+	Lats             []int64  `protobuf:"zigzag64,4,rep,packed,name=lats" json:"lats,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -108,6 +110,20 @@ func (m *Way) GetRefs() []int64 {
 	return nil
 }
 
+func (m *Way) GetLongs() []int64 {
+	if m != nil {
+		return m.Longs
+	}
+	return nil
+}
+
+func (m *Way) GetLats() []int64 {
+	if m != nil {
+		return m.Lats
+	}
+	return nil
+}
+
 type Relation struct {
 	Tags             []string              `protobuf:"bytes,1,rep,name=tags" json:"tags,omitempty"`
 	MemberIds        []int64               `protobuf:"varint,2,rep,name=member_ids" json:"member_ids,omitempty"`
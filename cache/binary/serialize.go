@@ -69,6 +69,16 @@ func MarshalWay(way *element.Way) ([]byte, error) {
 	deltaPack(way.Refs)
 	pbfWay.Refs = way.Refs
 	pbfWay.Tags = tagsAsArray(way.Tags)
+	if len(way.Nodes) > 0 && len(way.Nodes) == len(way.Refs) {
+		pbfWay.Longs = make([]int64, len(way.Nodes))
+		pbfWay.Lats = make([]int64, len(way.Nodes))
+		for i, nd := range way.Nodes {
+			pbfWay.Longs[i] = int64(CoordToInt(nd.Long))
+			pbfWay.Lats[i] = int64(CoordToInt(nd.Lat))
+		}
+		deltaPack(pbfWay.Longs)
+		deltaPack(pbfWay.Lats)
+	}
 	return proto.Marshal(pbfWay)
 }
 
@@ -83,6 +93,16 @@ func UnmarshalWay(data []byte) (way *element.Way, err error) {
 	deltaUnpack(pbfWay.Refs)
 	way.Refs = pbfWay.Refs
 	way.Tags = tagsFromArray(pbfWay.Tags)
+	if len(pbfWay.Longs) > 0 && len(pbfWay.Longs) == len(way.Refs) && len(pbfWay.Lats) == len(way.Refs) {
+		deltaUnpack(pbfWay.Longs)
+		deltaUnpack(pbfWay.Lats)
+		way.Nodes = make([]element.Node, len(way.Refs))
+		for i := range way.Nodes {
+			way.Nodes[i].Id = way.Refs[i]
+			way.Nodes[i].Long = IntToCoord(uint32(pbfWay.Longs[i]))
+			way.Nodes[i].Lat = IntToCoord(uint32(pbfWay.Lats[i]))
+		}
+	}
 	return way, nil
 }
 
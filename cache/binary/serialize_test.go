@@ -2,6 +2,7 @@ package binary
 
 import (
 	"github.com/olehz/imposm3/element"
+	"math"
 	"testing"
 )
 
@@ -68,6 +69,35 @@ func TestMarshalWay(t *testing.T) {
 
 }
 
+func TestMarshalWayWithNodes(t *testing.T) {
+	way := &element.Way{}
+	way.Id = 12345
+	way.Refs = []int64{1, 2, 3}
+	way.Nodes = []element.Node{
+		{OSMElem: element.OSMElem{Id: 1}, Long: 10.0, Lat: 53.0},
+		{OSMElem: element.OSMElem{Id: 2}, Long: 10.5, Lat: 53.25},
+		{OSMElem: element.OSMElem{Id: 3}, Long: -10.0, Lat: -53.0},
+	}
+	expected := append([]element.Node(nil), way.Nodes...)
+
+	data, _ := MarshalWay(way)
+	way, _ = UnmarshalWay(data)
+
+	if !compareRefs(way.Refs, []int64{1, 2, 3}) {
+		t.Error("refs do not match")
+	}
+	if len(way.Nodes) != len(expected) {
+		t.Fatalf("unexpected nodes %v", way.Nodes)
+	}
+	for i, nd := range way.Nodes {
+		if nd.Id != expected[i].Id ||
+			math.Abs(nd.Long-expected[i].Long) > 1e-6 ||
+			math.Abs(nd.Lat-expected[i].Lat) > 1e-6 {
+			t.Errorf("unexpected node %v != %v", nd, expected[i])
+		}
+	}
+}
+
 func TestMarshalRelation(t *testing.T) {
 	rel := &element.Relation{}
 	rel.Id = 12345
@@ -1,9 +1,10 @@
 package cache
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 )
 
@@ -13,14 +14,25 @@ type cacheOptions struct {
 	BlockRestartInterval int
 	WriteBufferSizeM     int
 	BlockSizeK           int
+	// Sync writes each change to disk before it returns.
+	Sync bool
 }
 
 type coordsCacheOptions struct {
 	cacheOptions
 	BunchSize          int
 	BunchCacheCapacity int
+	// Flat enables the memory mapped flat file for all node ids
+	// below FlatMaxId (8 bytes for each id). The flat file is only
+	// created for new caches.
+	Flat      bool
+	FlatMaxId int64
 }
 type osmCacheOptions struct {
+	// Preset is the name of the preset the options are based on.
+	Preset string
+	// Backend is the name of the Store for all caches, see Backends.
+	Backend      string
 	Coords       coordsCacheOptions
 	Ways         cacheOptions
 	Nodes        cacheOptions
@@ -28,6 +40,8 @@ type osmCacheOptions struct {
 	InsertedWays cacheOptions
 	CoordsIndex  cacheOptions
 	WaysIndex    cacheOptions
+	Versions     cacheOptions
+	Journal      cacheOptions
 }
 
 const defaultConfig = `
@@ -39,7 +53,9 @@ const defaultConfig = `
         "MaxOpenFiles": 64,
         "BlockRestartInterval": 256,
         "BunchSize": 32,
-        "BunchCacheCapacity": 8096
+        "BunchCacheCapacity": 8096,
+        "Flat": false,
+        "FlatMaxId": 16000000000
     },
     "Nodes": {
         "CacheSizeM": 16,
@@ -82,12 +98,31 @@ const defaultConfig = `
         "BlockSizeK": 0,
         "MaxOpenFiles": 64,
         "BlockRestartInterval": 128
+    },
+    "Versions": {
+        "CacheSizeM": 16,
+        "WriteBufferSizeM": 64,
+        "BlockSizeK": 0,
+        "MaxOpenFiles": 64,
+        "BlockRestartInterval": 128
+    },
+    "Journal": {
+        "CacheSizeM": 8,
+        "WriteBufferSizeM": 32,
+        "BlockSizeK": 0,
+        "MaxOpenFiles": 64,
+        "BlockRestartInterval": 128,
+        "Sync": true
     }
 }
 `
 
 var globalCacheOptions osmCacheOptions
 
+// envConfigErr is the error from reading the IMPOSM_CACHE_CONFIG file.
+// It is returned by Configure.
+var envConfigErr error
+
 func init() {
 	err := json.Unmarshal([]byte(defaultConfig), &globalCacheOptions)
 	if err != nil {
@@ -98,11 +133,106 @@ func init() {
 	if cacheConfFile != "" {
 		data, err := ioutil.ReadFile(cacheConfFile)
 		if err != nil {
-			log.Println("Unable to read cache config:", err)
+			envConfigErr = fmt.Errorf("unable to read cache config: %s", err)
+			return
 		}
-		err = json.Unmarshal(data, &globalCacheOptions)
-		if err != nil {
-			log.Println("Unable to parse cache config:", err)
+		opts := globalCacheOptions
+		if err := decodeOptions(data, &opts); err != nil {
+			envConfigErr = fmt.Errorf("unable to parse cache config %s: %s", cacheConfFile, err)
+			return
+		}
+		globalCacheOptions = opts
+	}
+}
+
+// Configure sets the options for all caches that are opened afterwards.
+// The options from IMPOSM_CACHE_CONFIG are updated with the preset and
+// then with the options from data, the JSON of the cache section of the
+// config file. data can also contain the preset, but preset takes
+// precedence if not empty. data can be nil.
+func Configure(preset string, data []byte) error {
+	if envConfigErr != nil {
+		return envConfigErr
+	}
+	opts := globalCacheOptions
+	if len(data) > 0 {
+		// decode the preset first, the options from data are applied after the preset
+		var conf struct{ Preset string }
+		if err := json.Unmarshal(data, &conf); err != nil {
+			return fmt.Errorf("unable to parse cache config: %s", err)
+		}
+		if preset == "" {
+			preset = conf.Preset
+		}
+	}
+	if preset != "" {
+		applyPreset, ok := presets[preset]
+		if !ok {
+			return fmt.Errorf("unknown cache preset '%s', available: %v", preset, Presets())
+		}
+		applyPreset(&opts, availableMemory())
+	}
+	if len(data) > 0 {
+		if err := decodeOptions(data, &opts); err != nil {
+			return fmt.Errorf("unable to parse cache config: %s", err)
+		}
+	}
+	opts.Preset = preset
+	if err := opts.check(); err != nil {
+		return fmt.Errorf("invalid cache config: %s", err)
+	}
+	globalCacheOptions = opts
+	return nil
+}
+
+// decodeOptions updates opts with all options from data. Unknown
+// options are an error.
+func decodeOptions(data []byte, opts *osmCacheOptions) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(opts)
+}
+
+func (o *cacheOptions) check(name string) error {
+	if o.CacheSizeM < 0 || o.MaxOpenFiles < 0 || o.BlockRestartInterval < 0 ||
+		o.WriteBufferSizeM < 0 || o.BlockSizeK < 0 {
+		return fmt.Errorf("negative option for %s", name)
+	}
+	return nil
+}
+
+func (o *osmCacheOptions) check() error {
+	if o.Backend != "" {
+		if _, ok := stores[o.Backend]; !ok {
+			return fmt.Errorf("unknown backend '%s', available: %v", o.Backend, Backends())
 		}
 	}
+	for _, opts := range []struct {
+		name string
+		opts *cacheOptions
+	}{
+		{"Coords", &o.Coords.cacheOptions},
+		{"Ways", &o.Ways},
+		{"Nodes", &o.Nodes},
+		{"Relations", &o.Relations},
+		{"InsertedWays", &o.InsertedWays},
+		{"CoordsIndex", &o.CoordsIndex},
+		{"WaysIndex", &o.WaysIndex},
+		{"Versions", &o.Versions},
+		{"Journal", &o.Journal},
+	} {
+		if err := opts.opts.check(opts.name); err != nil {
+			return err
+		}
+	}
+	if o.Coords.BunchSize <= 0 {
+		return fmt.Errorf("Coords.BunchSize needs to be larger than 0")
+	}
+	if o.Coords.BunchCacheCapacity <= 0 {
+		return fmt.Errorf("Coords.BunchCacheCapacity needs to be larger than 0")
+	}
+	if o.Coords.Flat && o.Coords.FlatMaxId <= 0 {
+		return fmt.Errorf("Coords.FlatMaxId needs to be larger than 0")
+	}
+	return nil
 }
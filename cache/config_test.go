@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/olehz/imposm3/element"
+)
+
+func TestConfigure(t *testing.T) {
+	defaults := globalCacheOptions
+	defer func() { globalCacheOptions = defaults }()
+
+	const gb = 1024 * 1024 * 1024
+	for _, test := range []struct {
+		preset string
+		data   string
+		valid  bool
+	}{
+		{"", "", true},
+		{"small", "", true},
+		{"planet", `{"Coords": {"CacheSizeM": 42}}`, true},
+		{"", `{"preset": "planet", "ways": {"cachesizem": 42}}`, true},
+		{"unknown", "", false},
+		{"", `{"preset": "unknown"}`, false},
+		{"", `{"Coords": {"Unknown": 1}}`, false},
+		{"", `{"Coords": {"BunchSize": 0}}`, false},
+		{"", `{"Nodes": {"CacheSizeM": -1}}`, false},
+		{"", `{"Backend": "unknown"}`, false},
+		{"", `{"Coords": `, false},
+	} {
+		globalCacheOptions = defaults
+		var data []byte
+		if test.data != "" {
+			data = []byte(test.data)
+		}
+		err := Configure(test.preset, data)
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %q %q: %s", test.preset, test.data, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected error for %q %q", test.preset, test.data)
+		}
+		if !test.valid && globalCacheOptions != defaults {
+			t.Errorf("options changed for invalid config %q %q", test.preset, test.data)
+		}
+	}
+
+	globalCacheOptions = defaults
+	if err := Configure("planet", []byte(`{"Coords": {"CacheSizeM": 42}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if opts := globalCacheOptions; opts.Preset != "planet" || !opts.Coords.Flat ||
+		opts.Coords.CacheSizeM != 42 || opts.Coords.BunchSize != defaults.Coords.BunchSize {
+		t.Errorf("unexpected options %#v", opts)
+	}
+
+	small, planet := defaults, defaults
+	presetSmall(&small, 2*gb)
+	presetPlanet(&planet, 64*gb)
+	if small.Coords.BunchCacheCapacity >= planet.Coords.BunchCacheCapacity ||
+		small.Ways.CacheSizeM >= planet.Ways.CacheSizeM ||
+		small.Coords.WriteBufferSizeM >= planet.Coords.WriteBufferSizeM {
+		t.Errorf("unexpected presets %#v %#v", small, planet)
+	}
+}
+
+func TestPlanetPresetExistingCache(t *testing.T) {
+	testBackends(t, testPlanetPresetExistingCache)
+}
+
+func testPlanetPresetExistingCache(t *testing.T) {
+	defaults := globalCacheOptions
+	defer func() { globalCacheOptions = defaults }()
+
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	osmCache := NewOSMCache(cache_dir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := osmCache.Coords.PutCoords([]element.Node{{OSMElem: element.OSMElem{Id: 1}, Long: 8, Lat: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	osmCache.Close()
+
+	if err := Configure("planet", nil); err != nil {
+		t.Fatal(err)
+	}
+	osmCache = NewOSMCache(cache_dir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer osmCache.Close()
+	if osmCache.Coords.flat != nil {
+		t.Error("flat coords enabled for existing cache")
+	}
+	if _, err := osmCache.Coords.GetCoord(1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	meminfo := "MemTotal:       16318424 kB\nMemFree:         1234567 kB\nMemAvailable:   12734680 kB\n"
+	if m := parseMeminfo(strings.NewReader(meminfo)); m != 12734680*1024 {
+		t.Errorf("unexpected MemAvailable %d", m)
+	}
+	if m := parseMeminfo(strings.NewReader("MemTotal:       16318424 kB\n")); m != 16318424*1024 {
+		t.Errorf("unexpected MemTotal %d", m)
+	}
+	if m := parseMeminfo(strings.NewReader("")); m != -1 {
+		t.Errorf("unexpected memory %d", m)
+	}
+
+	for _, test := range []struct {
+		data     string
+		expected int64
+	}{
+		{"2147483648\n", 2147483648},
+		{"max\n", -1},
+		{"9223372036854771712\n", -1},
+		{"", -1},
+	} {
+		if limit := parseCgroupLimit(test.data); limit != test.expected {
+			t.Errorf("%q: %d != %d", test.data, limit, test.expected)
+		}
+	}
+}
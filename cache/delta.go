@@ -4,8 +4,14 @@ import (
 	"container/list"
 	"github.com/olehz/imposm3/cache/binary"
 	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/stats"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 type byId []element.Node
@@ -131,11 +137,21 @@ type DeltaCoordsCache struct {
 	mu           sync.Mutex
 	bunchSize    int64
 	readOnly     bool
+	// flat stores all coords with ids up to the size of the flat
+	// file, if enabled. All other coords are stored in bunches.
+	flat *flatCoords
+	// journal records the changes of the flat coords, if set. Changes
+	// of the bunches are recorded by the journaled Store.
+	journal *Journal
 }
 
-func newDeltaCoordsCache(path string) (*DeltaCoordsCache, error) {
+// newDeltaCoordsCache opens the coords cache. A flat coords file is
+// only created with a new store (if enabled and createFlat is set), as
+// the ids of the flat file would hide the coords of an existing store.
+func newDeltaCoordsCache(path string, createFlat bool) (*DeltaCoordsCache, error) {
 	coordsCache := DeltaCoordsCache{}
 	coordsCache.options = &globalCacheOptions.Coords.cacheOptions
+	created := emptyDir(path)
 	err := coordsCache.open(path)
 	if err != nil {
 		return nil, err
@@ -145,9 +161,32 @@ func newDeltaCoordsCache(path string) (*DeltaCoordsCache, error) {
 	// mem req for cache approx. capacity*bunchSize*40
 	coordsCache.capacity = int64(globalCacheOptions.Coords.BunchCacheCapacity)
 	coordsCache.table = make(map[int64]*coordsBunch, coordsCache.capacity)
+
+	// existing flat files are used regardless of the current options
+	flatPath := filepath.Join(path, flatCoordsFile)
+	if flatCoordsExists(flatPath) {
+		coordsCache.flat, err = openFlatCoords(flatPath, false, 0)
+	} else if created && createFlat && globalCacheOptions.Coords.Flat {
+		coordsCache.flat, err = openFlatCoords(flatPath, true, globalCacheOptions.Coords.FlatMaxId)
+	}
+	if err != nil {
+		coordsCache.cache.Close()
+		return nil, err
+	}
 	return &coordsCache, nil
 }
 
+// emptyDir returns true if path does not exist or is an empty directory.
+func emptyDir(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	return err == io.EOF
+}
+
 func (self *DeltaCoordsCache) SetLinearImport(v bool) {
 	self.linearImport = v
 }
@@ -166,8 +205,26 @@ func (self *DeltaCoordsCache) Flush() {
 		delete(self.table, k)
 	}
 }
+
+// resetBunches removes all bunches from the LRU cache without writing
+// them.
+func (self *DeltaCoordsCache) resetBunches() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.lruList.Init()
+	for k, _ := range self.table {
+		delete(self.table, k)
+	}
+}
+
 func (self *DeltaCoordsCache) Close() {
 	self.Flush()
+	if self.flat != nil {
+		if err := self.flat.close(); err != nil {
+			log.Println("closing flat coords:", err)
+		}
+		self.flat = nil
+	}
 	self.cache.Close()
 }
 
@@ -176,6 +233,13 @@ func (self *DeltaCoordsCache) SetReadOnly(val bool) {
 }
 
 func (self *DeltaCoordsCache) GetCoord(id int64) (*element.Node, error) {
+	if self.flat != nil && self.flat.contains(id) {
+		nd := &element.Node{}
+		if !self.flat.get(id, nd) {
+			return nil, NotFound
+		}
+		return nd, nil
+	}
 	bunchId := self.getBunchId(id)
 	bunch, err := self.getBunch(bunchId)
 	if err != nil {
@@ -190,6 +254,15 @@ func (self *DeltaCoordsCache) GetCoord(id int64) (*element.Node, error) {
 }
 
 func (self *DeltaCoordsCache) DeleteCoord(id int64) error {
+	if self.flat != nil && self.flat.contains(id) {
+		if self.journal != nil {
+			if err := self.journal.recordFlat(self.flat, id); err != nil {
+				return err
+			}
+		}
+		self.flat.delete(id)
+		return nil
+	}
 	bunchId := self.getBunchId(id)
 	bunch, err := self.getBunch(bunchId)
 	if err != nil {
@@ -201,10 +274,16 @@ func (self *DeltaCoordsCache) DeleteCoord(id int64) error {
 	return nil
 }
 
+// FillWay sets the nodes of the way from the cached coords. Ways that
+// already contain the nodes of all refs (e.g. from the node locations
+// of a LocationsOnWays PBF) are not changed.
 func (self *DeltaCoordsCache) FillWay(way *element.Way) error {
 	if way == nil {
 		return nil
 	}
+	if len(way.Refs) > 0 && len(way.Nodes) == len(way.Refs) {
+		return nil
+	}
 	way.Nodes = make([]element.Node, len(way.Refs))
 
 	var err error
@@ -213,6 +292,15 @@ func (self *DeltaCoordsCache) FillWay(way *element.Way) error {
 	lastBunchId = -1
 
 	for i, id := range way.Refs {
+		if self.flat != nil && self.flat.contains(id) {
+			if !self.flat.get(id, &way.Nodes[i]) {
+				if bunch != nil {
+					bunch.Unlock()
+				}
+				return NotFound
+			}
+			continue
+		}
 		bunchId = self.getBunchId(id)
 		// re-use bunches
 		if bunchId != lastBunchId {
@@ -257,6 +345,13 @@ func removeSkippedNodes(nodes []element.Node) []element.Node {
 func (self *DeltaCoordsCache) PutCoords(nodes []element.Node) error {
 	var start, currentBunchId int64
 	nodes = removeSkippedNodes(nodes)
+	if self.flat != nil {
+		var err error
+		nodes, err = self.putFlatCoords(nodes)
+		if err != nil {
+			return err
+		}
+	}
 	if len(nodes) == 0 {
 		// skipped all nodes
 		return nil
@@ -310,6 +405,34 @@ func (self *DeltaCoordsCache) PutCoords(nodes []element.Node) error {
 	return nil
 }
 
+// putFlatCoords puts all nodes into the flat file that are within its
+// size and returns the remaining nodes.
+func (self *DeltaCoordsCache) putFlatCoords(nodes []element.Node) ([]element.Node, error) {
+	if self.journal != nil {
+		var ids []int64
+		for i := range nodes {
+			if self.flat.contains(nodes[i].Id) {
+				ids = append(ids, nodes[i].Id)
+			}
+		}
+		if err := self.journal.recordFlat(self.flat, ids...); err != nil {
+			return nil, err
+		}
+	}
+	insertPoint := 0
+	for i := 0; i < len(nodes); i++ {
+		if self.flat.contains(nodes[i].Id) {
+			self.flat.put(&nodes[i])
+			continue
+		}
+		if i != insertPoint {
+			nodes[insertPoint] = nodes[i]
+		}
+		insertPoint += 1
+	}
+	return nodes[:insertPoint], nil
+}
+
 var (
 	freeBuffer = make(chan []byte, 4)
 )
@@ -318,7 +441,7 @@ func (p *DeltaCoordsCache) putCoordsPacked(bunchId int64, nodes []element.Node)
 	keyBuf := idToKeyBuf(bunchId)
 
 	if len(nodes) == 0 {
-		return p.db.Delete(p.wo, keyBuf)
+		return p.db.Delete(keyBuf)
 	}
 
 	var data []byte
@@ -329,7 +452,7 @@ func (p *DeltaCoordsCache) putCoordsPacked(bunchId int64, nodes []element.Node)
 
 	data = binary.MarshalDeltaNodes(nodes, data)
 
-	err := p.db.Put(p.wo, keyBuf, data)
+	err := p.db.Put(keyBuf, data)
 	if err != nil {
 		return err
 	}
@@ -345,7 +468,7 @@ func (p *DeltaCoordsCache) putCoordsPacked(bunchId int64, nodes []element.Node)
 func (p *DeltaCoordsCache) getCoordsPacked(bunchId int64, nodes []element.Node) ([]element.Node, error) {
 	keyBuf := idToKeyBuf(bunchId)
 
-	data, err := p.db.Get(p.ro, keyBuf)
+	data, err := p.db.Get(keyBuf)
 	if err != nil {
 		return nil, err
 	}
@@ -369,6 +492,30 @@ var (
 	freeNodes = make(chan []element.Node, 4)
 )
 
+// bunchHits and bunchMisses count the lookups of coords bunches that
+// were already loaded and that were read from the cache of all
+// DeltaCoordsCaches.
+var bunchHits, bunchMisses int64
+
+var (
+	bunchLookupsMetric = stats.NewCounterMetric("imposm3_cache_coords_bunch_lookups_total",
+		"Number of coords bunch lookups by result (hit or miss).", "result")
+	bunchHitRatioMetric = stats.NewGaugeMetric("imposm3_cache_coords_bunch_hit_ratio",
+		"Ratio of coords bunch lookups that were already loaded.")
+)
+
+func init() {
+	stats.AddMetricsCollector(func() {
+		hits := atomic.LoadInt64(&bunchHits)
+		misses := atomic.LoadInt64(&bunchMisses)
+		bunchLookupsMetric.Set(float64(hits), "hit")
+		bunchLookupsMetric.Set(float64(misses), "miss")
+		if hits+misses > 0 {
+			bunchHitRatioMetric.Set(float64(hits) / float64(hits+misses))
+		}
+	})
+}
+
 func (self *DeltaCoordsCache) getBunch(bunchId int64) (*coordsBunch, error) {
 	self.mu.Lock()
 	bunch, ok := self.table[bunchId]
@@ -385,8 +532,10 @@ func (self *DeltaCoordsCache) getBunch(bunchId int64) (*coordsBunch, error) {
 		bunch = &coordsBunch{id: bunchId, coords: nodes, elem: elem}
 		needsGet = true
 		self.table[bunchId] = bunch
+		atomic.AddInt64(&bunchMisses, 1)
 	} else {
 		self.lruList.MoveToFront(bunch.elem)
+		atomic.AddInt64(&bunchHits, 1)
 	}
 	bunch.Lock()
 	self.CheckCapacity()
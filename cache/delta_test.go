@@ -3,8 +3,10 @@ package cache
 import (
 	"github.com/olehz/imposm3/element"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sort"
 	"testing"
 )
@@ -62,18 +64,189 @@ func TestRemoveSkippedNodes(t *testing.T) {
 }
 
 func TestReadWriteDeltaCoords(t *testing.T) {
-	checkReadWriteDeltaCoords(t, false)
+	testBackends(t, testReadWriteDeltaCoords)
+}
+
+func testReadWriteDeltaCoords(t *testing.T) {
+	checkReadWriteDeltaCoords(t, false, insertAndCheck)
 }
 
 func TestReadWriteDeltaCoordsLinearImport(t *testing.T) {
-	checkReadWriteDeltaCoords(t, true)
+	testBackends(t, testReadWriteDeltaCoordsLinearImport)
+}
+
+func testReadWriteDeltaCoordsLinearImport(t *testing.T) {
+	checkReadWriteDeltaCoords(t, true, insertAndCheck)
+}
+
+func TestReadWriteDeltaCoordsFlat(t *testing.T) {
+	testBackends(t, testReadWriteDeltaCoordsFlat)
+}
+
+func testReadWriteDeltaCoordsFlat(t *testing.T) {
+	// ids from 500 are stored in bunches
+	withFlatCoords(500, func() {
+		checkReadWriteDeltaCoords(t, false, insertAndCheckFlat)
+		checkReadWriteDeltaCoords(t, true, insertAndCheckFlat)
+	})
+}
+
+func withFlatCoords(maxId int64, f func()) {
+	opts := globalCacheOptions.Coords
+	defer func() { globalCacheOptions.Coords = opts }()
+	globalCacheOptions.Coords.Flat = true
+	globalCacheOptions.Coords.FlatMaxId = maxId
+	f()
+}
+
+func TestFlatCoordsReopen(t *testing.T) {
+	testBackends(t, testFlatCoordsReopen)
+}
+
+func testFlatCoordsReopen(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	withFlatCoords(100, func() {
+		cache, err := newDeltaCoordsCache(cache_dir, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes := []element.Node{mknode(-5), mknode(1), mknode(2), mknode(99), mknode(100), mknode(1000)}
+		if err := cache.PutCoords(nodes); err != nil {
+			t.Fatal(err)
+		}
+		cache.Close()
+	})
+
+	// flat file is used for existing caches, with the initial size
+	cache, err := newDeltaCoordsCache(cache_dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if cache.flat == nil || cache.flat.size != 100 {
+		t.Fatal("flat coords not opened", cache.flat)
+	}
+	if n := cache.flat.count(); n != 3 {
+		t.Error("unexpected flat count", n)
+	}
+
+	way := &element.Way{Refs: []int64{1, -5, 1000, 99, 100, 2}}
+	if err := cache.FillWay(way); err != nil {
+		t.Fatal(err)
+	}
+	for i, nd := range way.Nodes {
+		if nd.Id != way.Refs[i] || math.Abs(nd.Long-8) > 1e-6 || math.Abs(nd.Lat-10) > 1e-6 {
+			t.Errorf("unexpected node %v for ref %d", nd, way.Refs[i])
+		}
+	}
+
+	deleteAndCheck(t, cache, 99)
+	way = &element.Way{Refs: []int64{1, 99}}
+	if err := cache.FillWay(way); err != NotFound {
+		t.Error("expected NotFound for deleted node", err)
+	}
+	if n := cache.flat.count(); n != 2 {
+		t.Error("unexpected flat count after delete", n)
+	}
+}
+
+func TestFlatCoordsExistingCache(t *testing.T) {
+	testBackends(t, testFlatCoordsExistingCache)
+}
+
+func testFlatCoordsExistingCache(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	cache, err := newDeltaCoordsCache(cache_dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.PutCoords([]element.Node{mknode(1), mknode(2)}); err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+
+	withFlatCoords(100, func() {
+		// no flat file for existing caches or without createFlat
+		for _, createFlat := range []bool{true, false} {
+			cache, err := newDeltaCoordsCache(cache_dir, createFlat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cache.flat != nil {
+				t.Error("flat coords created for existing cache")
+			}
+			way := &element.Way{Refs: []int64{1, 2}}
+			if err := cache.FillWay(way); err != nil {
+				t.Error(err)
+			}
+			cache.Close()
+		}
+
+		empty_dir, _ := ioutil.TempDir("", "imposm3_test")
+		defer os.RemoveAll(empty_dir)
+		cache, err := newDeltaCoordsCache(empty_dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cache.flat != nil {
+			t.Error("flat coords created without createFlat")
+		}
+		cache.Close()
+	})
+	if flatCoordsExists(filepath.Join(cache_dir, flatCoordsFile)) {
+		t.Error("flat coords file created for existing cache")
+	}
+}
+
+func TestFlatCoordsPrecision(t *testing.T) {
+	testBackends(t, testFlatCoordsPrecision)
 }
 
-func checkReadWriteDeltaCoords(t *testing.T, withLinearImport bool) {
+func testFlatCoordsPrecision(t *testing.T) {
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
 
-	cache, err := newDeltaCoordsCache(cache_dir)
+	withFlatCoords(100, func() {
+		cache, err := newDeltaCoordsCache(cache_dir, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cache.Close()
+
+		// coords are stored with a precision of 1e-7
+		for _, nd := range []element.Node{
+			{OSMElem: element.OSMElem{Id: 1}, Long: 8.12345678, Lat: 53.87654321},
+			{OSMElem: element.OSMElem{Id: 2}, Long: -179.9999999, Lat: -89.9999999},
+			{OSMElem: element.OSMElem{Id: 1}, Long: 179.9999999, Lat: 89.9999999},
+		} {
+			if err := cache.PutCoords([]element.Node{nd}); err != nil {
+				t.Fatal(err)
+			}
+			result, err := cache.GetCoord(nd.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(result.Long-nd.Long) > 1e-6 || math.Abs(result.Lat-nd.Lat) > 1e-6 {
+				t.Errorf("invalid coords %f, %f != %v", nd.Long, nd.Lat, result)
+			}
+		}
+		if n := cache.flat.count(); n != 2 {
+			t.Error("unexpected flat count", n)
+		}
+	})
+}
+
+type insertChecker func(t *testing.T, cache *DeltaCoordsCache, id int64, lon, lat float64)
+
+func checkReadWriteDeltaCoords(t *testing.T, withLinearImport bool, insertAndCheck insertChecker) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	cache, err := newDeltaCoordsCache(cache_dir, true)
 	if err != nil {
 		t.Fatal()
 	}
@@ -143,6 +316,27 @@ func insertAndCheck(t *testing.T, cache *DeltaCoordsCache, id int64, lon, lat fl
 	}
 }
 
+// insertAndCheckFlat is insertAndCheck for nodes in the flat coords,
+// which are stored with a precision of 1e-7.
+func insertAndCheckFlat(t *testing.T, cache *DeltaCoordsCache, id int64, lon, lat float64) {
+	newNode := mknode(id)
+	newNode.Long = lon
+	newNode.Lat = lat
+
+	err := cache.PutCoords([]element.Node{newNode})
+	if err != nil {
+		t.Errorf("error during PutCoords for %v: %s", newNode, err)
+	}
+
+	result, err := cache.GetCoord(id)
+	if err != nil {
+		t.Errorf("got error after getting inserted node %d: %s", id, err)
+	}
+	if result == nil || math.Abs(result.Long-lon) > 1e-6 || math.Abs(result.Lat-lat) > 1e-6 {
+		t.Errorf("invalid coords %f, %f != %v", lon, lat, result)
+	}
+}
+
 func deleteAndCheck(t *testing.T, cache *DeltaCoordsCache, id int64) {
 	err := cache.DeleteCoord(id)
 	if err != nil {
@@ -156,10 +350,14 @@ func deleteAndCheck(t *testing.T, cache *DeltaCoordsCache, id int64) {
 }
 
 func TestSingleUpdate(t *testing.T) {
+	testBackends(t, testSingleUpdate)
+}
+
+func testSingleUpdate(t *testing.T) {
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
 
-	cache, err := newDeltaCoordsCache(cache_dir)
+	cache, err := newDeltaCoordsCache(cache_dir, true)
 	if err != nil {
 		t.Fatal()
 	}
@@ -1,7 +1,6 @@
 package cache
 
 import (
-	levigo "github.com/olehz/hyperleveldb-go"
 	"log"
 	"os"
 	"path/filepath"
@@ -245,7 +244,7 @@ func (index *bunchRefCache) Get(id int64) []int64 {
 	}
 	keyBuf := idToKeyBuf(index.getBunchId(id))
 
-	data, err := index.db.Get(index.ro, keyBuf)
+	data, err := index.db.Get(keyBuf)
 	if err != nil {
 		panic(err)
 	}
@@ -265,7 +264,7 @@ func (index *bunchRefCache) Get(id int64) []int64 {
 func (index *bunchRefCache) Add(id, ref int64) error {
 	keyBuf := idToKeyBuf(index.getBunchId(id))
 
-	data, err := index.db.Get(index.ro, keyBuf)
+	data, err := index.db.Get(keyBuf)
 	if err != nil {
 		return err
 	}
@@ -285,7 +284,7 @@ func (index *bunchRefCache) Add(id, ref int64) error {
 	defer bytePool.release(data)
 	data = binary.MarshalIdRefsBunch2(idRefBunch.idRefs, data)
 
-	return index.db.Put(index.wo, keyBuf, data)
+	return index.db.Put(keyBuf, data)
 }
 
 func (index *bunchRefCache) DeleteRef(id, ref int64) error {
@@ -295,7 +294,7 @@ func (index *bunchRefCache) DeleteRef(id, ref int64) error {
 
 	keyBuf := idToKeyBuf(index.getBunchId(id))
 
-	data, err := index.db.Get(index.ro, keyBuf)
+	data, err := index.db.Get(keyBuf)
 	if err != nil {
 		return err
 	}
@@ -311,7 +310,7 @@ func (index *bunchRefCache) DeleteRef(id, ref int64) error {
 			data := bytePool.get()
 			defer bytePool.release(data)
 			data = binary.MarshalIdRefsBunch2(idRefs, data)
-			return index.db.Put(index.wo, keyBuf, data)
+			return index.db.Put(keyBuf, data)
 		}
 	}
 	return nil
@@ -324,7 +323,7 @@ func (index *bunchRefCache) Delete(id int64) error {
 
 	keyBuf := idToKeyBuf(index.getBunchId(id))
 
-	data, err := index.db.Get(index.ro, keyBuf)
+	data, err := index.db.Get(keyBuf)
 	if err != nil {
 		return err
 	}
@@ -340,12 +339,29 @@ func (index *bunchRefCache) Delete(id int64) error {
 			data := bytePool.get()
 			defer bytePool.release(data)
 			data = binary.MarshalIdRefsBunch2(idRefs, data)
-			return index.db.Put(index.wo, keyBuf, data)
+			return index.db.Put(keyBuf, data)
 		}
 	}
 	return nil
 }
 
+// Iter returns all ids with their refs.
+func (index *bunchRefCache) Iter() chan element.IdRefs {
+	refs := make(chan element.IdRefs, 1024)
+	go func() {
+		it := index.db.Iterate()
+		// close the iter before closing the chan, see WaysCache.Iter
+		defer close(refs)
+		defer it.Close()
+		for it.Next() {
+			for _, idRefs := range binary.UnmarshalIdRefsBunch2(it.Value(), nil) {
+				refs <- idRefs
+			}
+		}
+	}()
+	return refs
+}
+
 func (index *CoordsRefIndex) AddFromWay(way *element.Way) {
 	for _, node := range way.Nodes {
 		if index.linearImport {
@@ -441,7 +457,7 @@ type writeBunchItem struct {
 }
 
 func (index *bunchRefCache) writeRefs(idRefs idRefBunches) error {
-	batch := levigo.NewWriteBatch()
+	batch := index.db.NewBatch()
 	defer batch.Close()
 
 	wg := sync.WaitGroup{}
@@ -484,7 +500,7 @@ func (index *bunchRefCache) writeRefs(idRefs idRefBunches) error {
 		case idRefBunchesPool <- idRefs:
 		}
 	}()
-	return index.db.Write(index.wo, batch)
+	return index.db.Write(batch)
 }
 
 func mergeBunch(bunch, newBunch []element.IdRefs) []element.IdRefs {
@@ -531,7 +547,7 @@ NextIdRef:
 // loadMergeMarshal loads an existing bunch, merges the IdRefs and
 // marshals the result again.
 func (index *bunchRefCache) loadMergeMarshal(keyBuf []byte, newBunch []element.IdRefs) []byte {
-	data, err := index.db.Get(index.ro, keyBuf)
+	data, err := index.db.Get(keyBuf)
 	if err != nil {
 		panic(err)
 	}
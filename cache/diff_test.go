@@ -9,6 +9,10 @@ import (
 )
 
 func TestDiffCache(t *testing.T) {
+	testBackends(t, testDiffCache)
+}
+
+func testDiffCache(t *testing.T) {
 
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
@@ -49,6 +53,10 @@ func TestDiffCache(t *testing.T) {
 }
 
 func TestWriteDiff(t *testing.T) {
+	testBackends(t, testWriteDiff)
+}
+
+func testWriteDiff(t *testing.T) {
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
 
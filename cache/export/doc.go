@@ -0,0 +1,5 @@
+/*
+Package export provides the export-pbf sub command to write the cached
+OSM data as PBF file.
+*/
+package export
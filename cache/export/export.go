@@ -0,0 +1,315 @@
+package export
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/config"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/geom/geos"
+	"github.com/olehz/imposm3/geom/limit"
+	"github.com/olehz/imposm3/parser/pbf"
+	"github.com/olehz/imposm3/proj"
+)
+
+var flags = flag.NewFlagSet("export-pbf", flag.ExitOnError)
+
+var (
+	opts = config.AddToolFlags(flags)
+
+	limitTo = flags.String("limitto", "", "limit to geometries")
+	output  = flags.String("o", "", "output PBF file")
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s %s -o out.pbf [-limitto area.geojson]:\n\n", os.Args[0], os.Args[1])
+	flags.PrintDefaults()
+	os.Exit(1)
+}
+
+func Export(args []string) {
+	flags.Usage = Usage
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		Usage()
+	}
+	if err := opts.Configure(); err != nil {
+		log.Fatal(err)
+	}
+
+	var limiter *limit.Limiter
+	if *limitTo != "" {
+		limiter, err = limit.NewFromSources(*limitTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	osmCache := cache.NewOSMCache(opts.CacheDir)
+	if !osmCache.Exists() {
+		log.Fatalf("no cache found in %s", opts.CacheDir)
+	}
+	err = osmCache.OpenExisting()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer osmCache.Close()
+
+	var inside func(nd *element.Node) bool
+	var bbox []float64
+	if limiter != nil {
+		g := geos.NewGeos()
+		defer g.Finish()
+		inside = func(nd *element.Node) bool {
+			x, y := proj.WgsToMerc(nd.Long, nd.Lat)
+			return limiter.IntersectsPoint(g, x, y)
+		}
+		bounds := limiter.Bounds()
+		bbox = make([]float64, 4)
+		bbox[0], bbox[1] = proj.MercToWgs(bounds.MinX, bounds.MinY)
+		bbox[2], bbox[3] = proj.MercToWgs(bounds.MaxX, bounds.MaxY)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	buf := bufio.NewWriter(f)
+
+	ex := newExporter(osmCache, inside)
+	if err := ex.collect(); err != nil {
+		log.Fatal(err)
+	}
+	w := pbf.NewWriter(buf, bbox)
+	counts, err := ex.write(w)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(*output)
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d nodes, %d ways and %d relations to %s",
+		counts.nodes, counts.ways, counts.relations, *output)
+}
+
+type idSet map[int64]struct{}
+
+func (s idSet) add(id int64) {
+	s[id] = struct{}{}
+}
+
+func (s idSet) contains(id int64) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s idSet) sorted() []int64 {
+	ids := make([]int64, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+type exportCounts struct {
+	nodes, ways, relations int
+}
+
+// exporter collects the ids of all elements inside the limitto
+// geometry and all elements they reference.
+type exporter struct {
+	osmCache *cache.OSMCache
+	// inside returns whether a node is inside the exported area,
+	// all nodes are inside if nil
+	inside    func(nd *element.Node) bool
+	nodes     idSet
+	ways      idSet
+	relations idSet
+	// locations of nodes that are only stored with the ways
+	locations map[int64]element.Node
+}
+
+func newExporter(osmCache *cache.OSMCache, inside func(nd *element.Node) bool) *exporter {
+	return &exporter{
+		osmCache:  osmCache,
+		inside:    inside,
+		nodes:     make(idSet),
+		ways:      make(idSet),
+		relations: make(idSet),
+		locations: make(map[int64]element.Node),
+	}
+}
+
+func (ex *exporter) isInside(nd *element.Node) bool {
+	return ex.inside == nil || ex.inside(nd)
+}
+
+// collect selects all tagged nodes and all ways with at least one node
+// inside the area, and all relations with a selected member. Nodes of
+// selected ways and member ways of selected multipolygons are added for
+// complete geometries.
+func (ex *exporter) collect() error {
+	for nd := range ex.osmCache.Nodes.Iter() {
+		if ex.isInside(nd) {
+			ex.nodes.add(nd.Id)
+		}
+	}
+
+	for way := range ex.osmCache.Ways.Iter() {
+		stored := len(way.Refs) > 0 && len(way.Nodes) == len(way.Refs)
+		if err := ex.osmCache.Coords.FillWay(way); err == cache.NotFound {
+			// incomplete way, e.g. outside of the -limitto of the import
+			continue
+		} else if err != nil {
+			return err
+		}
+		for i := range way.Nodes {
+			if ex.isInside(&way.Nodes[i]) {
+				ex.addWay(way, stored)
+				break
+			}
+		}
+	}
+
+	// relations with relation members are selected in a second step
+	parents := make(map[int64][]int64)
+	multipolygons := make(map[int64]bool)
+	for rel := range ex.osmCache.Relations.Iter() {
+		selected := false
+		for _, m := range rel.Members {
+			switch m.Type {
+			case element.NODE:
+				selected = selected || ex.nodes.contains(m.Id)
+			case element.WAY:
+				selected = selected || ex.ways.contains(m.Id)
+			case element.RELATION:
+				parents[m.Id] = append(parents[m.Id], rel.Id)
+			}
+		}
+		if selected {
+			ex.relations.add(rel.Id)
+		}
+		if rel.Tags["type"] == "multipolygon" {
+			multipolygons[rel.Id] = true
+		}
+	}
+	queue := ex.relations.sorted()
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, parent := range parents[id] {
+			if !ex.relations.contains(parent) {
+				ex.relations.add(parent)
+				queue = append(queue, parent)
+			}
+		}
+	}
+
+	for _, id := range ex.relations.sorted() {
+		if !multipolygons[id] {
+			continue
+		}
+		rel, err := ex.osmCache.Relations.GetRelation(id)
+		if err != nil {
+			return err
+		}
+		for _, m := range rel.Members {
+			if m.Type != element.WAY || ex.ways.contains(m.Id) {
+				continue
+			}
+			way, err := ex.osmCache.Ways.GetWay(m.Id)
+			if err == cache.NotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			stored := len(way.Refs) > 0 && len(way.Nodes) == len(way.Refs)
+			ex.addWay(way, stored)
+		}
+	}
+	return nil
+}
+
+func (ex *exporter) addWay(way *element.Way, stored bool) {
+	ex.ways.add(way.Id)
+	for i, ref := range way.Refs {
+		ex.nodes.add(ref)
+		if stored {
+			ex.locations[ref] = way.Nodes[i]
+		}
+	}
+}
+
+// write writes all collected elements sorted by id. Missing elements
+// are skipped.
+func (ex *exporter) write(w *pbf.Writer) (exportCounts, error) {
+	var counts exportCounts
+	for _, id := range ex.nodes.sorted() {
+		// tagged nodes contain the coords as well
+		nd, err := ex.osmCache.Nodes.GetNode(id)
+		if err == cache.NotFound {
+			nd, err = ex.osmCache.Coords.GetCoord(id)
+		}
+		if err == cache.NotFound {
+			loc, ok := ex.locations[id]
+			if !ok {
+				continue
+			}
+			nd, err = &loc, nil
+		}
+		if err != nil {
+			return counts, err
+		}
+		nd.Id = id
+		if err := w.WriteNode(nd); err != nil {
+			return counts, err
+		}
+		counts.nodes += 1
+	}
+
+	for _, id := range ex.ways.sorted() {
+		way, err := ex.osmCache.Ways.GetWay(id)
+		if err == cache.NotFound {
+			continue
+		} else if err != nil {
+			return counts, err
+		}
+		way.Id = id
+		if err := w.WriteWay(way); err != nil {
+			return counts, err
+		}
+		counts.ways += 1
+	}
+
+	for _, id := range ex.relations.sorted() {
+		rel, err := ex.osmCache.Relations.GetRelation(id)
+		if err == cache.NotFound {
+			continue
+		} else if err != nil {
+			return counts, err
+		}
+		rel.Id = id
+		if err := w.WriteRelation(rel); err != nil {
+			return counts, err
+		}
+		counts.relations += 1
+	}
+	return counts, nil
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/parser/pbf"
+)
+
+func mknode(id int64, long, lat float64) element.Node {
+	return element.Node{OSMElem: element.OSMElem{Id: id}, Long: long, Lat: lat}
+}
+
+func checkIds(t *testing.T, name string, ids idSet, expected ...int64) {
+	sorted := ids.sorted()
+	if len(sorted) != len(expected) {
+		t.Errorf("unexpected %s %v, expected %v", name, sorted, expected)
+		return
+	}
+	for i := range sorted {
+		if sorted[i] != expected[i] {
+			t.Errorf("unexpected %s %v, expected %v", name, sorted, expected)
+			return
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cacheDir)
+
+	osmCache := cache.NewOSMCache(cacheDir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer osmCache.Close()
+
+	// nodes 1-3 are inside, 4-6 outside
+	coords := []element.Node{
+		mknode(1, 1, 1), mknode(2, 2, 2), mknode(3, 3, 3),
+		mknode(4, 11, 11), mknode(5, 12, 12), mknode(6, 13, 13),
+	}
+	osmCache.Coords.PutCoords(coords)
+	tagged := mknode(3, 3, 3)
+	tagged.Tags = element.Tags{"amenity": "cafe"}
+	outside := mknode(6, 13, 13)
+	outside.Tags = element.Tags{"amenity": "bar"}
+	osmCache.Nodes.PutNodes([]element.Node{tagged, outside})
+
+	osmCache.Ways.PutWays([]element.Way{
+		// crosses the area
+		{OSMElem: element.OSMElem{Id: 10}, Refs: []int64{1, 4}},
+		// outside, but member of a multipolygon
+		{OSMElem: element.OSMElem{Id: 11}, Refs: []int64{5, 6}},
+		// outside
+		{OSMElem: element.OSMElem{Id: 12}, Refs: []int64{5, 4}},
+	})
+	osmCache.Relations.PutRelations([]element.Relation{
+		{OSMElem: element.OSMElem{Id: 20, Tags: element.Tags{"type": "multipolygon"}},
+			Members: []element.Member{{Id: 10, Type: element.WAY}, {Id: 11, Type: element.WAY}}},
+		{OSMElem: element.OSMElem{Id: 21, Tags: element.Tags{"type": "route"}},
+			Members: []element.Member{{Id: 12, Type: element.WAY}, {Id: 3, Type: element.NODE}}},
+		{OSMElem: element.OSMElem{Id: 22, Tags: element.Tags{"type": "site"}},
+			Members: []element.Member{{Id: 21, Type: element.RELATION}}},
+		{OSMElem: element.OSMElem{Id: 23, Tags: element.Tags{"type": "route"}},
+			Members: []element.Member{{Id: 12, Type: element.WAY}}},
+	})
+
+	ex := newExporter(osmCache, func(nd *element.Node) bool {
+		return nd.Long < 10 && nd.Lat < 10
+	})
+	if err := ex.collect(); err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, "nodes", ex.nodes, 1, 3, 4, 5, 6)
+	checkIds(t, "ways", ex.ways, 10, 11)
+	checkIds(t, "relations", ex.relations, 20, 21, 22)
+
+	buf := &bytes.Buffer{}
+	w := pbf.NewWriter(buf, nil)
+	counts, err := ex.write(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if counts != (exportCounts{nodes: 5, ways: 2, relations: 3}) {
+		t.Errorf("unexpected counts %v", counts)
+	}
+	if buf.Len() == 0 {
+		t.Error("empty output")
+	}
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	bin "encoding/binary"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/olehz/imposm3/cache/binary"
+	"github.com/olehz/imposm3/element"
+)
+
+// flatCoordsFile is the name of the flat coords file inside of the
+// coords cache directory.
+const flatCoordsFile = "flat"
+
+// flatCountSuffix is appended to the path of the flat coords file for the
+// file with the number of stored nodes. The file is removed while the
+// flat coords are open and written on close, the nodes are counted again
+// if it is missing.
+const flatCountSuffix = ".count"
+
+// flatCoords stores the coordinates in a memory mapped file with 8 bytes
+// for each node (lon/lat as uint32), indexed by the node id. Only the
+// ids up to size are stored, the file is sparse and only requires disk
+// space for the pages that contain nodes.
+// Nodes are missing if the lat is 0 (-90 degree), which is not a valid
+// latitude.
+type flatCoords struct {
+	f    *os.File
+	data []byte
+	size int64
+	// n is the number of stored nodes, updated atomically
+	n int64
+}
+
+// openFlatCoords opens an existing flat coords file, or creates a new
+// file for node ids up to maxId if create is true.
+func openFlatCoords(path string, create bool, maxId int64) (*flatCoords, error) {
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE | os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := fi.Size() / 8
+	if create {
+		size = maxId
+		if err := f.Truncate(size * 8); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	data, err := mmap(f, size*8)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	fc := &flatCoords{f: f, data: data, size: size}
+
+	countPath := path + flatCountSuffix
+	if n, err := readFlatCount(countPath); err == nil {
+		fc.n = n
+	} else if !create {
+		fc.n = fc.countStored()
+	}
+	if err := os.Remove(countPath); err != nil && !os.IsNotExist(err) {
+		fc.close()
+		return nil, err
+	}
+	return fc, nil
+}
+
+func readFlatCount(path string) (int64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func flatCoordsExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// contains returns whether id is stored in the flat file. All other ids
+// are stored in the sparse coords cache.
+func (f *flatCoords) contains(id int64) bool {
+	return id >= 0 && id < f.size
+}
+
+func (f *flatCoords) get(id int64, nd *element.Node) bool {
+	buf := f.data[id*8 : id*8+8]
+	lat := bin.LittleEndian.Uint32(buf[4:])
+	if lat == 0 {
+		return false
+	}
+	nd.Id = id
+	nd.Long = binary.IntToCoord(bin.LittleEndian.Uint32(buf))
+	nd.Lat = binary.IntToCoord(lat)
+	return true
+}
+
+// stored returns whether buf (the 8 bytes of a node) contains a node.
+func stored(buf []byte) bool {
+	return bin.LittleEndian.Uint32(buf[4:]) != 0
+}
+
+// updateCount updates the number of stored nodes after a node changed
+// from before to after.
+func (f *flatCoords) updateCount(before, after bool) {
+	if before && !after {
+		atomic.AddInt64(&f.n, -1)
+	} else if !before && after {
+		atomic.AddInt64(&f.n, 1)
+	}
+}
+
+func (f *flatCoords) put(nd *element.Node) {
+	buf := f.data[nd.Id*8 : nd.Id*8+8]
+	before := stored(buf)
+	bin.LittleEndian.PutUint32(buf, binary.CoordToInt(nd.Long))
+	bin.LittleEndian.PutUint32(buf[4:], binary.CoordToInt(nd.Lat))
+	f.updateCount(before, stored(buf))
+}
+
+func (f *flatCoords) delete(id int64) {
+	buf := f.data[id*8 : id*8+8]
+	before := stored(buf)
+	copy(buf, []byte{0, 0, 0, 0, 0, 0, 0, 0})
+	f.updateCount(before, false)
+}
+
+// raw returns a copy of the stored bytes of id.
+func (f *flatCoords) raw(id int64) []byte {
+	return append([]byte(nil), f.data[id*8:id*8+8]...)
+}
+
+// setRaw restores the stored bytes of id from raw.
+func (f *flatCoords) setRaw(id int64, raw []byte) {
+	buf := f.data[id*8 : id*8+8]
+	before := stored(buf)
+	copy(buf, raw)
+	f.updateCount(before, stored(buf))
+}
+
+// count returns the number of stored nodes.
+func (f *flatCoords) count() int64 {
+	return atomic.LoadInt64(&f.n)
+}
+
+// countStored counts the stored nodes. It reads the whole file.
+func (f *flatCoords) countStored() int64 {
+	var n int64
+	for i := int64(4); i < int64(len(f.data)); i += 8 {
+		if f.data[i] != 0 || f.data[i+1] != 0 || f.data[i+2] != 0 || f.data[i+3] != 0 {
+			n += 1
+		}
+	}
+	return n
+}
+
+func (f *flatCoords) close() error {
+	if err := munmap(f.data); err != nil {
+		f.f.Close()
+		return err
+	}
+	f.data = nil
+	if err := f.f.Sync(); err != nil {
+		f.f.Close()
+		return err
+	}
+	// the count is only valid if the file was synced
+	countPath := f.f.Name() + flatCountSuffix
+	if err := ioutil.WriteFile(countPath, []byte(strconv.FormatInt(f.count(), 10)), 0644); err != nil {
+		f.f.Close()
+		return err
+	}
+	return f.f.Close()
+}
@@ -0,0 +1,14 @@
+package cache
+
+import (
+	"errors"
+	"os"
+)
+
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("flat coords cache is not supported on windows")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
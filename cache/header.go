@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// headerBboxesFile contains the bboxes from the headers of the PBF files
+// that were read into the cache.
+const headerBboxesFile = "header_bboxes.json"
+
+// SetHeaderBboxes stores the bboxes from the headers of the PBF files. Imports
+// without -limitto are limited to these bboxes, also for later -write and
+// diff runs. nil removes the stored bboxes.
+func (c *OSMCache) SetHeaderBboxes(bboxes [][]float64) error {
+	filename := filepath.Join(c.dir, headerBboxesFile)
+	if bboxes == nil {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(bboxes)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// HeaderBboxes returns the bboxes stored with SetHeaderBboxes, or nil if
+// there are none.
+func (c *OSMCache) HeaderBboxes() ([][]float64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, headerBboxesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bboxes [][]float64
+	if err := json.Unmarshal(data, &bboxes); err != nil {
+		return nil, err
+	}
+	return bboxes, nil
+}
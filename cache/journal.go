@@ -0,0 +1,334 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// journalDir is the name of the journal directory inside of the cache
+// directory.
+const journalDir = "journal"
+
+var (
+	journalEntryPrefix = []byte("e/")
+	journalFlatName    = "flat"
+	journalPrepareKey  = []byte("m/prepared")
+	journalDataKey     = []byte("m/data")
+	journalCommitKey   = []byte("m/committed")
+)
+
+// Journal records the previous values of all cache entries that are
+// changed while the journal is attached to the caches. The caches can be
+// rolled back to the state before the journal was attached, e.g. if a
+// diff update was interrupted before the database changes were
+// committed.
+//
+// Only the first change of each entry is recorded, as only the oldest
+// value is required for the rollback.
+type Journal struct {
+	dir string
+	db  Store
+	mu  sync.Mutex
+	// recorded contains the journal keys of all recorded entries. Journals
+	// are attached to the caches after they are created, so it contains
+	// all entries of the journal.
+	recorded map[string]struct{}
+}
+
+// JournalExists returns whether the cache dir contains a journal, e.g.
+// from an interrupted update.
+func JournalExists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, journalDir))
+	return err == nil
+}
+
+// OpenJournal opens the journal in the cache dir. A new journal is
+// created if it does not exist.
+func OpenJournal(dir string) (*Journal, error) {
+	path := filepath.Join(dir, journalDir)
+	db, err := openStore(path, &globalCacheOptions.Journal)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{dir: path, db: db, recorded: make(map[string]struct{})}, nil
+}
+
+// Close closes the journal without removing it.
+func (j *Journal) Close() {
+	if j.db != nil {
+		j.db.Close()
+		j.db = nil
+	}
+}
+
+// Remove closes and removes the journal.
+func (j *Journal) Remove() error {
+	j.Close()
+	return os.RemoveAll(j.dir)
+}
+
+func journalKey(name string, key []byte) []byte {
+	k := make([]byte, 0, len(journalEntryPrefix)+len(name)+1+len(key))
+	k = append(k, journalEntryPrefix...)
+	k = append(k, name...)
+	k = append(k, 0)
+	return append(k, key...)
+}
+
+// record stores the current values of keys from db, if the keys were
+// not recorded before. Missing keys are recorded as well, to remove them
+// on rollback. All entries are written with a single batch.
+func (j *Journal) record(name string, db Store, keys ...[]byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	batch := j.db.NewBatch()
+	defer batch.Close()
+	pending := make(map[string]struct{})
+	for _, key := range keys {
+		jkey := journalKey(name, key)
+		if j.skip(jkey, pending) {
+			continue
+		}
+		value, err := db.Get(key)
+		if err != nil {
+			return err
+		}
+		// the first byte marks existing (1) and missing (0) values
+		var entry []byte
+		if value == nil {
+			entry = []byte{0}
+		} else {
+			entry = append([]byte{1}, value...)
+		}
+		batch.Put(jkey, entry)
+		pending[string(jkey)] = struct{}{}
+	}
+	return j.write(batch, pending)
+}
+
+// recordFlat stores the current values of the flat coords entries of ids.
+func (j *Journal) recordFlat(f *flatCoords, ids ...int64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	batch := j.db.NewBatch()
+	defer batch.Close()
+	pending := make(map[string]struct{})
+	for _, id := range ids {
+		jkey := journalKey(journalFlatName, idToKeyBuf(id))
+		if j.skip(jkey, pending) {
+			continue
+		}
+		batch.Put(jkey, append([]byte{1}, f.raw(id)...))
+		pending[string(jkey)] = struct{}{}
+	}
+	return j.write(batch, pending)
+}
+
+// skip returns whether jkey was recorded before or is pending.
+func (j *Journal) skip(jkey []byte, pending map[string]struct{}) bool {
+	if _, ok := j.recorded[string(jkey)]; ok {
+		return true
+	}
+	_, ok := pending[string(jkey)]
+	return ok
+}
+
+// write writes the batch with the pending entries and marks them as
+// recorded.
+func (j *Journal) write(batch Batch, pending map[string]struct{}) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := j.db.Write(batch); err != nil {
+		return err
+	}
+	for k := range pending {
+		j.recorded[k] = struct{}{}
+	}
+	return nil
+}
+
+// MarkPrepared marks that the database transaction with all changes of
+// the journal is about to be committed. marker identifies the
+// transaction in the database, to check whether it was committed if the
+// update is interrupted before MarkCommitted. data is stored with the
+// mark, e.g. to finish the update.
+func (j *Journal) MarkPrepared(marker string, data []byte) error {
+	batch := j.db.NewBatch()
+	defer batch.Close()
+	batch.Put(journalPrepareKey, []byte(marker))
+	batch.Put(journalDataKey, data)
+	return j.db.Write(batch)
+}
+
+// Prepared returns the marker and data from MarkPrepared, or an empty
+// marker if the journal was not prepared.
+func (j *Journal) Prepared() (string, []byte, error) {
+	marker, err := j.db.Get(journalPrepareKey)
+	if err != nil || marker == nil {
+		return "", nil, err
+	}
+	data, err := j.db.Get(journalDataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(marker), data, nil
+}
+
+// MarkCommitted marks that all changes of the journal are committed to
+// the database. The caches must not be rolled back afterwards.
+func (j *Journal) MarkCommitted() error {
+	return j.db.Put(journalCommitKey, []byte{1})
+}
+
+// Committed returns whether the journal was marked as committed.
+func (j *Journal) Committed() (bool, error) {
+	data, err := j.db.Get(journalCommitKey)
+	if err != nil {
+		return false, err
+	}
+	return data != nil, nil
+}
+
+// Attach records all following changes of osmCache and diffCache.
+// The caches need to be flushed before the journal is detached.
+func (j *Journal) Attach(osmCache *OSMCache, diffCache *DiffCache) {
+	for name, c := range journaledCaches(osmCache, diffCache) {
+		c.db = &journaledStore{Store: c.db, journal: j, name: name}
+	}
+	osmCache.Coords.journal = j
+}
+
+// Detach stops recording the changes of osmCache and diffCache.
+func (j *Journal) Detach(osmCache *OSMCache, diffCache *DiffCache) {
+	for _, c := range journaledCaches(osmCache, diffCache) {
+		if s, ok := c.db.(*journaledStore); ok {
+			c.db = s.Store
+		}
+	}
+	osmCache.Coords.journal = nil
+}
+
+// Rollback restores all recorded entries of osmCache and diffCache.
+// The caches must not be attached to a journal.
+func (j *Journal) Rollback(osmCache *OSMCache, diffCache *DiffCache) error {
+	caches := journaledCaches(osmCache, diffCache)
+	it := j.db.Iterate()
+	defer it.Close()
+	for it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, journalEntryPrefix) {
+			continue
+		}
+		key = key[len(journalEntryPrefix):]
+		sep := bytes.IndexByte(key, 0)
+		if sep < 0 {
+			return errors.New("invalid journal entry")
+		}
+		name, key := string(key[:sep]), key[sep+1:]
+		value := it.Value()
+		if len(value) == 0 {
+			return errors.New("invalid journal entry")
+		}
+
+		if name == journalFlatName {
+			if osmCache.Coords.flat == nil || len(value) != 9 {
+				return errors.New("unable to restore flat coords from journal")
+			}
+			osmCache.Coords.flat.setRaw(idFromKeyBuf(key), value[1:])
+			continue
+		}
+		c, ok := caches[name]
+		if !ok {
+			return errors.New("unknown cache in journal: " + name)
+		}
+		var err error
+		if value[0] == 0 {
+			err = c.db.Delete(key)
+		} else {
+			err = c.db.Put(key, value[1:])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	// the cached bunches can contain the rolled back coords
+	osmCache.Coords.resetBunches()
+	return nil
+}
+
+// journaledCaches returns all journaled caches by name. diffCache is
+// optional.
+func journaledCaches(osmCache *OSMCache, diffCache *DiffCache) map[string]*cache {
+	caches := map[string]*cache{
+		"coords":        &osmCache.Coords.cache,
+		"nodes":         &osmCache.Nodes.cache,
+		"ways":          &osmCache.Ways.cache,
+		"relations":     &osmCache.Relations.cache,
+		"inserted_ways": &osmCache.InsertedWays.cache,
+	}
+	if diffCache != nil {
+		caches["coords_index"] = &diffCache.Coords.cache
+		caches["ways_index"] = &diffCache.Ways.cache
+	}
+	return caches
+}
+
+// journaledStore records all changes in the journal before they are
+// written to the Store.
+type journaledStore struct {
+	Store
+	journal *Journal
+	name    string
+}
+
+func (s *journaledStore) Put(key, value []byte) error {
+	if err := s.journal.record(s.name, s.Store, key); err != nil {
+		return err
+	}
+	return s.Store.Put(key, value)
+}
+
+func (s *journaledStore) Delete(key []byte) error {
+	if err := s.journal.record(s.name, s.Store, key); err != nil {
+		return err
+	}
+	return s.Store.Delete(key)
+}
+
+func (s *journaledStore) NewBatch() Batch {
+	return &journaledBatch{Batch: s.Store.NewBatch()}
+}
+
+func (s *journaledStore) Write(batch Batch) error {
+	b, ok := batch.(*journaledBatch)
+	if !ok {
+		return errors.New("batch was not created by the journaled store")
+	}
+	if err := s.journal.record(s.name, s.Store, b.keys...); err != nil {
+		return err
+	}
+	return s.Store.Write(b.Batch)
+}
+
+// journaledBatch collects the keys of all changes.
+type journaledBatch struct {
+	Batch
+	keys [][]byte
+}
+
+func (b *journaledBatch) Put(key, value []byte) {
+	b.keys = append(b.keys, append([]byte(nil), key...))
+	b.Batch.Put(key, value)
+}
+
+func (b *journaledBatch) Delete(key []byte) {
+	b.keys = append(b.keys, append([]byte(nil), key...))
+	b.Batch.Delete(key)
+}
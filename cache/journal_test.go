@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/olehz/imposm3/element"
+)
+
+func TestJournalRollback(t *testing.T) {
+	testBackends(t, testJournalRollback)
+}
+
+func testJournalRollback(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	withFlatCoords(100, func() {
+		osmCache := NewOSMCache(cache_dir)
+		if err := osmCache.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer osmCache.Close()
+		diffCache := NewDiffCache(cache_dir)
+		if err := diffCache.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer diffCache.Close()
+
+		// 1 is stored in the flat file, 1000 in the bunches
+		osmCache.Coords.PutCoords([]element.Node{
+			{OSMElem: element.OSMElem{Id: 1}, Long: 10, Lat: 50},
+			{OSMElem: element.OSMElem{Id: 1000}, Long: 11, Lat: 51},
+		})
+		osmCache.Coords.Flush()
+		osmCache.Ways.PutWay(&element.Way{OSMElem: element.OSMElem{Id: 10}, Refs: []int64{1, 1000}})
+		diffCache.Coords.Add(1, 10)
+
+		if JournalExists(cache_dir) {
+			t.Fatal("unexpected journal")
+		}
+		journal, err := OpenJournal(cache_dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal.Attach(osmCache, diffCache)
+
+		osmCache.Coords.PutCoords([]element.Node{
+			{OSMElem: element.OSMElem{Id: 1}, Long: 20, Lat: 60},
+			{OSMElem: element.OSMElem{Id: 2}, Long: 20, Lat: 60},
+			{OSMElem: element.OSMElem{Id: 1000}, Long: 21, Lat: 61},
+		})
+		osmCache.Coords.PutCoords([]element.Node{
+			{OSMElem: element.OSMElem{Id: 1}, Long: 30, Lat: 70},
+		})
+		osmCache.Ways.DeleteWay(10)
+		osmCache.Ways.PutWays([]element.Way{{OSMElem: element.OSMElem{Id: 11}, Refs: []int64{1, 2}}})
+		diffCache.Coords.Add(1, 11)
+
+		osmCache.Coords.Flush()
+		journal.Detach(osmCache, diffCache)
+
+		if committed, err := journal.Committed(); err != nil || committed {
+			t.Fatal("unexpected committed journal", err)
+		}
+		journal.Close()
+
+		// loads the updated bunch into the LRU cache
+		if nd, err := osmCache.Coords.GetCoord(1000); err != nil || math.Abs(nd.Long-21) > 1e-6 {
+			t.Fatal("unexpected coord", nd, err)
+		}
+
+		// rollback on next start
+		if !JournalExists(cache_dir) {
+			t.Fatal("missing journal")
+		}
+		journal, err = OpenJournal(cache_dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.Rollback(osmCache, diffCache); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.Remove(); err != nil {
+			t.Fatal(err)
+		}
+		if JournalExists(cache_dir) {
+			t.Fatal("journal not removed")
+		}
+
+		for _, expected := range []element.Node{
+			{OSMElem: element.OSMElem{Id: 1}, Long: 10, Lat: 50},
+			{OSMElem: element.OSMElem{Id: 1000}, Long: 11, Lat: 51},
+		} {
+			nd, err := osmCache.Coords.GetCoord(expected.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(nd.Long-expected.Long) > 1e-6 || math.Abs(nd.Lat-expected.Lat) > 1e-6 {
+				t.Errorf("unexpected coord %v != %v", nd, expected)
+			}
+		}
+		if _, err := osmCache.Coords.GetCoord(2); err != NotFound {
+			t.Error("coord 2 not rolled back", err)
+		}
+		if _, err := osmCache.Ways.GetWay(10); err != nil {
+			t.Error("way 10 not restored", err)
+		}
+		if _, err := osmCache.Ways.GetWay(11); err != NotFound {
+			t.Error("way 11 not rolled back", err)
+		}
+		if refs := diffCache.Coords.Get(1); len(refs) != 1 || refs[0] != 10 {
+			t.Errorf("unexpected refs %v", refs)
+		}
+	})
+}
+
+func TestJournalCommitted(t *testing.T) {
+	testBackends(t, testJournalCommitted)
+}
+
+func testJournalCommitted(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	journal, err := OpenJournal(cache_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker, _, err := journal.Prepared(); err != nil || marker != "" {
+		t.Fatalf("unexpected prepare mark %q %v", marker, err)
+	}
+	if err := journal.MarkPrepared("42", []byte("state")); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	journal, err = OpenJournal(cache_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Remove()
+	marker, data, err := journal.Prepared()
+	if err != nil || marker != "42" || string(data) != "state" {
+		t.Errorf("unexpected prepare mark %q %q %v", marker, data, err)
+	}
+	if committed, err := journal.Committed(); err != nil || committed {
+		t.Errorf("unexpected commit mark %v %v", committed, err)
+	}
+	if err := journal.MarkCommitted(); err != nil {
+		t.Fatal(err)
+	}
+	if committed, err := journal.Committed(); err != nil || !committed {
+		t.Errorf("missing commit mark %v %v", committed, err)
+	}
+}
+
+func TestJournalBatch(t *testing.T) {
+	testBackends(t, testJournalBatch)
+}
+
+func testJournalBatch(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	osmCache := NewOSMCache(cache_dir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer osmCache.Close()
+	osmCache.Ways.PutWay(&element.Way{OSMElem: element.OSMElem{Id: 1}, Refs: []int64{1}})
+
+	journal, err := OpenJournal(cache_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Remove()
+	journal.Attach(osmCache, nil)
+
+	// all keys of a batch are recorded once, with the value before the
+	// batch
+	osmCache.Ways.PutWays([]element.Way{
+		{OSMElem: element.OSMElem{Id: 1}, Refs: []int64{2}},
+		{OSMElem: element.OSMElem{Id: 2}, Refs: []int64{2}},
+		{OSMElem: element.OSMElem{Id: 1}, Refs: []int64{3}},
+	})
+	osmCache.Ways.PutWays([]element.Way{{OSMElem: element.OSMElem{Id: 1}, Refs: []int64{4}}})
+	journal.Detach(osmCache, nil)
+
+	entries := 0
+	it := journal.db.Iterate()
+	for it.Next() {
+		entries++
+	}
+	it.Close()
+	if entries != 2 {
+		t.Errorf("unexpected number of journal entries %d", entries)
+	}
+
+	if err := journal.Rollback(osmCache, nil); err != nil {
+		t.Fatal(err)
+	}
+	if way, err := osmCache.Ways.GetWay(1); err != nil || way.Refs[0] != 1 {
+		t.Errorf("way not restored %v %v", way, err)
+	}
+	if way, _ := osmCache.Ways.GetWay(2); way != nil {
+		t.Errorf("way not removed %v", way)
+	}
+}
@@ -3,7 +3,7 @@ package cache
 import (
 	bin "encoding/binary"
 	"errors"
-	levigo "github.com/olehz/hyperleveldb-go"
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -53,11 +53,21 @@ func NewOSMCache(dir string) *OSMCache {
 }
 
 func (c *OSMCache) Open() error {
+	return c.open(true)
+}
+
+// OpenExisting opens the cache like Open, but without creating a flat
+// coords file. It is used by tools that only inspect existing caches.
+func (c *OSMCache) OpenExisting() error {
+	return c.open(false)
+}
+
+func (c *OSMCache) open(createFlat bool) error {
 	err := os.MkdirAll(c.dir, 0755)
 	if err != nil {
 		return err
 	}
-	c.Coords, err = newDeltaCoordsCache(filepath.Join(c.dir, "coords"))
+	c.Coords, err = newDeltaCoordsCache(filepath.Join(c.dir, "coords"), createFlat)
 	if err != nil {
 		return err
 	}
@@ -85,6 +95,16 @@ func (c *OSMCache) Open() error {
 	return nil
 }
 
+// RequireCoords returns an error if the cache does not contain all
+// coords, because the ways were read with their node locations. Diff
+// imports require the coords.
+func (c *OSMCache) RequireCoords() error {
+	if c.Ways != nil && c.Ways.StoresLocations() {
+		return fmt.Errorf("cache %s contains the node locations of the ways instead of all coords, import with -diff to create a cache for diff imports", c.dir)
+	}
+	return nil
+}
+
 func (c *OSMCache) Exists() bool {
 	if c.opened {
 		return true
@@ -126,44 +146,25 @@ func (c *OSMCache) Remove() error {
 	if err := os.RemoveAll(filepath.Join(c.dir, "inserted_ways")); err != nil {
 		return err
 	}
+	if err := c.SetHeaderBboxes(nil); err != nil {
+		return err
+	}
 	return nil
 }
 
 type cache struct {
-	db      *levigo.DB
+	path    string
+	db      Store
 	options *cacheOptions
-	cache   *levigo.Cache
-	wo      *levigo.WriteOptions
-	ro      *levigo.ReadOptions
 }
 
 func (c *cache) open(path string) error {
-	opts := levigo.NewOptions()
-	opts.SetCreateIfMissing(true)
-	if c.options.CacheSizeM > 0 {
-		c.cache = levigo.NewLRUCache(c.options.CacheSizeM * 1024 * 1024)
-		opts.SetCache(c.cache)
-	}
-	if c.options.MaxOpenFiles > 0 {
-		opts.SetMaxOpenFiles(c.options.MaxOpenFiles)
-	}
-	if c.options.BlockRestartInterval > 0 {
-		opts.SetBlockRestartInterval(c.options.BlockRestartInterval)
-	}
-	if c.options.WriteBufferSizeM > 0 {
-		opts.SetWriteBufferSize(c.options.WriteBufferSizeM * 1024 * 1024)
-	}
-	if c.options.BlockSizeK > 0 {
-		opts.SetBlockSize(c.options.BlockSizeK * 1024)
-	}
-
-	db, err := levigo.Open(path, opts)
+	db, err := openStore(path, c.options)
 	if err != nil {
 		return err
 	}
 	c.db = db
-	c.wo = levigo.NewWriteOptions()
-	c.ro = levigo.NewReadOptions()
+	c.path = path
 	return nil
 }
 
@@ -178,21 +179,8 @@ func idFromKeyBuf(buf []byte) int64 {
 }
 
 func (c *cache) Close() {
-	if c.ro != nil {
-		c.ro.Close()
-		c.ro = nil
-	}
-	if c.wo != nil {
-		c.wo.Close()
-		c.wo = nil
-	}
 	if c.db != nil {
 		c.db.Close()
 		c.db = nil
 	}
-	if c.cache != nil {
-		c.cache.Close()
-		c.cache = nil
-	}
-
 }
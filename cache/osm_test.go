@@ -3,12 +3,17 @@ package cache
 import (
 	"github.com/olehz/imposm3/element"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"os"
 	"testing"
 )
 
 func TestCreateCache(t *testing.T) {
+	testBackends(t, testCreateCache)
+}
+
+func testCreateCache(t *testing.T) {
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
 
@@ -24,6 +29,10 @@ func TestCreateCache(t *testing.T) {
 }
 
 func TestReadWriteNode(t *testing.T) {
+	testBackends(t, testReadWriteNode)
+}
+
+func testReadWriteNode(t *testing.T) {
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
 
@@ -58,6 +67,10 @@ func TestReadWriteNode(t *testing.T) {
 }
 
 func TestReadWriteWay(t *testing.T) {
+	testBackends(t, testReadWriteWay)
+}
+
+func testReadWriteWay(t *testing.T) {
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
 
@@ -92,7 +105,90 @@ func TestReadWriteWay(t *testing.T) {
 	}
 }
 
+func TestReadWriteWayLocations(t *testing.T) {
+	testBackends(t, testReadWriteWayLocations)
+}
+
+func testReadWriteWayLocations(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	cache, err := newWaysCache(cache_dir)
+	if err != nil {
+		t.Fatal()
+	}
+	defer cache.Close()
+
+	nodes := []element.Node{
+		{OSMElem: element.OSMElem{Id: 1}, Long: 10, Lat: 53},
+		{OSMElem: element.OSMElem{Id: 2}, Long: 11, Lat: 54},
+	}
+	cache.PutWay(&element.Way{OSMElem: element.OSMElem{Id: 1}, Refs: []int64{1, 2}, Nodes: nodes})
+	if err := cache.SetStoreLocations(true); err != nil {
+		t.Fatal(err)
+	}
+	cache.PutWays([]element.Way{{OSMElem: element.OSMElem{Id: 2}, Refs: []int64{1, 2}, Nodes: nodes}})
+
+	// locations are only stored if enabled
+	if way, _ := cache.GetWay(1); way == nil || way.Nodes != nil {
+		t.Errorf("unexpected result of GetWay: %#v", way)
+	}
+	way, _ := cache.GetWay(2)
+	if way == nil || len(way.Nodes) != 2 || way.Nodes[1].Id != 2 || math.Abs(way.Nodes[1].Long-11) > 1e-6 {
+		t.Errorf("unexpected result of GetWay: %#v", way)
+	}
+}
+
+func TestStoreLocationsPersisted(t *testing.T) {
+	testBackends(t, testStoreLocationsPersisted)
+}
+
+func testStoreLocationsPersisted(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	osmCache := NewOSMCache(cache_dir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if err := osmCache.RequireCoords(); err != nil {
+		t.Error(err)
+	}
+	if err := osmCache.Ways.SetStoreLocations(true); err != nil {
+		t.Fatal(err)
+	}
+	osmCache.Close()
+
+	// the option is stored with the cache and prevents diff imports
+	osmCache = NewOSMCache(cache_dir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if !osmCache.Ways.StoresLocations() {
+		t.Error("store locations not persisted")
+	}
+	if err := osmCache.RequireCoords(); err == nil {
+		t.Error("expected error for cache without coords")
+	}
+
+	// removed with the cache
+	if err := osmCache.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer osmCache.Close()
+	if err := osmCache.RequireCoords(); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestReadMissingWay(t *testing.T) {
+	testBackends(t, testReadMissingWay)
+}
+
+func testReadMissingWay(t *testing.T) {
 	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
 	defer os.RemoveAll(cache_dir)
 
@@ -178,3 +274,31 @@ func TestIds(t *testing.T) {
 	}
 
 }
+
+func TestHeaderBboxes(t *testing.T) {
+	testBackends(t, testHeaderBboxes)
+}
+
+func testHeaderBboxes(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	osmCache := NewOSMCache(cache_dir)
+	if bboxes, err := osmCache.HeaderBboxes(); err != nil || bboxes != nil {
+		t.Fatal("unexpected bboxes", bboxes, err)
+	}
+	expected := [][]float64{{8.1, 53.0, 8.5, 53.2}, {9.9, 53.5, 10.1, 53.6}}
+	if err := osmCache.SetHeaderBboxes(expected); err != nil {
+		t.Fatal(err)
+	}
+	bboxes, err := osmCache.HeaderBboxes()
+	if err != nil || len(bboxes) != 2 || bboxes[1][2] != 10.1 {
+		t.Fatal("unexpected bboxes", bboxes, err)
+	}
+	if err := osmCache.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if bboxes, err := osmCache.HeaderBboxes(); err != nil || bboxes != nil {
+		t.Fatal("bboxes not removed", bboxes, err)
+	}
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultMemory is used by the presets if the available memory is
+// unknown.
+const defaultMemory = 4 * 1024 * 1024 * 1024
+
+// presets update the cache options for the available memory in bytes.
+var presets = map[string]func(opts *osmCacheOptions, memory int64){
+	"small":  presetSmall,
+	"planet": presetPlanet,
+}
+
+// Presets returns the names of all cache presets.
+func Presets() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presetSmall uses little memory for the caches, e.g. for small
+// extracts or for updates on systems with limited memory.
+func presetSmall(opts *osmCacheOptions, memory int64) {
+	mb := int(memory / 1024 / 1024)
+	for _, o := range opts.all() {
+		o.CacheSizeM = clamp(mb/512, 4, 16)
+		o.WriteBufferSizeM = clamp(mb/256, 4, 32)
+	}
+	// each bunch requires approx. BunchSize*40 bytes
+	opts.Coords.BunchCacheCapacity = clamp(int(memory/32)/(opts.Coords.BunchSize*40), 1024, 8192)
+}
+
+// presetPlanet uses up to half of the memory for the caches and enables
+// the flat coords file. The flat file is only created for new caches,
+// existing caches keep their coords in LevelDB (see newDeltaCoordsCache).
+func presetPlanet(opts *osmCacheOptions, memory int64) {
+	mb := int(memory / 1024 / 1024)
+	for _, o := range opts.all() {
+		o.CacheSizeM = clamp(mb/128, 16, 256)
+		o.WriteBufferSizeM = clamp(mb/128, 64, 256)
+	}
+	opts.Coords.CacheSizeM = clamp(mb/32, 64, 1024)
+	opts.Coords.WriteBufferSizeM = clamp(mb/64, 64, 512)
+	opts.Coords.BunchCacheCapacity = clamp(int(memory/8)/(opts.Coords.BunchSize*40), 8192, 1<<20)
+	opts.CoordsIndex.CacheSizeM = clamp(mb/64, 32, 512)
+	opts.CoordsIndex.WriteBufferSizeM = clamp(mb/64, 128, 512)
+	opts.Coords.Flat = true
+}
+
+// all returns the options of all caches.
+func (o *osmCacheOptions) all() []*cacheOptions {
+	return []*cacheOptions{
+		&o.Coords.cacheOptions, &o.Ways, &o.Nodes, &o.Relations,
+		&o.InsertedWays, &o.CoordsIndex, &o.WaysIndex, &o.Versions, &o.Journal,
+	}
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// availableMemory returns the memory in bytes that is available for the
+// caches: MemAvailable from /proc/meminfo (MemTotal for kernels without
+// MemAvailable), limited by the memory limit of the cgroup. It returns
+// defaultMemory if neither is known.
+func availableMemory() int64 {
+	memory := int64(-1)
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		memory = parseMeminfo(f)
+		f.Close()
+	}
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",                   // cgroup v2
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes", // cgroup v1
+	} {
+		if data, err := ioutil.ReadFile(path); err == nil {
+			if limit := parseCgroupLimit(string(data)); limit > 0 && (memory < 0 || limit < memory) {
+				memory = limit
+			}
+			break
+		}
+	}
+	if memory <= 0 {
+		return defaultMemory
+	}
+	return memory
+}
+
+// parseMeminfo returns MemAvailable, or MemTotal if MemAvailable is
+// missing, in bytes. It returns -1 if both are missing.
+func parseMeminfo(r io.Reader) int64 {
+	total := int64(-1)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// MemAvailable:   12734680 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[2] != "kB" {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemAvailable:":
+			return kb * 1024
+		case "MemTotal:":
+			total = kb * 1024
+		}
+	}
+	return total
+}
+
+// maxCgroupLimit is the smallest value that is treated as unlimited.
+// cgroup v1 reports unlimited as a large page aligned number.
+const maxCgroupLimit = 1 << 60
+
+// parseCgroupLimit returns the memory limit in bytes of a cgroup
+// memory.max or memory.limit_in_bytes file, or -1 if it is unlimited or
+// invalid.
+func parseCgroupLimit(data string) int64 {
+	limit, err := strconv.ParseInt(strings.TrimSpace(data), 10, 64)
+	if err != nil || limit <= 0 || limit >= maxCgroupLimit {
+		// "max" for unlimited cgroup v2
+		return -1
+	}
+	return limit
+}
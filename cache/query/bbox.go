@@ -0,0 +1,83 @@
+package query
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/element"
+)
+
+type bbox struct {
+	minx, miny, maxx, maxy float64
+}
+
+// parseBbox parses minx,miny,maxx,maxy.
+func parseBbox(s string) (bbox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return bbox{}, errors.New("bbox requires minx,miny,maxx,maxy: " + s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return bbox{}, err
+		}
+		v[i] = f
+	}
+	if v[0] > v[2] || v[1] > v[3] {
+		return bbox{}, errors.New("invalid bbox: " + s)
+	}
+	return bbox{v[0], v[1], v[2], v[3]}, nil
+}
+
+func (b bbox) contains(nd *element.Node) bool {
+	return nd.Long >= b.minx && nd.Long <= b.maxx && nd.Lat >= b.miny && nd.Lat <= b.maxy
+}
+
+// searchBbox scans the caches for all tagged nodes inside the bbox, all
+// ways with at least one node inside and all relations with a node or
+// way member inside. The ids are sorted.
+func searchBbox(osmCache *cache.OSMCache, b bbox) (nodeIds, wayIds, relIds []int64) {
+	nodes := make(map[int64]bool)
+	for nd := range osmCache.Nodes.Iter() {
+		if b.contains(nd) {
+			nodes[nd.Id] = true
+			nodeIds = append(nodeIds, nd.Id)
+		}
+	}
+
+	ways := make(map[int64]bool)
+	for way := range osmCache.Ways.Iter() {
+		if err := osmCache.Coords.FillWay(way); err == cache.NotFound {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		for i := range way.Nodes {
+			if b.contains(&way.Nodes[i]) {
+				ways[way.Id] = true
+				wayIds = append(wayIds, way.Id)
+				break
+			}
+		}
+	}
+
+	for rel := range osmCache.Relations.Iter() {
+		for _, m := range rel.Members {
+			if (m.Type == element.NODE && nodes[m.Id]) || (m.Type == element.WAY && ways[m.Id]) {
+				relIds = append(relIds, rel.Id)
+				break
+			}
+		}
+	}
+
+	sort.Slice(nodeIds, func(i, j int) bool { return nodeIds[i] < nodeIds[j] })
+	sort.Slice(wayIds, func(i, j int) bool { return wayIds[i] < wayIds[j] })
+	sort.Slice(relIds, func(i, j int) bool { return relIds[i] < relIds[j] })
+	return nodeIds, wayIds, relIds
+}
@@ -0,0 +1,219 @@
+package query
+
+import (
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/geom"
+	"github.com/olehz/imposm3/geom/geos"
+)
+
+// maxRingGap for multipolygon rings in WGS84 (~0.1m)
+const maxRingGap = 1e-6
+
+type featureCollection struct {
+	Type     string     `json:"type"`
+	Features []*feature `json:"features"`
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	Id         string                 `json:"id"`
+	Geometry   *geometry              `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// newFeature returns a feature with the tags of elem as properties.
+// err is added as error property for elements without geometry.
+func newFeature(typ string, elem element.OSMElem, geom *geometry, err error) *feature {
+	props := map[string]interface{}{
+		"osm_type": typ,
+		"osm_id":   elem.Id,
+	}
+	for k, v := range elem.Tags {
+		props[k] = v
+	}
+	if err != nil {
+		props["error"] = err.Error()
+	}
+	return &feature{
+		Type:       "Feature",
+		Id:         typ + "/" + strconv.FormatInt(elem.Id, 10),
+		Geometry:   geom,
+		Properties: props,
+	}
+}
+
+func nodeCoords(nodes []element.Node) [][]float64 {
+	coords := make([][]float64, len(nodes))
+	for i, nd := range nodes {
+		coords[i] = []float64{nd.Long, nd.Lat}
+	}
+	return coords
+}
+
+func nodeFeature(nd *element.Node) *feature {
+	return newFeature("node", nd.OSMElem, &geometry{"Point", []float64{nd.Long, nd.Lat}}, nil)
+}
+
+// wayGeometry returns closed ways as Polygon (unless tagged with area=no)
+// and all other ways as LineString. The nodes of the way need to be
+// filled.
+func wayGeometry(way *element.Way) (*geometry, error) {
+	if len(way.Nodes) < 2 {
+		return nil, errors.New("way with less than two nodes")
+	}
+	if way.IsClosed() && way.Tags["area"] != "no" {
+		return &geometry{"Polygon", [][][]float64{nodeCoords(way.Nodes)}}, nil
+	}
+	return &geometry{"LineString", nodeCoords(way.Nodes)}, nil
+}
+
+func wayFeature(osmCache *cache.OSMCache, way *element.Way) *feature {
+	if err := osmCache.Coords.FillWay(way); err != nil {
+		return newFeature("way", way.OSMElem, nil, err)
+	}
+	g, err := wayGeometry(way)
+	return newFeature("way", way.OSMElem, g, err)
+}
+
+// relationFeature builds the geometry of multipolygon and boundary
+// relations. Other relations are returned without geometry.
+func relationFeature(osmCache *cache.OSMCache, rel *element.Relation) *feature {
+	if rel.Tags["type"] != "multipolygon" && rel.Tags["type"] != "boundary" {
+		return newFeature("relation", rel.OSMElem, nil, nil)
+	}
+	g, err := relationGeometry(osmCache, rel)
+	return newFeature("relation", rel.OSMElem, g, err)
+}
+
+func relationGeometry(osmCache *cache.OSMCache, rel *element.Relation) (*geometry, error) {
+	if err := osmCache.Ways.FillMembers(rel.Members); err != nil {
+		return nil, err
+	}
+	for _, m := range rel.Members {
+		if m.Way == nil {
+			continue
+		}
+		if err := osmCache.Coords.FillWay(m.Way); err != nil {
+			return nil, err
+		}
+	}
+	rings, err := geom.BuildRings(rel, maxRingGap)
+	if err != nil {
+		return nil, err
+	}
+	result, err := geom.BuildRelGeometry(rel, rings, 4326)
+	if err != nil {
+		return nil, err
+	}
+
+	g := geos.NewGeos()
+	defer g.Finish()
+	switch g.Type(result) {
+	case "Polygon":
+		coords, err := polygonCoords(g, result)
+		if err != nil {
+			return nil, err
+		}
+		return &geometry{"Polygon", coords}, nil
+	case "MultiPolygon":
+		var coords [][][][]float64
+		for _, p := range g.Geoms(result) {
+			c, err := polygonCoords(g, p)
+			if err != nil {
+				return nil, err
+			}
+			coords = append(coords, c)
+		}
+		return &geometry{"MultiPolygon", coords}, nil
+	}
+	return nil, errors.New("unexpected geometry type " + g.Type(result))
+}
+
+func polygonCoords(g *geos.Geos, polygon *geos.Geom) ([][][]float64, error) {
+	rings := []*geos.Geom{g.ExteriorRing(polygon)}
+	for i := int32(0); i < g.NumInteriorRings(polygon); i++ {
+		rings = append(rings, g.InteriorRing(polygon, i))
+	}
+	var coords [][][]float64
+	for _, ring := range rings {
+		if ring == nil {
+			return nil, errors.New("unable to get ring of polygon")
+		}
+		c, err := ringCoords(g, ring)
+		if err != nil {
+			return nil, err
+		}
+		coords = append(coords, c)
+	}
+	return coords, nil
+}
+
+func ringCoords(g *geos.Geos, ring *geos.Geom) ([][]float64, error) {
+	seq, err := g.CoordSeq(ring)
+	if err != nil {
+		return nil, err
+	}
+	size, err := seq.Size(g)
+	if err != nil {
+		return nil, err
+	}
+	coords := make([][]float64, size)
+	for i := uint32(0); i < size; i++ {
+		x, y, err := seq.XY(g, i)
+		if err != nil {
+			return nil, err
+		}
+		coords[i] = []float64{x, y}
+	}
+	return coords, nil
+}
+
+// collectFeatures returns the features of all nodes, ways and relations
+// in the order of the ids. Missing elements are skipped.
+func collectFeatures(osmCache *cache.OSMCache, nodeIds, wayIds, relIds []int64) *featureCollection {
+	fc := &featureCollection{Type: "FeatureCollection", Features: []*feature{}}
+	for _, id := range nodeIds {
+		nd, err := osmCache.Nodes.GetNode(id)
+		if err != cache.NotFound && err != nil {
+			log.Fatal(err)
+		}
+		if nd == nil {
+			nd, err = osmCache.Coords.GetCoord(id)
+			if err == cache.NotFound {
+				continue
+			} else if err != nil {
+				log.Fatal(err)
+			}
+		}
+		fc.Features = append(fc.Features, nodeFeature(nd))
+	}
+	for _, id := range wayIds {
+		way, err := osmCache.Ways.GetWay(id)
+		if err == cache.NotFound {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		fc.Features = append(fc.Features, wayFeature(osmCache, way))
+	}
+	for _, id := range relIds {
+		rel, err := osmCache.Relations.GetRelation(id)
+		if err == cache.NotFound {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		fc.Features = append(fc.Features, relationFeature(osmCache, rel))
+	}
+	return fc
+}
@@ -10,18 +10,29 @@ import (
 	"strings"
 
 	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/config"
 	"github.com/olehz/imposm3/element"
 )
 
 var flags = flag.NewFlagSet("query-cache", flag.ExitOnError)
 
 var (
-	nodeIds  = flags.String("node", "", "node")
-	wayIds   = flags.String("way", "", "way")
-	relIds   = flags.String("rel", "", "relation")
-	full     = flags.Bool("full", false, "recurse into relations/ways")
-	deps     = flags.Bool("deps", false, "show dependent ways/relations")
-	cachedir = flags.String("cachedir", "/tmp/imposm3", "cache directory")
+	opts = config.AddToolFlags(flags)
+
+	nodeIds = flags.String("node", "", "node")
+	wayIds  = flags.String("way", "", "way")
+	relIds  = flags.String("rel", "", "relation")
+	full    = flags.Bool("full", false, "recurse into relations/ways")
+	deps    = flags.Bool("deps", false, "show dependent ways/relations")
+	format  = flags.String("format", "json", "output format (json or geojson)")
+	bboxArg = flags.String("bbox", "", "search elements within minx,miny,maxx,maxy (EPSG:4326)")
+
+	verifyRows  = flags.Bool("verify", false, "compare the rows in the database with the cache")
+	mappingFile = flags.String("mapping", "", "mapping file (-verify)")
+	connection  = flags.String("connection", "", "connection parameters (-verify)")
+	schema      = flags.String("dbschema-production", "public", "db schema for production (-verify)")
+	srid        = flags.Int("srid", 3857, "srs id (-verify)")
+	limitTo     = flags.String("limitto", "", "limit to geometries (-verify)")
 )
 
 type nodes map[string]*node
@@ -133,7 +144,7 @@ func collectNodes(osmCache *cache.OSMCache, diffCache *cache.DiffCache, ids []in
 func Usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s %s:\n\n", os.Args[0], os.Args[1])
 	flags.PrintDefaults()
-	fmt.Fprintln(os.Stderr, "\nQuery cache for nodes/ways/relations.")
+	fmt.Fprintln(os.Stderr, "\nQuery cache for nodes/ways/relations by id or within a -bbox.")
 	os.Exit(1)
 }
 
@@ -170,13 +181,16 @@ func Query(args []string) {
 	}
 	log.SetFlags(0)
 	log.SetOutput(os.Stdout)
+	if err := opts.Configure(); err != nil {
+		log.Fatal(err)
+	}
 
-	osmCache := cache.NewOSMCache(*cachedir)
-	err = osmCache.Open()
+	osmCache := cache.NewOSMCache(opts.CacheDir)
+	err = osmCache.OpenExisting()
 	if err != nil {
 		log.Fatal(err)
 	}
-	diffCache := cache.NewDiffCache(*cachedir)
+	diffCache := cache.NewDiffCache(opts.CacheDir)
 	err = diffCache.Open()
 	if err != nil {
 		log.Fatal(err)
@@ -186,21 +200,61 @@ func Query(args []string) {
 		log.Fatal("cannot use -full and -deps option together")
 	}
 
-	result := result{}
+	if *format != "json" && *format != "geojson" {
+		log.Fatal("unsupported format: ", *format)
+	}
+	if *format == "geojson" && (*full || *deps) {
+		log.Fatal("cannot use -full or -deps option with -format geojson")
+	}
+	if *verifyRows && (*full || *format != "json") {
+		log.Fatal("cannot use -full or -format option with -verify")
+	}
 
+	var nids, wids, rids []int64
+	if *nodeIds != "" {
+		nids = splitIds(*nodeIds)
+	}
+	if *wayIds != "" {
+		wids = splitIds(*wayIds)
+	}
 	if *relIds != "" {
-		ids := splitIds(*relIds)
-		result.Relations = collectRelations(osmCache, ids, *full)
+		rids = splitIds(*relIds)
+	}
+	var b *bbox
+	if *bboxArg != "" {
+		parsed, err := parseBbox(*bboxArg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b = &parsed
+		bboxNodes, bboxWays, bboxRels := searchBbox(osmCache, parsed)
+		// explicit ids can also be within the bbox
+		nids = uniqueIds(append(nids, bboxNodes...))
+		wids = uniqueIds(append(wids, bboxWays...))
+		rids = uniqueIds(append(rids, bboxRels...))
 	}
 
-	if *wayIds != "" {
-		ids := splitIds(*wayIds)
-		result.Ways = collectWays(osmCache, diffCache, ids, *full, *deps)
+	if *format == "geojson" {
+		printJson(collectFeatures(osmCache, nids, wids, rids))
+		return
+	}
+	if *verifyRows {
+		printJson(verify(osmCache, diffCache, nids, wids, rids, b))
+		return
 	}
 
-	if *nodeIds != "" {
-		ids := splitIds(*nodeIds)
-		result.Nodes = collectNodes(osmCache, diffCache, ids, *deps)
+	result := result{}
+
+	if len(rids) > 0 {
+		result.Relations = collectRelations(osmCache, rids, *full)
+	}
+
+	if len(wids) > 0 {
+		result.Ways = collectWays(osmCache, diffCache, wids, *full, *deps)
+	}
+
+	if len(nids) > 0 {
+		result.Nodes = collectNodes(osmCache, diffCache, nids, *deps)
 	}
 
 	printJson(result)
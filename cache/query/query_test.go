@@ -0,0 +1,93 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/olehz/imposm3/element"
+)
+
+func TestParseBbox(t *testing.T) {
+	b, err := parseBbox("8.1,53.0, 8.5,53.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != (bbox{minx: 8.1, miny: 53.0, maxx: 8.5, maxy: 53.2}) {
+		t.Fatal(b)
+	}
+	if !b.contains(&element.Node{Long: 8.2, Lat: 53.1}) {
+		t.Error("node not in bbox")
+	}
+	if b.contains(&element.Node{Long: 8.6, Lat: 53.1}) {
+		t.Error("node in bbox")
+	}
+
+	for _, s := range []string{"", "1,2,3", "1,2,3,x", "3,2,1,4"} {
+		if _, err := parseBbox(s); err == nil {
+			t.Error("expected error for", s)
+		}
+	}
+}
+
+func TestWayGeometry(t *testing.T) {
+	nodes := []element.Node{
+		{Long: 0, Lat: 0},
+		{Long: 1, Lat: 0},
+		{Long: 1, Lat: 1},
+		{Long: 0, Lat: 0},
+	}
+	way := &element.Way{Refs: []int64{1, 2, 3, 1}, Nodes: nodes}
+	g, err := wayGeometry(way)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Type != "Polygon" {
+		t.Fatal(g)
+	}
+	if coords := g.Coordinates.([][][]float64); len(coords) != 1 || len(coords[0]) != 4 || coords[0][2][0] != 1 {
+		t.Fatal(coords)
+	}
+
+	way.Tags = element.Tags{"area": "no"}
+	if g, err := wayGeometry(way); err != nil || g.Type != "LineString" {
+		t.Fatal(g, err)
+	}
+
+	way = &element.Way{Refs: []int64{1, 2, 3}, Nodes: nodes[:3]}
+	if g, err := wayGeometry(way); err != nil || g.Type != "LineString" {
+		t.Fatal(g, err)
+	}
+
+	way = &element.Way{Refs: []int64{1}, Nodes: nodes[:1]}
+	if _, err := wayGeometry(way); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRowIds(t *testing.T) {
+	ids := rowIds(false, []int64{1}, []int64{2}, []int64{3})
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != -3 {
+		t.Error(ids)
+	}
+	ids = rowIds(true, []int64{1}, []int64{2}, []int64{3})
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != -2 || ids[2] != element.RelIdOffset-3 {
+		t.Error(ids)
+	}
+}
+
+func TestElementIds(t *testing.T) {
+	nids, wids, rids := elementIds(true, rowIds(true, []int64{1}, []int64{2}, []int64{3}))
+	if len(nids) != 1 || nids[0] != 1 || len(wids) != 1 || wids[0] != 2 || len(rids) != 1 || rids[0] != 3 {
+		t.Error(nids, wids, rids)
+	}
+	nids, wids, rids = elementIds(false, rowIds(false, []int64{1}, nil, []int64{3}))
+	if len(nids) != 1 || nids[0] != 1 || len(wids) != 1 || wids[0] != 1 || len(rids) != 1 || rids[0] != 3 {
+		t.Error(nids, wids, rids)
+	}
+}
+
+func TestUniqueIds(t *testing.T) {
+	ids := uniqueIds([]int64{3, 1, 3, 2, 1})
+	if len(ids) != 3 || ids[0] != 3 || ids[1] != 1 || ids[2] != 2 {
+		t.Error(ids)
+	}
+}
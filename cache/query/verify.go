@@ -0,0 +1,272 @@
+package query
+
+import (
+	"log"
+	"sync"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/database"
+	_ "github.com/olehz/imposm3/database/postgis"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/geom/limit"
+	"github.com/olehz/imposm3/logging"
+	"github.com/olehz/imposm3/mapping"
+	"github.com/olehz/imposm3/stats"
+	"github.com/olehz/imposm3/writer"
+)
+
+type verifyResult struct {
+	ExpectedRows int                `json:"expected_rows"`
+	Diffs        []database.RowDiff `json:"diffs"`
+	// Errors of the geometry builds, elements with errors have no
+	// expected rows
+	Errors []string `json:"errors"`
+}
+
+// expectedInserter is a database.Inserter that records all rows instead
+// of inserting them.
+type expectedInserter struct {
+	mu   sync.Mutex
+	rows []database.ExpectedRow
+}
+
+func (ei *expectedInserter) insert(elem element.OSMElem, matches []mapping.Match) error {
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+	for _, match := range matches {
+		ei.rows = append(ei.rows, database.ExpectedRow{
+			Table:  match.Table.Name,
+			Id:     elem.Id,
+			Values: match.Row(&elem),
+		})
+	}
+	return nil
+}
+
+func (ei *expectedInserter) InsertPoint(elem element.OSMElem, matches []mapping.Match) error {
+	return ei.insert(elem, matches)
+}
+
+func (ei *expectedInserter) InsertLineString(elem element.OSMElem, matches []mapping.Match) error {
+	return ei.insert(elem, matches)
+}
+
+func (ei *expectedInserter) InsertPolygon(elem element.OSMElem, matches []mapping.Match) error {
+	return ei.insert(elem, matches)
+}
+
+// rowIds returns the ids of the rows of the nodes, ways and relations,
+// see element.RelIdOffset.
+func rowIds(singleIdSpace bool, nids, wids, rids []int64) []int64 {
+	ids := make([]int64, 0, len(nids)+len(wids)+len(rids))
+	ids = append(ids, nids...)
+	for _, id := range wids {
+		if singleIdSpace {
+			id = -id
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range rids {
+		if singleIdSpace {
+			id = element.RelIdOffset - id
+		} else {
+			id = -id
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// elementIds returns the ids of the nodes, ways and relations of the row
+// ids, see rowIds. Without single id space, positive row ids are
+// returned as node and as way.
+func elementIds(singleIdSpace bool, ids []int64) (nids, wids, rids []int64) {
+	for _, id := range ids {
+		switch {
+		case singleIdSpace && id <= element.RelIdOffset:
+			rids = append(rids, element.RelIdOffset-id)
+		case singleIdSpace && id < 0:
+			wids = append(wids, -id)
+		case singleIdSpace:
+			nids = append(nids, id)
+		case id < 0:
+			rids = append(rids, -id)
+		default:
+			nids = append(nids, id)
+			wids = append(wids, id)
+		}
+	}
+	return nids, wids, rids
+}
+
+// dependentIds returns the ways and relations that depend on the nodes
+// and ways.
+func dependentIds(diffCache *cache.DiffCache, nids, wids []int64) ([]int64, []int64) {
+	var depWays, depRels []int64
+	for _, id := range nids {
+		depWays = append(depWays, diffCache.Coords.Get(id)...)
+	}
+	for _, id := range append(wids, depWays...) {
+		depRels = append(depRels, diffCache.Ways.Get(id)...)
+	}
+	return depWays, depRels
+}
+
+func uniqueIds(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	result := []int64{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// expectedRows passes all nodes, ways and relations through the writers
+// of the import and returns the rows they would insert.
+func expectedRows(osmCache *cache.OSMCache, tagmapping *mapping.Mapping, limiter *limit.Limiter, srid int,
+	nids, wids, rids []int64) ([]database.ExpectedRow, []string) {
+
+	inserter := &expectedInserter{}
+	var errMu sync.Mutex
+	errors := []string{}
+	errorHandler := func(err error) {
+		errMu.Lock()
+		errors = append(errors, err.Error())
+		errMu.Unlock()
+	}
+
+	progress := stats.NewStatsReporter()
+	defer progress.Stop()
+
+	// relations first, ways that are inserted as part of a multipolygon
+	// are checked against the InsertedWays cache of the import
+	relations := make(chan *element.Relation)
+	relWriter := writer.NewRelationWriter(osmCache, nil, tagmapping.SingleIdSpace, relations, inserter, progress,
+		tagmapping.PolygonMatcher(), tagmapping.StreetMatcher(), srid)
+	ways := make(chan *element.Way)
+	wayWriter := writer.NewWayWriter(osmCache, nil, tagmapping.SingleIdSpace, ways, inserter, progress,
+		tagmapping.PolygonMatcher(), tagmapping.LineStringMatcher(), srid)
+	nodes := make(chan *element.Node)
+	nodeWriter := writer.NewNodeWriter(osmCache, nodes, inserter, progress,
+		tagmapping.PointMatcher(), srid)
+	for _, w := range []*writer.OsmElemWriter{relWriter, wayWriter, nodeWriter} {
+		w.SetLimiter(limiter)
+		w.DisableCacheUpdates()
+		w.SetErrorHandler(errorHandler)
+		w.Start()
+	}
+
+	relFilter := tagmapping.RelationTagFilter()
+	for _, id := range rids {
+		rel, err := osmCache.Relations.GetRelation(id)
+		if err == cache.NotFound {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		relFilter.Filter(&rel.Tags)
+		relations <- rel
+	}
+	close(relations)
+	relWriter.Wait()
+
+	wayFilter := tagmapping.WayTagFilter()
+	for _, id := range wids {
+		way, err := osmCache.Ways.GetWay(id)
+		if err == cache.NotFound {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		wayFilter.Filter(&way.Tags)
+		ways <- way
+	}
+	close(ways)
+	wayWriter.Wait()
+
+	nodeFilter := tagmapping.NodeTagFilter()
+	for _, id := range nids {
+		nd, err := osmCache.Nodes.GetNode(id)
+		if err == cache.NotFound {
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		nodeFilter.Filter(&nd.Tags)
+		nodes <- nd
+	}
+	close(nodes)
+	nodeWriter.Wait()
+
+	return inserter.rows, errors
+}
+
+// verify compares the rows of the nodes, ways and relations in the
+// database with the rows of the current import. With a bbox, all rows
+// that intersect the bbox are compared as well, including rows of
+// elements that are no longer cached.
+func verify(osmCache *cache.OSMCache, diffCache *cache.DiffCache, nids, wids, rids []int64, b *bbox) *verifyResult {
+	if *mappingFile == "" || *connection == "" {
+		log.Fatal("-verify requires -mapping and -connection")
+	}
+	// progress of the writers
+	logging.SetQuiet(true)
+
+	tagmapping, err := mapping.NewMapping(*mappingFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var limiter *limit.Limiter
+	if *limitTo != "" {
+		limiter, err = limit.NewFromSources(*limitTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	conf := database.Config{
+		ConnectionParams: *connection,
+		Srid:             *srid,
+		ImportSchema:     *schema,
+		ProductionSchema: *schema,
+	}
+	db, err := database.Open(conf, tagmapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	verifier, ok := db.(database.Verifier)
+	if !ok {
+		log.Fatal("database does not support -verify")
+	}
+
+	if b != nil {
+		ids, err := verifier.BboxIds(b.minx, b.miny, b.maxx, b.maxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dbNodes, dbWays, dbRels := elementIds(tagmapping.SingleIdSpace, ids)
+		nids = uniqueIds(append(nids, dbNodes...))
+		wids = uniqueIds(append(wids, dbWays...))
+		rids = uniqueIds(append(rids, dbRels...))
+	}
+
+	if *deps {
+		depWays, depRels := dependentIds(diffCache, nids, wids)
+		wids = uniqueIds(append(wids, depWays...))
+		rids = uniqueIds(append(rids, depRels...))
+	}
+
+	rows, errors := expectedRows(osmCache, tagmapping, limiter, *srid, nids, wids, rids)
+
+	// nodes and ways share the row ids without single id space
+	ids := uniqueIds(rowIds(tagmapping.SingleIdSpace, nids, wids, rids))
+	diffs, err := verifier.Verify(ids, rows)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &verifyResult{ExpectedRows: len(rows), Diffs: diffs, Errors: errors}
+}
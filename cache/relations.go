@@ -1,7 +1,6 @@
 package cache
 
 import (
-	levigo "github.com/olehz/hyperleveldb-go"
 	"github.com/olehz/imposm3/cache/binary"
 	"github.com/olehz/imposm3/element"
 )
@@ -29,11 +28,11 @@ func (p *RelationsCache) PutRelation(relation *element.Relation) error {
 	if err != nil {
 		return err
 	}
-	return p.db.Put(p.wo, keyBuf, data)
+	return p.db.Put(keyBuf, data)
 }
 
 func (p *RelationsCache) PutRelations(rels []element.Relation) error {
-	batch := levigo.NewWriteBatch()
+	batch := p.db.NewBatch()
 	defer batch.Close()
 
 	for _, rel := range rels {
@@ -50,22 +49,19 @@ func (p *RelationsCache) PutRelations(rels []element.Relation) error {
 		}
 		batch.Put(keyBuf, data)
 	}
-	return p.db.Write(p.wo, batch)
+	return p.db.Write(batch)
 }
 
 func (p *RelationsCache) Iter() chan *element.Relation {
 	rels := make(chan *element.Relation)
 	go func() {
-		ro := levigo.NewReadOptions()
-		ro.SetFillCache(false)
-		it := p.db.NewIterator(ro)
+		it := p.db.Iterate()
 		// we need to Close the iter before closing the
 		// chan (and thus signaling that we are done)
 		// to avoid race where db is closed before the iterator
 		defer close(rels)
 		defer it.Close()
-		it.SeekToFirst()
-		for ; it.Valid(); it.Next() {
+		for it.Next() {
 			rel, err := binary.UnmarshalRelation(it.Value())
 			if err != nil {
 				panic(err)
@@ -80,7 +76,7 @@ func (p *RelationsCache) Iter() chan *element.Relation {
 
 func (p *RelationsCache) GetRelation(id int64) (*element.Relation, error) {
 	keyBuf := idToKeyBuf(id)
-	data, err := p.db.Get(p.ro, keyBuf)
+	data, err := p.db.Get(keyBuf)
 	if err != nil {
 		return nil, err
 	}
@@ -97,5 +93,5 @@ func (p *RelationsCache) GetRelation(id int64) (*element.Relation, error) {
 
 func (p *RelationsCache) DeleteRelation(id int64) error {
 	keyBuf := idToKeyBuf(id)
-	return p.db.Delete(p.wo, keyBuf)
+	return p.db.Delete(keyBuf)
 }
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	bin "encoding/binary"
+	"os"
+	"path/filepath"
+)
+
+// Stats are the statistics of a single sub-cache.
+type Stats struct {
+	Name string
+	// Entries is the number of LevelDB entries.
+	Entries int64
+	// Elements is the number of cached elements. Coords and the diff
+	// indices store multiple elements in a single entry.
+	Elements int64
+	// Size is the size of all files in bytes.
+	Size int64
+}
+
+// bunchLength returns the number of elements of packed coords or
+// IdRefs bunches. Both are prefixed with the number of elements.
+func bunchLength(value []byte) int64 {
+	length, n := bin.Uvarint(value)
+	if n <= 0 {
+		return 0
+	}
+	return int64(length)
+}
+
+// stats iterates over all entries. elements returns the number of
+// elements for each value, all entries count as one element if nil.
+func (c *cache) stats(name string, elements func(value []byte) int64) (Stats, error) {
+	stats := Stats{Name: name}
+
+	it := c.db.Iterate()
+	defer it.Close()
+	for it.Next() {
+		stats.Entries += 1
+		if elements != nil {
+			stats.Elements += elements(it.Value())
+		}
+	}
+	if err := it.Error(); err != nil {
+		return stats, err
+	}
+	if elements == nil {
+		stats.Elements = stats.Entries
+	}
+
+	err := filepath.Walk(c.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			stats.Size += info.Size()
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Stats returns the statistics of all sub-caches.
+func (c *OSMCache) Stats() ([]Stats, error) {
+	c.Coords.Flush()
+	var result []Stats
+	for _, s := range []struct {
+		name     string
+		cache    *cache
+		elements func([]byte) int64
+	}{
+		{"coords", &c.Coords.cache, bunchLength},
+		{"nodes", &c.Nodes.cache, nil},
+		{"ways", &c.Ways.cache, nil},
+		{"relations", &c.Relations.cache, nil},
+		{"inserted_ways", &c.InsertedWays.cache, nil},
+	} {
+		stats, err := s.cache.stats(s.name, s.elements)
+		if err != nil {
+			return nil, err
+		}
+		if s.cache == &c.Coords.cache && c.Coords.flat != nil {
+			stats.Elements += c.Coords.flat.count()
+		}
+		result = append(result, stats)
+	}
+	return result, nil
+}
+
+// Compact compacts all sub-caches.
+func (c *OSMCache) Compact() {
+	c.Coords.Flush()
+	for _, cache := range []*cache{
+		&c.Coords.cache, &c.Nodes.cache, &c.Ways.cache,
+		&c.Relations.cache, &c.InsertedWays.cache,
+	} {
+		cache.db.Compact()
+	}
+}
+
+// Stats returns the statistics of all indices.
+func (c *DiffCache) Stats() ([]Stats, error) {
+	coords, err := c.Coords.stats("coords_index", bunchLength)
+	if err != nil {
+		return nil, err
+	}
+	ways, err := c.Ways.stats("ways_index", bunchLength)
+	if err != nil {
+		return nil, err
+	}
+	return []Stats{coords, ways}, nil
+}
+
+// Compact compacts all indices.
+func (c *DiffCache) Compact() {
+	c.Coords.db.Compact()
+	c.Ways.db.Compact()
+}
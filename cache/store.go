@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Store is a key-value store with sorted keys. Each sub-cache uses its
+// own Store.
+type Store interface {
+	// Get returns the value of key or nil if the key does not exist.
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	NewBatch() Batch
+	Write(batch Batch) error
+	// Iterate returns an Iterator over all entries, sorted by key.
+	// Iterate does not fill the read cache.
+	Iterate() Iterator
+	// Compact removes deleted and overwritten entries from disk.
+	Compact()
+	Close()
+}
+
+// Batch collects multiple updates that are written at once with
+// Store.Write.
+type Batch interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+	Close()
+}
+
+// Iterator iterates over all entries of a Store. Next needs to be
+// called before the first entry. Key and Value are only valid until the
+// next call of Next.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Close()
+}
+
+type storeOpener func(path string, opts *cacheOptions) (Store, error)
+
+var stores = make(map[string]storeOpener)
+
+func registerStore(name string, open storeOpener) {
+	stores[name] = open
+}
+
+// Backends returns the names of all available cache backends.
+func Backends() []string {
+	var names []string
+	for name := range stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetBackend sets the backend for all caches that are opened
+// afterwards.
+func SetBackend(name string) error {
+	if _, ok := stores[name]; !ok {
+		return fmt.Errorf("unknown cache backend '%s', available: %v", name, Backends())
+	}
+	globalCacheOptions.Backend = name
+	return nil
+}
+
+// backend returns the configured backend, leveldb if available or the
+// pure Go goleveldb otherwise.
+func backend() string {
+	if globalCacheOptions.Backend != "" {
+		return globalCacheOptions.Backend
+	}
+	if _, ok := stores["leveldb"]; ok {
+		return "leveldb"
+	}
+	return "goleveldb"
+}
+
+func openStore(path string, opts *cacheOptions) (Store, error) {
+	name := backend()
+	open, ok := stores[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cache backend '%s', available: %v", name, Backends())
+	}
+	return open(path, opts)
+}
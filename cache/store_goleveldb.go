@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// goleveldbStore is a Store with the pure Go LevelDB implementation.
+// The files are not compatible with the leveldb Store.
+//
+// goleveldb is vendored in Godeps with github.com/golang/snappy, which
+// its table package requires for the block compression. Both are only
+// needed for this backend and allow builds without cgo and the LevelDB
+// C++ library (see the noleveldb build tag).
+type goleveldbStore struct {
+	db *leveldb.DB
+	wo *opt.WriteOptions
+}
+
+func openGoleveldbStore(path string, o *cacheOptions) (Store, error) {
+	opts := &opt.Options{
+		BlockCacheCapacity:     o.CacheSizeM * opt.MiB,
+		OpenFilesCacheCapacity: o.MaxOpenFiles,
+		BlockRestartInterval:   o.BlockRestartInterval,
+		WriteBuffer:            o.WriteBufferSizeM * opt.MiB,
+		BlockSize:              o.BlockSizeK * opt.KiB,
+	}
+	db, err := leveldb.OpenFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &goleveldbStore{db: db, wo: &opt.WriteOptions{Sync: o.Sync}}, nil
+}
+
+func (s *goleveldbStore) Get(key []byte) ([]byte, error) {
+	data, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *goleveldbStore) Put(key, value []byte) error {
+	return s.db.Put(key, value, s.wo)
+}
+
+func (s *goleveldbStore) Delete(key []byte) error {
+	return s.db.Delete(key, s.wo)
+}
+
+func (s *goleveldbStore) NewBatch() Batch {
+	return goleveldbBatch{&leveldb.Batch{}}
+}
+
+func (s *goleveldbStore) Write(batch Batch) error {
+	return s.db.Write(batch.(goleveldbBatch).Batch, s.wo)
+}
+
+func (s *goleveldbStore) Iterate() Iterator {
+	return goleveldbIterator{s.db.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})}
+}
+
+func (s *goleveldbStore) Compact() {
+	s.db.CompactRange(util.Range{})
+}
+
+func (s *goleveldbStore) Close() {
+	if s.db != nil {
+		s.db.Close()
+		s.db = nil
+	}
+}
+
+type goleveldbBatch struct {
+	*leveldb.Batch
+}
+
+func (b goleveldbBatch) Close() {}
+
+type goleveldbIterator struct {
+	iterator.Iterator
+}
+
+func (i goleveldbIterator) Close() {
+	i.Release()
+}
+
+func init() {
+	registerStore("goleveldb", openGoleveldbStore)
+}
@@ -0,0 +1,134 @@
+//go:build !noleveldb
+// +build !noleveldb
+
+package cache
+
+import (
+	levigo "github.com/olehz/hyperleveldb-go"
+)
+
+// leveldbStore is a Store with the (Hyper)LevelDB C++ library.
+type leveldbStore struct {
+	db    *levigo.DB
+	cache *levigo.Cache
+	wo    *levigo.WriteOptions
+	ro    *levigo.ReadOptions
+}
+
+func openLeveldbStore(path string, o *cacheOptions) (Store, error) {
+	s := &leveldbStore{}
+	opts := levigo.NewOptions()
+	defer opts.Close()
+	opts.SetCreateIfMissing(true)
+	if o.CacheSizeM > 0 {
+		s.cache = levigo.NewLRUCache(o.CacheSizeM * 1024 * 1024)
+		opts.SetCache(s.cache)
+	}
+	if o.MaxOpenFiles > 0 {
+		opts.SetMaxOpenFiles(o.MaxOpenFiles)
+	}
+	if o.BlockRestartInterval > 0 {
+		opts.SetBlockRestartInterval(o.BlockRestartInterval)
+	}
+	if o.WriteBufferSizeM > 0 {
+		opts.SetWriteBufferSize(o.WriteBufferSizeM * 1024 * 1024)
+	}
+	if o.BlockSizeK > 0 {
+		opts.SetBlockSize(o.BlockSizeK * 1024)
+	}
+
+	db, err := levigo.Open(path, opts)
+	if err != nil {
+		if s.cache != nil {
+			s.cache.Close()
+		}
+		return nil, err
+	}
+	s.db = db
+	s.wo = levigo.NewWriteOptions()
+	s.wo.SetSync(o.Sync)
+	s.ro = levigo.NewReadOptions()
+	return s, nil
+}
+
+func (s *leveldbStore) Get(key []byte) ([]byte, error) {
+	return s.db.Get(s.ro, key)
+}
+
+func (s *leveldbStore) Put(key, value []byte) error {
+	return s.db.Put(s.wo, key, value)
+}
+
+func (s *leveldbStore) Delete(key []byte) error {
+	return s.db.Delete(s.wo, key)
+}
+
+func (s *leveldbStore) NewBatch() Batch {
+	return leveldbBatch{levigo.NewWriteBatch()}
+}
+
+func (s *leveldbStore) Write(batch Batch) error {
+	return s.db.Write(s.wo, batch.(leveldbBatch).WriteBatch)
+}
+
+func (s *leveldbStore) Iterate() Iterator {
+	ro := levigo.NewReadOptions()
+	ro.SetFillCache(false)
+	it := s.db.NewIterator(ro)
+	it.SeekToFirst()
+	return &leveldbIterator{it: it, ro: ro}
+}
+
+func (s *leveldbStore) Compact() {
+	s.db.CompactRange(levigo.Range{})
+}
+
+func (s *leveldbStore) Close() {
+	if s.ro != nil {
+		s.ro.Close()
+		s.ro = nil
+	}
+	if s.wo != nil {
+		s.wo.Close()
+		s.wo = nil
+	}
+	if s.db != nil {
+		s.db.Close()
+		s.db = nil
+	}
+	if s.cache != nil {
+		s.cache.Close()
+		s.cache = nil
+	}
+}
+
+type leveldbBatch struct {
+	*levigo.WriteBatch
+}
+
+type leveldbIterator struct {
+	it      *levigo.Iterator
+	ro      *levigo.ReadOptions
+	started bool
+}
+
+func (i *leveldbIterator) Next() bool {
+	if i.started {
+		i.it.Next()
+	}
+	i.started = true
+	return i.it.Valid()
+}
+
+func (i *leveldbIterator) Key() []byte   { return i.it.Key() }
+func (i *leveldbIterator) Value() []byte { return i.it.Value() }
+func (i *leveldbIterator) Error() error  { return i.it.GetError() }
+
+func (i *leveldbIterator) Close() {
+	i.it.Close()
+	i.ro.Close()
+}
+
+func init() {
+	registerStore("leveldb", openLeveldbStore)
+}
@@ -0,0 +1,15 @@
+package cache
+
+import "testing"
+
+// testBackends runs test as subtest for each available backend.
+func testBackends(t *testing.T, test func(t *testing.T)) {
+	prev := globalCacheOptions.Backend
+	defer func() { globalCacheOptions.Backend = prev }()
+	for _, name := range Backends() {
+		if err := SetBackend(name); err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, test)
+	}
+}
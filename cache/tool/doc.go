@@ -0,0 +1,5 @@
+/*
+Package tool provides the cache sub command to inspect, verify and compact
+the cache.
+*/
+package tool
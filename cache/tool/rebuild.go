@@ -0,0 +1,109 @@
+package tool
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/mapping"
+	"github.com/olehz/imposm3/stats"
+)
+
+// rebuildDiffCache removes the diff cache and recreates the coords and
+// ways index from all ways and relations in the osm cache. Ways and
+// relations are filtered like the way and relation writers do.
+func rebuildDiffCache(osmCache *cache.OSMCache, diffCache *cache.DiffCache, m *mapping.Mapping) error {
+	if err := diffCache.Remove(); err != nil {
+		return err
+	}
+	if err := diffCache.Open(); err != nil {
+		return err
+	}
+	diffCache.Coords.SetLinearImport(true)
+	diffCache.Ways.SetLinearImport(true)
+	osmCache.Coords.SetReadOnly(true)
+
+	progress := stats.NewStatsReporter()
+	polygonMatcher := m.PolygonMatcher()
+
+	worker := runtime.NumCPU()
+	wg := sync.WaitGroup{}
+
+	ways := osmCache.Ways.Iter()
+	for i := 0; i < worker; i++ {
+		wg.Add(1)
+		go func() {
+			for w := range ways {
+				progress.AddWays(1)
+				if len(w.Tags) == 0 {
+					continue
+				}
+				if err := osmCache.Coords.FillWay(w); err != nil {
+					continue
+				}
+				diffCache.Coords.AddFromWay(w)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	rels := osmCache.Relations.Iter()
+	for i := 0; i < worker; i++ {
+		wg.Add(1)
+		go func() {
+			for r := range rels {
+				progress.AddRelations(1)
+				if !indexRelation(r, polygonMatcher) {
+					continue
+				}
+				if err := fillRelation(osmCache, r); err != nil {
+					continue
+				}
+				diffCache.Ways.AddFromMembers(r.Id, r.Members)
+				for _, member := range r.Members {
+					if member.Way != nil {
+						diffCache.Coords.AddFromWay(member.Way)
+					}
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	progress.Stop()
+	diffCache.Coords.SetLinearImport(false)
+	diffCache.Ways.SetLinearImport(false)
+	return nil
+}
+
+// indexRelation returns true for all multipolygon and boundary relations
+// that match the mapping. Relations with only a type tag are included
+// since they can take the tags of their outer ways.
+func indexRelation(r *element.Relation, matcher mapping.RelWayMatcher) bool {
+	if !(r.Tags["type"] == "boundary" || r.Tags["type"] == "multipolygon") {
+		return false
+	}
+	if len(r.Tags) == 1 {
+		return true
+	}
+	return len(matcher.MatchRelation(r)) > 0
+}
+
+// fillRelation fills all member ways with their coords.
+func fillRelation(osmCache *cache.OSMCache, r *element.Relation) error {
+	if err := osmCache.Ways.FillMembers(r.Members); err != nil {
+		return err
+	}
+	for _, m := range r.Members {
+		if m.Way == nil {
+			continue
+		}
+		if err := osmCache.Coords.FillWay(m.Way); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package tool
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/mapping"
+)
+
+func TestIndexRelation(t *testing.T) {
+	m, err := mapping.NewMapping("../../mapping/test_mapping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := m.PolygonMatcher()
+
+	for _, test := range []struct {
+		tags     element.Tags
+		expected bool
+	}{
+		{element.Tags{"type": "multipolygon"}, true},
+		{element.Tags{"type": "multipolygon", "natural": "wood"}, true},
+		{element.Tags{"type": "boundary", "boundary": "administrative"}, true},
+		{element.Tags{"type": "multipolygon", "natural": "unknown"}, false},
+		{element.Tags{"type": "route", "natural": "wood"}, false},
+		{element.Tags{"natural": "wood"}, false},
+	} {
+		r := &element.Relation{OSMElem: element.OSMElem{Id: 1, Tags: test.tags}}
+		if indexRelation(r, matcher) != test.expected {
+			t.Errorf("%v: expected %v", test.tags, test.expected)
+		}
+	}
+}
+
+func TestRebuildDiffCache(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cacheDir)
+
+	m, err := mapping.NewMapping("../../mapping/test_mapping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	osmCache := cache.NewOSMCache(cacheDir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer osmCache.Close()
+
+	var nodes []element.Node
+	for id := int64(1); id <= 9; id++ {
+		nodes = append(nodes, element.Node{OSMElem: element.OSMElem{Id: id}, Long: float64(id), Lat: float64(id % 3)})
+	}
+	osmCache.Coords.PutCoords(nodes)
+	for _, w := range []*element.Way{
+		// tagged way
+		{OSMElem: element.OSMElem{Id: 10, Tags: element.Tags{"highway": "path"}}, Refs: []int64{1, 2}},
+		// untagged way, only indexed as member of a matching relation
+		{OSMElem: element.OSMElem{Id: 11}, Refs: []int64{3, 4, 5, 3}},
+		// untagged way, member of a relation that does not match
+		{OSMElem: element.OSMElem{Id: 12}, Refs: []int64{6, 7, 8, 6}},
+		// tagged way with missing coords
+		{OSMElem: element.OSMElem{Id: 13, Tags: element.Tags{"highway": "path"}}, Refs: []int64{9, 99}},
+	} {
+		if err := osmCache.Ways.PutWay(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []*element.Relation{
+		{
+			OSMElem: element.OSMElem{Id: 20, Tags: element.Tags{"type": "multipolygon", "natural": "wood"}},
+			Members: []element.Member{{Id: 11, Type: element.WAY, Role: "outer"}, {Id: 1, Type: element.NODE}},
+		},
+		{
+			OSMElem: element.OSMElem{Id: 21, Tags: element.Tags{"type": "multipolygon", "natural": "unknown"}},
+			Members: []element.Member{{Id: 12, Type: element.WAY, Role: "outer"}},
+		},
+		{
+			OSMElem: element.OSMElem{Id: 22, Tags: element.Tags{"type": "route"}},
+			Members: []element.Member{{Id: 10, Type: element.WAY}},
+		},
+	} {
+		if err := osmCache.Relations.PutRelation(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diffCache := cache.NewDiffCache(cacheDir)
+	defer diffCache.Close()
+	if err := rebuildDiffCache(osmCache, diffCache, m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		node int64
+		ways []int64
+	}{
+		{1, []int64{10}},
+		{2, []int64{10}},
+		{3, []int64{11}},
+		{5, []int64{11}},
+		{6, nil},
+		{9, nil},
+	} {
+		if refs := diffCache.Coords.Get(test.node); !equalIds(refs, test.ways) {
+			t.Errorf("node %d: %v != %v", test.node, refs, test.ways)
+		}
+	}
+	for _, test := range []struct {
+		way  int64
+		rels []int64
+	}{
+		{10, nil},
+		{11, []int64{20}},
+		{12, nil},
+	} {
+		if refs := diffCache.Ways.Get(test.way); !equalIds(refs, test.rels) {
+			t.Errorf("way %d: %v != %v", test.way, refs, test.rels)
+		}
+	}
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
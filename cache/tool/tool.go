@@ -0,0 +1,165 @@
+package tool
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/config"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/mapping"
+)
+
+var flags = flag.NewFlagSet("cache", flag.ExitOnError)
+
+var (
+	opts = config.AddToolFlags(flags)
+
+	maxMessages = flags.Int("maxerrors", 100, "max. number of reported inconsistencies")
+	mappingFile = flags.String("mapping", "", "mapping file (required for rebuild-diff, verify checks relations in the diff index with it)")
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s %s stats|verify|compact|rebuild-diff:\n\n", os.Args[0], os.Args[1])
+	flags.PrintDefaults()
+	fmt.Fprintln(os.Stderr, `
+stats:   print the number of entries and the size of each cache
+verify:  check that all way refs and the diff indices are consistent
+compact: compact all caches
+rebuild-diff: recreate the diff cache from all cached ways and relations`)
+	os.Exit(1)
+}
+
+func Tool(args []string) {
+	flags.Usage = Usage
+
+	// flags are allowed before and after the sub command
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flags.NArg() == 0 {
+		Usage()
+	}
+	subcmd := flags.Arg(0)
+	err = flags.Parse(flags.Args()[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetFlags(0)
+	log.SetOutput(os.Stdout)
+	if err := opts.Configure(); err != nil {
+		log.Fatal(err)
+	}
+
+	osmCache := cache.NewOSMCache(opts.CacheDir)
+	if !osmCache.Exists() {
+		log.Fatalf("no cache found in %s", opts.CacheDir)
+	}
+	err = osmCache.OpenExisting()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer osmCache.Close()
+
+	// the diff cache is optional
+	var diffCache *cache.DiffCache
+	if dc := cache.NewDiffCache(opts.CacheDir); dc.Exists() {
+		if err := dc.Open(); err != nil {
+			log.Fatal(err)
+		}
+		defer dc.Close()
+		diffCache = dc
+	}
+
+	switch subcmd {
+	case "stats":
+		stats, err := osmCache.Stats()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if diffCache != nil {
+			diffStats, err := diffCache.Stats()
+			if err != nil {
+				log.Fatal(err)
+			}
+			stats = append(stats, diffStats...)
+		}
+		printStats(stats)
+	case "verify":
+		// only relations that match the mapping are in the ways index
+		var indexed func(*element.Relation) bool
+		if *mappingFile != "" {
+			m, err := mapping.NewMapping(*mappingFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			matcher := m.PolygonMatcher()
+			indexed = func(r *element.Relation) bool {
+				return indexRelation(r, matcher)
+			}
+		} else if diffCache != nil {
+			log.Println("relations are only checked in the ways index with -mapping")
+		}
+		report, err := cache.Verify(osmCache, diffCache, *maxMessages, indexed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, msg := range report.Messages {
+			log.Println(msg)
+		}
+		log.Printf("checked %d ways, %d relations, %d coords index and %d ways index entries: %d errors",
+			report.Ways, report.Relations, report.CoordsRefs, report.WaysRefs, report.Errors)
+		if report.Errors > 0 {
+			osmCache.Close()
+			if diffCache != nil {
+				diffCache.Close()
+			}
+			os.Exit(2)
+		}
+	case "rebuild-diff":
+		if *mappingFile == "" {
+			log.Fatal("missing -mapping")
+		}
+		m, err := mapping.NewMapping(*mappingFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if diffCache == nil {
+			diffCache = cache.NewDiffCache(opts.CacheDir)
+			defer diffCache.Close()
+		}
+		log.Println("rebuilding diff cache")
+		if err := rebuildDiffCache(osmCache, diffCache, m); err != nil {
+			log.Fatal(err)
+		}
+		stats, err := diffCache.Stats()
+		if err != nil {
+			log.Fatal(err)
+		}
+		printStats(stats)
+	case "compact":
+		log.Println("compacting cache")
+		osmCache.Compact()
+		if diffCache != nil {
+			log.Println("compacting diff cache")
+			diffCache.Compact()
+		}
+	default:
+		Usage()
+	}
+}
+
+func printStats(stats []cache.Stats) {
+	var entries, elements, size int64
+	log.Printf("%-15s %15s %15s %12s", "cache", "entries", "elements", "size (MB)")
+	for _, s := range stats {
+		log.Printf("%-15s %15d %15d %12.1f", s.Name, s.Entries, s.Elements, float64(s.Size)/1024/1024)
+		entries += s.Entries
+		elements += s.Elements
+		size += s.Size
+	}
+	log.Printf("%-15s %15d %15d %12.1f", "total", entries, elements, float64(size)/1024/1024)
+}
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/olehz/imposm3/element"
+)
+
+// VerifyReport contains the result of Verify.
+type VerifyReport struct {
+	Ways       int64
+	Relations  int64
+	CoordsRefs int64
+	WaysRefs   int64
+	// Errors is the number of all inconsistencies, Messages only
+	// contains the first few.
+	Errors   int64
+	Messages []string
+}
+
+func (r *VerifyReport) addError(maxMessages int, format string, args ...interface{}) {
+	r.Errors += 1
+	if len(r.Messages) < maxMessages {
+		r.Messages = append(r.Messages, fmt.Sprintf(format, args...))
+	}
+}
+
+// Verify checks that all way refs resolve in the coords cache. If
+// diffCache is not nil, it also checks that all entries of the diff
+// indices refer to existing ways/relations that contain the node/way,
+// and that the nodes of all ways with tags are in the coords index. The
+// way members of relations are checked in the ways index for all
+// relations where indexed returns true, if indexed is not nil.
+func Verify(osmCache *OSMCache, diffCache *DiffCache, maxMessages int, indexed func(*element.Relation) bool) (*VerifyReport, error) {
+	report := &VerifyReport{}
+
+	for way := range osmCache.Ways.Iter() {
+		report.Ways += 1
+		storedNodes := len(way.Refs) > 0 && len(way.Nodes) == len(way.Refs)
+		for _, ref := range way.Refs {
+			if !storedNodes {
+				if _, err := osmCache.Coords.GetCoord(ref); err == NotFound {
+					report.addError(maxMessages, "way %d: missing node %d in coords", way.Id, ref)
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+			}
+			// ways are only indexed if they have tags, the way id is
+			// negative with a single id space
+			if diffCache != nil && len(way.Tags) > 0 {
+				refs := diffCache.Coords.Get(ref)
+				if !containsId(refs, way.Id) && !containsId(refs, -way.Id) {
+					report.addError(maxMessages, "way %d: missing in coords index of node %d", way.Id, ref)
+				}
+			}
+		}
+	}
+
+	if diffCache == nil {
+		return report, nil
+	}
+
+	if indexed != nil {
+		for rel := range osmCache.Relations.Iter() {
+			report.Relations += 1
+			if !indexed(rel) {
+				continue
+			}
+			for _, m := range rel.Members {
+				if m.Type != element.WAY {
+					continue
+				}
+				if !containsId(diffCache.Ways.Get(m.Id), rel.Id) {
+					report.addError(maxMessages, "relation %d: missing in ways index of way %d", rel.Id, m.Id)
+				}
+			}
+		}
+	}
+
+	for idRefs := range diffCache.Coords.Iter() {
+		report.CoordsRefs += 1
+		if _, err := osmCache.Coords.GetCoord(idRefs.Id); err == NotFound {
+			report.addError(maxMessages, "coords index: missing node %d in coords", idRefs.Id)
+		} else if err != nil {
+			return nil, err
+		}
+		for _, wayId := range idRefs.Refs {
+			way, err := osmCache.Ways.GetWay(wayId)
+			if err == NotFound {
+				report.addError(maxMessages, "coords index: node %d refers to missing way %d", idRefs.Id, wayId)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if !containsId(way.Refs, idRefs.Id) {
+				report.addError(maxMessages, "coords index: node %d is not a ref of way %d", idRefs.Id, wayId)
+			}
+		}
+	}
+
+	for idRefs := range diffCache.Ways.Iter() {
+		report.WaysRefs += 1
+		for _, relId := range idRefs.Refs {
+			rel, err := osmCache.Relations.GetRelation(relId)
+			if err == NotFound {
+				report.addError(maxMessages, "ways index: way %d refers to missing relation %d", idRefs.Id, relId)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			member := false
+			for _, m := range rel.Members {
+				if m.Type == element.WAY && m.Id == idRefs.Id {
+					member = true
+					break
+				}
+			}
+			if !member {
+				report.addError(maxMessages, "ways index: way %d is not a member of relation %d", idRefs.Id, relId)
+			}
+		}
+	}
+	return report, nil
+}
+
+func containsId(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
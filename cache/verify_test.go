@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/olehz/imposm3/element"
+)
+
+func TestVerify(t *testing.T) {
+	testBackends(t, testVerify)
+}
+
+func testVerify(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	osmCache := NewOSMCache(cache_dir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer osmCache.Close()
+	diffCache := NewDiffCache(cache_dir)
+	if err := diffCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer diffCache.Close()
+
+	osmCache.Coords.PutCoords([]element.Node{
+		{OSMElem: element.OSMElem{Id: 1}},
+		{OSMElem: element.OSMElem{Id: 2}},
+	})
+	osmCache.Ways.PutWay(&element.Way{OSMElem: element.OSMElem{Id: 10}, Refs: []int64{1, 2}})
+	diffCache.Coords.Add(1, 10)
+	diffCache.Coords.Add(2, 10)
+
+	report, err := Verify(osmCache, diffCache, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors != 0 || report.Ways != 1 || report.CoordsRefs != 2 {
+		t.Errorf("unexpected report %#v", report)
+	}
+
+	// way with missing node, index entry for missing way
+	osmCache.Ways.PutWay(&element.Way{OSMElem: element.OSMElem{Id: 11}, Refs: []int64{1, 3}})
+	diffCache.Coords.Add(2, 12)
+
+	report, err = Verify(osmCache, diffCache, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors != 2 || len(report.Messages) != 1 {
+		t.Errorf("unexpected report %#v", report)
+	}
+
+	stats, err := osmCache.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[0].Name != "coords" || stats[0].Entries != 1 || stats[0].Elements != 2 {
+		t.Errorf("unexpected coords stats %#v", stats[0])
+	}
+	if stats[2].Name != "ways" || stats[2].Elements != 2 {
+		t.Errorf("unexpected ways stats %#v", stats[2])
+	}
+
+	// ways with tags need to be in the coords index, way members of
+	// indexed relations in the ways index
+	osmCache.Ways.PutWay(&element.Way{OSMElem: element.OSMElem{Id: 13, Tags: element.Tags{"highway": "path"}}, Refs: []int64{1, 2}})
+	diffCache.Coords.Add(1, 13)
+	osmCache.Relations.PutRelation(&element.Relation{
+		OSMElem: element.OSMElem{Id: 20, Tags: element.Tags{"type": "multipolygon"}},
+		Members: []element.Member{{Id: 10, Type: element.WAY}, {Id: 13, Type: element.WAY}, {Id: 1, Type: element.NODE}},
+	})
+	diffCache.Ways.Add(10, 20)
+
+	report, err = Verify(osmCache, diffCache, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors != 3 || report.Relations != 0 {
+		t.Errorf("unexpected report %#v", report)
+	}
+	if report.Messages[1] != "way 13: missing in coords index of node 2" {
+		t.Errorf("unexpected messages %q", report.Messages)
+	}
+	report, err = Verify(osmCache, diffCache, 10, func(*element.Relation) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors != 4 || report.Relations != 1 {
+		t.Errorf("unexpected report %#v", report)
+	}
+	if report.Messages[2] != "relation 20: missing in ways index of way 13" {
+		t.Errorf("unexpected messages %q", report.Messages)
+	}
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	bin "encoding/binary"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/olehz/imposm3/element"
+)
+
+// VersionsCache stores the version of all read elements. It is used to
+// skip duplicate or older elements when multiple files or history files
+// are read into the same cache.
+type VersionsCache struct {
+	cache
+	dir      string
+	snapshot time.Time
+}
+
+// NewVersionsCache returns a new VersionsCache in the versions
+// directory of dir.
+func NewVersionsCache(dir string) *VersionsCache {
+	return &VersionsCache{dir: dir}
+}
+
+func (c *VersionsCache) Open() error {
+	c.options = &globalCacheOptions.Versions
+	return c.open(filepath.Join(c.dir, "versions"))
+}
+
+// SetSnapshot skips all elements with a timestamp after t.
+func (c *VersionsCache) SetSnapshot(t time.Time) {
+	c.snapshot = t
+}
+
+func (c *VersionsCache) Remove() error {
+	c.Close()
+	return os.RemoveAll(filepath.Join(c.dir, "versions"))
+}
+
+// Key prefixes for the different element types. Nodes are handled
+// together with the coords.
+const (
+	coordVersion    = 'c'
+	wayVersion      = 'w'
+	relationVersion = 'r'
+)
+
+func versionKey(kind byte, id int64) []byte {
+	key := make([]byte, 9)
+	key[0] = kind
+	bin.BigEndian.PutUint64(key[1:], uint64(id))
+	return key
+}
+
+// newer returns whether the element is newer than the stored element
+// with the same id and adds the version to batch in this case. seen
+// contains the versions of the current batch, which are not stored yet.
+// replaced is true if there was a stored element. Elements without
+// metadata are only newer if they are not stored.
+func (c *VersionsCache) newer(batch Batch, seen map[int64]int64, kind byte, elem *element.OSMElem) (newer, replaced bool, err error) {
+	var version int32
+	if elem.Metadata != nil {
+		version = elem.Metadata.Version
+	}
+	key := versionKey(kind, elem.Id)
+	if stored, ok := seen[elem.Id]; ok {
+		if int64(version) <= stored {
+			return false, false, nil
+		}
+	} else {
+		data, err := c.db.Get(key)
+		if err != nil {
+			return false, false, err
+		}
+		if data != nil {
+			stored, _ := bin.Varint(data)
+			if int64(version) <= stored {
+				return false, false, nil
+			}
+			replaced = true
+		}
+	}
+	seen[elem.Id] = int64(version)
+	buf := make([]byte, bin.MaxVarintLen32)
+	batch.Put(key, buf[:bin.PutVarint(buf, int64(version))])
+	return true, replaced, nil
+}
+
+// skipOlder sets the Id of all elements to SKIP that are not newer than
+// the stored elements, that are after the snapshot or that are deleted.
+// Older versions of an element in the same elems are skipped as well.
+// It returns the ids of all elements that replace an older element. These
+// need to be removed from the cache, as the new element might be skipped
+// or might not be stored in the same cache (e.g. nodes without tags).
+func (c *VersionsCache) skipOlder(kind byte, elems []*element.OSMElem) ([]int64, error) {
+	batch := c.db.NewBatch()
+	defer batch.Close()
+	seen := make(map[int64]int64)
+	// kept contains the elements that are not skipped by id
+	kept := make(map[int64]*element.OSMElem)
+	var replacedIds []int64
+	for _, elem := range elems {
+		if elem.Id == SKIP {
+			continue
+		}
+		if !c.snapshot.IsZero() && elem.Metadata != nil && elem.Metadata.Timestamp.After(c.snapshot) {
+			elem.Id = SKIP
+			continue
+		}
+		id := elem.Id
+		newer, replaced, err := c.newer(batch, seen, kind, elem)
+		if err != nil {
+			return nil, err
+		}
+		if replaced {
+			replacedIds = append(replacedIds, id)
+		}
+		if newer {
+			if older, ok := kept[id]; ok {
+				older.Id = SKIP
+				delete(kept, id)
+			}
+		}
+		if !newer || (elem.Metadata != nil && !elem.Metadata.Visible) {
+			elem.Id = SKIP
+			continue
+		}
+		kept[id] = elem
+	}
+	return replacedIds, c.db.Write(batch)
+}
+
+// SkipOlderCoords sets the Id of all nodes to SKIP that are not newer
+// than already read coords, see skipOlder.
+func (c *VersionsCache) SkipOlderCoords(nodes []element.Node) ([]int64, error) {
+	elems := make([]*element.OSMElem, len(nodes))
+	for i := range nodes {
+		elems[i] = &nodes[i].OSMElem
+	}
+	return c.skipOlder(coordVersion, elems)
+}
+
+// SkipOlderWays sets the Id of all ways to SKIP that are not newer
+// than already read ways, see skipOlder.
+func (c *VersionsCache) SkipOlderWays(ways []element.Way) ([]int64, error) {
+	elems := make([]*element.OSMElem, len(ways))
+	for i := range ways {
+		elems[i] = &ways[i].OSMElem
+	}
+	return c.skipOlder(wayVersion, elems)
+}
+
+// SkipOlderRelations sets the Id of all relations to SKIP that are not
+// newer than already read relations, see skipOlder.
+func (c *VersionsCache) SkipOlderRelations(rels []element.Relation) ([]int64, error) {
+	elems := make([]*element.OSMElem, len(rels))
+	for i := range rels {
+		elems[i] = &rels[i].OSMElem
+	}
+	return c.skipOlder(relationVersion, elems)
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/olehz/imposm3/element"
+)
+
+func mkway(id int64, version int32, visible bool, timestamp time.Time) element.Way {
+	w := element.Way{}
+	w.Id = id
+	if version != 0 {
+		w.Metadata = &element.Metadata{Version: version, Visible: visible, Timestamp: timestamp}
+	}
+	return w
+}
+
+func checkIds(t *testing.T, ways []element.Way, ids []int64) {
+	for i, id := range ids {
+		if ways[i].Id != id {
+			t.Errorf("unexpected id %d for %d", ways[i].Id, id)
+		}
+	}
+}
+
+func TestVersionsCache(t *testing.T) {
+	testBackends(t, testVersionsCache)
+}
+
+func testVersionsCache(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	versions := NewVersionsCache(cache_dir)
+	if err := versions.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer versions.Close()
+
+	var now time.Time
+	ways := []element.Way{mkway(1, 2, true, now), mkway(2, 1, true, now), mkway(3, 0, true, now), mkway(SKIP, 1, true, now)}
+	replaced, err := versions.SkipOlderWays(ways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, ways, []int64{1, 2, 3, SKIP})
+	if len(replaced) != 0 {
+		t.Error("unexpected replaced ways", replaced)
+	}
+
+	// same or older versions are skipped
+	ways = []element.Way{mkway(1, 2, true, now), mkway(2, 3, true, now), mkway(3, 0, true, now), mkway(4, 1, true, now)}
+	replaced, err = versions.SkipOlderWays(ways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, ways, []int64{SKIP, 2, SKIP, 4})
+	if len(replaced) != 1 || replaced[0] != 2 {
+		t.Error("unexpected replaced ways", replaced)
+	}
+
+	// elements are stored separately for each type
+	nodes := []element.Node{{OSMElem: element.OSMElem{Id: 1}}}
+	if _, err := versions.SkipOlderCoords(nodes); err != nil {
+		t.Fatal(err)
+	}
+	if nodes[0].Id != 1 {
+		t.Error("coord skipped")
+	}
+}
+
+func TestVersionsCacheSnapshot(t *testing.T) {
+	testBackends(t, testVersionsCacheSnapshot)
+}
+
+func testVersionsCacheSnapshot(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	versions := NewVersionsCache(cache_dir)
+	if err := versions.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer versions.Close()
+
+	snapshot := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := snapshot.Add(-time.Hour)
+	after := snapshot.Add(time.Hour)
+	versions.SetSnapshot(snapshot)
+
+	// versions of history files can be in different blocks, in any order
+	ways := []element.Way{mkway(1, 2, true, before), mkway(1, 3, true, after), mkway(2, 1, true, before)}
+	if _, err := versions.SkipOlderWays(ways); err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, ways, []int64{1, SKIP, 2})
+
+	ways = []element.Way{mkway(1, 1, true, before), mkway(2, 2, false, before), mkway(3, 1, false, before)}
+	replaced, err := versions.SkipOlderWays(ways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// way 2 was deleted before the snapshot
+	checkIds(t, ways, []int64{SKIP, SKIP, SKIP})
+	if len(replaced) != 1 || replaced[0] != 2 {
+		t.Error("unexpected replaced ways", replaced)
+	}
+
+	// older version after deletion
+	ways = []element.Way{mkway(3, 0, true, before)}
+	if _, err := versions.SkipOlderWays(ways); err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, ways, []int64{SKIP})
+}
+
+func TestVersionsCacheSameBatch(t *testing.T) {
+	testBackends(t, testVersionsCacheSameBatch)
+}
+
+func testVersionsCacheSameBatch(t *testing.T) {
+	cache_dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cache_dir)
+
+	versions := NewVersionsCache(cache_dir)
+	if err := versions.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer versions.Close()
+
+	var now time.Time
+	// only the newest version of each way is kept, in any order
+	ways := []element.Way{
+		mkway(1, 1, true, now), mkway(1, 2, true, now),
+		mkway(2, 2, true, now), mkway(2, 1, true, now),
+		mkway(3, 1, true, now), mkway(3, 2, false, now),
+	}
+	replaced, err := versions.SkipOlderWays(ways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, ways, []int64{SKIP, 1, 2, SKIP, SKIP, SKIP})
+	if len(replaced) != 0 {
+		t.Error("unexpected replaced ways", replaced)
+	}
+
+	// the newest versions are stored
+	ways = []element.Way{mkway(1, 2, true, now), mkway(2, 2, true, now), mkway(3, 2, true, now)}
+	if _, err := versions.SkipOlderWays(ways); err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, ways, []int64{SKIP, SKIP, SKIP})
+
+	// stored ways are replaced once
+	ways = []element.Way{mkway(1, 3, true, now), mkway(1, 4, true, now)}
+	replaced, err = versions.SkipOlderWays(ways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIds(t, ways, []int64{SKIP, 1})
+	if len(replaced) != 1 || replaced[0] != 1 {
+		t.Error("unexpected replaced ways", replaced)
+	}
+}
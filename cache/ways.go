@@ -1,13 +1,21 @@
 package cache
 
 import (
-	levigo "github.com/olehz/hyperleveldb-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/olehz/imposm3/cache/binary"
 	"github.com/olehz/imposm3/element"
 )
 
+// storeLocationsFile marks a ways cache that stores the nodes of the
+// ways. The coords of these caches are incomplete.
+const storeLocationsFile = "store_locations"
+
 type WaysCache struct {
 	cache
+	storeLocations bool
 }
 
 func newWaysCache(path string) (*WaysCache, error) {
@@ -17,29 +25,60 @@ func newWaysCache(path string) (*WaysCache, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, err := os.Stat(filepath.Join(path, storeLocationsFile)); err == nil {
+		cache.storeLocations = true
+	}
 	return &cache, err
 }
 
+// SetStoreLocations enables storing the nodes of the ways, if they
+// are present. Ways with stored nodes do not require the coords cache.
+// The option is stored with the cache.
+func (p *WaysCache) SetStoreLocations(enabled bool) error {
+	p.storeLocations = enabled
+	filename := filepath.Join(p.path, storeLocationsFile)
+	if !enabled {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(filename, nil, 0644)
+}
+
+// StoresLocations returns whether the nodes of the ways are stored.
+func (p *WaysCache) StoresLocations() bool {
+	return p.storeLocations
+}
+
 func (p *WaysCache) PutWay(way *element.Way) error {
 	if way.Id == SKIP {
 		return nil
 	}
+	if !p.storeLocations && way.Nodes != nil {
+		w := *way
+		w.Nodes = nil
+		way = &w
+	}
 	keyBuf := idToKeyBuf(way.Id)
 	data, err := binary.MarshalWay(way)
 	if err != nil {
 		return err
 	}
-	return p.db.Put(p.wo, keyBuf, data)
+	return p.db.Put(keyBuf, data)
 }
 
 func (p *WaysCache) PutWays(ways []element.Way) error {
-	batch := levigo.NewWriteBatch()
+	batch := p.db.NewBatch()
 	defer batch.Close()
 
 	for _, way := range ways {
 		if way.Id == SKIP {
 			continue
 		}
+		if !p.storeLocations {
+			way.Nodes = nil
+		}
 		keyBuf := idToKeyBuf(way.Id)
 		data, err := binary.MarshalWay(&way)
 		if err != nil {
@@ -47,12 +86,12 @@ func (p *WaysCache) PutWays(ways []element.Way) error {
 		}
 		batch.Put(keyBuf, data)
 	}
-	return p.db.Write(p.wo, batch)
+	return p.db.Write(batch)
 }
 
 func (p *WaysCache) GetWay(id int64) (*element.Way, error) {
 	keyBuf := idToKeyBuf(id)
-	data, err := p.db.Get(p.ro, keyBuf)
+	data, err := p.db.Get(keyBuf)
 	if err != nil {
 		return nil, err
 	}
@@ -69,22 +108,19 @@ func (p *WaysCache) GetWay(id int64) (*element.Way, error) {
 
 func (p *WaysCache) DeleteWay(id int64) error {
 	keyBuf := idToKeyBuf(id)
-	return p.db.Delete(p.wo, keyBuf)
+	return p.db.Delete(keyBuf)
 }
 
 func (p *WaysCache) Iter() chan *element.Way {
 	ways := make(chan *element.Way, 1024)
 	go func() {
-		ro := levigo.NewReadOptions()
-		ro.SetFillCache(false)
-		it := p.db.NewIterator(ro)
+		it := p.db.Iterate()
 		// we need to Close the iter before closing the
 		// chan (and thus signaling that we are done)
 		// to avoid race where db is closed before the iterator
 		defer close(ways)
 		defer it.Close()
-		it.SeekToFirst()
-		for ; it.Valid(); it.Next() {
+		for it.Next() {
 			way, err := binary.UnmarshalWay(it.Value())
 			if err != nil {
 				panic(err)
@@ -143,11 +179,11 @@ func newInsertedWaysCache(path string) (*InsertedWaysCache, error) {
 
 func (p *InsertedWaysCache) PutWay(way *element.Way) error {
 	keyBuf := idToKeyBuf(way.Id)
-	return p.db.Put(p.wo, keyBuf, []byte{})
+	return p.db.Put(keyBuf, []byte{})
 }
 
 func (p *InsertedWaysCache) PutMembers(members []element.Member) error {
-	batch := levigo.NewWriteBatch()
+	batch := p.db.NewBatch()
 	defer batch.Close()
 
 	for _, m := range members {
@@ -157,11 +193,11 @@ func (p *InsertedWaysCache) PutMembers(members []element.Member) error {
 		keyBuf := idToKeyBuf(m.Id)
 		batch.Put(keyBuf, []byte{})
 	}
-	return p.db.Write(p.wo, batch)
+	return p.db.Write(batch)
 }
 
 func (p *InsertedWaysCache) DeleteMembers(members []element.Member) error {
-	batch := levigo.NewWriteBatch()
+	batch := p.db.NewBatch()
 	defer batch.Close()
 
 	for _, m := range members {
@@ -171,12 +207,12 @@ func (p *InsertedWaysCache) DeleteMembers(members []element.Member) error {
 		keyBuf := idToKeyBuf(m.Id)
 		batch.Delete(keyBuf)
 	}
-	return p.db.Write(p.wo, batch)
+	return p.db.Write(batch)
 }
 
 func (p *InsertedWaysCache) IsInserted(id int64) (bool, error) {
 	keyBuf := idToKeyBuf(id)
-	data, err := p.db.Get(p.ro, keyBuf)
+	data, err := p.db.Get(keyBuf)
 	if err != nil {
 		return false, err
 	}
@@ -7,9 +7,12 @@ import (
 	"os"
 	"runtime"
 
+	"github.com/olehz/imposm3/cache/export"
 	"github.com/olehz/imposm3/cache/query"
+	"github.com/olehz/imposm3/cache/tool"
 	"github.com/olehz/imposm3/config"
 	"github.com/olehz/imposm3/diff"
+	"github.com/olehz/imposm3/explain"
 	"github.com/olehz/imposm3/geom/limit"
 	"github.com/olehz/imposm3/import_"
 	"github.com/olehz/imposm3/logging"
@@ -24,9 +27,36 @@ func PrintCmds() {
 	fmt.Println("\timport")
 	fmt.Println("\tdiff")
 	fmt.Println("\tquery-cache")
+	fmt.Println("\texplain")
+	fmt.Println("\tcache")
+	fmt.Println("\texport-pbf")
 	fmt.Println("\tversion")
 }
 
+// configureCache sets the cache options from the config file and the
+// -cachepreset and -cachebackend flags.
+func configureCache() {
+	err := cache.Configure(config.BaseOptions.CachePreset, config.BaseOptions.CacheConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.BaseOptions.CacheBackend != "" {
+		if err := cache.SetBackend(config.BaseOptions.CacheBackend); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// configureLogging sets the log format and level from the -log-format
+// and -log-level flags. The query-cache, explain, cache and export-pbf
+// commands configure the logging and the caches with config.ToolOptions.
+func configureLogging() {
+	err := logging.Configure(config.BaseOptions.LogFormat, config.BaseOptions.LogLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func Main(usage func()) {
 	golog.SetFlags(golog.LstdFlags | golog.Lshortfile)
 	if os.Getenv("GOMAXPROCS") == "" {
@@ -42,26 +72,36 @@ func Main(usage func()) {
 	switch os.Args[1] {
 	case "import":
 		config.ParseImport(os.Args[2:])
+		configureLogging()
 		if config.BaseOptions.Httpprofile != "" {
 			stats.StartHttpPProf(config.BaseOptions.Httpprofile)
 		}
+		if config.BaseOptions.Metrics != "" {
+			stats.StartHttpMetrics(config.BaseOptions.Metrics)
+		}
+		configureCache()
 		import_.Import()
 	case "diff":
 		config.ParseDiffImport(os.Args[2:])
+		configureLogging()
 
 		if config.BaseOptions.Httpprofile != "" {
 			stats.StartHttpPProf(config.BaseOptions.Httpprofile)
 		}
+		if config.BaseOptions.Metrics != "" {
+			stats.StartHttpMetrics(config.BaseOptions.Metrics)
+		}
 
 		if config.BaseOptions.Quiet {
 			logging.SetQuiet(true)
 		}
+		configureCache()
 
 		var geometryLimiter *limit.Limiter
 		if config.BaseOptions.LimitTo != "" {
 			var err error
 			step := log.StartStep("Reading limitto geometries")
-			geometryLimiter, err = limit.NewFromGeoJsonWithBuffered(
+			geometryLimiter, err = limit.NewFromSourcesWithBuffered(
 				config.BaseOptions.LimitTo,
 				config.BaseOptions.LimitToCacheBuffer,
 			)
@@ -71,11 +111,17 @@ func Main(usage func()) {
 			log.StopStep(step)
 		}
 		osmCache := cache.NewOSMCache(config.BaseOptions.CacheDir)
+		if geometryLimiter == nil {
+			geometryLimiter = import_.HeaderLimiter(osmCache)
+		}
 		err := osmCache.Open()
 		if err != nil {
 			log.Fatal("osm cache: ", err)
 		}
 		defer osmCache.Close()
+		if err := osmCache.RequireCoords(); err != nil {
+			log.Fatal(err)
+		}
 
 		diffCache := cache.NewDiffCache(config.BaseOptions.CacheDir)
 		err = diffCache.Open()
@@ -83,13 +129,22 @@ func Main(usage func()) {
 			log.Fatal("diff cache: ", err)
 		}
 
-		for _, oscFile := range config.DiffFlags.Args() {
-			err := diff.Update(oscFile, geometryLimiter, nil, osmCache, diffCache, false)
+		if config.DiffImportOptions.Batch || config.DiffImportOptions.Merge {
+			err := diff.UpdateBatch(config.DiffFlags.Args(), geometryLimiter, nil, osmCache, diffCache, false)
 			if err != nil {
 				osmCache.Close()
 				diffCache.Close()
 				log.Fatal(err)
 			}
+		} else {
+			for _, oscFile := range config.DiffFlags.Args() {
+				err := diff.Update(oscFile, geometryLimiter, nil, osmCache, diffCache, false)
+				if err != nil {
+					osmCache.Close()
+					diffCache.Close()
+					log.Fatal(err)
+				}
+			}
 		}
 		// explicitly Close since os.Exit prevents defers
 		osmCache.Close()
@@ -97,6 +152,12 @@ func Main(usage func()) {
 
 	case "query-cache":
 		query.Query(os.Args[2:])
+	case "explain":
+		explain.Explain(os.Args[2:])
+	case "cache":
+		tool.Tool(os.Args[2:])
+	case "export-pbf":
+		export.Export(os.Args[2:])
 	case "version":
 		fmt.Println(Version)
 		os.Exit(0)
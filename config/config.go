@@ -19,6 +19,8 @@ type Config struct {
 	LimitToCacheBuffer float64 `json:"limitto_cache_buffer"`
 	Srid               int     `json:"srid"`
 	Schemas            Schemas `json:"schemas"`
+	// Cache contains the cache options, see cache.Configure.
+	Cache json.RawMessage `json:"cache"`
 }
 
 type Schemas struct {
@@ -39,13 +41,20 @@ var DiffFlags = flag.NewFlagSet("diff", flag.ExitOnError)
 type _BaseOptions struct {
 	Connection         string
 	CacheDir           string
+	CacheBackend       string
+	CachePreset        string
+	CacheConfig        []byte
 	DiffDir            string
 	MappingFile        string
 	Srid               int
 	LimitTo            string
+	NoLimitTo          bool
 	LimitToCacheBuffer float64
 	ConfigFile         string
 	Httpprofile        string
+	Metrics            string
+	LogFormat          string
+	LogLevel           string
 	Quiet              bool
 	Schemas            Schemas
 }
@@ -57,14 +66,7 @@ func (o *_BaseOptions) updateFromConfig() error {
 	}
 
 	if o.ConfigFile != "" {
-		f, err := os.Open(o.ConfigFile)
-		if err != nil {
-			return err
-		}
-		decoder := json.NewDecoder(f)
-
-		err = decoder.Decode(&conf)
-		if err != nil {
+		if err := readConfig(o.ConfigFile, conf); err != nil {
 			return err
 		}
 	}
@@ -95,8 +97,10 @@ func (o *_BaseOptions) updateFromConfig() error {
 		o.LimitTo = conf.LimitTo
 	}
 	if o.LimitTo == "NONE" {
-		// allow overwrite from cmd line
+		// allow overwrite from cmd line, also disables the default limit
+		// to the bbox from the PBF headers
 		o.LimitTo = ""
+		o.NoLimitTo = true
 	}
 	if o.LimitToCacheBuffer == 0.0 {
 		o.LimitToCacheBuffer = conf.LimitToCacheBuffer
@@ -104,6 +108,7 @@ func (o *_BaseOptions) updateFromConfig() error {
 	if o.CacheDir == defaultCacheDir {
 		o.CacheDir = conf.CacheDir
 	}
+	o.CacheConfig = conf.Cache
 	if o.DiffDir == "" {
 		if conf.DiffDir == "" {
 			// use CacheDir for backwards compatibility
@@ -115,6 +120,16 @@ func (o *_BaseOptions) updateFromConfig() error {
 	return nil
 }
 
+// readConfig decodes the config file into conf.
+func readConfig(path string, conf *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(conf)
+}
+
 func (o *_BaseOptions) check() []error {
 	errs := []error{}
 	if o.Srid != 3857 && o.Srid != 4326 {
@@ -136,23 +151,44 @@ type _ImportOptions struct {
 	DeployProduction bool
 	RevertDeploy     bool
 	RemoveBackup     bool
+	DryRun           bool
 	DiffStateBefore  time.Duration
+	Timestamp        string
+}
+
+type _DiffImportOptions struct {
+	// Batch imports all diff files in a single transaction.
+	Batch bool
+	// Merge merges all diff files into a single set of changes before
+	// the import. Implies Batch.
+	Merge bool
+	// ChangeSink records all changed rows (type:param, e.g.
+	// file:/path/to/changes.jsonl).
+	ChangeSink string
+	// LogStats logs the statistics of each diff file.
+	LogStats bool
 }
 
 var BaseOptions = _BaseOptions{}
 var ImportOptions = _ImportOptions{}
+var DiffImportOptions = _DiffImportOptions{}
 
 func addBaseFlags(flags *flag.FlagSet) {
 	flags.StringVar(&BaseOptions.Connection, "connection", "", "connection parameters")
 	flags.StringVar(&BaseOptions.CacheDir, "cachedir", defaultCacheDir, "cache directory")
+	flags.StringVar(&BaseOptions.CacheBackend, "cachebackend", "", "cache backend (leveldb or goleveldb)")
+	flags.StringVar(&BaseOptions.CachePreset, "cachepreset", "", "cache preset (small or planet)")
 	flags.StringVar(&BaseOptions.DiffDir, "diffdir", "", "diff directory for last.state.txt")
 	flags.StringVar(&BaseOptions.MappingFile, "mapping", "", "mapping file")
 	flags.IntVar(&BaseOptions.Srid, "srid", defaultSrid, "srs id")
-	flags.StringVar(&BaseOptions.LimitTo, "limitto", "", "limit to geometries")
+	flags.StringVar(&BaseOptions.LimitTo, "limitto", "", "limit to geometries (comma separated .geojson, .wkt, .wkb or .shp files or bbox=minx,miny,maxx,maxy, NONE to disable the default PBF header bbox)")
 	flags.Float64Var(&BaseOptions.LimitToCacheBuffer, "limittocachebuffer", 0.0, "limit to buffer for cache")
 	flags.StringVar(&BaseOptions.ConfigFile, "config", "", "config (json)")
 	flags.StringVar(&BaseOptions.Httpprofile, "httpprofile", "", "bind address for profile server")
+	flags.StringVar(&BaseOptions.Metrics, "metrics", "", "bind address for Prometheus metrics (/metrics)")
 	flags.BoolVar(&BaseOptions.Quiet, "quiet", false, "quiet log output")
+	flags.StringVar(&BaseOptions.LogFormat, "log-format", "text", "log format (text or json)")
+	flags.StringVar(&BaseOptions.LogLevel, "log-level", "info", "log level (debug, info or warn)")
 	flags.StringVar(&BaseOptions.Schemas.Import, "dbschema-import", defaultSchemaImport, "db schema for imports")
 	flags.StringVar(&BaseOptions.Schemas.Production, "dbschema-production", defaultSchemaProduction, "db schema for production")
 	flags.StringVar(&BaseOptions.Schemas.Backup, "dbschema-backup", defaultSchemaBackup, "db schema for backups")
@@ -175,6 +211,10 @@ func init() {
 	DiffFlags.Usage = UsageDiff
 
 	addBaseFlags(DiffFlags)
+	DiffFlags.BoolVar(&DiffImportOptions.Batch, "batch", false, "import all diff files in a single transaction")
+	DiffFlags.StringVar(&DiffImportOptions.ChangeSink, "changesink", "", "record changed rows (file:changes.jsonl, notify:channel or changelog:table)")
+	DiffFlags.BoolVar(&DiffImportOptions.LogStats, "logstats", false, "log the statistics of each diff file")
+	DiffFlags.BoolVar(&DiffImportOptions.Merge, "merge", false, "merge all diff files before the import (implies -batch)")
 	addBaseFlags(ImportFlags)
 	ImportFlags.BoolVar(&ImportOptions.Overwritecache, "overwritecache", false, "overwritecache")
 	ImportFlags.BoolVar(&ImportOptions.Appendcache, "appendcache", false, "append cache")
@@ -185,7 +225,9 @@ func init() {
 	ImportFlags.BoolVar(&ImportOptions.DeployProduction, "deployproduction", false, "deploy production")
 	ImportFlags.BoolVar(&ImportOptions.RevertDeploy, "revertdeploy", false, "revert deploy to production")
 	ImportFlags.BoolVar(&ImportOptions.RemoveBackup, "removebackup", false, "remove backups from deploy")
+	ImportFlags.BoolVar(&ImportOptions.DryRun, "dryrun", false, "only report changes of -deployproduction/-revertdeploy/-removebackup")
 	ImportFlags.DurationVar(&ImportOptions.DiffStateBefore, "diff-state-before", 2*time.Hour, "set initial diff sequence before")
+	ImportFlags.StringVar(&ImportOptions.Timestamp, "timestamp", "", "import snapshot of history files at this time (e.g. 2015-01-01T00:00:00Z)")
 }
 
 func ParseImport(args []string) {
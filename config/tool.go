@@ -0,0 +1,56 @@
+package config
+
+import (
+	"flag"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/logging"
+)
+
+// ToolOptions are the cache and log options of the commands that work
+// on an existing cache (query-cache, explain, cache and export-pbf).
+type ToolOptions struct {
+	CacheDir     string
+	CacheBackend string
+	CachePreset  string
+	ConfigFile   string
+	LogFormat    string
+	LogLevel     string
+}
+
+// AddToolFlags adds the flags for the ToolOptions.
+func AddToolFlags(flags *flag.FlagSet) *ToolOptions {
+	o := &ToolOptions{}
+	flags.StringVar(&o.CacheDir, "cachedir", defaultCacheDir, "cache directory")
+	flags.StringVar(&o.CacheBackend, "cachebackend", "", "cache backend (leveldb or goleveldb)")
+	flags.StringVar(&o.CachePreset, "cachepreset", "", "cache preset (small or planet)")
+	flags.StringVar(&o.ConfigFile, "config", "", "config (json) with the cachedir and cache options")
+	flags.StringVar(&o.LogFormat, "log-format", "text", "log format (text or json)")
+	flags.StringVar(&o.LogLevel, "log-level", "info", "log level (debug, info or warn)")
+	return o
+}
+
+// Configure configures the logging and the caches. The cachedir and the
+// cache section are read from the config file, flags overwrite these
+// options like for the import.
+func (o *ToolOptions) Configure() error {
+	if err := logging.Configure(o.LogFormat, o.LogLevel); err != nil {
+		return err
+	}
+	conf := &Config{}
+	if o.ConfigFile != "" {
+		if err := readConfig(o.ConfigFile, conf); err != nil {
+			return err
+		}
+	}
+	if o.CacheDir == defaultCacheDir && conf.CacheDir != "" {
+		o.CacheDir = conf.CacheDir
+	}
+	if err := cache.Configure(o.CachePreset, conf.Cache); err != nil {
+		return err
+	}
+	if o.CacheBackend != "" {
+		return cache.SetBackend(o.CacheBackend)
+	}
+	return nil
+}
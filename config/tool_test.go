@@ -0,0 +1,49 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToolOptionsConfigure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm3_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config.json")
+	for _, test := range []struct {
+		config   string
+		args     []string
+		cacheDir string
+		valid    bool
+	}{
+		{`{"cachedir": "/tmp/osm", "cache": {"Coords": {"CacheSizeM": 42}}}`, nil, "/tmp/osm", true},
+		{`{"cachedir": "/tmp/osm"}`, []string{"-cachedir", "/tmp/other"}, "/tmp/other", true},
+		{`{"cache": {"Coords": {"Unknown": 1}}}`, nil, defaultCacheDir, false},
+		{`{"cache": `, nil, defaultCacheDir, false},
+	} {
+		if err := ioutil.WriteFile(configFile, []byte(test.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		opts := AddToolFlags(flags)
+		if err := flags.Parse(append([]string{"-config", configFile}, test.args...)); err != nil {
+			t.Fatal(err)
+		}
+		err := opts.Configure()
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %s: %s", test.config, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected error for %s", test.config)
+		}
+		if test.valid && opts.CacheDir != test.cacheDir {
+			t.Errorf("unexpected cachedir %q for %s", opts.CacheDir, test.config)
+		}
+	}
+}
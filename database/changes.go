@@ -0,0 +1,140 @@
+package database
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Operations of a Change.
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change is an inserted, updated or deleted row of a diff import.
+type Change struct {
+	Table string `json:"table"`
+	Id    int64  `json:"id"`
+	// Cell is the grid cell (x, y) of the rows of aggregated tables,
+	// which have no id.
+	Cell     []int  `json:"cell,omitempty"`
+	Op       string `json:"op"`
+	Sequence int32  `json:"sequence"`
+}
+
+// ChangeSink receives all changed rows of a transaction.
+type ChangeSink interface {
+	// Write writes the changes before the transaction is committed.
+	// tx is the transaction of the changes.
+	Write(tx *sql.Tx, changes []Change) error
+	// Commit is called after the transaction was committed.
+	Commit() error
+	// Abort discards the changes of the last Write, if the transaction
+	// was rolled back.
+	Abort() error
+	Close() error
+}
+
+// ChangeTracker is implemented by databases that report the changed rows
+// to a ChangeSink.
+type ChangeTracker interface {
+	// SetChangeSink enables the change tracking for all following
+	// transactions.
+	SetChangeSink(ChangeSink)
+	// SetChangeSequence sets the diff sequence of all following changes.
+	SetChangeSequence(int32)
+}
+
+var changeSinks map[string]func(Config, string) (ChangeSink, error)
+
+func init() {
+	changeSinks = make(map[string]func(Config, string) (ChangeSink, error))
+}
+
+func RegisterChangeSink(name string, f func(Config, string) (ChangeSink, error)) {
+	changeSinks[name] = f
+}
+
+// OpenChangeSink opens the change sink for sink (type:param, e.g.
+// file:/path/to/changes.jsonl).
+func OpenChangeSink(conf Config, sink string) (ChangeSink, error) {
+	parts := strings.SplitN(sink, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.New("invalid change sink: " + sink)
+	}
+	newFunc, ok := changeSinks[parts[0]]
+	if !ok {
+		return nil, errors.New("unsupported change sink type: " + parts[0])
+	}
+	return newFunc(conf, parts[1])
+}
+
+// fileSink appends all changes as JSON lines to a file. Changes are
+// written before the transaction is committed and removed if the
+// transaction is aborted. The file is not part of the transaction: if
+// the import is interrupted after Write, the changes remain in the file,
+// also if the transaction was not committed. The next import repeats
+// the diff files and writes the changes again. Consumers need to skip
+// the duplicate changes by the table, id and sequence.
+type fileSink struct {
+	f *os.File
+	// size of the file before the last uncommitted Write, or -1
+	size int64
+}
+
+func newFileSink(conf Config, path string) (ChangeSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{f: f, size: -1}, nil
+}
+
+func (s *fileSink) Write(tx *sql.Tx, changes []Change) error {
+	size, err := s.f.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+	s.size = size
+
+	w := bufio.NewWriter(s.f)
+	enc := json.NewEncoder(w)
+	for _, c := range changes {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *fileSink) Commit() error {
+	s.size = -1
+	return nil
+}
+
+func (s *fileSink) Abort() error {
+	if s.size < 0 {
+		return nil
+	}
+	if err := s.f.Truncate(s.size); err != nil {
+		return err
+	}
+	s.size = -1
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+func init() {
+	RegisterChangeSink("file", newFileSink)
+}
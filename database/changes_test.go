@@ -0,0 +1,47 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "changes.jsonl")
+
+	sink, err := OpenChangeSink(Config{}, "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	if err := sink.Write(nil, []Change{{Table: "osm_roads", Id: 1, Op: ChangeInsert, Sequence: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// aborted changes are removed
+	if err := sink.Write(nil, []Change{{Table: "osm_roads", Id: 2, Op: ChangeDelete, Sequence: 6}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"table":"osm_roads","id":1,"op":"insert","sequence":5}` + "\n"
+	if string(data) != expected {
+		t.Errorf("unexpected changes %q", data)
+	}
+
+	if _, err := OpenChangeSink(Config{}, "unknown:foo"); err == nil {
+		t.Error("expected error for unknown sink")
+	}
+}
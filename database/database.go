@@ -40,6 +40,9 @@ type Deployer interface {
 	Deploy() error
 	RevertDeploy() error
 	RemoveBackup() error
+	// EnableDeployDryRun reports all changes of the following
+	// deploy calls without applying them.
+	EnableDeployDryRun()
 }
 
 type Generalizer interface {
@@ -64,6 +67,31 @@ type Optimizer interface {
 	Optimize() error
 }
 
+// RowCount is the number of inserted and deleted rows of a table.
+type RowCount struct {
+	Inserted int64 `json:"inserted"`
+	Deleted  int64 `json:"deleted"`
+}
+
+// RowCounter is implemented by databases that count the inserted and
+// deleted rows of each table.
+type RowCounter interface {
+	// RowCounts returns the counts of all tables since the last call.
+	RowCounts() map[string]RowCount
+}
+
+// UpdateMarker is implemented by databases that store a marker of each
+// update with the changes of the update. The marker tells whether the
+// transaction of an interrupted update was committed.
+type UpdateMarker interface {
+	// SetUpdateMarker sets the marker and the diff sequence that are
+	// stored with the next End.
+	SetUpdateMarker(marker string, sequence int32)
+	// UpdateMarker returns the marker of the last committed update, or
+	// "" if there is none.
+	UpdateMarker() (string, error)
+}
+
 var databases map[string]func(Config, *mapping.Mapping) (DB, error)
 
 func init() {
@@ -0,0 +1,347 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/olehz/imposm3/mapping"
+)
+
+// aggregateUpdateBatchSize is the max number of grid cells that are
+// recomputed with a single statement.
+const aggregateUpdateBatchSize = 1000
+
+type gridCell struct {
+	x, y int
+}
+
+type byCell []gridCell
+
+func (c byCell) Len() int      { return len(c) }
+func (c byCell) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byCell) Less(i, j int) bool {
+	if c[i].x == c[j].x {
+		return c[i].y < c[j].y
+	}
+	return c[i].x < c[j].x
+}
+
+var cellColumnType = mapping.FieldType{Name: "integer", GoType: "int32"}
+
+// aggregateColumns returns the group by columns, the grid cell columns
+// and the geometry column of aggregated tables.
+func (spec *GeneralizedTableSpec) aggregateColumns() []ColumnSpec {
+	var cols []ColumnSpec
+	for _, name := range spec.GroupBy {
+		for _, col := range spec.Source.Columns {
+			if col.Name == name {
+				cols = append(cols, col)
+				break
+			}
+		}
+	}
+	cols = append(cols,
+		ColumnSpec{"cell_x", cellColumnType, pgTypes["int32"]},
+		ColumnSpec{"cell_y", cellColumnType, pgTypes["int32"]},
+	)
+	if i := spec.Source.geometryColumn(); i >= 0 {
+		cols = append(cols, spec.Source.Columns[i])
+	}
+	return cols
+}
+
+func (spec *GeneralizedTableSpec) sourceGeometryName() string {
+	if i := spec.Source.geometryColumn(); i >= 0 {
+		return spec.Source.Columns[i].Name
+	}
+	return "geometry"
+}
+
+// aggregateSQL returns a query that dissolves all source geometries by
+// the GroupBy columns and grid cell. Only cells are returned if cells is
+// not empty.
+func (spec *GeneralizedTableSpec) aggregateSQL(cells []gridCell) string {
+	size := spec.GridSize
+	geom := `g."` + spec.sourceGeometryName() + `"`
+
+	clipped := fmt.Sprintf(`CASE WHEN ST_Within(%s, c.cell_env) THEN %s ELSE ST_Intersection(%s, c.cell_env) END`,
+		geom, geom, geom)
+	switch spec.Source.GeometryType {
+	case "polygon":
+		clipped = fmt.Sprintf(`ST_CollectionExtract(%s, 3)`, clipped)
+	case "linestring":
+		clipped = fmt.Sprintf(`ST_CollectionExtract(%s, 2)`, clipped)
+	}
+
+	var groupBy []string
+	for _, col := range spec.aggregateColumns() {
+		if col.Type.Name() == "GEOMETRY" {
+			continue
+		}
+		if col.Name == "cell_x" || col.Name == "cell_y" {
+			groupBy = append(groupBy, "c."+col.Name)
+		} else {
+			groupBy = append(groupBy, `g."`+col.Name+`"`)
+		}
+	}
+	cols := append([]string{}, groupBy...)
+	cols = append(cols, fmt.Sprintf(`%s AS "%s"`,
+		spec.simplifySQL("ST_Union("+clipped+")"), spec.sourceGeometryName()))
+
+	var conds []string
+	if cond := spec.whereSQL(); cond != "" {
+		conds = append(conds, cond)
+	}
+	if len(cells) > 0 {
+		minx, miny := math.MaxInt32, math.MaxInt32
+		maxx, maxy := math.MinInt32, math.MinInt32
+		values := make([]string, len(cells))
+		for i, c := range cells {
+			values[i] = fmt.Sprintf("(%d, %d)", c.x, c.y)
+			minx, miny = minInt(minx, c.x), minInt(miny, c.y)
+			maxx, maxy = maxInt(maxx, c.x), maxInt(maxy, c.y)
+		}
+		conds = append(conds, fmt.Sprintf(`%s && ST_MakeEnvelope(%f, %f, %f, %f, %d)`,
+			geom, float64(minx)*size, float64(miny)*size, float64(maxx+1)*size, float64(maxy+1)*size,
+			spec.Source.Srid))
+		conds = append(conds, fmt.Sprintf(`(c.cell_x, c.cell_y) IN (VALUES %s)`, strings.Join(values, ", ")))
+	}
+	var where string
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	return fmt.Sprintf(`SELECT * FROM (
+        SELECT %s
+        FROM "%s"."%s" AS g, LATERAL (
+            SELECT cell_x, cell_y,
+                ST_MakeEnvelope(cell_x * %f, cell_y * %f, (cell_x + 1) * %f, (cell_y + 1) * %f, %d) AS cell_env
+            FROM generate_series(floor(ST_XMin(%s) / %f)::int, floor(ST_XMax(%s) / %f)::int) AS cell_x,
+                generate_series(floor(ST_YMin(%s) / %f)::int, floor(ST_YMax(%s) / %f)::int) AS cell_y
+        ) AS c%s
+        GROUP BY %s
+    ) AS agg WHERE NOT ST_IsEmpty("%s")`,
+		strings.Join(cols, ", "),
+		spec.Source.Schema, spec.Source.FullName,
+		size, size, size, size, spec.Source.Srid,
+		geom, size, geom, size,
+		geom, size, geom, size,
+		where,
+		strings.Join(groupBy, ", "),
+		spec.sourceGeometryName(),
+	)
+}
+
+// cellsSQL returns a query for the grid cell range of all source
+// geometries with the ids from $1.
+func (spec *GeneralizedTableSpec) cellsSQL() string {
+	var idColumnName string
+	for _, col := range spec.Source.Columns {
+		if col.FieldType.Name == "id" {
+			idColumnName = col.Name
+			break
+		}
+	}
+
+	if idColumnName == "" {
+		panic("missing id column")
+	}
+	geom := `"` + spec.sourceGeometryName() + `"`
+	size := spec.GridSize
+	return fmt.Sprintf(`SELECT floor(ST_XMin(%s) / %f)::int, floor(ST_YMin(%s) / %f)::int,
+        floor(ST_XMax(%s) / %f)::int, floor(ST_YMax(%s) / %f)::int
+        FROM "%s"."%s" WHERE "%s" = ANY($1::bigint[]) AND %s IS NOT NULL`,
+		geom, size, geom, size, geom, size, geom, size,
+		spec.Source.Schema, spec.Source.FullName, idColumnName, geom,
+	)
+}
+
+// aggregateTableTx collects all grid cells of updated and deleted
+// source geometries. update recomputes all collected cells.
+//
+// Delete needs to be called before the source geometry is deleted
+// and Insert (with an array of ids) after the source geometry was
+// inserted.
+type aggregateTableTx struct {
+	Pg        *PostGIS
+	Tx        *sql.Tx
+	Spec      *GeneralizedTableSpec
+	CellsStmt *sql.Stmt
+	CellsSql  string
+	cells     map[gridCell]struct{}
+	// counts and changes record all recomputed rows, if set
+	counts  *rowCounter
+	changes *changeTracker
+}
+
+func NewAggregateTableTx(pg *PostGIS, spec *GeneralizedTableSpec) TableTx {
+	return &aggregateTableTx{
+		Pg:    pg,
+		Spec:  spec,
+		cells: make(map[gridCell]struct{}),
+	}
+}
+
+func (tt *aggregateTableTx) Begin(tx *sql.Tx) error {
+	var err error
+	if tx == nil {
+		tx, err = tt.Pg.Db.Begin()
+		if err != nil {
+			return err
+		}
+	}
+	tt.Tx = tx
+
+	tt.CellsSql = tt.Spec.cellsSQL()
+	stmt, err := tt.Tx.Prepare(tt.CellsSql)
+	if err != nil {
+		return &SQLError{tt.CellsSql, err}
+	}
+	tt.CellsStmt = stmt
+	return nil
+}
+
+// Insert adds the cells of the ids (array literal in row[0]).
+func (tt *aggregateTableTx) Insert(row []interface{}) error {
+	return tt.addCells(row[0])
+}
+
+// Delete adds the cells of the source geometry with id.
+func (tt *aggregateTableTx) Delete(id int64) error {
+	return tt.addCells(int64ArrayLiteral([]int64{id}))
+}
+
+func (tt *aggregateTableTx) addCells(ids interface{}) error {
+	rows, err := tt.CellsStmt.Query(ids)
+	if err != nil {
+		return &SQLInsertError{SQLError{tt.CellsSql, err}, ids}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var minx, miny, maxx, maxy int
+		if err := rows.Scan(&minx, &miny, &maxx, &maxy); err != nil {
+			return err
+		}
+		for x := minx; x <= maxx; x++ {
+			for y := miny; y <= maxy; y++ {
+				tt.cells[gridCell{x, y}] = struct{}{}
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// update recomputes all collected cells.
+func (tt *aggregateTableTx) update() error {
+	if len(tt.cells) == 0 {
+		return nil
+	}
+	cells := make([]gridCell, 0, len(tt.cells))
+	for c := range tt.cells {
+		cells = append(cells, c)
+	}
+	sort.Sort(byCell(cells))
+
+	var cols []string
+	for _, col := range tt.Spec.aggregateColumns() {
+		cols = append(cols, `"`+col.Name+`"`)
+	}
+
+	for len(cells) > 0 {
+		n := len(cells)
+		if n > aggregateUpdateBatchSize {
+			n = aggregateUpdateBatchSize
+		}
+		batch := cells[:n]
+		cells = cells[n:]
+
+		values := make([]string, len(batch))
+		for i, c := range batch {
+			values[i] = fmt.Sprintf("(%d, %d)", c.x, c.y)
+		}
+		sql := fmt.Sprintf(`DELETE FROM "%s"."%s" WHERE (cell_x, cell_y) IN (VALUES %s) RETURNING cell_x, cell_y`,
+			tt.Spec.Schema, tt.Spec.FullName, strings.Join(values, ", "))
+		if err := tt.execCells(sql, false); err != nil {
+			return err
+		}
+
+		sql = fmt.Sprintf(`INSERT INTO "%s"."%s" (%s) %s RETURNING cell_x, cell_y`,
+			tt.Spec.Schema, tt.Spec.FullName, strings.Join(cols, ", "),
+			tt.Spec.aggregateSQL(batch))
+		if err := tt.execCells(sql, true); err != nil {
+			return err
+		}
+	}
+	tt.cells = make(map[gridCell]struct{})
+	return nil
+}
+
+// execCells executes the DELETE or INSERT sql and records the cells of
+// the returned rows as deleted or inserted.
+func (tt *aggregateTableTx) execCells(sql string, inserted bool) error {
+	rows, err := tt.Tx.Query(sql)
+	if err != nil {
+		return &SQLError{sql, err}
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		var cell gridCell
+		if err := rows.Scan(&cell.x, &cell.y); err != nil {
+			return err
+		}
+		n++
+		if tt.changes == nil {
+			continue
+		}
+		if inserted {
+			tt.changes.insertedCell(tt.Spec.FullName, cell)
+		} else {
+			tt.changes.deletedCell(tt.Spec.FullName, cell)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if tt.counts != nil {
+		if inserted {
+			tt.counts.inserted(tt.Spec.FullName, n)
+		} else {
+			tt.counts.deleted(tt.Spec.FullName, n)
+		}
+	}
+	return nil
+}
+
+func (tt *aggregateTableTx) End() {
+}
+
+func (tt *aggregateTableTx) Commit() error {
+	err := tt.Tx.Commit()
+	if err != nil {
+		return err
+	}
+	tt.Tx = nil
+	return nil
+}
+
+func (tt *aggregateTableTx) Rollback() {
+	rollbackIfTx(&tt.Tx)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,298 @@
+package postgis
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// binaryEncoder appends val in the PostgreSQL binary COPY format
+// (int32 length followed by the binary representation) to buf.
+type binaryEncoder func(buf []byte, val interface{}) ([]byte, error)
+
+// int8OID is the type OID of BIGINT, required for array elements.
+const int8OID = 20
+
+// encodeBinaryRow appends a complete binary COPY tuple for row to buf.
+func encodeBinaryRow(buf []byte, columns []ColumnSpec, row []interface{}) ([]byte, error) {
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("row with %d values for %d columns", len(row), len(columns))
+	}
+	buf = appendInt16(buf, int16(len(columns)))
+	var err error
+	for i, col := range columns {
+		if row[i] == nil {
+			buf = appendNull(buf)
+			continue
+		}
+		buf, err = col.Type.EncodeBinary(buf, row[i])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %s", col.Name, err)
+		}
+	}
+	return buf, nil
+}
+
+func appendNull(buf []byte) []byte {
+	return appendInt32(buf, -1)
+}
+
+func appendInt16(buf []byte, v int16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendInt32(buf []byte, v int32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendInt64(buf []byte, v int64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	return append(buf, b[:]...)
+}
+
+func appendField(buf []byte, data []byte) []byte {
+	buf = appendInt32(buf, int32(len(data)))
+	return append(buf, data...)
+}
+
+func toInt64(val interface{}) (int64, error) {
+	switch v := val.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("unable to encode %T as integer", val)
+}
+
+func encodeString(buf []byte, val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case string:
+		buf = appendInt32(buf, int32(len(v)))
+		return append(buf, v...), nil
+	case []byte:
+		return appendField(buf, v), nil
+	}
+	return appendField(buf, []byte(fmt.Sprint(val))), nil
+}
+
+func encodeBool(buf []byte, val interface{}) ([]byte, error) {
+	v, ok := val.(bool)
+	if !ok {
+		i, err := toInt64(val)
+		if err != nil {
+			return nil, err
+		}
+		v = i != 0
+	}
+	buf = appendInt32(buf, 1)
+	if v {
+		return append(buf, 1), nil
+	}
+	return append(buf, 0), nil
+}
+
+func encodeInt16(buf []byte, val interface{}) ([]byte, error) {
+	v, err := toInt64(val)
+	if err != nil {
+		return nil, err
+	}
+	if v < math.MinInt16 || v > math.MaxInt16 {
+		return nil, fmt.Errorf("%d out of range for SMALLINT", v)
+	}
+	buf = appendInt32(buf, 2)
+	return appendInt16(buf, int16(v)), nil
+}
+
+func encodeInt32(buf []byte, val interface{}) ([]byte, error) {
+	v, err := toInt64(val)
+	if err != nil {
+		return nil, err
+	}
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return nil, fmt.Errorf("%d out of range for INT", v)
+	}
+	buf = appendInt32(buf, 4)
+	return appendInt32(buf, int32(v)), nil
+}
+
+func encodeInt64(buf []byte, val interface{}) ([]byte, error) {
+	v, err := toInt64(val)
+	if err != nil {
+		return nil, err
+	}
+	buf = appendInt32(buf, 8)
+	return appendInt64(buf, v), nil
+}
+
+func encodeFloat32(buf []byte, val interface{}) ([]byte, error) {
+	var v float32
+	switch f := val.(type) {
+	case float32:
+		v = f
+	case float64:
+		v = float32(f)
+	default:
+		i, err := toInt64(val)
+		if err != nil {
+			return nil, err
+		}
+		v = float32(i)
+	}
+	buf = appendInt32(buf, 4)
+	return appendInt32(buf, int32(math.Float32bits(v))), nil
+}
+
+// encodeHstore encodes hstore values as created by mapping.HstoreString
+// ("key"=>"value", ...) in the binary format of hstore_recv.
+func encodeHstore(buf []byte, val interface{}) ([]byte, error) {
+	str, ok := val.(string)
+	if !ok {
+		return nil, fmt.Errorf("unable to encode %T as hstore", val)
+	}
+	pairs, err := parseHstore(str)
+	if err != nil {
+		return nil, err
+	}
+
+	lenPos := len(buf)
+	buf = appendInt32(buf, 0) // placeholder for field length
+	buf = appendInt32(buf, int32(len(pairs)/2))
+	for _, s := range pairs {
+		buf = appendInt32(buf, int32(len(s)))
+		buf = append(buf, s...)
+	}
+	binary.BigEndian.PutUint32(buf[lenPos:], uint32(len(buf)-lenPos-4))
+	return buf, nil
+}
+
+var errInvalidHstore = errors.New("invalid hstore string")
+
+// parseHstore parses `"k1"=>"v1", "k2"=>"v2"` into [k1, v1, k2, v2].
+func parseHstore(str string) ([]string, error) {
+	var result []string
+	expectKey := true
+	for i := 0; i < len(str); {
+		switch {
+		case str[i] == ' ' || str[i] == ',':
+			i++
+			continue
+		case strings.HasPrefix(str[i:], "=>"):
+			if expectKey {
+				return nil, errInvalidHstore
+			}
+			i += 2
+			continue
+		case str[i] != '"':
+			return nil, errInvalidHstore
+		}
+		s, n, err := parseHstoreString(str[i:])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+		expectKey = !expectKey
+		i += n
+	}
+	if !expectKey {
+		return nil, errInvalidHstore
+	}
+	return result, nil
+}
+
+// parseHstoreString parses a quoted and escaped string at the start of str.
+// Returns the unescaped string and the number of consumed bytes.
+func parseHstoreString(str string) (string, int, error) {
+	buf := make([]byte, 0, len(str))
+	for i := 1; i < len(str); i++ {
+		switch str[i] {
+		case '\\':
+			i++
+			if i == len(str) {
+				return "", 0, errInvalidHstore
+			}
+			buf = append(buf, str[i])
+		case '"':
+			return string(buf), i + 1, nil
+		default:
+			buf = append(buf, str[i])
+		}
+	}
+	return "", 0, errInvalidHstore
+}
+
+// encodeInt64Array encodes a one dimensional BIGINT[] from
+// a "{1,2,3}" string (see mapping.MemberString) or an []int64.
+func encodeInt64Array(buf []byte, val interface{}) ([]byte, error) {
+	var values []int64
+	switch v := val.(type) {
+	case []int64:
+		values = v
+	case string:
+		v = strings.Trim(v, "{}")
+		if v != "" {
+			for _, part := range strings.Split(v, ",") {
+				i, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, i)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unable to encode %T as BIGINT[]", val)
+	}
+
+	if len(values) == 0 {
+		// empty arrays have no dimensions
+		buf = appendInt32(buf, 12)
+		buf = appendInt32(buf, 0) // ndim
+		buf = appendInt32(buf, 0) // no nulls
+		return appendInt32(buf, int8OID), nil
+	}
+
+	buf = appendInt32(buf, int32(20+len(values)*12))
+	buf = appendInt32(buf, 1) // ndim
+	buf = appendInt32(buf, 0) // no nulls
+	buf = appendInt32(buf, int8OID)
+	buf = appendInt32(buf, int32(len(values)))
+	buf = appendInt32(buf, 1) // lower bound
+	for _, v := range values {
+		buf = appendInt32(buf, 8)
+		buf = appendInt64(buf, v)
+	}
+	return buf, nil
+}
+
+// encodeGeometry encodes EWKB (see geos.AsEwkb), which is also the
+// binary format of PostGIS geometry_recv.
+func encodeGeometry(buf []byte, val interface{}) ([]byte, error) {
+	wkb, ok := val.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unable to encode %T as geometry", val)
+	}
+	return appendField(buf, wkb), nil
+}
@@ -0,0 +1,100 @@
+package postgis
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseHstore(t *testing.T) {
+	for _, test := range []struct {
+		hstore   string
+		expected []string
+	}{
+		{``, nil},
+		{`"key"=>"value"`, []string{"key", "value"}},
+		{`"a"=>"1", "b"=>"2"`, []string{"a", "1", "b", "2"}},
+		{`"\"key\""=>"'\"value\"'"`, []string{`"key"`, `'"value"'`}},
+		{`"\\"=>"\\\\\\\\"`, []string{`\`, `\\\\`}},
+		{`"Ümlåütê=>"=>""`, []string{"Ümlåütê=>", ""}},
+	} {
+		pairs, err := parseHstore(test.hstore)
+		if err != nil {
+			t.Fatal(test.hstore, err)
+		}
+		if len(pairs) != len(test.expected) {
+			t.Fatalf("%s: %q != %q", test.hstore, pairs, test.expected)
+		}
+		for i := range pairs {
+			if pairs[i] != test.expected[i] {
+				t.Errorf("%s: %q != %q", test.hstore, pairs, test.expected)
+			}
+		}
+	}
+
+	for _, invalid := range []string{`"key"`, `"key"=>`, `key=>"value"`, `"key"=>"value`} {
+		if _, err := parseHstore(invalid); err == nil {
+			t.Error("expected error for", invalid)
+		}
+	}
+}
+
+func TestEncodeBinaryRow(t *testing.T) {
+	columns := []ColumnSpec{
+		{Name: "id", Type: pgTypes["int64"]},
+		{Name: "name", Type: pgTypes["string"]},
+		{Name: "z_order", Type: pgTypes["int32"]},
+		{Name: "tunnel", Type: pgTypes["bool"]},
+		{Name: "area", Type: pgTypes["float32"]},
+		{Name: "geometry", Type: pgTypes["geometry"]},
+	}
+	row := []interface{}{int64(42), "foo", 3, true, nil, []byte{1, 1}}
+
+	buf, err := encodeBinaryRow(nil, columns, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0, 6, // field count
+		0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 42,
+		0, 0, 0, 3, 'f', 'o', 'o',
+		0, 0, 0, 4, 0, 0, 0, 3,
+		0, 0, 0, 1, 1,
+		0xff, 0xff, 0xff, 0xff, // NULL
+		0, 0, 0, 2, 1, 1,
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("%v != %v", buf, expected)
+	}
+
+	if _, err := encodeBinaryRow(nil, columns, row[:2]); err == nil {
+		t.Error("expected error for short row")
+	}
+}
+
+func TestEncodeInt64Array(t *testing.T) {
+	buf, err := encodeInt64Array(nil, "{1,-2}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0, 0, 0, 44,
+		0, 0, 0, 1, // ndim
+		0, 0, 0, 0, // no nulls
+		0, 0, 0, 20, // int8
+		0, 0, 0, 2, // elements
+		0, 0, 0, 1, // lower bound
+		0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 8, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe,
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("%v != %v", buf, expected)
+	}
+
+	buf, err = encodeInt64Array(nil, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != 16 {
+		t.Errorf("unexpected empty array %v", buf)
+	}
+}
@@ -0,0 +1,275 @@
+package postgis
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/olehz/imposm3/database"
+	"github.com/olehz/imposm3/mapping"
+)
+
+type changeKey struct {
+	table string
+	id    int64
+	// cell of aggregated tables, if isCell
+	cell   gridCell
+	isCell bool
+}
+
+type rowChange struct {
+	// existed is true if the row existed before the transaction
+	existed  bool
+	exists   bool
+	sequence int32
+}
+
+// changeTracker collects the net changes of all rows of a transaction.
+// A row that is deleted and inserted again is reported as update.
+type changeTracker struct {
+	mu       sync.Mutex
+	sequence int32
+	changes  map[changeKey]*rowChange
+	order    []changeKey
+}
+
+func newChangeTracker(sequence int32) *changeTracker {
+	return &changeTracker{
+		sequence: sequence,
+		changes:  make(map[changeKey]*rowChange),
+	}
+}
+
+func (t *changeTracker) setSequence(sequence int32) {
+	t.mu.Lock()
+	t.sequence = sequence
+	t.mu.Unlock()
+}
+
+// change returns the change of the row. existed is only used for the
+// first change of a row.
+func (t *changeTracker) change(key changeKey, existed bool) *rowChange {
+	c, ok := t.changes[key]
+	if !ok {
+		c = &rowChange{existed: existed}
+		t.changes[key] = c
+		t.order = append(t.order, key)
+	}
+	c.sequence = t.sequence
+	return c
+}
+
+func (t *changeTracker) inserted(table string, id int64) {
+	t.mu.Lock()
+	t.change(changeKey{table: table, id: id}, false).exists = true
+	t.mu.Unlock()
+}
+
+func (t *changeTracker) deleted(table string, id int64) {
+	t.mu.Lock()
+	t.change(changeKey{table: table, id: id}, true).exists = false
+	t.mu.Unlock()
+}
+
+// insertedCell and deletedCell record the changed rows of a grid cell of
+// an aggregated table.
+func (t *changeTracker) insertedCell(table string, cell gridCell) {
+	t.mu.Lock()
+	t.change(changeKey{table: table, cell: cell, isCell: true}, false).exists = true
+	t.mu.Unlock()
+}
+
+func (t *changeTracker) deletedCell(table string, cell gridCell) {
+	t.mu.Lock()
+	t.change(changeKey{table: table, cell: cell, isCell: true}, true).exists = false
+	t.mu.Unlock()
+}
+
+// result returns all changes in the order of the first change of each
+// row. Rows that were inserted and deleted again are skipped.
+func (t *changeTracker) result() []database.Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changes := make([]database.Change, 0, len(t.order))
+	for _, key := range t.order {
+		c := t.changes[key]
+		var op string
+		switch {
+		case c.existed && c.exists:
+			op = database.ChangeUpdate
+		case c.exists:
+			op = database.ChangeInsert
+		case c.existed:
+			op = database.ChangeDelete
+		default:
+			continue
+		}
+		change := database.Change{
+			Table:    key.table,
+			Id:       key.id,
+			Op:       op,
+			Sequence: c.sequence,
+		}
+		if key.isCell {
+			change.Cell = []int{key.cell.x, key.cell.y}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func (pg *PostGIS) SetChangeSink(sink database.ChangeSink) {
+	pg.changeSink = sink
+}
+
+func (pg *PostGIS) SetChangeSequence(sequence int32) {
+	pg.changeSequence = sequence
+	if pg.changes != nil {
+		pg.changes.setSequence(sequence)
+	}
+}
+
+// recordInserts records the inserted rows of all matched tables.
+func (pg *PostGIS) recordInserts(id int64, matches []mapping.Match) {
+	for _, match := range matches {
+		pg.recordInsert(pg.Tables[match.Table.Name].FullName, id)
+	}
+}
+
+// recordInsert records the inserted row of table.
+func (pg *PostGIS) recordInsert(table string, id int64) {
+	if pg.rowCounts != nil {
+		pg.rowCounts.inserted(table, 1)
+	}
+	if pg.changes != nil {
+		pg.changes.inserted(table, id)
+	}
+}
+
+func (pg *PostGIS) RowCounts() map[string]database.RowCount {
+	if pg.rowCounts == nil {
+		return nil
+	}
+	return pg.rowCounts.reset()
+}
+
+// rowCounter counts the inserted and deleted rows of each table.
+type rowCounter struct {
+	mu     sync.Mutex
+	counts map[string]database.RowCount
+}
+
+func newRowCounter() *rowCounter {
+	return &rowCounter{counts: make(map[string]database.RowCount)}
+}
+
+func (c *rowCounter) inserted(table string, n int64) {
+	c.mu.Lock()
+	count := c.counts[table]
+	count.Inserted += n
+	c.counts[table] = count
+	c.mu.Unlock()
+}
+
+func (c *rowCounter) deleted(table string, n int64) {
+	c.mu.Lock()
+	count := c.counts[table]
+	count.Deleted += n
+	c.counts[table] = count
+	c.mu.Unlock()
+}
+
+// reset returns all counts and starts new counts.
+func (c *rowCounter) reset() map[string]database.RowCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := c.counts
+	c.counts = make(map[string]database.RowCount)
+	return counts
+}
+
+// notifySink sends all changes as JSON with NOTIFY to a channel.
+// Notifications are only delivered if the transaction is committed.
+type notifySink struct {
+	channel string
+}
+
+func newNotifySink(conf database.Config, channel string) (database.ChangeSink, error) {
+	return &notifySink{channel: channel}, nil
+}
+
+func (s *notifySink) Write(tx *sql.Tx, changes []database.Change) error {
+	if tx == nil {
+		return fmt.Errorf("change sink requires a transaction")
+	}
+	for _, c := range changes {
+		payload, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`SELECT pg_notify($1, $2)`, s.channel, string(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *notifySink) Commit() error { return nil }
+func (s *notifySink) Abort() error  { return nil }
+func (s *notifySink) Close() error  { return nil }
+
+// changelogSink inserts all changes into a table in the production schema.
+// The table is created if it does not exist.
+type changelogSink struct {
+	table string
+}
+
+func newChangelogSink(conf database.Config, table string) (database.ChangeSink, error) {
+	return &changelogSink{table: fmt.Sprintf(`"%s"."%s"`, conf.ProductionSchema, table)}, nil
+}
+
+func (s *changelogSink) Write(tx *sql.Tx, changes []database.Change) error {
+	if tx == nil {
+		return fmt.Errorf("change sink requires a transaction")
+	}
+	sql := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id SERIAL PRIMARY KEY,
+		"table" VARCHAR NOT NULL,
+		osm_id BIGINT NOT NULL,
+		cell_x INTEGER,
+		cell_y INTEGER,
+		op VARCHAR NOT NULL,
+		sequence INTEGER,
+		created TIMESTAMP NOT NULL DEFAULT now()
+	)`, s.table)
+	if _, err := tx.Exec(sql); err != nil {
+		return &SQLError{sql, err}
+	}
+
+	sql = fmt.Sprintf(`INSERT INTO %s ("table", osm_id, cell_x, cell_y, op, sequence) VALUES ($1, $2, $3, $4, $5, $6)`, s.table)
+	stmt, err := tx.Prepare(sql)
+	if err != nil {
+		return &SQLError{sql, err}
+	}
+	defer stmt.Close()
+	for _, c := range changes {
+		var cellX, cellY interface{}
+		if len(c.Cell) == 2 {
+			cellX, cellY = c.Cell[0], c.Cell[1]
+		}
+		if _, err := stmt.Exec(c.Table, c.Id, cellX, cellY, c.Op, c.Sequence); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return nil
+}
+
+func (s *changelogSink) Commit() error { return nil }
+func (s *changelogSink) Abort() error  { return nil }
+func (s *changelogSink) Close() error  { return nil }
+
+func init() {
+	database.RegisterChangeSink("notify", newNotifySink)
+	database.RegisterChangeSink("changelog", newChangelogSink)
+}
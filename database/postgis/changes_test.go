@@ -0,0 +1,46 @@
+package postgis
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/olehz/imposm3/database"
+)
+
+func TestChangeTracker(t *testing.T) {
+	tracker := newChangeTracker(1)
+	// modified
+	tracker.deleted("osm_roads", 1)
+	tracker.inserted("osm_roads", 1)
+	// new
+	tracker.inserted("osm_roads", 2)
+	// modified and deleted in a later diff
+	tracker.deleted("osm_buildings", 1)
+	tracker.inserted("osm_buildings", 1)
+	tracker.deleted("osm_roads", 3)
+	tracker.setSequence(2)
+	tracker.deleted("osm_buildings", 1)
+	// new and deleted
+	tracker.inserted("osm_roads", 4)
+	tracker.deleted("osm_roads", 4)
+	// recomputed grid cell
+	tracker.deletedCell("osm_landusages_agg", gridCell{3, -2})
+	tracker.insertedCell("osm_landusages_agg", gridCell{3, -2})
+
+	expected := []database.Change{
+		{Table: "osm_roads", Id: 1, Op: database.ChangeUpdate, Sequence: 1},
+		{Table: "osm_roads", Id: 2, Op: database.ChangeInsert, Sequence: 1},
+		{Table: "osm_buildings", Id: 1, Op: database.ChangeDelete, Sequence: 2},
+		{Table: "osm_roads", Id: 3, Op: database.ChangeDelete, Sequence: 1},
+		{Table: "osm_landusages_agg", Cell: []int{3, -2}, Op: database.ChangeUpdate, Sequence: 2},
+	}
+	changes := tracker.result()
+	if len(changes) != len(expected) {
+		t.Fatalf("unexpected changes %v", changes)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(changes[i], expected[i]) {
+			t.Errorf("unexpected change %v, expected %v", changes[i], expected[i])
+		}
+	}
+}
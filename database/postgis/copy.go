@@ -0,0 +1,277 @@
+package postgis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	pq "github.com/lib/pq"
+)
+
+// copyConn is a connection for COPY ... FROM STDIN (FORMAT binary). pq
+// only supports the text format. The connection is opened by pq, with the
+// connection parameters, environment and authentication of pq, and only
+// the messages of the COPY are sent on the net.Conn of the copyDialer.
+type copyConn struct {
+	cn driver.Conn
+	c  net.Conn
+	r  *bufio.Reader
+	// buf collects CopyData messages until it is flushed
+	buf []byte
+}
+
+// copyFlushSize is the size of the buffered CopyData messages that are
+// sent at once.
+const copyFlushSize = 64 * 1024
+
+// binaryCopyHeader is the signature, flags field and header extension
+// length that start every binary COPY stream.
+var binaryCopyHeader = []byte("PGCOPY\n\377\r\n\000\000\000\000\000\000\000\000\000")
+
+// binaryCopyTrailer is the 16-bit -1 field count that ends a binary COPY
+// stream.
+var binaryCopyTrailer = []byte{0xff, 0xff}
+
+// copyDialer is the pq.Dialer of a copyConn. pq keeps its net.Conn
+// private, copyDialer keeps a reference for the COPY messages. As pq
+// would wrap the net.Conn for SSL, the dialer requests SSL itself and pq
+// connects with sslmode=disable.
+type copyDialer struct {
+	sslmode string
+	conn    net.Conn
+}
+
+func (d *copyDialer) Dial(network, address string) (net.Conn, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return d.ssl(c, address, time.Time{})
+}
+
+func (d *copyDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	c, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return d.ssl(c, address, time.Now().Add(timeout))
+}
+
+// ssl requests SSL for the sslmode of pq and keeps the resulting
+// connection.
+func (d *copyDialer) ssl(c net.Conn, address string, deadline time.Time) (net.Conn, error) {
+	tlsConf := tls.Config{}
+	switch d.sslmode {
+	case "require", "":
+		tlsConf.InsecureSkipVerify = true
+	case "verify-full":
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		tlsConf.ServerName = host
+	case "disable":
+		d.conn = c
+		return c, nil
+	default:
+		c.Close()
+		return nil, fmt.Errorf(`unsupported sslmode %q; only "require" (default), "verify-full", and "disable" supported`, d.sslmode)
+	}
+
+	if err := c.SetDeadline(deadline); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// SSLRequest
+	if _, err := c.Write(appendInt32(appendInt32(nil, 8), 80877103)); err != nil {
+		c.Close()
+		return nil, err
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(c, b); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if b[0] != 'S' {
+		c.Close()
+		return nil, pq.ErrSSLNotSupported
+	}
+	d.conn = tls.Client(c, &tlsConf)
+	return d.conn, nil
+}
+
+// sslMode returns the sslmode of params or of the PGSSLMODE environment.
+func sslMode(params string) string {
+	mode := os.Getenv("PGSSLMODE")
+	for _, p := range strings.Fields(params) {
+		if strings.HasPrefix(p, "sslmode=") {
+			mode = strings.TrimPrefix(p, "sslmode=")
+		}
+	}
+	return mode
+}
+
+func openCopyConn(params string) (*copyConn, error) {
+	d := &copyDialer{sslmode: sslMode(params)}
+	cn, err := pq.DialOpen(d, params+" sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+	return &copyConn{cn: cn, c: d.conn}, nil
+}
+
+// exec executes a query without results with pq.
+func (cn *copyConn) exec(query string) error {
+	execer, ok := cn.cn.(driver.Execer)
+	if !ok {
+		return errors.New("pq connection does not support Exec")
+	}
+	_, err := execer.Exec(query, nil)
+	return err
+}
+
+func (cn *copyConn) send(typ byte, payload []byte) error {
+	msg := appendInt32([]byte{typ}, int32(len(payload)+4))
+	msg = append(msg, payload...)
+	_, err := cn.c.Write(msg)
+	return err
+}
+
+func (cn *copyConn) recv() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(cn.r, header); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint32(header[1:])) - 4
+	if n < 0 {
+		return 0, nil, errors.New("invalid message length")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(cn.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// parseCopyError returns the message of an ErrorResponse.
+func parseCopyError(r []byte) error {
+	var msg, code string
+	for len(r) > 0 && r[0] != 0 {
+		field := r[0]
+		end := 1
+		for end < len(r) && r[end] != 0 {
+			end++
+		}
+		value := string(r[1:end])
+		switch field {
+		case 'M':
+			msg = value
+		case 'C':
+			code = value
+		}
+		if end >= len(r) {
+			break
+		}
+		r = r[end+1:]
+	}
+	return fmt.Errorf("pq: %s (SQLSTATE %s)", msg, code)
+}
+
+// waitReady reads all responses until ReadyForQuery and returns the
+// first error.
+func (cn *copyConn) waitReady() error {
+	var result error
+	for {
+		t, r, err := cn.recv()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case 'E':
+			if result == nil {
+				result = parseCopyError(r)
+			}
+		case 'Z':
+			return result
+		}
+	}
+}
+
+// startCopy starts a COPY ... FROM STDIN (FORMAT binary) query. pq is
+// idle until endCopy returns.
+func (cn *copyConn) startCopy(query string) error {
+	cn.r = bufio.NewReader(cn.c)
+	if err := cn.send('Q', append([]byte(query), 0)); err != nil {
+		return err
+	}
+	for {
+		t, r, err := cn.recv()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case 'G':
+			cn.buf = cn.buf[:0]
+			return cn.writeCopyData(binaryCopyHeader)
+		case 'E':
+			err := parseCopyError(r)
+			cn.waitReady()
+			return err
+		case 'N', 'S':
+		default:
+			return fmt.Errorf("unexpected response for COPY: %q", t)
+		}
+	}
+}
+
+// writeCopyData adds data as CopyData message.
+func (cn *copyConn) writeCopyData(data []byte) error {
+	cn.buf = append(cn.buf, 'd')
+	cn.buf = appendInt32(cn.buf, int32(len(data)+4))
+	cn.buf = append(cn.buf, data...)
+	if len(cn.buf) > copyFlushSize {
+		return cn.flush()
+	}
+	return nil
+}
+
+func (cn *copyConn) flush() error {
+	if len(cn.buf) == 0 {
+		return nil
+	}
+	_, err := cn.c.Write(cn.buf)
+	cn.buf = cn.buf[:0]
+	return err
+}
+
+// writeTuple adds a tuple in the binary COPY format (see encodeBinaryRow).
+func (cn *copyConn) writeTuple(tuple []byte) error {
+	return cn.writeCopyData(tuple)
+}
+
+// endCopy finishes the COPY and returns errors of the COPY.
+func (cn *copyConn) endCopy() error {
+	if err := cn.writeCopyData(binaryCopyTrailer); err != nil {
+		return err
+	}
+	if err := cn.flush(); err != nil {
+		return err
+	}
+	if err := cn.send('c', nil); err != nil {
+		return err
+	}
+	return cn.waitReady()
+}
+
+// close terminates the connection, open transactions are rolled back.
+func (cn *copyConn) close() error {
+	return cn.cn.Close()
+}
@@ -0,0 +1,34 @@
+package postgis
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSslMode(t *testing.T) {
+	env := os.Getenv("PGSSLMODE")
+	defer os.Setenv("PGSSLMODE", env)
+
+	os.Setenv("PGSSLMODE", "")
+	if m := sslMode("host=localhost dbname=osm"); m != "" {
+		t.Errorf("unexpected sslmode %q", m)
+	}
+	if m := sslMode("host=localhost sslmode=verify-full dbname=osm"); m != "verify-full" {
+		t.Errorf("unexpected sslmode %q", m)
+	}
+
+	os.Setenv("PGSSLMODE", "disable")
+	if m := sslMode("host=localhost dbname=osm"); m != "disable" {
+		t.Errorf("unexpected sslmode %q", m)
+	}
+	if m := sslMode("host=localhost sslmode=require"); m != "require" {
+		t.Errorf("unexpected sslmode %q", m)
+	}
+}
+
+func TestParseCopyError(t *testing.T) {
+	err := parseCopyError([]byte("SERROR\x00C22P02\x00Minvalid input syntax\x00\x00"))
+	if err.Error() != "pq: invalid input syntax (SQLSTATE 22P02)" {
+		t.Errorf("unexpected error %q", err)
+	}
+}
@@ -9,16 +9,23 @@ type ColumnType interface {
 	PrepareInsertSql(i int,
 		spec *TableSpec) string
 	GeneralizeSql(colSpec *ColumnSpec, spec *GeneralizedTableSpec) string
+	// EncodeBinary appends val in the binary COPY format to buf.
+	EncodeBinary(buf []byte, val interface{}) ([]byte, error)
 }
 
 type simpleColumnType struct {
-	name string
+	name   string
+	encode binaryEncoder
 }
 
 func (t *simpleColumnType) Name() string {
 	return t.name
 }
 
+func (t *simpleColumnType) EncodeBinary(buf []byte, val interface{}) ([]byte, error) {
+	return t.encode(buf, val)
+}
+
 func (t *simpleColumnType) PrepareInsertSql(i int, spec *TableSpec) string {
 	return fmt.Sprintf("$%d", i)
 }
@@ -43,15 +50,19 @@ func (t *geometryType) Name() string {
 	return t.name
 }
 
+func (t *geometryType) EncodeBinary(buf []byte, val interface{}) ([]byte, error) {
+	return encodeGeometry(buf, val)
+}
+
 func (t *geometryType) PrepareInsertSql(i int, spec *TableSpec) string {
-	return fmt.Sprintf("$%d::Geometry",
+	return fmt.Sprintf("ST_GeomFromEWKB($%d)",
 		i,
 	)
 }
 
 func (t *geometryType) GeneralizeSql(colSpec *ColumnSpec, spec *GeneralizedTableSpec) string {
-	return fmt.Sprintf(`ST_SimplifyPreserveTopology("%s", %f) as "%s"`,
-		colSpec.Name, spec.Tolerance, colSpec.Name,
+	return fmt.Sprintf(`%s as "%s"`,
+		spec.simplifySQL(`"`+colSpec.Name+`"`), colSpec.Name,
 	)
 }
 
@@ -60,8 +71,8 @@ type validatedGeometryType struct {
 }
 
 func (t *validatedGeometryType) GeneralizeSql(colSpec *ColumnSpec, spec *GeneralizedTableSpec) string {
-	return fmt.Sprintf(`ST_Buffer(ST_SimplifyPreserveTopology("%s", %f), 0) as "%s"`,
-		colSpec.Name, spec.Tolerance, colSpec.Name,
+	return fmt.Sprintf(`ST_Buffer(%s, 0) as "%s"`,
+		spec.simplifySQL(`"`+colSpec.Name+`"`), colSpec.Name,
 	)
 }
 
@@ -69,14 +80,14 @@ var pgTypes map[string]ColumnType
 
 func init() {
 	pgTypes = map[string]ColumnType{
-		"string":             &simpleColumnType{"VARCHAR"},
-		"bool":               &simpleColumnType{"BOOL"},
-		"int8":               &simpleColumnType{"SMALLINT"},
-		"int32":              &simpleColumnType{"INT"},
-		"int64":              &simpleColumnType{"BIGINT"},
-		"float32":            &simpleColumnType{"REAL"},
-		"hstore_string":      &simpleColumnType{"HSTORE"},
-		"member_string":      &simpleColumnType{"BIGINT[]"},
+		"string":             &simpleColumnType{"VARCHAR", encodeString},
+		"bool":               &simpleColumnType{"BOOL", encodeBool},
+		"int8":               &simpleColumnType{"SMALLINT", encodeInt16},
+		"int32":              &simpleColumnType{"INT", encodeInt32},
+		"int64":              &simpleColumnType{"BIGINT", encodeInt64},
+		"float32":            &simpleColumnType{"REAL", encodeFloat32},
+		"hstore_string":      &simpleColumnType{"HSTORE", encodeHstore},
+		"member_string":      &simpleColumnType{"BIGINT[]", encodeInt64Array},
 		"geometry":           &geometryType{"GEOMETRY"},
 		"validated_geometry": &validatedGeometryType{geometryType{"GEOMETRY"}},
 	}
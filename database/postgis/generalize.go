@@ -0,0 +1,151 @@
+package postgis
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/geom/geos"
+	"github.com/olehz/imposm3/mapping"
+)
+
+// generalizeUpdatesBatchSize is the max number of ids for each
+// INSERT ... SELECT of GeneralizeUpdates.
+const generalizeUpdatesBatchSize = 10000
+
+// generalizeGeom returns a new generalized geometry, or nil if the
+// geometry is smaller then MinArea or if nothing is left after the
+// generalization.
+func generalizeGeom(g *geos.Geos, spec *GeneralizedTableSpec, geom *geos.Geom) *geos.Geom {
+	if spec.MinArea > 0 && geom.Area() < spec.MinArea {
+		return nil
+	}
+
+	var result *geos.Geom
+	switch spec.Method {
+	case mapping.SnapToGrid:
+		result = g.SnapToGrid(geom, spec.Tolerance)
+	default:
+		result = g.SimplifyPreserveTopology(geom, spec.Tolerance)
+	}
+	if result == nil {
+		return nil
+	}
+
+	for _, col := range spec.Source.Columns {
+		if _, ok := col.Type.(*validatedGeometryType); ok {
+			buffered := g.Buffer(result, 0)
+			g.Destroy(result)
+			if buffered == nil {
+				return nil
+			}
+			result = buffered
+			break
+		}
+	}
+
+	if g.IsEmpty(result) {
+		g.Destroy(result)
+		return nil
+	}
+	return result
+}
+
+// insertGeneralizedInWrite inserts the generalized geometries of elem
+// into all generalized tables of the matches that are computed
+// during the write.
+func (pg *PostGIS) insertGeneralizedInWrite(elem element.OSMElem, matches []mapping.Match) error {
+	if elem.Geom == nil || elem.Geom.Geom == nil {
+		return nil
+	}
+
+	var g *geos.Geos
+	for _, match := range matches {
+		tbl := pg.Tables[match.Table.Name]
+		geomCol := tbl.geometryColumn()
+		if geomCol < 0 {
+			continue
+		}
+
+		// generalized geometries by table name, for
+		// generalized tables with generalized sources
+		geoms := make(map[string]*geos.Geom)
+		var generalized func(spec *GeneralizedTableSpec) *geos.Geom
+		generalized = func(spec *GeneralizedTableSpec) *geos.Geom {
+			if geom, ok := geoms[spec.Name]; ok {
+				return geom
+			}
+			source := elem.Geom.Geom
+			if spec.SourceGeneralized != nil {
+				source = generalized(spec.SourceGeneralized)
+			}
+			var geom *geos.Geom
+			if source != nil {
+				geom = generalizeGeom(g, spec, source)
+			}
+			geoms[spec.Name] = geom
+			return geom
+		}
+
+		for _, spec := range tbl.Generalizations {
+			if !spec.InWrite {
+				continue
+			}
+			if g == nil {
+				g = geos.NewGeos()
+				defer g.Finish()
+				g.SetHandleSrid(pg.Config.Srid)
+			}
+			geom := generalized(spec)
+			if geom == nil {
+				continue
+			}
+			row := match.Row(&elem)
+			row[geomCol] = g.AsEwkb(geom)
+			if err := pg.txRouter.Insert(spec.Name, row); err != nil {
+				return err
+			}
+			pg.recordInsert(spec.FullName, elem.Id)
+		}
+		for _, geom := range geoms {
+			if geom != nil {
+				g.Destroy(geom)
+			}
+		}
+	}
+	return nil
+}
+
+// recordUpdatedIds marks id as updated for all generalized tables
+// that are updated by GeneralizeUpdates.
+func (pg *PostGIS) recordUpdatedIds(id int64, matches []mapping.Match) {
+	for _, generalizedTable := range pg.generalizedFromMatches(matches) {
+		if generalizedTable.InWrite {
+			continue
+		}
+		pg.updatedIds[generalizedTable.Name] = append(pg.updatedIds[generalizedTable.Name], id)
+	}
+}
+
+// int64ArrayLiteral returns an SQL array literal ({1,2,3}) of ids.
+func int64ArrayLiteral(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// uniqueIds returns ids without duplicates.
+func uniqueIds(ids []int64) []int64 {
+	seen := make(map[int64]struct{}, len(ids))
+	result := ids[:0]
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+	}
+	return result
+}
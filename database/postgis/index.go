@@ -0,0 +1,162 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/olehz/imposm3/mapping"
+)
+
+// indexSQL returns the statement to create idx on tableName.
+func indexSQL(schema, tableName string, idx *mapping.Index, tablespace string) string {
+	var expr string
+	if idx.Expression != "" {
+		expr = "(" + idx.Expression + ")"
+	} else {
+		var cols []string
+		for _, col := range idx.Columns {
+			cols = append(cols, `"`+col+`"`)
+		}
+		expr = strings.Join(cols, ", ")
+	}
+	sql := fmt.Sprintf(`CREATE INDEX "%s_%s" ON "%s"."%s" USING %s (%s)`,
+		tableName, idx.IndexName(), schema, tableName, strings.ToUpper(string(idx.Method)), expr)
+	if tablespace != "" {
+		sql += fmt.Sprintf(` TABLESPACE "%s"`, tablespace)
+	}
+	if idx.Where != "" {
+		sql += " WHERE " + idx.Where
+	}
+	return sql
+}
+
+// cellIndex is the index of aggregated tables for the grid cell updates.
+var cellIndex = &mapping.Index{Columns: []string{"cell_x", "cell_y"}, Method: mapping.BTree}
+
+// indexes returns the additional indexes of the generalized table, the
+// indexes of the source table and of the generalized table itself.
+// Aggregated tables only use source indexes on the remaining columns and
+// an index on the grid cell.
+func (spec *GeneralizedTableSpec) indexes() []*mapping.Index {
+	own := make(map[string]bool)
+	for _, idx := range spec.Indexes {
+		own[idx.IndexName()] = true
+	}
+
+	columns := make(map[string]bool)
+	for _, col := range spec.columns() {
+		columns[col.Name] = true
+	}
+
+	var indexes []*mapping.Index
+NextIndex:
+	for _, idx := range spec.Source.Indexes {
+		if own[idx.IndexName()] {
+			continue
+		}
+		if spec.Aggregate {
+			if idx.Expression != "" {
+				continue
+			}
+			for _, col := range idx.Columns {
+				if !columns[col] {
+					continue NextIndex
+				}
+			}
+		}
+		indexes = append(indexes, idx)
+	}
+	if spec.Aggregate && !own[cellIndex.IndexName()] {
+		indexes = append(indexes, cellIndex)
+	}
+	return append(indexes, spec.Indexes...)
+}
+
+// tableIndexes returns the additional indexes of all tables by the full
+// table name.
+func (pg *PostGIS) tableIndexes() map[string][]*mapping.Index {
+	indexes := make(map[string][]*mapping.Index)
+	for _, tbl := range pg.Tables {
+		indexes[tbl.FullName] = tbl.Indexes
+	}
+	for _, tbl := range pg.GeneralizedTables {
+		indexes[tbl.FullName] = tbl.indexes()
+	}
+	return indexes
+}
+
+// missingIndexesSQL returns the statements to create all indexes that
+// are missing on the table in schema, e.g. for tables that were
+// imported before the indexes were added to the mapping.
+func (pg *PostGIS) missingIndexesSQL(tx *sql.Tx, schema, tableName string) ([]string, error) {
+	indexes := pg.tableIndexes()[tableName]
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf(`SELECT indexname FROM pg_indexes WHERE schemaname = '%s' AND tablename = '%s'`,
+		schema, tableName)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var tablespace string
+	for _, tbl := range pg.Tables {
+		if tbl.FullName == tableName {
+			tablespace = tbl.Storage.Tablespace
+		}
+	}
+	var stmts []string
+	for _, idx := range indexes {
+		if hasIndex(existing, tableName+"_"+idx.IndexName()) {
+			continue
+		}
+		log.Printf("creating missing index %s_%s", tableName, idx.IndexName())
+		stmts = append(stmts, indexSQL(schema, tableName, idx, tablespace))
+	}
+	return stmts, nil
+}
+
+// hasIndex returns whether existing contains the index name, either
+// as is or as renamed by indexRenamesSQL.
+func hasIndex(existing map[string]bool, name string) bool {
+	// PostgreSQL truncates identifiers to 63 bytes
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	if existing[name] {
+		return true
+	}
+	for other := range existing {
+		i := strings.LastIndex(other, "_")
+		if i < 0 {
+			continue
+		}
+		if _, err := strconv.Atoi(other[i+1:]); err != nil {
+			continue
+		}
+		suffix := other[i:]
+		base := name
+		if len(base)+len(suffix) > 63 {
+			base = base[:63-len(suffix)]
+		}
+		if base+suffix == other {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// updateMarkerTable is the name of the table in the production schema
+// that contains the marker of the last committed update.
+func (pg *PostGIS) updateMarkerTable() string {
+	return fmt.Sprintf(`"%s"."%simposm_update"`, pg.Config.ProductionSchema, pg.Prefix)
+}
+
+func (pg *PostGIS) SetUpdateMarker(marker string, sequence int32) {
+	pg.updateMarker = marker
+	pg.updateSequence = sequence
+}
+
+// writeUpdateMarker replaces the marker in the update table within tx.
+// The table is created if it does not exist.
+func (pg *PostGIS) writeUpdateMarker(tx *sql.Tx) error {
+	if tx == nil {
+		return fmt.Errorf("update marker requires a transaction")
+	}
+	table := pg.updateMarkerTable()
+	for _, sql := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			marker VARCHAR NOT NULL,
+			sequence INTEGER,
+			updated TIMESTAMP NOT NULL DEFAULT now()
+		)`, table),
+		fmt.Sprintf(`DELETE FROM %s`, table),
+	} {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	sql := fmt.Sprintf(`INSERT INTO %s (marker, sequence) VALUES ($1, $2)`, table)
+	if _, err := tx.Exec(sql, pg.updateMarker, pg.updateSequence); err != nil {
+		return &SQLError{sql, err}
+	}
+	return nil
+}
+
+func (pg *PostGIS) UpdateMarker() (string, error) {
+	table := pg.updateMarkerTable()
+	var exists bool
+	query := `SELECT to_regclass($1) IS NOT NULL`
+	if err := pg.Db.QueryRow(query, table).Scan(&exists); err != nil {
+		return "", &SQLError{query, err}
+	}
+	if !exists {
+		return "", nil
+	}
+	var marker string
+	query = fmt.Sprintf(`SELECT marker FROM %s LIMIT 1`, table)
+	err := pg.Db.QueryRow(query).Scan(&marker)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", &SQLError{query, err}
+	}
+	return marker, nil
+}
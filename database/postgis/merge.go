@@ -0,0 +1,314 @@
+package postgis
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// merged returns true if the table dissolves the source geometries by
+// the MergeBy columns.
+func (spec *GeneralizedTableSpec) merged() bool {
+	return len(spec.MergeBy) > 0
+}
+
+// mergeSQL returns a query that dissolves all adjacent geometries from
+// table (with the optional cond) with the same MergeBy values. Each
+// connected component is one row. The id column contains the smallest id
+// of all geometries of the component, all other columns are NULL.
+func mergeSQL(spec *GeneralizedTableSpec, table, cond string) string {
+	mergeBy := make(map[string]bool)
+	for _, name := range spec.MergeBy {
+		mergeBy[name] = true
+	}
+	var where, andWhere string
+	if cond != "" {
+		where = " WHERE " + cond
+		andWhere = cond + " AND "
+	}
+
+	var geom string
+	var groupBy, sameGroup []string
+	for _, col := range spec.Source.Columns {
+		switch {
+		case col.Type.Name() == "GEOMETRY":
+			geom = col.Name
+		case mergeBy[col.Name]:
+			groupBy = append(groupBy, `"`+col.Name+`"`)
+			sameGroup = append(sameGroup, fmt.Sprintf(`s."%s" IS NOT DISTINCT FROM components."%s"`, col.Name, col.Name))
+		}
+	}
+
+	var cols []string
+	for _, col := range spec.Source.Columns {
+		switch {
+		case col.Type.Name() == "GEOMETRY":
+			cols = append(cols, `"`+col.Name+`"`)
+		case col.FieldType.Name == "id":
+			cols = append(cols, fmt.Sprintf(
+				`(SELECT min(s."%s") FROM %s AS s WHERE %s%s AND ST_Intersects(s."%s", components."%s")) AS "%s"`,
+				col.Name, table, andWhere, strings.Join(sameGroup, " AND "), geom, geom, col.Name))
+		case mergeBy[col.Name]:
+			cols = append(cols, `"`+col.Name+`"`)
+		default:
+			cols = append(cols, fmt.Sprintf(`NULL::%s AS "%s"`, col.Type.Name(), col.Name))
+		}
+	}
+	components := fmt.Sprintf(`SELECT %s, (ST_Dump(ST_Union("%s"))).geom AS "%s" FROM %s%s GROUP BY %s`,
+		strings.Join(groupBy, ", "), geom, geom, table, where, strings.Join(groupBy, ", "))
+	return fmt.Sprintf(`SELECT %s FROM (%s) AS components`, strings.Join(cols, ", "), components)
+}
+
+// mergeKeySQL returns the expression for the text representation of the
+// MergeBy values of a row (of the table alias, if set), which identifies
+// the merge group of the row.
+func (spec *GeneralizedTableSpec) mergeKeySQL(alias string) string {
+	if alias != "" {
+		alias += "."
+	}
+	var cols []string
+	for _, name := range spec.MergeBy {
+		cols = append(cols, alias+`"`+name+`"`)
+	}
+	return "ROW(" + strings.Join(cols, ", ") + ")::text"
+}
+
+// mergeSourceName returns the table the merged table is created from.
+func (spec *GeneralizedTableSpec) mergeSourceName() string {
+	if spec.SourceGeneralized != nil {
+		return spec.SourceGeneralized.FullName
+	}
+	return spec.Source.FullName
+}
+
+// mergeSource returns the source table, filtered by all conditions.
+func (spec *GeneralizedTableSpec) mergeSource() string {
+	table := fmt.Sprintf(`"%s"."%s"`, spec.Source.Schema, spec.mergeSourceName())
+	if cond := spec.whereSQL(); cond != "" {
+		return fmt.Sprintf(`(SELECT * FROM %s WHERE %s)`, table, cond)
+	}
+	return table
+}
+
+// componentsSQL returns a query for the ids and merge groups of all
+// source geometries that are connected with the source geometries with
+// the ids from $1, including these geometries.
+func (spec *GeneralizedTableSpec) componentsSQL() string {
+	var geom string
+	for _, col := range spec.Source.Columns {
+		if col.Type.Name() == "GEOMETRY" {
+			geom = col.Name
+			break
+		}
+	}
+	id := spec.Source.idColumn()
+	source := spec.mergeSource()
+	return fmt.Sprintf(`WITH RECURSIVE component(id, key) AS (`+
+		`SELECT "%s", %s FROM %s AS s WHERE "%s" = ANY($1::bigint[]) `+
+		`UNION SELECT s."%s", %s FROM component `+
+		`JOIN %s AS m ON m."%s" = component.id AND %s = component.key `+
+		`JOIN %s AS s ON %s = component.key AND ST_Intersects(s."%s", m."%s")`+
+		`) SELECT id, key FROM component`,
+		id, spec.mergeKeySQL(""), source, id,
+		id, spec.mergeKeySQL("s"),
+		source, id, spec.mergeKeySQL("m"),
+		source, spec.mergeKeySQL("s"), geom, geom)
+}
+
+// mergeUpdateSQL returns the statements to delete and recompute the
+// connected components of the merge group $1 with the source ids from $2.
+// Both return the ids of the deleted and inserted rows.
+func (spec *GeneralizedTableSpec) mergeUpdateSQL() []string {
+	id := spec.Source.idColumn()
+	inComponents := fmt.Sprintf(`%s = $1 AND "%s" = ANY($2::bigint[])`, spec.mergeKeySQL(""), id)
+
+	cond := inComponents
+	if where := spec.whereSQL(); where != "" {
+		cond = where + " AND " + cond
+	}
+	table := fmt.Sprintf(`"%s"."%s"`, spec.Source.Schema, spec.mergeSourceName())
+
+	var names, cols []string
+	for _, col := range spec.Source.Columns {
+		names = append(names, `"`+col.Name+`"`)
+		cols = append(cols, col.Type.GeneralizeSql(&col, spec))
+	}
+	returning := fmt.Sprintf(` RETURNING "%s"`, id)
+	return []string{
+		fmt.Sprintf(`DELETE FROM "%s"."%s" WHERE %s`, spec.Schema, spec.FullName, inComponents) + returning,
+		fmt.Sprintf(`INSERT INTO "%s"."%s" (%s) SELECT %s FROM (%s) AS merged`,
+			spec.Schema, spec.FullName, strings.Join(names, ", "),
+			strings.Join(cols, ",\n"), mergeSQL(spec, table, cond)) + returning,
+	}
+}
+
+// mergeTableTx collects all connected components of updated and deleted
+// source geometries. update recomputes all collected components.
+//
+// Delete needs to be called before the source geometry is deleted
+// and Insert (with an array of ids) after the source geometry was
+// inserted.
+type mergeTableTx struct {
+	Pg             *PostGIS
+	Tx             *sql.Tx
+	Spec           *GeneralizedTableSpec
+	ComponentsStmt *sql.Stmt
+	ComponentsSql  string
+	// components contains the source ids of all collected components
+	// by merge group
+	components map[string]map[int64]struct{}
+	// counts and changes record all recomputed rows, if set
+	counts  *rowCounter
+	changes *changeTracker
+}
+
+func NewMergeTableTx(pg *PostGIS, spec *GeneralizedTableSpec) TableTx {
+	return &mergeTableTx{
+		Pg:         pg,
+		Spec:       spec,
+		components: make(map[string]map[int64]struct{}),
+	}
+}
+
+func (tt *mergeTableTx) Begin(tx *sql.Tx) error {
+	var err error
+	if tx == nil {
+		tx, err = tt.Pg.Db.Begin()
+		if err != nil {
+			return err
+		}
+	}
+	tt.Tx = tx
+
+	tt.ComponentsSql = tt.Spec.componentsSQL()
+	stmt, err := tt.Tx.Prepare(tt.ComponentsSql)
+	if err != nil {
+		return &SQLError{tt.ComponentsSql, err}
+	}
+	tt.ComponentsStmt = stmt
+	return nil
+}
+
+// Insert adds the components of the ids (array literal in row[0]).
+func (tt *mergeTableTx) Insert(row []interface{}) error {
+	return tt.addComponents(row[0])
+}
+
+// Delete adds the component of the source geometry with id.
+func (tt *mergeTableTx) Delete(id int64) error {
+	return tt.addComponents(int64ArrayLiteral([]int64{id}))
+}
+
+func (tt *mergeTableTx) addComponents(ids interface{}) error {
+	rows, err := tt.ComponentsStmt.Query(ids)
+	if err != nil {
+		return &SQLInsertError{SQLError{tt.ComponentsSql, err}, ids}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var group string
+		if err := rows.Scan(&id, &group); err != nil {
+			return err
+		}
+		if tt.components[group] == nil {
+			tt.components[group] = make(map[int64]struct{})
+		}
+		tt.components[group][id] = struct{}{}
+	}
+	return rows.Err()
+}
+
+// update recomputes all collected components. The components are
+// collected again, as they can be connected by geometries that were
+// inserted after they were added.
+func (tt *mergeTableTx) update() error {
+	if len(tt.components) == 0 {
+		return nil
+	}
+	var ids []int64
+	for _, groupIds := range tt.components {
+		for id := range groupIds {
+			ids = append(ids, id)
+		}
+	}
+	if err := tt.addComponents(int64ArrayLiteral(ids)); err != nil {
+		return err
+	}
+
+	groups := make([]string, 0, len(tt.components))
+	for g := range tt.components {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	stmts := tt.Spec.mergeUpdateSQL()
+	for _, group := range groups {
+		ids = ids[:0]
+		for id := range tt.components[group] {
+			ids = append(ids, id)
+		}
+		for i, sql := range stmts {
+			// the first statement deletes, the second inserts
+			if err := tt.execIds(sql, i > 0, group, int64ArrayLiteral(ids)); err != nil {
+				return err
+			}
+		}
+	}
+	tt.components = make(map[string]map[int64]struct{})
+	return nil
+}
+
+// execIds executes the DELETE or INSERT sql and records the returned
+// ids as deleted or inserted.
+func (tt *mergeTableTx) execIds(sql string, inserted bool, args ...interface{}) error {
+	rows, err := tt.Tx.Query(sql, args...)
+	if err != nil {
+		return &SQLError{sql, err}
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		n++
+		if tt.changes == nil {
+			continue
+		}
+		if inserted {
+			tt.changes.inserted(tt.Spec.FullName, id)
+		} else {
+			tt.changes.deleted(tt.Spec.FullName, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if tt.counts != nil {
+		if inserted {
+			tt.counts.inserted(tt.Spec.FullName, n)
+		} else {
+			tt.counts.deleted(tt.Spec.FullName, n)
+		}
+	}
+	return nil
+}
+
+func (tt *mergeTableTx) End() {
+}
+
+func (tt *mergeTableTx) Commit() error {
+	err := tt.Tx.Commit()
+	if err != nil {
+		return err
+	}
+	tt.Tx = nil
+	return nil
+}
+
+func (tt *mergeTableTx) Rollback() {
+	rollbackIfTx(&tt.Tx)
+}
@@ -48,7 +48,12 @@ func createTable(tx *sql.Tx, spec TableSpec) error {
 	if err != nil {
 		return &SQLError{sql, err}
 	}
-	if spec.GeometryType == "" {
+	for _, sql := range spec.CreatePartitionsSQL() {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	if spec.GeometryType == "" || spec.partitioned() {
 		return nil
 	}
 
@@ -68,12 +73,8 @@ func addGeometryColumn(tx *sql.Tx, tableName string, spec TableSpec) error {
 		}
 	}
 
-	geomType := strings.ToUpper(spec.GeometryType)
-	if geomType == "POLYGON" {
-		geomType = "GEOMETRY" // for multipolygon support
-	}
 	sql := fmt.Sprintf("SELECT AddGeometryColumn('%s', '%s', '%s', '%d', '%s', 2);",
-		spec.Schema, tableName, colName, spec.Srid, geomType)
+		spec.Schema, tableName, colName, spec.Srid, spec.geometryTypeSQL())
 	row := tx.QueryRow(sql)
 	var void interface{}
 	err := row.Scan(&void)
@@ -139,6 +140,14 @@ func (pg *PostGIS) Init() error {
 			return err
 		}
 	}
+	for _, spec := range pg.GeneralizedTables {
+		if !spec.InWrite {
+			continue
+		}
+		if err := createTable(tx, *spec.tableSpec()); err != nil {
+			return err
+		}
+	}
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -147,7 +156,8 @@ func (pg *PostGIS) Init() error {
 	return nil
 }
 
-// Finish creates spatial indices on all tables.
+// Finish changes unlogged tables to logged and creates spatial indices
+// and all additional indices of the mapping on all tables.
 func (pg *PostGIS) Finish() error {
 	defer log.StopStep(log.StartStep(fmt.Sprintf("Creating geometry indices")))
 
@@ -156,23 +166,47 @@ func (pg *PostGIS) Finish() error {
 		worker = 1
 	}
 
-	p := newWorkerPool(worker, len(pg.Tables)+len(pg.GeneralizedTables))
+	// change to logged first, SET LOGGED rewrites all existing indices
+	p := newWorkerPool(worker, len(pg.Tables))
+	for _, tbl := range pg.Tables {
+		table := tbl
+		if table.Storage.Unlogged {
+			p.in <- func() error {
+				return setLogged(pg, table.storageTables())
+			}
+		}
+	}
+	if err := p.wait(); err != nil {
+		return err
+	}
+
+	var tasks []func() error
 	for _, tbl := range pg.Tables {
 		tableName := tbl.FullName
 		table := tbl
-		p.in <- func() error {
-			return createIndex(pg, tableName, table.Columns)
+		tasks = append(tasks, func() error {
+			return createIndex(pg, tableName, table.Columns, table.Storage.Tablespace)
+		})
+		for _, idx := range table.Indexes {
+			tasks = append(tasks, customIndexTask(pg, tableName, idx, table.Storage.Tablespace))
 		}
 	}
 
 	for _, tbl := range pg.GeneralizedTables {
 		tableName := tbl.FullName
 		table := tbl
-		p.in <- func() error {
-			return createIndex(pg, tableName, table.Source.Columns)
+		tasks = append(tasks, func() error {
+			return createIndex(pg, tableName, table.columns(), "")
+		})
+		for _, idx := range table.indexes() {
+			tasks = append(tasks, customIndexTask(pg, tableName, idx, ""))
 		}
 	}
 
+	p = newWorkerPool(worker, len(tasks))
+	for _, task := range tasks {
+		p.in <- task
+	}
 	err := p.wait()
 	if err != nil {
 		return err
@@ -181,11 +215,42 @@ func (pg *PostGIS) Finish() error {
 	return nil
 }
 
-func createIndex(pg *PostGIS, tableName string, columns []ColumnSpec) error {
+func customIndexTask(pg *PostGIS, tableName string, idx *mapping.Index, tablespace string) func() error {
+	return func() error {
+		sql := indexSQL(pg.Config.ImportSchema, tableName, idx, tablespace)
+		step := log.StartStep(fmt.Sprintf("Creating index %s on %s", idx.IndexName(), tableName))
+		_, err := pg.Db.Exec(sql)
+		log.StopStep(step)
+		if err != nil {
+			return &SQLError{sql, err}
+		}
+		return nil
+	}
+}
+
+func setLogged(pg *PostGIS, tableNames []string) error {
+	for _, tableName := range tableNames {
+		sql := fmt.Sprintf(`ALTER TABLE "%s"."%s" SET LOGGED`,
+			pg.Config.ImportSchema, tableName)
+		step := log.StartStep(fmt.Sprintf("Changing %s to logged", tableName))
+		_, err := pg.Db.Exec(sql)
+		log.StopStep(step)
+		if err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return nil
+}
+
+func createIndex(pg *PostGIS, tableName string, columns []ColumnSpec, tablespace string) error {
+	var tablespaceSQL string
+	if tablespace != "" {
+		tablespaceSQL = fmt.Sprintf(` TABLESPACE "%s"`, tablespace)
+	}
 	for _, col := range columns {
 		if col.Type.Name() == "GEOMETRY" {
-			sql := fmt.Sprintf(`CREATE INDEX "%s_geom" ON "%s"."%s" USING GIST ("%s")`,
-				tableName, pg.Config.ImportSchema, tableName, col.Name)
+			sql := fmt.Sprintf(`CREATE INDEX "%s_geom" ON "%s"."%s" USING GIST ("%s")%s`,
+				tableName, pg.Config.ImportSchema, tableName, col.Name, tablespaceSQL)
 			step := log.StartStep(fmt.Sprintf("Creating geometry index on %s", tableName))
 			_, err := pg.Db.Exec(sql)
 			log.StopStep(step)
@@ -194,8 +259,8 @@ func createIndex(pg *PostGIS, tableName string, columns []ColumnSpec) error {
 			}
 		}
 		if col.FieldType.Name == "id" {
-			sql := fmt.Sprintf(`CREATE INDEX "%s_osm_id_idx" ON "%s"."%s" USING BTREE ("%s")`,
-				tableName, pg.Config.ImportSchema, tableName, col.Name)
+			sql := fmt.Sprintf(`CREATE INDEX "%s_osm_id_idx" ON "%s"."%s" USING BTREE ("%s")%s`,
+				tableName, pg.Config.ImportSchema, tableName, col.Name, tablespaceSQL)
 			step := log.StartStep(fmt.Sprintf("Creating OSM id index on %s", tableName))
 			_, err := pg.Db.Exec(sql)
 			log.StopStep(step)
@@ -211,8 +276,26 @@ func (pg *PostGIS) GeneralizeUpdates() error {
 	defer log.StopStep(log.StartStep(fmt.Sprintf("Updating generalized tables")))
 	for _, table := range pg.sortedGeneralizedTables() {
 		if ids, ok := pg.updatedIds[table]; ok {
-			for _, id := range ids {
-				pg.txRouter.Insert(table, []interface{}{id})
+			ids = uniqueIds(ids)
+			for len(ids) > 0 {
+				n := len(ids)
+				if n > generalizeUpdatesBatchSize {
+					n = generalizeUpdatesBatchSize
+				}
+				if err := pg.txRouter.Insert(table, []interface{}{int64ArrayLiteral(ids[:n])}); err != nil {
+					return err
+				}
+				ids = ids[n:]
+			}
+		}
+		switch tt := pg.txRouter.Tables[table].(type) {
+		case *aggregateTableTx:
+			if err := tt.update(); err != nil {
+				return err
+			}
+		case *mergeTableTx:
+			if err := tt.update(); err != nil {
+				return err
 			}
 		}
 	}
@@ -233,8 +316,11 @@ func (pg *PostGIS) Generalize() error {
 		if table.SourceGeneralized == nil {
 			tbl := table // for following closure
 			p.in <- func() error {
-				if err := pg.generalizeTable(tbl); err != nil {
-					return err
+				// tables computed during write are already filled
+				if !tbl.InWrite {
+					if err := pg.generalizeTable(tbl); err != nil {
+						return err
+					}
 				}
 				tbl.created = true
 				return nil
@@ -257,8 +343,10 @@ func (pg *PostGIS) Generalize() error {
 			if !table.created && table.SourceGeneralized.created {
 				tbl := table // for following closure
 				p.in <- func() error {
-					if err := pg.generalizeTable(tbl); err != nil {
-						return err
+					if !tbl.InWrite {
+						if err := pg.generalizeTable(tbl); err != nil {
+							return err
+						}
 					}
 					tbl.created = true
 					atomic.StoreInt32(&created, 1)
@@ -285,8 +373,8 @@ func (pg *PostGIS) generalizeTable(table *GeneralizedTableSpec) error {
 	defer rollbackIfTx(&tx)
 
 	var where string
-	if table.Where != "" {
-		where = " WHERE " + table.Where
+	if cond := table.whereSQL(); cond != "" {
+		where = " WHERE " + cond
 	}
 	var cols []string
 
@@ -306,9 +394,17 @@ func (pg *PostGIS) generalizeTable(table *GeneralizedTableSpec) error {
 	} else {
 		sourceTable = table.Source.FullName
 	}
-	sql := fmt.Sprintf(`CREATE TABLE "%s"."%s" AS (SELECT %s FROM "%s"."%s"%s)`,
-		pg.Config.ImportSchema, table.FullName, columnSQL, pg.Config.ImportSchema,
-		sourceTable, where)
+	sourceTable = fmt.Sprintf(`"%s"."%s"`, pg.Config.ImportSchema, sourceTable)
+	source := sourceTable + where
+	if len(table.MergeBy) > 0 {
+		source = "(" + mergeSQL(table, sourceTable, table.whereSQL()) + ") AS merged"
+	}
+	query := fmt.Sprintf(`SELECT %s FROM %s`, columnSQL, source)
+	if table.Aggregate {
+		query = table.aggregateSQL(nil)
+	}
+	sql := fmt.Sprintf(`CREATE TABLE "%s"."%s" AS (%s)`,
+		pg.Config.ImportSchema, table.FullName, query)
 
 	_, err = tx.Exec(sql)
 	if err != nil {
@@ -343,14 +439,14 @@ func (pg *PostGIS) Optimize() error {
 		tableName := tbl.FullName
 		table := tbl
 		p.in <- func() error {
-			return clusterTable(pg, tableName, table.Srid, table.Columns)
+			return clusterTable(pg, tableName, table.storageTables(), table.Srid, table.Columns)
 		}
 	}
 	for _, tbl := range pg.GeneralizedTables {
 		tableName := tbl.FullName
 		table := tbl
 		p.in <- func() error {
-			return clusterTable(pg, tableName, table.Source.Srid, table.Source.Columns)
+			return clusterTable(pg, tableName, []string{tableName}, table.Source.Srid, table.columns())
 		}
 	}
 
@@ -362,25 +458,29 @@ func (pg *PostGIS) Optimize() error {
 	return nil
 }
 
-func clusterTable(pg *PostGIS, tableName string, srid int, columns []ColumnSpec) error {
+// clusterTable clusters each of the storageTables (the table itself or
+// all partitions) and analyses the table.
+func clusterTable(pg *PostGIS, tableName string, storageTables []string, srid int, columns []ColumnSpec) error {
 	for _, col := range columns {
 		if col.Type.Name() == "GEOMETRY" {
-			step := log.StartStep(fmt.Sprintf("Indexing %s on geohash", tableName))
-			sql := fmt.Sprintf(`CREATE INDEX "%s_geom_geohash" ON "%s"."%s" (ST_GeoHash(ST_Transform(ST_SetSRID(Box2D(%s), %d), 4326)))`,
-				tableName, pg.Config.ImportSchema, tableName, col.Name, srid)
-			_, err := pg.Db.Exec(sql)
-			log.StopStep(step)
-			if err != nil {
-				return err
-			}
+			for _, storageTable := range storageTables {
+				step := log.StartStep(fmt.Sprintf("Indexing %s on geohash", storageTable))
+				sql := fmt.Sprintf(`CREATE INDEX "%s_geom_geohash" ON "%s"."%s" (ST_GeoHash(ST_Transform(ST_SetSRID(Box2D(%s), %d), 4326)))`,
+					storageTable, pg.Config.ImportSchema, storageTable, col.Name, srid)
+				_, err := pg.Db.Exec(sql)
+				log.StopStep(step)
+				if err != nil {
+					return err
+				}
 
-			step = log.StartStep(fmt.Sprintf("Clustering %s on geohash", tableName))
-			sql = fmt.Sprintf(`CLUSTER "%s_geom_geohash" ON "%s"."%s"`,
-				tableName, pg.Config.ImportSchema, tableName)
-			_, err = pg.Db.Exec(sql)
-			log.StopStep(step)
-			if err != nil {
-				return err
+				step = log.StartStep(fmt.Sprintf("Clustering %s on geohash", storageTable))
+				sql = fmt.Sprintf(`CLUSTER "%s_geom_geohash" ON "%s"."%s"`,
+					storageTable, pg.Config.ImportSchema, storageTable)
+				_, err = pg.Db.Exec(sql)
+				log.StopStep(step)
+				if err != nil {
+					return err
+				}
 			}
 			break
 		}
@@ -408,6 +508,16 @@ type PostGIS struct {
 	txRouter                *TxRouter
 	updateGeneralizedTables bool
 	updatedIds              map[string][]int64
+	deployDryRun            bool
+	changeSink              database.ChangeSink
+	changeSequence          int32
+	// changes of the current transaction, only set with a changeSink
+	changes *changeTracker
+	// rowCounts is only set for non-bulk transactions
+	rowCounts *rowCounter
+	// updateMarker is stored with the next End, if set
+	updateMarker   string
+	updateSequence int32
 }
 
 func (pg *PostGIS) Open() error {
@@ -432,6 +542,7 @@ func (pg *PostGIS) InsertPoint(elem element.OSMElem, matches []mapping.Match) er
 			return err
 		}
 	}
+	pg.recordInserts(elem.Id, matches)
 	return nil
 }
 
@@ -442,10 +553,12 @@ func (pg *PostGIS) InsertLineString(elem element.OSMElem, matches []mapping.Matc
 			return err
 		}
 	}
+	pg.recordInserts(elem.Id, matches)
+	if err := pg.insertGeneralizedInWrite(elem, matches); err != nil {
+		return err
+	}
 	if pg.updateGeneralizedTables {
-		for _, generalizedTable := range pg.generalizedFromMatches(matches) {
-			pg.updatedIds[generalizedTable.Name] = append(pg.updatedIds[generalizedTable.Name], elem.Id)
-		}
+		pg.recordUpdatedIds(elem.Id, matches)
 	}
 	return nil
 }
@@ -457,22 +570,35 @@ func (pg *PostGIS) InsertPolygon(elem element.OSMElem, matches []mapping.Match)
 			return err
 		}
 	}
+	pg.recordInserts(elem.Id, matches)
+	if err := pg.insertGeneralizedInWrite(elem, matches); err != nil {
+		return err
+	}
 	if pg.updateGeneralizedTables {
-		for _, generalizedTable := range pg.generalizedFromMatches(matches) {
-			pg.updatedIds[generalizedTable.Name] = append(pg.updatedIds[generalizedTable.Name], elem.Id)
-		}
+		pg.recordUpdatedIds(elem.Id, matches)
 	}
 	return nil
 }
 
 func (pg *PostGIS) Delete(id int64, matches interface{}) error {
 	if matches, ok := matches.([]mapping.Match); ok {
+		if pg.updateGeneralizedTables {
+			// aggregated and merged tables need the source geometry before
+			// it is deleted
+			for _, generalizedTable := range pg.generalizedFromMatches(matches) {
+				if generalizedTable.Aggregate || generalizedTable.merged() {
+					pg.txRouter.Delete(generalizedTable.Name, id)
+				}
+			}
+		}
 		for _, match := range matches {
 			pg.txRouter.Delete(match.Table.Name, id)
 		}
 		if pg.updateGeneralizedTables {
 			for _, generalizedTable := range pg.generalizedFromMatches(matches) {
-				pg.txRouter.Delete(generalizedTable.Name, id)
+				if generalizedTable.incremental() {
+					pg.txRouter.Delete(generalizedTable.Name, id)
+				}
 			}
 		}
 	}
@@ -489,10 +615,19 @@ func (pg *PostGIS) DeleteElem(elem element.OSMElem) error {
 			if tableSpec.GeometryType != "polygon" {
 				continue
 			}
+			if pg.updateGeneralizedTables {
+				for _, genTable := range tableSpec.Generalizations {
+					if genTable.Aggregate || genTable.merged() {
+						pg.txRouter.Delete(genTable.Name, elem.Id)
+					}
+				}
+			}
 			pg.txRouter.Delete(tableSpec.Name, elem.Id)
 			if pg.updateGeneralizedTables {
 				for _, genTable := range tableSpec.Generalizations {
-					pg.txRouter.Delete(genTable.Name, elem.Id)
+					if genTable.incremental() {
+						pg.txRouter.Delete(genTable.Name, elem.Id)
+					}
 				}
 			}
 		}
@@ -533,6 +668,12 @@ func (pg *PostGIS) EnableGeneralizeUpdates() {
 
 func (pg *PostGIS) Begin() error {
 	var err error
+	if pg.changeSink != nil {
+		pg.changes = newChangeTracker(pg.changeSequence)
+	}
+	if pg.rowCounts == nil {
+		pg.rowCounts = newRowCounter()
+	}
 	pg.txRouter, err = newTxRouter(pg, false)
 	return err
 }
@@ -544,11 +685,35 @@ func (pg *PostGIS) BeginBulk() error {
 }
 
 func (pg *PostGIS) Abort() error {
-	return pg.txRouter.Abort()
+	err := pg.txRouter.Abort()
+	if pg.changes != nil {
+		pg.changes = nil
+		if serr := pg.changeSink.Abort(); err == nil {
+			err = serr
+		}
+	}
+	return err
 }
 
 func (pg *PostGIS) End() error {
-	return pg.txRouter.End()
+	if pg.updateMarker != "" {
+		if err := pg.writeUpdateMarker(pg.txRouter.tx); err != nil {
+			return err
+		}
+		pg.updateMarker = ""
+	}
+	if pg.changes == nil {
+		return pg.txRouter.End()
+	}
+	// changes are written with the transaction of the router
+	if err := pg.changeSink.Write(pg.txRouter.tx, pg.changes.result()); err != nil {
+		return err
+	}
+	if err := pg.txRouter.End(); err != nil {
+		return err
+	}
+	pg.changes = nil
+	return pg.changeSink.Commit()
 }
 
 func (pg *PostGIS) Close() error {
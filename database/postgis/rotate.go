@@ -1,17 +1,372 @@
 package postgis
 
 import (
+	"database/sql"
 	"fmt"
+	"strings"
 )
 
+// dependentView is a (materialized) view that depends on one of the
+// rotated tables, either directly or through another view.
+type dependentView struct {
+	Schema       string
+	Name         string
+	Materialized bool
+	Definition   string
+	Indices      []string
+	// Grants are the GRANT statements for all privileges of the view,
+	// except the implicit privileges of the owner
+	Grants []string
+	depth  int
+}
+
+// dependentForeignKey is a foreign key constraint of a non-imposm table
+// that references one of the rotated tables.
+type dependentForeignKey struct {
+	Schema     string
+	Table      string
+	Name       string
+	Definition string
+}
+
+// dependencies are all objects that reference tables in a schema and that
+// need to be dropped before and recreated after rotating these tables.
+type dependencies struct {
+	Views       []dependentView
+	ForeignKeys []dependentForeignKey
+}
+
+func (d *dependencies) dropSQL() []string {
+	var stmts []string
+	for _, fk := range d.ForeignKeys {
+		stmts = append(stmts, fmt.Sprintf(`ALTER TABLE "%s"."%s" DROP CONSTRAINT "%s"`,
+			fk.Schema, fk.Table, fk.Name))
+	}
+	// views are sorted by depth, drop views that depend on other views first
+	for i := len(d.Views) - 1; i >= 0; i-- {
+		v := d.Views[i]
+		if v.Materialized {
+			stmts = append(stmts, fmt.Sprintf(`DROP MATERIALIZED VIEW "%s"."%s"`, v.Schema, v.Name))
+		} else {
+			stmts = append(stmts, fmt.Sprintf(`DROP VIEW "%s"."%s"`, v.Schema, v.Name))
+		}
+	}
+	return stmts
+}
+
+func (d *dependencies) createSQL() []string {
+	var stmts []string
+	for _, v := range d.Views {
+		def := strings.TrimSuffix(strings.TrimSpace(v.Definition), ";")
+		if v.Materialized {
+			stmts = append(stmts, fmt.Sprintf(`CREATE MATERIALIZED VIEW "%s"."%s" AS %s`, v.Schema, v.Name, def))
+			stmts = append(stmts, v.Indices...)
+		} else {
+			stmts = append(stmts, fmt.Sprintf(`CREATE VIEW "%s"."%s" AS %s`, v.Schema, v.Name, def))
+		}
+		stmts = append(stmts, v.Grants...)
+	}
+	for _, fk := range d.ForeignKeys {
+		stmts = append(stmts, fmt.Sprintf(`ALTER TABLE "%s"."%s" ADD CONSTRAINT "%s" %s`,
+			fk.Schema, fk.Table, fk.Name, fk.Definition))
+	}
+	return stmts
+}
+
+// regclassArray returns an SQL array of all tables in schema.
+func regclassArray(schema string, tables []string) string {
+	var classes []string
+	for _, t := range tables {
+		classes = append(classes, fmt.Sprintf(`'"%s"."%s"'::regclass`, schema, t))
+	}
+	return "ARRAY[" + strings.Join(classes, ", ") + "]::oid[]"
+}
+
+// findDependencies returns all views and foreign keys that reference
+// the tables in schema. View and constraint definitions are relative to
+// the current search_path.
+func findDependencies(tx *sql.Tx, schema string, tables []string) (*dependencies, error) {
+	deps := &dependencies{}
+	if len(tables) == 0 {
+		return deps, nil
+	}
+	tableOids := regclassArray(schema, tables)
+
+	// views reference tables and other views through the rewrite rules,
+	// collect them recursively and keep the max. depth for ordering
+	query := fmt.Sprintf(`
+        WITH RECURSIVE views(oid, depth) AS (
+            SELECT DISTINCT r.ev_class, 1
+            FROM pg_depend d JOIN pg_rewrite r ON r.oid = d.objid
+            WHERE d.classid = 'pg_rewrite'::regclass
+                AND d.refclassid = 'pg_class'::regclass
+                AND d.refobjid = ANY(%s)
+                AND r.ev_class <> d.refobjid
+            UNION
+            SELECT r.ev_class, views.depth + 1
+            FROM views JOIN pg_depend d ON d.refobjid = views.oid
+                JOIN pg_rewrite r ON r.oid = d.objid
+            WHERE d.classid = 'pg_rewrite'::regclass
+                AND d.refclassid = 'pg_class'::regclass
+                AND r.ev_class <> d.refobjid
+        )
+        SELECT n.nspname, c.relname, c.relkind = 'm', pg_get_viewdef(c.oid), max(views.depth) AS depth
+        FROM views JOIN pg_class c ON c.oid = views.oid
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+        GROUP BY n.nspname, c.relname, c.relkind, c.oid
+        ORDER BY depth, n.nspname, c.relname`, tableOids)
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	for rows.Next() {
+		v := dependentView{}
+		if err := rows.Scan(&v.Schema, &v.Name, &v.Materialized, &v.Definition, &v.depth); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deps.Views = append(deps.Views, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, v := range deps.Views {
+		grants, err := viewGrants(tx, v.Schema, v.Name)
+		if err != nil {
+			return nil, err
+		}
+		deps.Views[i].Grants = grants
+
+		if !v.Materialized {
+			continue
+		}
+		query := fmt.Sprintf(`SELECT indexdef FROM pg_indexes WHERE schemaname = '%s' AND tablename = '%s'`,
+			v.Schema, v.Name)
+		rows, err := tx.Query(query)
+		if err != nil {
+			return nil, &SQLError{query, err}
+		}
+		for rows.Next() {
+			var indexDef string
+			if err := rows.Scan(&indexDef); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			deps.Views[i].Indices = append(deps.Views[i].Indices, indexDef)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// foreign keys between the rotated tables are moved with the tables
+	query = fmt.Sprintf(`
+        SELECT n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid)
+        FROM pg_constraint con JOIN pg_class c ON c.oid = con.conrelid
+            JOIN pg_namespace n ON n.oid = c.relnamespace
+        WHERE con.contype = 'f'
+            AND con.confrelid = ANY(%s)
+            AND NOT con.conrelid = ANY(%s)
+        ORDER BY n.nspname, c.relname, con.conname`, tableOids, tableOids)
+
+	rows, err = tx.Query(query)
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	for rows.Next() {
+		fk := dependentForeignKey{}
+		if err := rows.Scan(&fk.Schema, &fk.Table, &fk.Name, &fk.Definition); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deps.ForeignKeys = append(deps.ForeignKeys, fk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// viewGrants returns the GRANT statements for the privileges on the view.
+// Privileges of the owner are implicit and skipped.
+func viewGrants(tx *sql.Tx, schema, view string) ([]string, error) {
+	query := fmt.Sprintf(`
+        SELECT CASE WHEN a.grantee = 0 THEN 'PUBLIC' ELSE quote_ident(pg_get_userbyid(a.grantee)) END AS grantee,
+            a.privilege_type, a.is_grantable
+        FROM pg_class c, aclexplode(c.relacl) a
+        WHERE c.oid = '"%s"."%s"'::regclass AND a.grantee <> c.relowner
+        ORDER BY grantee, a.privilege_type`, schema, view)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	defer rows.Close()
+	var grants []string
+	for rows.Next() {
+		var grantee, privilege string
+		var grantable bool
+		if err := rows.Scan(&grantee, &privilege, &grantable); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grantSQL(schema, view, privilege, grantee, grantable))
+	}
+	return grants, rows.Err()
+}
+
+func grantSQL(schema, view, privilege, grantee string, grantable bool) string {
+	sql := fmt.Sprintf(`GRANT %s ON "%s"."%s" TO %s`, privilege, schema, view, grantee)
+	if grantable {
+		sql += " WITH GRANT OPTION"
+	}
+	return sql
+}
+
+// relationNames returns the names of all relations in schema that do not
+// belong to one of the excluded tables. Indexes and partitions belong to
+// their table.
+func relationNames(tx *sql.Tx, schema string, excluded []string) (map[string]bool, error) {
+	var excludedOids string
+	if len(excluded) == 0 {
+		excludedOids = "ARRAY[]::oid[]"
+	} else {
+		excludedOids = regclassArray(schema, excluded)
+	}
+	query := fmt.Sprintf(`
+        SELECT c.relname
+        FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+            LEFT JOIN pg_index i ON i.indexrelid = c.oid
+            LEFT JOIN pg_inherits inh ON inh.inhrelid = COALESCE(i.indrelid, c.oid)
+        WHERE n.nspname = '%s'
+            AND NOT COALESCE(i.indrelid, c.oid) = ANY(%s)
+            AND (inh.inhparent IS NULL OR NOT inh.inhparent = ANY(%s))`,
+		schema, excludedOids, excludedOids)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	defer rows.Close()
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// tableIndexNames returns the names of all indexes of tables (and their
+// partitions) in schema.
+func tableIndexNames(tx *sql.Tx, schema string, tables []string) ([]string, error) {
+	if len(tables) == 0 {
+		return nil, nil
+	}
+	tableOids := regclassArray(schema, tables)
+	query := fmt.Sprintf(`
+        SELECT c.relname
+        FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+            LEFT JOIN pg_inherits inh ON inh.inhrelid = i.indrelid
+        WHERE i.indrelid = ANY(%s) OR inh.inhparent = ANY(%s)
+        ORDER BY c.relname`, tableOids, tableOids)
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, &SQLError{query, err}
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// freeIndexName returns name, or name with the lowest numeric suffix
+// that is not taken. The name is truncated to the 63 bytes of PostgreSQL
+// identifiers.
+func freeIndexName(name string, taken map[string]bool) string {
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	if !taken[name] {
+		return name
+	}
+	for i := 1; ; i++ {
+		suffix := fmt.Sprintf("_%d", i)
+		base := name
+		if len(base)+len(suffix) > 63 {
+			base = base[:63-len(suffix)]
+		}
+		if !taken[base+suffix] {
+			return base + suffix
+		}
+	}
+}
+
+// indexRenamesSQL returns the statements to rename indexes of tables
+// that are moved from schema to newSchema, if their names are already
+// used by other relations in newSchema. Relations of the leaving tables
+// are moved out of newSchema before and do not collide.
+func indexRenamesSQL(tx *sql.Tx, schema string, tables []string, newSchema string, leaving []string) ([]string, error) {
+	indexes, err := tableIndexNames(tx, schema, tables)
+	if err != nil || len(indexes) == 0 {
+		return nil, err
+	}
+	taken, err := relationNames(tx, newSchema, leaving)
+	if err != nil {
+		return nil, err
+	}
+	// renamed indexes need a name that is free in both schemas
+	existing, err := relationNames(tx, schema, nil)
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for name := range existing {
+		used[name] = true
+	}
+	for name := range taken {
+		used[name] = true
+	}
+	var stmts []string
+	for _, idx := range indexes {
+		if !taken[idx] {
+			continue
+		}
+		newName := freeIndexName(idx, used)
+		used[newName] = true
+		log.Warnf("index %s already exists in %s, renaming to %s", idx, newSchema, newName)
+		stmts = append(stmts, fmt.Sprintf(`ALTER INDEX "%s"."%s" RENAME TO "%s"`, schema, idx, newName))
+	}
+	return stmts, nil
+}
+
+// rotate moves all tables from source to dest and from dest to backup.
+// Views and foreign keys that reference tables in dest are recreated
+// for the new tables within the same transaction.
 func (pg *PostGIS) rotate(source, dest, backup string) error {
 	defer log.StopStep(log.StartStep(fmt.Sprintf("Rotating tables")))
 
-	if err := pg.createSchema(dest); err != nil {
-		return err
+	if !pg.deployDryRun {
+		if err := pg.createSchema(dest); err != nil {
+			return err
+		}
+
+		if err := pg.createSchema(backup); err != nil {
+			return err
+		}
 	}
 
-	if err := pg.createSchema(backup); err != nil {
+	// indexes move with their tables. create indexes from the mapping
+	// that are missing in source before and not within the rotate
+	// transaction, which locks the production tables
+	if err := pg.createMissingIndexes(source); err != nil {
 		return err
 	}
 
@@ -21,6 +376,19 @@ func (pg *PostGIS) rotate(source, dest, backup string) error {
 	}
 	defer rollbackIfTx(&tx)
 
+	// pg_get_viewdef/constraintdef only qualify tables that are not
+	// visible in the search_path. put dest first so that recreated
+	// dependencies reference the new tables in dest
+	sql := fmt.Sprintf(`SET LOCAL search_path TO "%s", public`, dest)
+	if _, err := tx.Exec(sql); err != nil {
+		return &SQLError{sql, err}
+	}
+
+	var stmts []string
+	var rotatedTables []string
+	var destTables []string
+	var droppedTables []string
+
 	for _, tableName := range pg.tableNames() {
 		tableName = pg.Prefix + tableName
 
@@ -47,23 +415,61 @@ func (pg *PostGIS) rotate(source, dest, backup string) error {
 		if destExists {
 			log.Printf("backup of %s, to %s", tableName, backup)
 			if backupExists {
-				err = dropTableIfExists(tx, backup, tableName)
-				if err != nil {
-					return err
-				}
+				stmts = append(stmts, dropTableSQL(backup, tableName))
+				droppedTables = append(droppedTables, tableName)
 			}
-			sql := fmt.Sprintf(`ALTER TABLE "%s"."%s" SET SCHEMA "%s"`, dest, tableName, backup)
-			_, err = tx.Exec(sql)
+			moved, err := setSchemaSQL(tx, dest, tableName, backup)
 			if err != nil {
 				return err
 			}
+			stmts = append(stmts, moved...)
+			destTables = append(destTables, tableName)
 		}
+		rotatedTables = append(rotatedTables, tableName)
+	}
 
-		sql := fmt.Sprintf(`ALTER TABLE "%s"."%s" SET SCHEMA "%s"`, source, tableName, dest)
-		_, err = tx.Exec(sql)
+	for _, tableName := range rotatedTables {
+		moved, err := setSchemaSQL(tx, source, tableName, dest)
 		if err != nil {
 			return err
 		}
+		stmts = append(stmts, moved...)
+	}
+	// indexes move with their tables and index names are unique per
+	// schema. rename indexes that collide with relations that stay in
+	// the new schema, e.g. indexes of other tables
+	backupRenames, err := indexRenamesSQL(tx, dest, destTables, backup, droppedTables)
+	if err != nil {
+		return err
+	}
+	destRenames, err := indexRenamesSQL(tx, source, rotatedTables, dest, destTables)
+	if err != nil {
+		return err
+	}
+	stmts = append(append(backupRenames, destRenames...), stmts...)
+
+	deps, err := findDependencies(tx, dest, destTables)
+	if err != nil {
+		return err
+	}
+	for _, v := range deps.Views {
+		log.Printf("recreating view %s.%s", v.Schema, v.Name)
+	}
+	for _, fk := range deps.ForeignKeys {
+		log.Printf("recreating foreign key %s on %s.%s", fk.Name, fk.Schema, fk.Table)
+	}
+	stmts = append(deps.dropSQL(), stmts...)
+	stmts = append(stmts, deps.createSQL()...)
+
+	if pg.deployDryRun {
+		logDryRun(stmts)
+		return nil
+	}
+
+	for _, sql := range stmts {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
 	}
 
 	err = tx.Commit()
@@ -74,6 +480,74 @@ func (pg *PostGIS) rotate(source, dest, backup string) error {
 	return nil
 }
 
+// createMissingIndexes creates all indexes from the mapping that are
+// missing in the tables in schema.
+func (pg *PostGIS) createMissingIndexes(schema string) error {
+	tx, err := pg.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollbackIfTx(&tx)
+
+	var stmts []string
+	for _, tableName := range pg.tableNames() {
+		tableName = pg.Prefix + tableName
+		exists, err := tableExists(tx, schema, tableName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		created, err := pg.missingIndexesSQL(tx, schema, tableName)
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, created...)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	tx = nil // set nil to prevent rollback
+
+	if pg.deployDryRun {
+		logDryRun(stmts)
+		return nil
+	}
+	if len(stmts) == 0 {
+		return nil
+	}
+	defer log.StopStep(log.StartStep(fmt.Sprintf("Creating missing indexes in %s", schema)))
+	for _, sql := range stmts {
+		if _, err := pg.Db.Exec(sql); err != nil {
+			return &SQLError{sql, err}
+		}
+	}
+	return nil
+}
+
+// setSchemaSQL returns the statements to move table and all of its
+// partitions from schema to newSchema. Partitions are not moved with
+// their partitioned table.
+func setSchemaSQL(tx *sql.Tx, schema, table, newSchema string) ([]string, error) {
+	partitions, err := partitionsOf(tx, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	var stmts []string
+	for _, name := range append([]string{table}, partitions...) {
+		stmts = append(stmts, fmt.Sprintf(`ALTER TABLE "%s"."%s" SET SCHEMA "%s"`, schema, name, newSchema))
+	}
+	return stmts, nil
+}
+
+func logDryRun(stmts []string) {
+	log.Printf("dry run, the following statements would be executed:")
+	for _, sql := range stmts {
+		log.Printf("  %s;", strings.Join(strings.Fields(sql), " "))
+	}
+}
+
 func (pg *PostGIS) Deploy() error {
 	return pg.rotate(pg.Config.ImportSchema, pg.Config.ProductionSchema, pg.Config.BackupSchema)
 }
@@ -82,6 +556,12 @@ func (pg *PostGIS) RevertDeploy() error {
 	return pg.rotate(pg.Config.BackupSchema, pg.Config.ProductionSchema, pg.Config.ImportSchema)
 }
 
+// EnableDeployDryRun only logs the statements of Deploy, RevertDeploy and
+// RemoveBackup without changing any table.
+func (pg *PostGIS) EnableDeployDryRun() {
+	pg.deployDryRun = true
+}
+
 func (pg *PostGIS) RemoveBackup() error {
 	tx, err := pg.Db.Begin()
 	if err != nil {
@@ -91,6 +571,7 @@ func (pg *PostGIS) RemoveBackup() error {
 
 	backup := pg.Config.BackupSchema
 
+	var stmts []string
 	for _, tableName := range pg.tableNames() {
 		tableName = pg.Prefix + tableName
 
@@ -100,11 +581,18 @@ func (pg *PostGIS) RemoveBackup() error {
 		}
 		if backupExists {
 			log.Printf("removing backup of %s from %s", tableName, backup)
-			err = dropTableIfExists(tx, backup, tableName)
-			if err != nil {
-				return err
-			}
+			stmts = append(stmts, dropTableSQL(backup, tableName))
+		}
+	}
+
+	if pg.deployDryRun {
+		logDryRun(stmts)
+		return nil
+	}
 
+	for _, sql := range stmts {
+		if _, err := tx.Exec(sql); err != nil {
+			return &SQLError{sql, err}
 		}
 	}
 
@@ -0,0 +1,124 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDependenciesSQL(t *testing.T) {
+	deps := &dependencies{
+		Views: []dependentView{
+			{
+				Schema:     "public",
+				Name:       "roads",
+				Definition: " SELECT osm_roads.id, osm_roads.geometry FROM osm_roads;",
+				Grants: []string{
+					grantSQL("public", "roads", "SELECT", "PUBLIC", false),
+				},
+				depth: 1,
+			},
+			{
+				Schema:       "public",
+				Name:         "major_roads",
+				Materialized: true,
+				Definition:   " SELECT roads.id, roads.geometry FROM roads;",
+				Indices: []string{
+					`CREATE INDEX major_roads_geom ON public.major_roads USING gist (geometry)`,
+				},
+				Grants: []string{
+					grantSQL("public", "major_roads", "SELECT", `"tile user"`, true),
+				},
+				depth: 2,
+			},
+		},
+		ForeignKeys: []dependentForeignKey{
+			{
+				Schema:     "public",
+				Table:      "road_notes",
+				Name:       "road_notes_road_fkey",
+				Definition: "FOREIGN KEY (road_id) REFERENCES osm_roads(id)",
+			},
+		},
+	}
+
+	assertStmts(t, deps.dropSQL(), []string{
+		`ALTER TABLE "public"."road_notes" DROP CONSTRAINT "road_notes_road_fkey"`,
+		`DROP MATERIALIZED VIEW "public"."major_roads"`,
+		`DROP VIEW "public"."roads"`,
+	})
+	assertStmts(t, deps.createSQL(), []string{
+		`CREATE VIEW "public"."roads" AS SELECT osm_roads.id, osm_roads.geometry FROM osm_roads`,
+		`GRANT SELECT ON "public"."roads" TO PUBLIC`,
+		`CREATE MATERIALIZED VIEW "public"."major_roads" AS SELECT roads.id, roads.geometry FROM roads`,
+		`CREATE INDEX major_roads_geom ON public.major_roads USING gist (geometry)`,
+		`GRANT SELECT ON "public"."major_roads" TO "tile user" WITH GRANT OPTION`,
+		`ALTER TABLE "public"."road_notes" ADD CONSTRAINT "road_notes_road_fkey" FOREIGN KEY (road_id) REFERENCES osm_roads(id)`,
+	})
+}
+
+func TestDependenciesSQLEmpty(t *testing.T) {
+	deps := &dependencies{}
+	if stmts := deps.dropSQL(); len(stmts) != 0 {
+		t.Errorf("unexpected statements %q", stmts)
+	}
+	if stmts := deps.createSQL(); len(stmts) != 0 {
+		t.Errorf("unexpected statements %q", stmts)
+	}
+}
+
+func TestFreeIndexName(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	for _, test := range []struct {
+		name     string
+		taken    []string
+		expected string
+	}{
+		{name: "osm_roads_geom", expected: "osm_roads_geom"},
+		{name: "osm_roads_geom", taken: []string{"osm_roads_geom"}, expected: "osm_roads_geom_1"},
+		{name: "osm_roads_geom", taken: []string{"osm_roads_geom", "osm_roads_geom_1"}, expected: "osm_roads_geom_2"},
+		{name: long, expected: long[:63]},
+		{name: long, taken: []string{long[:63]}, expected: long[:61] + "_1"},
+	} {
+		taken := make(map[string]bool)
+		for _, name := range test.taken {
+			taken[name] = true
+		}
+		if name := freeIndexName(test.name, taken); name != test.expected {
+			t.Errorf("%s: %s != %s", test.name, name, test.expected)
+		}
+	}
+}
+
+func TestHasIndex(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	existing := map[string]bool{
+		"osm_roads_geom":       true,
+		"osm_roads_name_idx_2": true,
+		long[:61] + "_1":       true,
+	}
+	for _, test := range []struct {
+		name     string
+		expected bool
+	}{
+		{"osm_roads_geom", true},
+		{"osm_roads_name_idx", true},
+		{long, true},
+		{"osm_roads_osm_id_idx", false},
+		{"osm_roads_name", false},
+	} {
+		if hasIndex(existing, test.name) != test.expected {
+			t.Errorf("%s: expected %v", test.name, test.expected)
+		}
+	}
+}
+
+func assertStmts(t *testing.T, stmts, expected []string) {
+	if len(stmts) != len(expected) {
+		t.Fatalf("unexpected statements %q", stmts)
+	}
+	for i := range stmts {
+		if stmts[i] != expected[i] {
+			t.Errorf("%q != %q", stmts[i], expected[i])
+		}
+	}
+}
@@ -24,6 +24,17 @@ func newTxRouter(pg *PostGIS, bulkImport bool) (*TxRouter, error) {
 			}
 			txr.Tables[tableName] = tt
 		}
+		for tableName, table := range pg.GeneralizedTables {
+			if !table.InWrite {
+				continue
+			}
+			tt := NewBulkTableTx(pg, table.tableSpec())
+			err := tt.Begin(nil)
+			if err != nil {
+				return nil, err
+			}
+			txr.Tables[tableName] = tt
+		}
 	} else {
 		tx, err := pg.Db.Begin()
 		if err != nil {
@@ -32,6 +43,8 @@ func newTxRouter(pg *PostGIS, bulkImport bool) (*TxRouter, error) {
 		txr.tx = tx
 		for tableName, table := range pg.Tables {
 			tt := NewSynchronousTableTx(pg, table.FullName, table)
+			tt.(*syncTableTx).counts = pg.rowCounts
+			tt.(*syncTableTx).changes = pg.changes
 			err := tt.Begin(tx)
 			if err != nil {
 				return nil, err
@@ -39,7 +52,26 @@ func newTxRouter(pg *PostGIS, bulkImport bool) (*TxRouter, error) {
 			txr.Tables[tableName] = tt
 		}
 		for tableName, table := range pg.GeneralizedTables {
-			tt := NewSynchronousTableTx(pg, table.FullName, table)
+			var tt TableTx
+			if table.Aggregate {
+				att := NewAggregateTableTx(pg, table).(*aggregateTableTx)
+				att.counts = pg.rowCounts
+				att.changes = pg.changes
+				tt = att
+			} else if table.merged() {
+				mtt := NewMergeTableTx(pg, table).(*mergeTableTx)
+				mtt.counts = pg.rowCounts
+				mtt.changes = pg.changes
+				tt = mtt
+			} else {
+				stt := NewSynchronousTableTx(pg, table.FullName, table).(*syncTableTx)
+				stt.counts = pg.rowCounts
+				stt.changes = pg.changes
+				if !table.InWrite && (pg.rowCounts != nil || pg.changes != nil) {
+					stt.returning = table.Source.idColumn()
+				}
+				tt = stt
+			}
 			err := tt.Begin(tx)
 			if err != nil {
 				return nil, err
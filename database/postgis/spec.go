@@ -3,6 +3,7 @@ package postgis
 import (
 	"fmt"
 	"github.com/olehz/imposm3/mapping"
+	"sort"
 	"strings"
 )
 
@@ -18,6 +19,8 @@ type TableSpec struct {
 	Columns         []ColumnSpec
 	GeometryType    string
 	Srid            int
+	Storage         mapping.TableStorage
+	Indexes         []*mapping.Index
 	Generalizations []*GeneralizedTableSpec
 }
 
@@ -30,6 +33,14 @@ type GeneralizedTableSpec struct {
 	SourceGeneralized *GeneralizedTableSpec
 	Tolerance         float64
 	Where             string
+	Method            mapping.GeneralizeMethod
+	MinArea           float64
+	MergeBy           []string
+	InWrite           bool
+	Aggregate         bool
+	GroupBy           []string
+	GridSize          float64
+	Indexes           []*mapping.Index
 	created           bool
 	Generalizations   []*GeneralizedTableSpec
 }
@@ -38,27 +49,186 @@ func (col *ColumnSpec) AsSQL() string {
 	return fmt.Sprintf("\"%s\" %s", col.Name, col.Type.Name())
 }
 
+// geometryColumn returns the index of the geometry column or -1.
+func (spec *TableSpec) geometryColumn() int {
+	for i, col := range spec.Columns {
+		if col.Type.Name() == "GEOMETRY" {
+			return i
+		}
+	}
+	return -1
+}
+
+// geometryTypeSQL returns the PostGIS type of the geometry column.
+func (spec *TableSpec) geometryTypeSQL() string {
+	geomType := strings.ToUpper(spec.GeometryType)
+	if geomType == "POLYGON" {
+		geomType = "GEOMETRY" // for multipolygon support
+	}
+	return geomType
+}
+
+func (spec *TableSpec) partitioned() bool {
+	return spec.Storage.Partition != nil
+}
+
 func (spec *TableSpec) CreateTableSQL() string {
-	cols := []string{
-		"id SERIAL PRIMARY KEY",
+	var cols []string
+	if !spec.Storage.NoSerialId {
+		if spec.partitioned() {
+			// primary keys of partitioned tables need to include all
+			// partition columns
+			cols = append(cols, "id SERIAL")
+		} else {
+			cols = append(cols, "id SERIAL PRIMARY KEY")
+		}
 	}
 	for _, col := range spec.Columns {
 		if col.Type.Name() == "GEOMETRY" {
+			// the partition key can depend on the geometry, add it
+			// directly instead of AddGeometryColumn
+			if spec.partitioned() && spec.GeometryType != "" {
+				cols = append(cols, fmt.Sprintf(`"%s" geometry(%s, %d)`,
+					col.Name, spec.geometryTypeSQL(), spec.Srid))
+			}
 			continue
 		}
 		cols = append(cols, col.AsSQL())
 	}
 	columnSQL := strings.Join(cols, ",\n")
+
+	var create, options string
+	if spec.partitioned() {
+		create = "CREATE TABLE"
+		options = " PARTITION BY " + spec.partitionKeySQL()
+	} else {
+		create = spec.createSQL()
+		options = spec.storageSQL()
+	}
 	return fmt.Sprintf(`
-        CREATE TABLE IF NOT EXISTS "%s"."%s" (
+        %s IF NOT EXISTS "%s"."%s" (
             %s
-        );`,
+        )%s;`,
+		create,
 		spec.Schema,
 		spec.FullName,
 		columnSQL,
+		options,
 	)
 }
 
+// createSQL returns CREATE TABLE or CREATE UNLOGGED TABLE.
+func (spec *TableSpec) createSQL() string {
+	if spec.Storage.Unlogged {
+		return "CREATE UNLOGGED TABLE"
+	}
+	return "CREATE TABLE"
+}
+
+// storageSQL returns the storage parameters and tablespace of the table
+// or of each partition.
+func (spec *TableSpec) storageSQL() string {
+	var sql string
+	if spec.Storage.Fillfactor > 0 {
+		sql += fmt.Sprintf(" WITH (fillfactor=%d)", spec.Storage.Fillfactor)
+	}
+	if spec.Storage.Tablespace != "" {
+		sql += fmt.Sprintf(` TABLESPACE "%s"`, spec.Storage.Tablespace)
+	}
+	return sql
+}
+
+// geohashChars are all characters of the geohash alphabet.
+const geohashChars = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+func (spec *TableSpec) partitionKeySQL() string {
+	p := spec.Storage.Partition
+	switch p.Method {
+	case mapping.PartitionHash:
+		return fmt.Sprintf(`HASH ("%s")`, p.Column)
+	case mapping.PartitionGeohash:
+		geom := spec.Columns[spec.geometryColumn()].Name
+		return fmt.Sprintf(`LIST ((ST_GeoHash(ST_Transform(ST_SetSRID(ST_Centroid(Box2D("%s")), %d), 4326), 1)))`,
+			geom, spec.Srid)
+	}
+	return fmt.Sprintf(`LIST ("%s")`, p.Column)
+}
+
+// partitionSuffixes returns the name suffix and the FOR VALUES clause
+// of all partitions.
+func (spec *TableSpec) partitionSuffixes() ([]string, []string) {
+	p := spec.Storage.Partition
+	var suffixes, bounds []string
+	switch p.Method {
+	case mapping.PartitionHash:
+		for i := 0; i < p.Partitions; i++ {
+			suffixes = append(suffixes, fmt.Sprintf("p%d", i))
+			bounds = append(bounds, fmt.Sprintf("FOR VALUES WITH (MODULUS %d, REMAINDER %d)", p.Partitions, i))
+		}
+		return suffixes, bounds
+	case mapping.PartitionGeohash:
+		for _, c := range geohashChars {
+			suffixes = append(suffixes, string(c))
+			bounds = append(bounds, fmt.Sprintf("FOR VALUES IN ('%c')", c))
+		}
+	case mapping.PartitionList:
+		names := make([]string, 0, len(p.Values))
+		for name := range p.Values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var values []string
+			for _, v := range p.Values[name] {
+				values = append(values, "'"+strings.Replace(v, "'", "''", -1)+"'")
+			}
+			suffixes = append(suffixes, name)
+			bounds = append(bounds, fmt.Sprintf("FOR VALUES IN (%s)", strings.Join(values, ", ")))
+		}
+	}
+	suffixes = append(suffixes, "default")
+	bounds = append(bounds, "DEFAULT")
+	return suffixes, bounds
+}
+
+// partitionNames returns the names of all partitions, or nil if the
+// table is not partitioned.
+func (spec *TableSpec) partitionNames() []string {
+	if !spec.partitioned() {
+		return nil
+	}
+	suffixes, _ := spec.partitionSuffixes()
+	names := make([]string, len(suffixes))
+	for i, suffix := range suffixes {
+		names[i] = spec.FullName + "_" + suffix
+	}
+	return names
+}
+
+// CreatePartitionsSQL returns the statements to create all partitions.
+func (spec *TableSpec) CreatePartitionsSQL() []string {
+	if !spec.partitioned() {
+		return nil
+	}
+	_, bounds := spec.partitionSuffixes()
+	var stmts []string
+	for i, name := range spec.partitionNames() {
+		stmts = append(stmts, fmt.Sprintf(`%s "%s"."%s" PARTITION OF "%s"."%s" %s%s`,
+			spec.createSQL(), spec.Schema, name, spec.Schema, spec.FullName,
+			bounds[i], spec.storageSQL()))
+	}
+	return stmts
+}
+
+// storageTables returns the names of the tables that hold the rows,
+// the partitions or the table itself.
+func (spec *TableSpec) storageTables() []string {
+	if spec.partitioned() {
+		return spec.partitionNames()
+	}
+	return []string{spec.FullName}
+}
+
 func (spec *TableSpec) InsertSQL() string {
 	var cols []string
 	var vars []string
@@ -85,30 +255,76 @@ func (spec *TableSpec) CopySQL() string {
 	}
 	columns := strings.Join(cols, ", ")
 
-	return fmt.Sprintf(`COPY "%s"."%s" (%s) FROM STDIN`,
+	return fmt.Sprintf(`COPY "%s"."%s" (%s) FROM STDIN (FORMAT binary)`,
 		spec.Schema,
 		spec.FullName,
 		columns,
 	)
 }
 
-func (spec *TableSpec) DeleteSQL() string {
-	var idColumnName string
+// hasIdColumn returns whether the table has an id column.
+func (spec *TableSpec) hasIdColumn() bool {
 	for _, col := range spec.Columns {
 		if col.FieldType.Name == "id" {
-			idColumnName = col.Name
-			break
+			return true
 		}
 	}
+	return false
+}
 
-	if idColumnName == "" {
-		panic("missing id column")
+// idColumn returns the name of the id column.
+func (spec *TableSpec) idColumn() string {
+	for _, col := range spec.Columns {
+		if col.FieldType.Name == "id" {
+			return col.Name
+		}
 	}
+	panic("missing id column")
+}
 
+func (spec *TableSpec) DeleteSQL() string {
 	return fmt.Sprintf(`DELETE FROM "%s"."%s" WHERE "%s" = $1`,
 		spec.Schema,
 		spec.FullName,
-		idColumnName,
+		spec.idColumn(),
+	)
+}
+
+// compareSQL returns a query that compares each column of a row with the
+// values of an insert. The id of the row is the last parameter.
+func (spec *TableSpec) compareSQL() string {
+	var cols []string
+	for i, col := range spec.Columns {
+		value := col.Type.PrepareInsertSql(i+1, spec)
+		if col.Type.Name() == "GEOMETRY" {
+			cols = append(cols, fmt.Sprintf(`ST_AsEWKB("%s") IS NOT DISTINCT FROM ST_AsEWKB(%s)`, col.Name, value))
+		} else {
+			cols = append(cols, fmt.Sprintf(`"%s" IS NOT DISTINCT FROM %s`, col.Name, value))
+		}
+	}
+	return fmt.Sprintf(`SELECT %s FROM "%s"."%s" WHERE "%s" = $%d ORDER BY ctid`,
+		strings.Join(cols, ", "),
+		spec.Schema,
+		spec.FullName,
+		spec.idColumn(),
+		len(spec.Columns)+1,
+	)
+}
+
+// bboxIdsSQL returns a query for the ids of all rows that intersect the
+// bbox $1, $2, $3, $4 (EPSG:4326), or "" for tables without geometry or
+// id.
+func (spec *TableSpec) bboxIdsSQL() string {
+	geom := spec.geometryColumn()
+	if geom == -1 || !spec.hasIdColumn() {
+		return ""
+	}
+	return fmt.Sprintf(`SELECT DISTINCT "%s" FROM "%s"."%s" WHERE ST_Intersects("%s", ST_Transform(ST_MakeEnvelope($1, $2, $3, $4, 4326), %d))`,
+		spec.idColumn(),
+		spec.Schema,
+		spec.FullName,
+		spec.Columns[geom].Name,
+		spec.Srid,
 	)
 }
 
@@ -119,6 +335,10 @@ func NewTableSpec(pg *PostGIS, t *mapping.Table) *TableSpec {
 		Schema:       pg.Config.ImportSchema,
 		GeometryType: string(t.Type),
 		Srid:         pg.Config.Srid,
+		Indexes:      t.Indexes,
+	}
+	if t.Storage != nil {
+		spec.Storage = *t.Storage
 	}
 	for _, field := range t.Fields {
 		fieldType := field.FieldType()
@@ -144,10 +364,73 @@ func NewGeneralizedTableSpec(pg *PostGIS, t *mapping.GeneralizedTable) *Generali
 		Tolerance:  t.Tolerance,
 		Where:      t.SqlFilter,
 		SourceName: t.SourceTableName,
+		Method:     t.Method,
+		MinArea:    t.MinArea,
+		MergeBy:    t.MergeBy,
+		InWrite:    t.InWrite(),
+		Aggregate:  t.Aggregate(),
+		GroupBy:    t.GroupBy,
+		GridSize:   t.GridSize,
+		Indexes:    t.Indexes,
 	}
 	return &spec
 }
 
+// columns returns the columns of the generalized table.
+func (spec *GeneralizedTableSpec) columns() []ColumnSpec {
+	if spec.Aggregate {
+		return spec.aggregateColumns()
+	}
+	return spec.Source.Columns
+}
+
+// tableSpec returns a TableSpec with the columns of the source table
+// for generalized tables that are inserted like normal tables.
+func (spec *GeneralizedTableSpec) tableSpec() *TableSpec {
+	return &TableSpec{
+		Name:         spec.Name,
+		FullName:     spec.FullName,
+		Schema:       spec.Schema,
+		Columns:      spec.Source.Columns,
+		GeometryType: spec.Source.GeometryType,
+		Srid:         spec.Source.Srid,
+	}
+}
+
+// incremental returns true if the table is updated by inserting and
+// deleting single source ids.
+func (spec *GeneralizedTableSpec) incremental() bool {
+	return len(spec.MergeBy) == 0 && !spec.Aggregate
+}
+
+// simplifySQL returns the SQL expression to simplify the geometry expression.
+func (spec *GeneralizedTableSpec) simplifySQL(geom string) string {
+	switch spec.Method {
+	case mapping.Visvalingam:
+		return fmt.Sprintf(`ST_SimplifyVW(%s, %f)`, geom, spec.Tolerance)
+	case mapping.SnapToGrid:
+		return fmt.Sprintf(`ST_SnapToGrid(%s, %f)`, geom, spec.Tolerance)
+	}
+	return fmt.Sprintf(`ST_SimplifyPreserveTopology(%s, %f)`, geom, spec.Tolerance)
+}
+
+// whereSQL returns all conditions for the source rows, or an empty string.
+func (spec *GeneralizedTableSpec) whereSQL() string {
+	var conds []string
+	if spec.Where != "" {
+		conds = append(conds, "("+spec.Where+")")
+	}
+	if spec.MinArea > 0 {
+		for _, col := range spec.Source.Columns {
+			if col.Type.Name() == "GEOMETRY" {
+				conds = append(conds, fmt.Sprintf(`ST_Area("%s") >= %f`, col.Name, spec.MinArea))
+				break
+			}
+		}
+	}
+	return strings.Join(conds, " AND ")
+}
+
 func (spec *GeneralizedTableSpec) DeleteSQL() string {
 	var idColumnName string
 	for _, col := range spec.Source.Columns {
@@ -169,6 +452,10 @@ func (spec *GeneralizedTableSpec) DeleteSQL() string {
 }
 
 func (spec *GeneralizedTableSpec) InsertSQL() string {
+	if spec.InWrite {
+		return spec.tableSpec().InsertSQL()
+	}
+
 	var idColumnName string
 	for _, col := range spec.Source.Columns {
 		if col.FieldType.Name == "id" {
@@ -186,9 +473,10 @@ func (spec *GeneralizedTableSpec) InsertSQL() string {
 		cols = append(cols, col.Type.GeneralizeSql(&col, spec))
 	}
 
-	where := fmt.Sprintf(` WHERE "%s" = $1`, idColumnName)
-	if spec.Where != "" {
-		where += " AND (" + spec.Where + ")"
+	// $1 is an array of all updated ids, see GeneralizeUpdates
+	where := fmt.Sprintf(` WHERE "%s" = ANY($1::bigint[])`, idColumnName)
+	if cond := spec.whereSQL(); cond != "" {
+		where += " AND " + cond
 	}
 
 	columnSQL := strings.Join(cols, ",\n")
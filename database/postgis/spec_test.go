@@ -0,0 +1,206 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/olehz/imposm3/mapping"
+)
+
+func TestCreatePartitionsSQL(t *testing.T) {
+	spec := &TableSpec{
+		FullName: "osm_roads",
+		Schema:   "import",
+		Storage: mapping.TableStorage{
+			Unlogged:   true,
+			Fillfactor: 90,
+			Partition: &mapping.TablePartition{
+				Method: mapping.PartitionList,
+				Column: "type",
+				Values: map[string][]string{
+					"major": {"motorway", "trunk"},
+					"minor": {"residential", "it's"},
+				},
+			},
+		},
+	}
+	stmts := spec.CreatePartitionsSQL()
+	expected := []string{
+		`CREATE UNLOGGED TABLE "import"."osm_roads_major" PARTITION OF "import"."osm_roads" FOR VALUES IN ('motorway', 'trunk') WITH (fillfactor=90)`,
+		`CREATE UNLOGGED TABLE "import"."osm_roads_minor" PARTITION OF "import"."osm_roads" FOR VALUES IN ('residential', 'it''s') WITH (fillfactor=90)`,
+		`CREATE UNLOGGED TABLE "import"."osm_roads_default" PARTITION OF "import"."osm_roads" DEFAULT WITH (fillfactor=90)`,
+	}
+	if len(stmts) != len(expected) {
+		t.Fatalf("unexpected statements %q", stmts)
+	}
+	for i := range stmts {
+		if stmts[i] != expected[i] {
+			t.Errorf("%q != %q", stmts[i], expected[i])
+		}
+	}
+
+	create := spec.CreateTableSQL()
+	if strings.Contains(create, "UNLOGGED") || strings.Contains(create, "PRIMARY KEY") ||
+		!strings.Contains(create, `PARTITION BY LIST ("type")`) {
+		t.Errorf("unexpected create statement %s", create)
+	}
+
+	spec.Storage.Partition = &mapping.TablePartition{Method: mapping.PartitionHash, Column: "osm_id", Partitions: 4}
+	if names := spec.partitionNames(); len(names) != 4 || names[3] != "osm_roads_p3" {
+		t.Errorf("unexpected hash partitions %q", names)
+	}
+}
+
+func TestIndexSQL(t *testing.T) {
+	idx := &mapping.Index{Columns: []string{"type", "name"}, Method: mapping.BTree, Where: "name IS NOT NULL"}
+	sql := indexSQL("import", "osm_roads", idx, "fast")
+	expected := `CREATE INDEX "osm_roads_type_name_idx" ON "import"."osm_roads" USING BTREE ("type", "name") TABLESPACE "fast" WHERE name IS NOT NULL`
+	if sql != expected {
+		t.Errorf("%q != %q", sql, expected)
+	}
+
+	idx = &mapping.Index{Name: "lower_name", Expression: "lower(name)", Method: mapping.Gin}
+	sql = indexSQL("import", "osm_roads", idx, "")
+	expected = `CREATE INDEX "osm_roads_lower_name" ON "import"."osm_roads" USING GIN ((lower(name)))`
+	if sql != expected {
+		t.Errorf("%q != %q", sql, expected)
+	}
+}
+
+func TestCompareSQL(t *testing.T) {
+	spec := &TableSpec{
+		FullName: "osm_roads",
+		Schema:   "public",
+		Columns: []ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.FieldType{Name: "id"}, Type: pgTypes["int64"]},
+			{Name: "geometry", Type: pgTypes["geometry"]},
+			{Name: "tags", Type: &hstoreColumnType{simpleColumnType{"HSTORE", encodeHstore}}},
+		},
+	}
+	sql := spec.compareSQL()
+	expected := `SELECT "osm_id" IS NOT DISTINCT FROM $1, ` +
+		`ST_AsEWKB("geometry") IS NOT DISTINCT FROM ST_AsEWKB(ST_GeomFromEWKB($2)), ` +
+		`"tags" IS NOT DISTINCT FROM $3::hstore ` +
+		`FROM "public"."osm_roads" WHERE "osm_id" = $4 ORDER BY ctid`
+	if sql != expected {
+		t.Errorf("%q != %q", sql, expected)
+	}
+}
+
+func TestBboxIdsSQL(t *testing.T) {
+	spec := &TableSpec{
+		FullName: "osm_roads",
+		Schema:   "public",
+		Srid:     3857,
+		Columns: []ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.FieldType{Name: "id"}, Type: pgTypes["int64"]},
+			{Name: "geometry", Type: pgTypes["geometry"]},
+		},
+	}
+	sql := spec.bboxIdsSQL()
+	expected := `SELECT DISTINCT "osm_id" FROM "public"."osm_roads" ` +
+		`WHERE ST_Intersects("geometry", ST_Transform(ST_MakeEnvelope($1, $2, $3, $4, 4326), 3857))`
+	if sql != expected {
+		t.Errorf("%q != %q", sql, expected)
+	}
+
+	spec.Columns = spec.Columns[:1]
+	if sql := spec.bboxIdsSQL(); sql != "" {
+		t.Errorf("unexpected query for table without geometry %q", sql)
+	}
+
+	spec.Columns = []ColumnSpec{{Name: "geometry", Type: pgTypes["geometry"]}}
+	if sql := spec.bboxIdsSQL(); sql != "" {
+		t.Errorf("unexpected query for table without id %q", sql)
+	}
+}
+
+func TestMergeUpdateSQL(t *testing.T) {
+	source := &TableSpec{
+		FullName: "osm_landusages",
+		Schema:   "import",
+		Columns: []ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.FieldType{Name: "id"}, Type: pgTypes["int64"]},
+			{Name: "type", FieldType: mapping.FieldType{Name: "mapping_value"}, Type: pgTypes["string"]},
+			{Name: "name", FieldType: mapping.FieldType{Name: "string"}, Type: pgTypes["string"]},
+			{Name: "geometry", FieldType: mapping.FieldType{Name: "geometry"}, Type: pgTypes["geometry"]},
+		},
+	}
+	spec := &GeneralizedTableSpec{
+		FullName:  "osm_landusages_merged",
+		Schema:    "import",
+		Source:    source,
+		Tolerance: 10,
+		MergeBy:   []string{"type"},
+	}
+
+	expected := `WITH RECURSIVE component(id, key) AS (` +
+		`SELECT "osm_id", ROW("type")::text FROM "import"."osm_landusages" AS s WHERE "osm_id" = ANY($1::bigint[]) ` +
+		`UNION SELECT s."osm_id", ROW(s."type")::text FROM component ` +
+		`JOIN "import"."osm_landusages" AS m ON m."osm_id" = component.id AND ROW(m."type")::text = component.key ` +
+		`JOIN "import"."osm_landusages" AS s ON ROW(s."type")::text = component.key AND ST_Intersects(s."geometry", m."geometry")` +
+		`) SELECT id, key FROM component`
+	if sql := spec.componentsSQL(); sql != expected {
+		t.Errorf("%q != %q", sql, expected)
+	}
+
+	stmts := spec.mergeUpdateSQL()
+	if len(stmts) != 2 {
+		t.Fatalf("unexpected statements %q", stmts)
+	}
+	expected = `DELETE FROM "import"."osm_landusages_merged" WHERE ROW("type")::text = $1 AND "osm_id" = ANY($2::bigint[]) RETURNING "osm_id"`
+	if stmts[0] != expected {
+		t.Errorf("%q != %q", stmts[0], expected)
+	}
+	for _, part := range []string{
+		`INSERT INTO "import"."osm_landusages_merged" ("osm_id", "type", "name", "geometry") SELECT `,
+		`SELECT (SELECT min(s."osm_id") FROM "import"."osm_landusages" AS s WHERE ROW("type")::text = $1 AND "osm_id" = ANY($2::bigint[]) ` +
+			`AND s."type" IS NOT DISTINCT FROM components."type" AND ST_Intersects(s."geometry", components."geometry")) AS "osm_id", ` +
+			`"type", NULL::VARCHAR AS "name", "geometry" FROM (`,
+		`SELECT "type", (ST_Dump(ST_Union("geometry"))).geom AS "geometry" FROM "import"."osm_landusages" ` +
+			`WHERE ROW("type")::text = $1 AND "osm_id" = ANY($2::bigint[]) GROUP BY "type") AS components) AS merged RETURNING "osm_id"`,
+	} {
+		if !strings.Contains(stmts[1], part) {
+			t.Errorf("%q not in %q", part, stmts[1])
+		}
+	}
+
+	spec.MinArea = 100
+	if sql := spec.componentsSQL(); !strings.Contains(sql, `JOIN (SELECT * FROM "import"."osm_landusages" WHERE ST_Area("geometry") >= 100.000000) AS s ON`) {
+		t.Errorf("unexpected components query %q", sql)
+	}
+}
+
+func TestAggregateIndexes(t *testing.T) {
+	source := &TableSpec{
+		FullName: "osm_landusages",
+		Columns: []ColumnSpec{
+			{Name: "osm_id", FieldType: mapping.FieldType{Name: "id"}, Type: pgTypes["int64"]},
+			{Name: "type", FieldType: mapping.FieldType{Name: "mapping_value"}, Type: pgTypes["string"]},
+			{Name: "name", FieldType: mapping.FieldType{Name: "string"}, Type: pgTypes["string"]},
+			{Name: "geometry", FieldType: mapping.FieldType{Name: "geometry"}, Type: pgTypes["geometry"]},
+		},
+		Indexes: []*mapping.Index{
+			{Columns: []string{"type"}, Method: mapping.BTree},
+			{Columns: []string{"name"}, Method: mapping.BTree},
+		},
+	}
+	spec := &GeneralizedTableSpec{
+		FullName:  "osm_landusages_agg",
+		Schema:    "import",
+		Source:    source,
+		Aggregate: true,
+		GroupBy:   []string{"type"},
+	}
+	var names []string
+	for _, idx := range spec.indexes() {
+		names = append(names, idx.IndexName())
+	}
+	if strings.Join(names, " ") != "type_idx cell_x_cell_y_idx" {
+		t.Errorf("unexpected indexes %v", names)
+	}
+	expected := `CREATE INDEX "osm_landusages_agg_cell_x_cell_y_idx" ON "import"."osm_landusages_agg" USING BTREE ("cell_x", "cell_y")`
+	if sql := indexSQL("import", spec.FullName, cellIndex, ""); sql != expected {
+		t.Errorf("%q != %q", sql, expected)
+	}
+}
@@ -2,6 +2,7 @@ package postgis
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"sync"
 )
@@ -16,14 +17,15 @@ type TableTx interface {
 }
 
 type bulkTableTx struct {
-	Pg         *PostGIS
-	Tx         *sql.Tx
-	Table      string
-	Spec       *TableSpec
-	InsertStmt *sql.Stmt
-	InsertSql  string
-	wg         *sync.WaitGroup
-	rows       chan []interface{}
+	Pg    *PostGIS
+	Table string
+	Spec  *TableSpec
+	// conn is a separate connection for the binary COPY, the COPY runs
+	// in its own transaction
+	conn      *copyConn
+	InsertSql string
+	wg        *sync.WaitGroup
+	rows      chan []interface{}
 }
 
 func NewBulkTableTx(pg *PostGIS, spec *TableSpec) TableTx {
@@ -40,28 +42,27 @@ func NewBulkTableTx(pg *PostGIS, spec *TableSpec) TableTx {
 }
 
 func (tt *bulkTableTx) Begin(tx *sql.Tx) error {
-	var err error
-	if tx == nil {
-		tx, err = tt.Pg.Db.Begin()
-		if err != nil {
-			return err
-		}
+	if tx != nil {
+		return errors.New("bulk import requires its own transaction")
 	}
-	tt.Tx = tx
-
-	_, err = tx.Exec(fmt.Sprintf(`TRUNCATE TABLE "%s"."%s" RESTART IDENTITY`, tt.Pg.Config.ImportSchema, tt.Table))
+	conn, err := openCopyConn(tt.Pg.Params)
 	if err != nil {
 		return err
 	}
+	tt.conn = conn
 
-	tt.InsertSql = tt.Spec.CopySQL()
+	if err := conn.exec("BEGIN"); err != nil {
+		return err
+	}
+	truncateSql := fmt.Sprintf(`TRUNCATE TABLE "%s"."%s" RESTART IDENTITY`, tt.Pg.Config.ImportSchema, tt.Table)
+	if err := conn.exec(truncateSql); err != nil {
+		return &SQLError{truncateSql, err}
+	}
 
-	stmt, err := tt.Tx.Prepare(tt.InsertSql)
-	if err != nil {
+	tt.InsertSql = tt.Spec.CopySQL()
+	if err := conn.startCopy(tt.InsertSql); err != nil {
 		return &SQLError{tt.InsertSql, err}
 	}
-	tt.InsertStmt = stmt
-
 	return nil
 }
 
@@ -71,10 +72,16 @@ func (tt *bulkTableTx) Insert(row []interface{}) error {
 }
 
 func (tt *bulkTableTx) loop() {
+	var buf []byte
 	for row := range tt.rows {
-		_, err := tt.InsertStmt.Exec(row...)
+		var err error
+		// rows are encoded in the binary COPY format, the buffer is
+		// reused as copyConn copies the tuple into its own send buffer
+		buf, err = encodeBinaryRow(buf[:0], tt.Spec.Columns, row)
 		if err != nil {
-			// TODO
+			log.Fatal(&SQLInsertError{SQLError{tt.InsertSql, err}, row})
+		}
+		if err := tt.conn.writeTuple(buf); err != nil {
 			log.Fatal(&SQLInsertError{SQLError{tt.InsertSql, err}, row})
 		}
 	}
@@ -92,22 +99,23 @@ func (tt *bulkTableTx) End() {
 
 func (tt *bulkTableTx) Commit() error {
 	tt.End()
-	if tt.InsertStmt != nil {
-		_, err := tt.InsertStmt.Exec()
-		if err != nil {
-			return err
-		}
+	if err := tt.conn.endCopy(); err != nil {
+		return &SQLError{tt.InsertSql, err}
 	}
-	err := tt.Tx.Commit()
-	if err != nil {
+	if err := tt.conn.exec("COMMIT"); err != nil {
 		return err
 	}
-	tt.Tx = nil
-	return nil
+	err := tt.conn.close()
+	tt.conn = nil
+	return err
 }
 
 func (tt *bulkTableTx) Rollback() {
-	rollbackIfTx(&tt.Tx)
+	if tt.conn != nil {
+		// closing the connection rolls back the COPY
+		tt.conn.close()
+		tt.conn = nil
+	}
 }
 
 type syncTableTx struct {
@@ -119,6 +127,13 @@ type syncTableTx struct {
 	DeleteStmt *sql.Stmt
 	InsertSql  string
 	DeleteSql  string
+	// counts and changes record all deleted rows, if set
+	counts  *rowCounter
+	changes *changeTracker
+	// returning is the id column that is returned by the inserts of
+	// generalized tables (INSERT ... SELECT) to record the inserted
+	// rows, if set
+	returning string
 }
 
 type tableSpec interface {
@@ -146,6 +161,9 @@ func (tt *syncTableTx) Begin(tx *sql.Tx) error {
 	tt.Tx = tx
 
 	tt.InsertSql = tt.Spec.InsertSQL()
+	if tt.returning != "" {
+		tt.InsertSql += fmt.Sprintf(` RETURNING "%s"`, tt.returning)
+	}
 
 	stmt, err := tt.Tx.Prepare(tt.InsertSql)
 	if err != nil {
@@ -164,6 +182,9 @@ func (tt *syncTableTx) Begin(tx *sql.Tx) error {
 }
 
 func (tt *syncTableTx) Insert(row []interface{}) error {
+	if tt.returning != "" {
+		return tt.insertReturning(row)
+	}
 	_, err := tt.InsertStmt.Exec(row...)
 	if err != nil {
 		return &SQLInsertError{SQLError{tt.InsertSql, err}, row}
@@ -171,11 +192,48 @@ func (tt *syncTableTx) Insert(row []interface{}) error {
 	return nil
 }
 
+// insertReturning inserts the rows and records the returned ids.
+func (tt *syncTableTx) insertReturning(row []interface{}) error {
+	rows, err := tt.InsertStmt.Query(row...)
+	if err != nil {
+		return &SQLInsertError{SQLError{tt.InsertSql, err}, row}
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if tt.counts != nil {
+			tt.counts.inserted(tt.Table, 1)
+		}
+		if tt.changes != nil {
+			tt.changes.inserted(tt.Table, id)
+		}
+	}
+	return rows.Err()
+}
+
 func (tt *syncTableTx) Delete(id int64) error {
-	_, err := tt.DeleteStmt.Exec(id)
+	result, err := tt.DeleteStmt.Exec(id)
 	if err != nil {
 		return &SQLInsertError{SQLError{tt.DeleteSql, err}, id}
 	}
+	if tt.counts == nil && tt.changes == nil {
+		return nil
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		if tt.counts != nil {
+			tt.counts.deleted(tt.Table, n)
+		}
+		if tt.changes != nil {
+			tt.changes.deleted(tt.Table, id)
+		}
+	}
 	return nil
 }
 
@@ -72,6 +72,30 @@ func tableExists(tx *sql.Tx, schema, table string) (bool, error) {
 	return exists, nil
 }
 
+// partitionsOf returns the names of all partitions of table.
+func partitionsOf(tx *sql.Tx, schema, table string) ([]string, error) {
+	sql := fmt.Sprintf(`SELECT c.relname FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        JOIN pg_class p ON p.oid = i.inhparent
+        JOIN pg_namespace n ON n.oid = p.relnamespace
+        WHERE n.nspname = '%s' AND p.relname = '%s' AND p.relkind = 'p'
+        ORDER BY c.relname`, schema, table)
+	rows, err := tx.Query(sql)
+	if err != nil {
+		return nil, &SQLError{sql, err}
+	}
+	defer rows.Close()
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
+
 func dropTableIfExists(tx *sql.Tx, schema, table string) error {
 	exists, err := tableExists(tx, schema, table)
 	if err != nil {
@@ -80,8 +104,7 @@ func dropTableIfExists(tx *sql.Tx, schema, table string) error {
 	if !exists {
 		return nil
 	}
-	sqlStmt := fmt.Sprintf("SELECT DropGeometryTable('%s', '%s');",
-		schema, table)
+	sqlStmt := dropTableSQL(schema, table)
 	row := tx.QueryRow(sqlStmt)
 	var void interface{}
 	err = row.Scan(&void)
@@ -91,6 +114,11 @@ func dropTableIfExists(tx *sql.Tx, schema, table string) error {
 	return nil
 }
 
+// dropTableSQL returns the statement to drop a table with geometry columns.
+func dropTableSQL(schema, table string) string {
+	return fmt.Sprintf("SELECT DropGeometryTable('%s', '%s');", schema, table)
+}
+
 // rollbackIfTx rollsback transaction if tx is not nil.
 func rollbackIfTx(tx **sql.Tx) {
 	if *tx != nil {
@@ -0,0 +1,242 @@
+package postgis
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olehz/imposm3/database"
+)
+
+// verifyChunkSize is the max number of ids in a single query.
+const verifyChunkSize = 1000
+
+func (pg *PostGIS) Verify(ids []int64, expected []database.ExpectedRow) ([]database.RowDiff, error) {
+	expectedRows := make(map[string]map[int64][][]interface{})
+	for _, row := range expected {
+		if _, ok := pg.Tables[row.Table]; !ok {
+			return nil, fmt.Errorf("unknown table %s", row.Table)
+		}
+		if expectedRows[row.Table] == nil {
+			expectedRows[row.Table] = make(map[int64][][]interface{})
+		}
+		expectedRows[row.Table][row.Id] = append(expectedRows[row.Table][row.Id], row.Values)
+	}
+
+	tableNames := make([]string, 0, len(pg.Tables))
+	for name := range pg.Tables {
+		tableNames = append(tableNames, name)
+	}
+	sort.Strings(tableNames)
+
+	diffs := []database.RowDiff{}
+	for _, name := range tableNames {
+		spec := pg.Tables[name]
+		if !spec.hasIdColumn() {
+			// rows of tables without id can not be assigned to elements
+			if len(expectedRows[name]) > 0 {
+				log.Warnf("table %s without id field is not verified", spec.FullName)
+			}
+			continue
+		}
+		counts, err := pg.countRowsById(spec, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			rows := expectedRows[name][id]
+			n := counts[id]
+			switch {
+			case n == 0 && len(rows) == 0:
+			case n < len(rows):
+				diffs = append(diffs, database.RowDiff{Table: spec.FullName, Id: id, Problem: database.RowMissing})
+			case n > len(rows):
+				diffs = append(diffs, database.RowDiff{Table: spec.FullName, Id: id, Problem: database.RowExtra})
+			default:
+				columns, err := pg.staleColumns(spec, id, rows)
+				if err != nil {
+					return nil, err
+				}
+				if len(columns) > 0 {
+					diffs = append(diffs, database.RowDiff{Table: spec.FullName, Id: id, Problem: database.RowStale, Columns: columns})
+				}
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// countRowsById returns the number of rows for each id. The table needs
+// an id column.
+func (pg *PostGIS) countRowsById(spec *TableSpec, ids []int64) (map[int64]int, error) {
+	counts := make(map[int64]int)
+	idColumn := spec.idColumn()
+	for start := 0; start < len(ids); start += verifyChunkSize {
+		end := start + verifyChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		values := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			values = append(values, strconv.FormatInt(id, 10))
+		}
+		sql := fmt.Sprintf(`SELECT "%s", count(*) FROM "%s"."%s" WHERE "%s" IN (%s) GROUP BY "%s"`,
+			idColumn, spec.Schema, spec.FullName, idColumn, strings.Join(values, ", "), idColumn)
+		rows, err := pg.Db.Query(sql)
+		if err != nil {
+			return nil, &SQLError{sql, err}
+		}
+		for rows.Next() {
+			var id int64
+			var n int
+			if err := rows.Scan(&id, &n); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts[id] = n
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return counts, nil
+}
+
+// BboxIds returns the ids of all rows in all tables with a geometry that
+// intersects the bbox (EPSG:4326).
+func (pg *PostGIS) BboxIds(minx, miny, maxx, maxy float64) ([]int64, error) {
+	seen := make(map[int64]bool)
+	ids := []int64{}
+	for _, spec := range pg.Tables {
+		sql := spec.bboxIdsSQL()
+		if sql == "" {
+			continue
+		}
+		rows, err := pg.Db.Query(sql, minx, miny, maxx, maxy)
+		if err != nil {
+			return nil, &SQLError{sql, err}
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// compareRows compares all rows with id with values and returns the
+// equality of each column for each row.
+func (pg *PostGIS) compareRows(spec *TableSpec, id int64, values []interface{}) ([][]bool, error) {
+	sql := spec.compareSQL()
+	args := append(append([]interface{}{}, values...), id)
+	rows, err := pg.Db.Query(sql, args...)
+	if err != nil {
+		return nil, &SQLError{sql, err}
+	}
+	defer rows.Close()
+	var result [][]bool
+	for rows.Next() {
+		equal := make([]bool, len(spec.Columns))
+		dest := make([]interface{}, len(spec.Columns))
+		for i := range equal {
+			dest[i] = &equal[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, &SQLError{sql, err}
+		}
+		result = append(result, equal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &SQLError{sql, err}
+	}
+	return result, nil
+}
+
+// staleColumns returns the columns of the rows with id that differ from
+// the expected rows. Each expected row is compared with all rows, see
+// matchRows.
+func (pg *PostGIS) staleColumns(spec *TableSpec, id int64, expected [][]interface{}) ([]string, error) {
+	comparisons := make([][][]bool, len(expected))
+	for i, values := range expected {
+		equal, err := pg.compareRows(spec, id, values)
+		if err != nil {
+			return nil, err
+		}
+		comparisons[i] = equal
+	}
+	return matchRows(spec, comparisons), nil
+}
+
+// matchRows assigns each expected row to a row of the table and returns
+// the columns that differ. comparisons contains the equality of each
+// column of each row for each expected row. Equal rows are assigned
+// first, the remaining expected rows are assigned to the row with the
+// fewest different columns.
+func matchRows(spec *TableSpec, comparisons [][][]bool) []string {
+	differing := func(equal []bool) int {
+		n := 0
+		for _, eq := range equal {
+			if !eq {
+				n++
+			}
+		}
+		return n
+	}
+
+	assigned := make(map[int]bool)
+	done := make([]bool, len(comparisons))
+	for i, rows := range comparisons {
+		for j, equal := range rows {
+			if !assigned[j] && differing(equal) == 0 {
+				assigned[j] = true
+				done[i] = true
+				break
+			}
+		}
+	}
+
+	stale := make([]bool, len(spec.Columns))
+	for i, rows := range comparisons {
+		if done[i] {
+			continue
+		}
+		best := -1
+		for j, equal := range rows {
+			if !assigned[j] && (best == -1 || differing(equal) < differing(rows[best])) {
+				best = j
+			}
+		}
+		if best == -1 {
+			continue
+		}
+		assigned[best] = true
+		for c, eq := range rows[best] {
+			if !eq {
+				stale[c] = true
+			}
+		}
+	}
+
+	var columns []string
+	for c, isStale := range stale {
+		if isStale {
+			columns = append(columns, spec.Columns[c].Name)
+		}
+	}
+	return columns
+}
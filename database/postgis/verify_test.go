@@ -0,0 +1,50 @@
+package postgis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchRows(t *testing.T) {
+	spec := &TableSpec{
+		Columns: []ColumnSpec{{Name: "osm_id"}, {Name: "geometry"}, {Name: "name"}},
+	}
+	for _, test := range []struct {
+		comparisons [][][]bool
+		expected    []string
+	}{
+		// single equal row
+		{[][][]bool{{{true, true, true}}}, nil},
+		// single stale row
+		{[][][]bool{{{true, false, true}}}, []string{"geometry"}},
+		// second expected row equals first row, first expected row is
+		// compared with the second row
+		{
+			[][][]bool{
+				{{true, true, false}, {true, false, true}},
+				{{true, true, true}, {true, false, false}},
+			},
+			[]string{"geometry"},
+		},
+		// both rows equal
+		{
+			[][][]bool{
+				{{true, false, true}, {true, true, true}},
+				{{true, true, true}, {true, false, true}},
+			},
+			nil,
+		},
+		// two rows with one expected row that matches no row
+		{
+			[][][]bool{
+				{{true, true, true}, {true, false, false}},
+				{{true, true, false}, {true, true, false}},
+			},
+			[]string{"name"},
+		},
+	} {
+		if columns := matchRows(spec, test.comparisons); !reflect.DeepEqual(columns, test.expected) {
+			t.Errorf("%v: %v != %v", test.comparisons, columns, test.expected)
+		}
+	}
+}
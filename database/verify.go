@@ -0,0 +1,37 @@
+package database
+
+// Problems of a RowDiff.
+const (
+	RowMissing = "missing"
+	RowExtra   = "extra"
+	RowStale   = "stale"
+)
+
+// ExpectedRow is a row that the import of an element inserts into a table.
+type ExpectedRow struct {
+	// Table is the name of the table in the mapping.
+	Table  string
+	Id     int64
+	Values []interface{}
+}
+
+// RowDiff is a row that is missing in the database, a row that is not
+// expected or a row with different values.
+type RowDiff struct {
+	Table   string `json:"table"`
+	Id      int64  `json:"id"`
+	Problem string `json:"problem"`
+	// Columns with different values of stale rows.
+	Columns []string `json:"columns,omitempty"`
+}
+
+// Verifier is implemented by databases that compare their rows with the
+// expected rows.
+type Verifier interface {
+	// Verify compares all rows with the ids in all tables with the
+	// expected rows. ids needs to contain all ids of expected.
+	Verify(ids []int64, expected []ExpectedRow) ([]RowDiff, error)
+	// BboxIds returns the ids of all rows in all tables with a geometry
+	// that intersects the bbox (EPSG:4326).
+	BboxIds(minx, miny, maxx, maxy float64) ([]int64, error)
+}
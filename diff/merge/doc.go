@@ -0,0 +1,5 @@
+/*
+Package merge provides functions for merging multiple OSM diff files into
+a single set of changes.
+*/
+package merge
@@ -0,0 +1,119 @@
+package merge
+
+import (
+	"io"
+	"sort"
+
+	"github.com/olehz/imposm3/diff/parser"
+	"github.com/olehz/imposm3/logging"
+)
+
+var log = logging.NewLogger("diff")
+
+// Merger collects the net changes of a sequence of diff elements. Only
+// the latest change of each element is kept.
+type Merger struct {
+	nodes     map[int64]parser.DiffElem
+	ways      map[int64]parser.DiffElem
+	relations map[int64]parser.DiffElem
+	added     int
+}
+
+func NewMerger() *Merger {
+	return &Merger{
+		nodes:     make(map[int64]parser.DiffElem),
+		ways:      make(map[int64]parser.DiffElem),
+		relations: make(map[int64]parser.DiffElem),
+	}
+}
+
+// Add adds the change of an element. Changes need to be added in the order
+// of the diff files. elem replaces all previous changes of the same
+// element.
+func (m *Merger) Add(elem parser.DiffElem) {
+	var changes map[int64]parser.DiffElem
+	var id int64
+	if elem.Rel != nil {
+		changes, id = m.relations, elem.Rel.Id
+	} else if elem.Way != nil {
+		changes, id = m.ways, elem.Way.Id
+	} else if elem.Node != nil {
+		changes, id = m.nodes, elem.Node.Id
+	} else {
+		return
+	}
+	m.added += 1
+
+	if _, ok := changes[id]; ok && elem.Add {
+		// the element might exist from a previous change or from before
+		// the first diff, always import it as modification
+		elem.Mod = true
+		elem.Del = true
+	}
+	changes[id] = elem
+}
+
+// Len returns the number of merged changes.
+func (m *Merger) Len() int {
+	return len(m.nodes) + len(m.ways) + len(m.relations)
+}
+
+// Elems returns all merged changes. Nodes are returned before ways and
+// relations, each sorted by id.
+func (m *Merger) Elems() []parser.DiffElem {
+	elems := make([]parser.DiffElem, 0, m.Len())
+	for _, changes := range []map[int64]parser.DiffElem{m.nodes, m.ways, m.relations} {
+		ids := make([]int64, 0, len(changes))
+		for id := range changes {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			elems = append(elems, changes[id])
+		}
+	}
+	return elems
+}
+
+// MergeFiles parses all oscFiles in order and returns the merged changes.
+func MergeFiles(oscFiles []string) ([]parser.DiffElem, error) {
+	m := NewMerger()
+	for _, oscFile := range oscFiles {
+		elems, errc := parser.Parse(oscFile)
+	For:
+		for {
+			select {
+			case elem := <-elems:
+				m.Add(elem)
+			case err := <-errc:
+				if err != io.EOF {
+					return nil, err
+				}
+				break For
+			}
+		}
+	}
+	log.Printf("merged %d changes of %d files into %d changes", m.added, len(oscFiles), m.Len())
+	return m.Elems(), nil
+}
+
+// Parse merges all oscFiles and returns the merged changes like
+// parser.Parse.
+func Parse(oscFiles []string) (chan parser.DiffElem, chan error) {
+	elems := make(chan parser.DiffElem)
+	errc := make(chan error)
+	go func() {
+		defer close(elems)
+		defer close(errc)
+		merged, err := MergeFiles(oscFiles)
+		if err != nil {
+			errc <- err
+			return
+		}
+		for _, elem := range merged {
+			elems <- elem
+		}
+		errc <- io.EOF
+	}()
+	return elems, errc
+}
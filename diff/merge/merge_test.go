@@ -0,0 +1,61 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/olehz/imposm3/diff/parser"
+	"github.com/olehz/imposm3/element"
+)
+
+func node(id int64, add, mod, del bool) parser.DiffElem {
+	return parser.DiffElem{Add: add, Mod: mod, Del: del, Node: &element.Node{OSMElem: element.OSMElem{Id: id}}}
+}
+
+func way(id int64, add, mod, del bool) parser.DiffElem {
+	return parser.DiffElem{Add: add, Mod: mod, Del: del, Way: &element.Way{OSMElem: element.OSMElem{Id: id}}}
+}
+
+func TestMerger(t *testing.T) {
+	m := NewMerger()
+	// created and modified
+	m.Add(node(2, true, false, false))
+	nd := node(2, true, true, true)
+	nd.Node.Long = 10
+	m.Add(nd)
+	// modified and deleted
+	m.Add(way(1, true, true, true))
+	m.Add(way(1, false, false, true))
+	// deleted and created
+	m.Add(node(1, false, false, true))
+	m.Add(node(1, true, false, false))
+	// modified only
+	m.Add(way(3, true, true, true))
+
+	elems := m.Elems()
+	if len(elems) != 4 || m.Len() != 4 {
+		t.Fatalf("unexpected elems %v", elems)
+	}
+	for i, expected := range []struct {
+		id            int64
+		node          bool
+		add, mod, del bool
+	}{
+		{1, true, true, true, true},
+		{2, true, true, true, true},
+		{1, false, false, false, true},
+		{3, false, true, true, true},
+	} {
+		e := elems[i]
+		if expected.node && (e.Node == nil || e.Node.Id != expected.id) ||
+			!expected.node && (e.Way == nil || e.Way.Id != expected.id) {
+			t.Errorf("unexpected elem %d %v", i, e)
+			continue
+		}
+		if e.Add != expected.add || e.Mod != expected.mod || e.Del != expected.del {
+			t.Errorf("unexpected change flags of elem %d %v", i, e)
+		}
+	}
+	if elems[1].Node.Long != 10 {
+		t.Error("latest change not kept", elems[1].Node)
+	}
+}
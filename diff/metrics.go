@@ -0,0 +1,75 @@
+package diff
+
+import (
+	"sync"
+	"time"
+
+	diffstate "github.com/olehz/imposm3/diff/state"
+	"github.com/olehz/imposm3/stats"
+)
+
+var (
+	sequenceMetric = stats.NewGaugeMetric("imposm3_diff_sequence",
+		"Sequence number of the last imported diff.")
+	timestampMetric = stats.NewGaugeMetric("imposm3_diff_timestamp_seconds",
+		"Timestamp of the last imported diff as Unix time.")
+	lagMetric = stats.NewGaugeMetric("imposm3_diff_lag_seconds",
+		"Time since the timestamp of the last imported diff.")
+	rowsMetric = stats.NewCounterMetric("imposm3_diff_rows_total",
+		"Number of inserted and deleted rows by table.", "table", "op")
+	transactionsMetric = stats.NewCounterMetric("imposm3_db_transactions_total",
+		"Number of diff import transactions by result (commit or abort).", "result")
+	transactionSecondsMetric = stats.NewCounterMetric("imposm3_db_transaction_seconds_total",
+		"Total duration of all diff import transactions.")
+	lastTransactionSecondsMetric = stats.NewGaugeMetric("imposm3_db_transaction_last_seconds",
+		"Duration of the last diff import transaction.")
+)
+
+var (
+	lastStateMu   sync.Mutex
+	lastStateTime time.Time
+)
+
+func init() {
+	stats.AddMetricsCollector(func() {
+		lastStateMu.Lock()
+		defer lastStateMu.Unlock()
+		if !lastStateTime.IsZero() {
+			lagMetric.Set(time.Since(lastStateTime).Seconds())
+		}
+	})
+}
+
+// setStateMetrics sets the sequence and timestamp metrics to state.
+func setStateMetrics(state *diffstate.DiffState) {
+	if state == nil {
+		return
+	}
+	sequenceMetric.Set(float64(state.Sequence))
+	timestampMetric.Set(float64(state.Time.Unix()))
+	lastStateMu.Lock()
+	lastStateTime = state.Time
+	lastStateMu.Unlock()
+}
+
+// recordTransactionMetrics records the duration and the result of a
+// transaction.
+func recordTransactionMetrics(duration time.Duration, committed bool) {
+	result := "abort"
+	if committed {
+		result = "commit"
+	}
+	transactionsMetric.Add(1, result)
+	transactionSecondsMetric.Add(duration.Seconds())
+	lastTransactionSecondsMetric.Set(duration.Seconds())
+}
+
+// recordRowMetrics adds the row counts of all diffs.
+func recordRowMetrics(importStats *Stats) {
+	for _, diffStats := range importStats.Diffs {
+		for table, count := range diffStats.Rows {
+			rowsMetric.Add(float64(count.Inserted), table, "insert")
+			rowsMetric.Add(float64(count.Deleted), table, "delete")
+		}
+	}
+}
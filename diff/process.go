@@ -1,14 +1,19 @@
 package diff
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"time"
 
 	"github.com/olehz/imposm3/cache"
 	"github.com/olehz/imposm3/config"
 	"github.com/olehz/imposm3/database"
 	_ "github.com/olehz/imposm3/database/postgis"
+	"github.com/olehz/imposm3/diff/merge"
 	"github.com/olehz/imposm3/diff/parser"
 	diffstate "github.com/olehz/imposm3/diff/state"
 	"github.com/olehz/imposm3/element"
@@ -24,26 +29,17 @@ import (
 
 var log = logging.NewLogger("diff")
 
+// Update imports a single diff file, see UpdateBatch.
 func Update(oscFile string, geometryLimiter *limit.Limiter, expireor expire.Expireor, osmCache *cache.OSMCache, diffCache *cache.DiffCache, force bool) error {
-	state, err := diffstate.ParseFromOsc(oscFile)
-	if err != nil {
-		return err
-	}
-	lastState, err := diffstate.ParseLastState(config.BaseOptions.DiffDir)
-	if err != nil {
-		log.Warn(err)
-	}
-
-	if lastState != nil && lastState.Sequence != 0 && state != nil && state.Sequence <= lastState.Sequence {
-		if !force {
-			log.Warn(state, " already imported")
-			return nil
-		}
-	}
-
-	defer log.StopStep(log.StartStep(fmt.Sprintf("Processing %s", oscFile)))
+	return UpdateBatch([]string{oscFile}, geometryLimiter, expireor, osmCache, diffCache, force)
+}
 
-	elems, errc := parser.Parse(oscFile)
+// UpdateBatch imports all diff files in a single database transaction.
+// All changes of the caches are recorded in a journal until the
+// transaction is committed. The caches are rolled back if the update
+// fails, or with Recover if the update was interrupted.
+func UpdateBatch(oscFiles []string, geometryLimiter *limit.Limiter, expireor expire.Expireor, osmCache *cache.OSMCache, diffCache *cache.DiffCache, force bool) error {
+	updateStart := time.Now()
 
 	tagmapping, err := mapping.NewMapping(config.BaseOptions.MappingFile)
 	if err != nil {
@@ -64,21 +60,295 @@ func Update(oscFile string, geometryLimiter *limit.Limiter, expireor expire.Expi
 	}
 	defer db.Close()
 
-	err = db.Begin()
-	if err != nil {
+	if err := Recover(osmCache, diffCache, db); err != nil {
 		return err
 	}
 
+	lastState, err := diffstate.ParseLastState(config.BaseOptions.DiffDir)
+	if err != nil {
+		log.Warn(err)
+	}
+	if lastState != nil && lastState.Sequence != 0 {
+		setStateMetrics(lastState)
+	}
+
+	var files []string
+	var states []*diffstate.DiffState
+	var state *diffstate.DiffState
+	for _, oscFile := range oscFiles {
+		fileState, err := diffstate.ParseFromOsc(oscFile)
+		if err != nil {
+			return err
+		}
+		if lastState != nil && lastState.Sequence != 0 && fileState != nil && fileState.Sequence <= lastState.Sequence {
+			if !force {
+				log.Warn(fileState, " already imported")
+				continue
+			}
+		}
+		files = append(files, oscFile)
+		states = append(states, fileState)
+		if fileState != nil && (state == nil || fileState.Sequence >= state.Sequence) {
+			state = fileState
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
 	delDb, ok := db.(database.Deleter)
 	if !ok {
 		return errors.New("database not deletable")
 	}
 
+	rowCounter, _ := db.(database.RowCounter)
+
+	var changeTracker database.ChangeTracker
+	if config.DiffImportOptions.ChangeSink != "" {
+		changeTracker, ok = db.(database.ChangeTracker)
+		if !ok {
+			return errors.New("database does not support change sinks")
+		}
+		sink, err := database.OpenChangeSink(dbConf, config.DiffImportOptions.ChangeSink)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+		changeTracker.SetChangeSink(sink)
+	}
+	setSequence := func(state *diffstate.DiffState) {
+		if changeTracker != nil && state != nil {
+			changeTracker.SetChangeSequence(state.Sequence)
+		}
+	}
+
+	err = db.Begin()
+	if err != nil {
+		return err
+	}
+	txStart := time.Now()
+
 	genDb, ok := db.(database.Generalizer)
 	if ok {
 		genDb.EnableGeneralizeUpdates()
 	}
 
+	journal, err := cache.OpenJournal(config.BaseOptions.CacheDir)
+	if err != nil {
+		db.Abort()
+		return err
+	}
+	journal.Attach(osmCache, diffCache)
+
+	importStats := &Stats{}
+	processFile := func(name string, state *diffstate.DiffState, elems chan parser.DiffElem, errc chan error) error {
+		setSequence(state)
+		diffStats := &DiffStats{File: name}
+		if state != nil {
+			diffStats.Sequence = state.Sequence
+		}
+		importStats.Diffs = append(importStats.Diffs, diffStats)
+		err := process(name, elems, errc, geometryLimiter, expireor, osmCache, diffCache, db, delDb, tagmapping, diffStats)
+		if rowCounter != nil {
+			diffStats.Rows = rowCounter.RowCounts()
+		}
+		return err
+	}
+	if config.DiffImportOptions.Merge && len(files) > 1 {
+		elems, errc := merge.Parse(files)
+		name := fmt.Sprintf("%d merged diff files", len(files))
+		err = processFile(name, state, elems, errc)
+	} else {
+		for i, oscFile := range files {
+			elems, errc := parser.Parse(oscFile)
+			err = processFile(oscFile, states[i], elems, errc)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && genDb != nil {
+		start := time.Now()
+		genDb.GeneralizeUpdates()
+		importStats.GeneralizeSeconds = seconds(time.Since(start))
+	}
+
+	// write all changes before the journal is detached
+	osmCache.Coords.Flush()
+	diffCache.Flush()
+	journal.Detach(osmCache, diffCache)
+
+	// the marker is stored in the journal and with the database
+	// transaction, to check whether the transaction was committed if
+	// the update is interrupted
+	marker := strconv.FormatInt(time.Now().UnixNano(), 10)
+	var stateData bytes.Buffer
+	if err == nil {
+		var sequence int32
+		if state != nil {
+			if lastState != nil {
+				state.Url = lastState.Url
+			}
+			if err := state.Write(&stateData); err != nil {
+				log.Warn(err)
+			}
+			sequence = state.Sequence
+		}
+		if markerDb, ok := db.(database.UpdateMarker); ok {
+			markerDb.SetUpdateMarker(marker, sequence)
+		}
+		err = journal.MarkPrepared(marker, stateData.Bytes())
+	}
+	if err == nil {
+		start := time.Now()
+		err = db.End()
+		importStats.CommitSeconds = seconds(time.Since(start))
+		if err != nil {
+			// COMMIT can fail after the transaction was committed,
+			// e.g. if the connection is lost
+			committed, cerr := markerCommitted(db, marker)
+			if cerr != nil {
+				// keep the journal, Recover checks the marker again
+				db.Abort()
+				journal.Close()
+				return fmt.Errorf("%s (unable to check commit: %s)", err, cerr)
+			}
+			if committed {
+				log.Warn("transaction committed despite error: ", err)
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		db.Abort()
+		recordTransactionMetrics(time.Since(txStart), false)
+		if rerr := rollback(journal, osmCache, diffCache); rerr != nil {
+			log.Warn("unable to rollback cache: ", rerr)
+		}
+		return err
+	}
+
+	// the database is committed, the journal must not be rolled back
+	recordTransactionMetrics(time.Since(txStart), true)
+	recordRowMetrics(importStats)
+	setStateMetrics(state)
+	if err := journal.MarkCommitted(); err != nil {
+		// Recover finishes the update with the marker of the database
+		journal.Close()
+		return fmt.Errorf("unable to mark journal as committed: %s", err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+
+	if state != nil {
+		err = diffstate.WriteLastState(config.BaseOptions.DiffDir, state)
+		if err != nil {
+			log.Warn(err) // warn only
+		}
+	}
+
+	importStats.TotalSeconds = seconds(time.Since(updateStart))
+	if err := WriteStats(config.BaseOptions.DiffDir, importStats); err != nil {
+		log.Warn(err) // warn only
+	}
+	if config.DiffImportOptions.LogStats {
+		for _, diffStats := range importStats.Diffs {
+			if data, err := json.Marshal(diffStats); err == nil {
+				log.Printf("stats: %s", data)
+			}
+		}
+	}
+	return journal.Remove()
+}
+
+// Recover finishes or rolls back an interrupted update. The caches are
+// rolled back if the update was interrupted before the database
+// transaction was committed. Otherwise only the diff state is written.
+// The update marker of db decides if the update was interrupted during
+// the commit.
+func Recover(osmCache *cache.OSMCache, diffCache *cache.DiffCache, db database.DB) error {
+	if !cache.JournalExists(config.BaseOptions.CacheDir) {
+		return nil
+	}
+	journal, err := cache.OpenJournal(config.BaseOptions.CacheDir)
+	if err != nil {
+		return err
+	}
+	committed, err := journal.Committed()
+	if err != nil {
+		journal.Close()
+		return err
+	}
+	marker, stateData, err := journal.Prepared()
+	if err != nil {
+		journal.Close()
+		return err
+	}
+	if !committed && marker != "" {
+		committed, err = markerCommitted(db, marker)
+		if err != nil {
+			journal.Close()
+			return err
+		}
+	}
+	if !committed {
+		log.Warn("rolling back cache changes of interrupted update")
+		return rollback(journal, osmCache, diffCache)
+	}
+	if len(stateData) > 0 {
+		state, err := diffstate.Parse(bytes.NewReader(stateData))
+		if err != nil {
+			journal.Close()
+			return err
+		}
+		log.Printf("finishing interrupted update of %s", state)
+		if err := diffstate.WriteLastState(config.BaseOptions.DiffDir, state); err != nil {
+			journal.Close()
+			return err
+		}
+	}
+	return journal.Remove()
+}
+
+// markerCommitted returns whether the transaction with the update marker
+// was committed. It returns false if db does not store update markers.
+func markerCommitted(db database.DB, marker string) (bool, error) {
+	markerDb, ok := db.(database.UpdateMarker)
+	if !ok {
+		return false, nil
+	}
+	dbMarker, err := markerDb.UpdateMarker()
+	if err != nil {
+		return false, err
+	}
+	return dbMarker == marker, nil
+}
+
+// rollback restores the caches from the journal and removes the
+// journal. The journal is kept if the rollback fails, to retry it with
+// the next update.
+func rollback(journal *cache.Journal, osmCache *cache.OSMCache, diffCache *cache.DiffCache) error {
+	if err := journal.Rollback(osmCache, diffCache); err != nil {
+		journal.Close()
+		return err
+	}
+	return journal.Remove()
+}
+
+// process reads all changes from elems, updates the caches and writes
+// all changes to db. errc returns io.EOF after the last change. The
+// statistics are collected in diffStats.
+func process(name string, elems chan parser.DiffElem, errc chan error,
+	geometryLimiter *limit.Limiter, expireor expire.Expireor,
+	osmCache *cache.OSMCache, diffCache *cache.DiffCache,
+	db database.DB, delDb database.Deleter, tagmapping *mapping.Mapping,
+	diffStats *DiffStats,
+) error {
+	defer log.StopStep(log.StartStep(fmt.Sprintf("Processing %s", name)))
+
 	deleter := NewDeleter(
 		delDb,
 		osmCache,
@@ -134,12 +404,15 @@ func Update(oscFile string, geometryLimiter *limit.Limiter, expireor expire.Expi
 	relIds := make(map[int64]bool)
 
 	step := log.StartStep("Parsing changes, updating cache and removing elements")
+	parseStart := time.Now()
+	var deleteTime time.Duration
 
 	g := geos.NewGeos()
 For:
 	for {
 		select {
 		case elem := <-elems:
+			diffStats.addElem(elem)
 			if elem.Rel != nil {
 				relTagFilter.Filter(&elem.Rel.Tags)
 				progress.AddRelations(1)
@@ -154,9 +427,11 @@ For:
 				progress.AddCoords(1)
 			}
 			if elem.Del {
+				deleteStart := time.Now()
 				if err := deleter.Delete(elem); err != nil {
 					return err
 				}
+				deleteTime += time.Since(deleteStart)
 				if !elem.Add {
 					// no new or modified elem -> remove from cache
 					if elem.Rel != nil {
@@ -229,7 +504,10 @@ For:
 
 	progress.Stop()
 	log.StopStep(step)
+	diffStats.DeleteSeconds = seconds(deleteTime)
+	diffStats.ParseSeconds = seconds(time.Since(parseStart) - deleteTime)
 	step = log.StartStep("Writing added/modified elements")
+	writeStart := time.Now()
 
 	progress = stats.NewStatsReporter()
 
@@ -237,6 +515,9 @@ For:
 	for nodeId, _ := range nodeIds {
 		dependers := diffCache.Coords.Get(nodeId)
 		for _, way := range dependers {
+			if !wayIds[way] {
+				diffStats.Dependent.Ways += 1
+			}
 			wayIds[way] = true
 		}
 	}
@@ -246,6 +527,9 @@ For:
 		dependers := diffCache.Ways.Get(wayId)
 		// mark depending relations for (re)insert
 		for _, rel := range dependers {
+			if !relIds[rel] {
+				diffStats.Dependent.Relations += 1
+			}
 			relIds[rel] = true
 		}
 	}
@@ -300,31 +584,9 @@ For:
 	relWriter.Wait()
 	wayWriter.Wait()
 
-	if genDb != nil {
-		genDb.GeneralizeUpdates()
-	}
-
-	err = db.End()
-	if err != nil {
-		return err
-	}
-	err = db.Close()
-	if err != nil {
-		return err
-	}
-
+	diffStats.WriteSeconds = seconds(time.Since(writeStart))
 	log.StopStep(step)
 
 	progress.Stop()
-
-	if state != nil {
-		if lastState != nil {
-			state.Url = lastState.Url
-		}
-		err = diffstate.WriteLastState(config.BaseOptions.DiffDir, state)
-		if err != nil {
-			log.Warn(err) // warn only
-		}
-	}
 	return nil
 }
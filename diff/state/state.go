@@ -34,8 +34,12 @@ func (d DiffState) WriteToFile(file string) error {
 		return err
 	}
 	defer f.Close()
+	return d.Write(f)
+}
 
-	writer := bufio.NewWriter(f)
+// Write writes the state in the format of the state.txt files.
+func (d DiffState) Write(w io.Writer) error {
+	writer := bufio.NewWriter(w)
 
 	lines := []string{}
 	lines = append(lines, "timestamp="+d.Time.Format(timestampFormat))
@@ -45,7 +49,7 @@ func (d DiffState) WriteToFile(file string) error {
 	lines = append(lines, "replicationUrl="+d.Url)
 
 	for _, line := range lines {
-		_, err = writer.WriteString(line + "\n")
+		_, err := writer.WriteString(line + "\n")
 		if err != nil {
 			return err
 		}
@@ -80,18 +84,28 @@ func ParseFromOsc(oscFile string) (*DiffState, error) {
 }
 
 func FromPbf(pbfFile *pbf.Pbf, before time.Duration) *DiffState {
+	return FromPbfs([]*pbf.Pbf{pbfFile}, before)
+}
+
+// FromPbfs returns the state for the oldest of all pbfFiles.
+func FromPbfs(pbfFiles []*pbf.Pbf, before time.Duration) *DiffState {
 	var timestamp time.Time
-	if pbfFile.Header.Time.Unix() != 0 {
-		timestamp = pbfFile.Header.Time
-	} else {
-		fstat, err := os.Stat(pbfFile.Filename)
+	for _, pbfFile := range pbfFiles {
+		t, err := pbfTimestamp(pbfFile)
 		if err != nil {
 			log.Warn("unable to stat pbffile: ", err)
 			return nil
 		}
-		timestamp = fstat.ModTime()
+		if timestamp.IsZero() || t.Before(timestamp) {
+			timestamp = t
+		}
 	}
+	return FromTimestamp(timestamp, before)
+}
 
+// FromTimestamp returns the state for data till timestamp, e.g. for
+// snapshots of history files.
+func FromTimestamp(timestamp time.Time, before time.Duration) *DiffState {
 	replicationUrl := "http://planet.openstreetmap.org/replication/minute/"
 
 	seq := estimateSequence(replicationUrl, timestamp)
@@ -104,6 +118,19 @@ func FromPbf(pbfFile *pbf.Pbf, before time.Duration) *DiffState {
 	return &DiffState{Time: timestamp, Url: replicationUrl, Sequence: seq}
 }
 
+// pbfTimestamp returns the replication timestamp from the header, or
+// the modification time of the file.
+func pbfTimestamp(pbfFile *pbf.Pbf) (time.Time, error) {
+	if pbfFile.Header.Time.Unix() != 0 {
+		return pbfFile.Header.Time, nil
+	}
+	fstat, err := os.Stat(pbfFile.Filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fstat.ModTime(), nil
+}
+
 func ParseFile(stateFile string) (*DiffState, error) {
 	f, err := os.Open(stateFile)
 	if err != nil {
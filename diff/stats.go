@@ -0,0 +1,86 @@
+package diff
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/olehz/imposm3/database"
+	"github.com/olehz/imposm3/diff/parser"
+)
+
+// ElemStats counts elements by type.
+type ElemStats struct {
+	Nodes     int64 `json:"nodes"`
+	Ways      int64 `json:"ways"`
+	Relations int64 `json:"relations"`
+}
+
+func (s *ElemStats) add(elem parser.DiffElem) {
+	if elem.Rel != nil {
+		s.Relations += 1
+	} else if elem.Way != nil {
+		s.Ways += 1
+	} else if elem.Node != nil {
+		s.Nodes += 1
+	}
+}
+
+// DiffStats is the summary of a processed diff file.
+type DiffStats struct {
+	File     string    `json:"file"`
+	Sequence int32     `json:"sequence,omitempty"`
+	Created  ElemStats `json:"created"`
+	Modified ElemStats `json:"modified"`
+	Deleted  ElemStats `json:"deleted"`
+	// Dependent are the ways and relations that are rebuilt because
+	// of changed nodes and ways.
+	Dependent ElemStats `json:"dependent"`
+	// Rows are the inserted and deleted rows of each table.
+	Rows map[string]database.RowCount `json:"rows"`
+	// ParseSeconds includes the parsing and the cache updates.
+	ParseSeconds  float64 `json:"parse_seconds"`
+	DeleteSeconds float64 `json:"delete_seconds"`
+	WriteSeconds  float64 `json:"write_seconds"`
+}
+
+func (s *DiffStats) addElem(elem parser.DiffElem) {
+	switch {
+	case elem.Mod:
+		s.Modified.add(elem)
+	case elem.Add:
+		s.Created.add(elem)
+	case elem.Del:
+		s.Deleted.add(elem)
+	}
+}
+
+// Stats is the summary of a diff import. All diffs are imported in a
+// single transaction.
+type Stats struct {
+	Diffs             []*DiffStats `json:"diffs"`
+	GeneralizeSeconds float64      `json:"generalize_seconds"`
+	CommitSeconds     float64      `json:"commit_seconds"`
+	TotalSeconds      float64      `json:"total_seconds"`
+}
+
+func seconds(d time.Duration) float64 {
+	return float64(d/time.Millisecond) / 1000
+}
+
+// WriteStats writes stats as last.stats.json into dir, next to the
+// last.state.txt.
+func WriteStats(dir string, stats *Stats) error {
+	f, err := os.Create(path.Join(dir, "last.stats.json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,43 @@
+package diff
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/olehz/imposm3/diff/parser"
+	"github.com/olehz/imposm3/element"
+)
+
+func TestDiffStats(t *testing.T) {
+	stats := &DiffStats{File: "1.osc.gz", Sequence: 1}
+	stats.addElem(parser.DiffElem{Add: true, Node: &element.Node{}})
+	stats.addElem(parser.DiffElem{Add: true, Mod: true, Del: true, Way: &element.Way{}})
+	stats.addElem(parser.DiffElem{Add: true, Mod: true, Del: true, Way: &element.Way{}})
+	stats.addElem(parser.DiffElem{Del: true, Rel: &element.Relation{}})
+
+	if stats.Created != (ElemStats{Nodes: 1}) ||
+		stats.Modified != (ElemStats{Ways: 2}) ||
+		stats.Deleted != (ElemStats{Relations: 1}) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+
+	dir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(dir)
+	if err := WriteStats(dir, &Stats{Diffs: []*DiffStats{stats}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "last.stats.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read Stats
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Diffs) != 1 || read.Diffs[0].Modified.Ways != 2 || read.Diffs[0].Sequence != 1 {
+		t.Errorf("unexpected stats %s", data)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"time"
 
 	"github.com/olehz/imposm3/geom/geos"
 )
@@ -18,6 +19,16 @@ type OSMElem struct {
 	Id   int64     `json:"-"`
 	Tags Tags      `json:"tags,omitempty"`
 	Geom *Geometry `json:"-"`
+	// Metadata is only set if the parser was requested to read it.
+	Metadata *Metadata `json:"-"`
+}
+
+// Metadata contains the version information of an element.
+type Metadata struct {
+	Version   int32
+	Timestamp time.Time
+	// Visible is false for deleted elements in history files.
+	Visible bool
 }
 
 type Node struct {
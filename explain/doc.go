@@ -0,0 +1,5 @@
+/*
+Package explain provides the explain sub command to show how a single
+cached node, way or relation is mapped and imported.
+*/
+package explain
@@ -0,0 +1,388 @@
+package explain
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/config"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/geom/geos"
+	"github.com/olehz/imposm3/geom/limit"
+	"github.com/olehz/imposm3/logging"
+	"github.com/olehz/imposm3/mapping"
+	"github.com/olehz/imposm3/stats"
+	"github.com/olehz/imposm3/writer"
+)
+
+var flags = flag.NewFlagSet("explain", flag.ExitOnError)
+
+var (
+	opts = config.AddToolFlags(flags)
+
+	nodeId      = flags.Int64("node", 0, "node id")
+	wayId       = flags.Int64("way", 0, "way id")
+	relId       = flags.Int64("rel", 0, "relation id")
+	mappingFile = flags.String("mapping", "", "mapping file")
+	srid        = flags.Int("srid", 3857, "srs id")
+	limitTo     = flags.String("limitto", "", "limit to geometries")
+)
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s %s -mapping mapping.json -node|-way|-rel id:\n\n", os.Args[0], os.Args[1])
+	flags.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\nShow how a cached node/way/relation is mapped and imported.")
+	os.Exit(1)
+}
+
+// match is a table that matched the tags of an element.
+type match struct {
+	Table string `json:"table"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// insert is a geometry that would be inserted into the database.
+type insert struct {
+	Geometry string   `json:"geometry"`
+	Tables   []string `json:"tables"`
+	Type     string   `json:"type,omitempty"`
+	Valid    bool     `json:"valid"`
+	Length   float64  `json:"length"`
+	Area     float64  `json:"area"`
+}
+
+type explanation struct {
+	Type string `json:"type"`
+	Id   int64  `json:"id"`
+	// Found is false if the element is not cached
+	Found bool `json:"found"`
+	// CachedTags are the tags from the cache, Tags the tags after the
+	// tag filter of the mapping
+	CachedTags element.Tags `json:"cached_tags,omitempty"`
+	Tags       element.Tags `json:"tags,omitempty"`
+	// InsertedAsRelation is set for ways that are already inserted as
+	// part of a multipolygon relation
+	InsertedAsRelation *bool `json:"inserted_as_relation,omitempty"`
+	// MissingRefs are nodes or members that are not cached
+	MissingRefs []int64 `json:"missing_refs,omitempty"`
+	// Matches and Rejected are the matched tables and the tables that
+	// were rejected by the filters of the table for each matcher
+	Matches  map[string][]match `json:"matches"`
+	Rejected map[string][]match `json:"rejected,omitempty"`
+	Inserts  []insert           `json:"inserts"`
+	Errors   []string           `json:"errors"`
+}
+
+func (e *explanation) match(name string, matcher interface{}, matches []mapping.Match, tags *element.Tags) {
+	e.Matches[name] = makeMatches(matches)
+	if rm, ok := matcher.(mapping.RejectedMatcher); ok {
+		if rejected := rm.RejectedMatches(tags); len(rejected) > 0 {
+			e.Rejected[name] = makeMatches(rejected)
+		}
+	}
+}
+
+func makeMatches(matches []mapping.Match) []match {
+	result := []match{}
+	for _, m := range matches {
+		result = append(result, match{m.Table.Name, m.Key, m.Value})
+	}
+	sort.Sort(byTable(result))
+	return result
+}
+
+type byTable []match
+
+func (m byTable) Len() int           { return len(m) }
+func (m byTable) Less(i, j int) bool { return m[i].Table < m[j].Table }
+func (m byTable) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// recorder is a database.Inserter that records all inserts instead of
+// writing them to the database.
+type recorder struct {
+	mu      sync.Mutex
+	g       *geos.Geos
+	inserts []insert
+}
+
+func (r *recorder) record(geometry string, elem element.OSMElem, matches []mapping.Match) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ins := insert{Geometry: geometry}
+	for _, m := range matches {
+		ins.Tables = append(ins.Tables, m.Table.Name)
+	}
+	sort.Strings(ins.Tables)
+	if elem.Geom != nil && elem.Geom.Geom != nil {
+		ins.Type = r.g.Type(elem.Geom.Geom)
+		ins.Valid = r.g.IsValid(elem.Geom.Geom)
+		ins.Length = elem.Geom.Geom.Length()
+		ins.Area = elem.Geom.Geom.Area()
+	}
+	r.inserts = append(r.inserts, ins)
+	return nil
+}
+
+func (r *recorder) InsertPoint(elem element.OSMElem, matches []mapping.Match) error {
+	return r.record("point", elem, matches)
+}
+
+func (r *recorder) InsertLineString(elem element.OSMElem, matches []mapping.Match) error {
+	return r.record("linestring", elem, matches)
+}
+
+func (r *recorder) InsertPolygon(elem element.OSMElem, matches []mapping.Match) error {
+	return r.record("polygon", elem, matches)
+}
+
+func Explain(args []string) {
+	flags.Usage = Usage
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *mappingFile == "" || (*nodeId == 0 && *wayId == 0 && *relId == 0) {
+		Usage()
+	}
+	if err := opts.Configure(); err != nil {
+		log.Fatal(err)
+	}
+
+	tagmapping, err := mapping.NewMapping(*mappingFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var limiter *limit.Limiter
+	if *limitTo != "" {
+		limiter, err = limit.NewFromSources(*limitTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	osmCache := cache.NewOSMCache(opts.CacheDir)
+	if !osmCache.Exists() {
+		log.Fatalf("no cache found in %s", opts.CacheDir)
+	}
+	err = osmCache.OpenExisting()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer osmCache.Close()
+	osmCache.Coords.SetReadOnly(true)
+
+	// the output of the writers is only reported in the explanation
+	logging.SetQuiet(true)
+	logging.SetLevel(logging.ERROR)
+
+	ex := &explainer{osmCache: osmCache, tagmapping: tagmapping, limiter: limiter, srid: *srid}
+	var result []*explanation
+	if *nodeId != 0 {
+		result = append(result, ex.node(*nodeId))
+	}
+	if *wayId != 0 {
+		result = append(result, ex.way(*wayId))
+	}
+	if *relId != 0 {
+		result = append(result, ex.relation(*relId))
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
+
+type explainer struct {
+	osmCache   *cache.OSMCache
+	tagmapping *mapping.Mapping
+	limiter    *limit.Limiter
+	srid       int
+}
+
+func newExplanation(typ string, id int64) *explanation {
+	return &explanation{
+		Type:     typ,
+		Id:       id,
+		Matches:  make(map[string][]match),
+		Rejected: make(map[string][]match),
+		Inserts:  []insert{},
+		Errors:   []string{},
+	}
+}
+
+// write passes the element through the writer w and records all inserts
+// and errors. send sends the element to the writer and closes the input
+// channel.
+func (ex *explainer) write(e *explanation, w *writer.OsmElemWriter, rec *recorder, send func()) {
+	w.SetLimiter(ex.limiter)
+	w.DisableCacheUpdates()
+	// the error handler is called from all writer goroutines
+	var errMu sync.Mutex
+	w.SetErrorHandler(func(err error) {
+		errMu.Lock()
+		e.Errors = append(e.Errors, err.Error())
+		errMu.Unlock()
+	})
+	w.Start()
+	send()
+	w.Wait()
+	e.Inserts = append(e.Inserts, rec.inserts...)
+}
+
+func (ex *explainer) newRecorder() *recorder {
+	g := geos.NewGeos()
+	g.SetHandleSrid(ex.srid)
+	return &recorder{g: g}
+}
+
+func (ex *explainer) node(id int64) *explanation {
+	e := newExplanation("node", id)
+	nd, err := ex.osmCache.Nodes.GetNode(id)
+	if err == cache.NotFound {
+		return e
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	e.Found = true
+	e.CachedTags = copyTags(nd.Tags)
+	ex.tagmapping.NodeTagFilter().Filter(&nd.Tags)
+	e.Tags = nd.Tags
+
+	matcher := ex.tagmapping.PointMatcher()
+	e.match("point", matcher, matcher.MatchNode(nd), &nd.Tags)
+
+	rec := ex.newRecorder()
+	defer rec.g.Finish()
+	progress := stats.NewStatsReporter()
+	defer progress.Stop()
+	nodes := make(chan *element.Node, 1)
+	w := writer.NewNodeWriter(ex.osmCache, nodes, rec, progress, matcher, ex.srid)
+	ex.write(e, w, rec, func() {
+		nodes <- nd
+		close(nodes)
+	})
+	return e
+}
+
+func (ex *explainer) way(id int64) *explanation {
+	e := newExplanation("way", id)
+	way, err := ex.osmCache.Ways.GetWay(id)
+	if err == cache.NotFound {
+		return e
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	e.Found = true
+	e.CachedTags = copyTags(way.Tags)
+	ex.tagmapping.WayTagFilter().Filter(&way.Tags)
+	e.Tags = way.Tags
+
+	inserted, err := ex.osmCache.InsertedWays.IsInserted(id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	e.InsertedAsRelation = &inserted
+	e.MissingRefs = ex.missingCoords(way)
+
+	lineMatcher := ex.tagmapping.LineStringMatcher()
+	polygonMatcher := ex.tagmapping.PolygonMatcher()
+	e.match("linestring", lineMatcher, lineMatcher.MatchWay(way), &way.Tags)
+	e.match("polygon", polygonMatcher, polygonMatcher.MatchWay(way), &way.Tags)
+
+	rec := ex.newRecorder()
+	defer rec.g.Finish()
+	progress := stats.NewStatsReporter()
+	defer progress.Stop()
+	ways := make(chan *element.Way, 1)
+	w := writer.NewWayWriter(ex.osmCache, nil, ex.tagmapping.SingleIdSpace, ways, rec, progress,
+		polygonMatcher, lineMatcher, ex.srid)
+	ex.write(e, w, rec, func() {
+		ways <- way
+		close(ways)
+	})
+	return e
+}
+
+func (ex *explainer) relation(id int64) *explanation {
+	e := newExplanation("relation", id)
+	rel, err := ex.osmCache.Relations.GetRelation(id)
+	if err == cache.NotFound {
+		return e
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	e.Found = true
+	e.CachedTags = copyTags(rel.Tags)
+	ex.tagmapping.RelationTagFilter().Filter(&rel.Tags)
+	e.Tags = rel.Tags
+
+	for _, m := range rel.Members {
+		if m.Type != element.WAY {
+			continue
+		}
+		way, err := ex.osmCache.Ways.GetWay(m.Id)
+		if err == cache.NotFound {
+			e.MissingRefs = append(e.MissingRefs, m.Id)
+			continue
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		e.MissingRefs = append(e.MissingRefs, ex.missingCoords(way)...)
+	}
+
+	polygonMatcher := ex.tagmapping.PolygonMatcher()
+	streetMatcher := ex.tagmapping.StreetMatcher()
+	e.match("polygon", polygonMatcher, polygonMatcher.MatchRelation(rel), &rel.Tags)
+	e.match("relation", streetMatcher, streetMatcher.MatchRelation(rel), &rel.Tags)
+
+	rec := ex.newRecorder()
+	defer rec.g.Finish()
+	progress := stats.NewStatsReporter()
+	defer progress.Stop()
+	rels := make(chan *element.Relation, 1)
+	w := writer.NewRelationWriter(ex.osmCache, nil, ex.tagmapping.SingleIdSpace, rels, rec, progress,
+		polygonMatcher, streetMatcher, ex.srid)
+	ex.write(e, w, rec, func() {
+		rels <- rel
+		close(rels)
+	})
+	return e
+}
+
+// missingCoords returns the refs of way without cached coords.
+func (ex *explainer) missingCoords(way *element.Way) []int64 {
+	if len(way.Nodes) == len(way.Refs) && len(way.Refs) > 0 {
+		// locations are stored with the way
+		return nil
+	}
+	var missing []int64
+	for _, ref := range way.Refs {
+		if _, err := ex.osmCache.Coords.GetCoord(ref); err == cache.NotFound {
+			missing = append(missing, ref)
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return missing
+}
+
+func copyTags(tags element.Tags) element.Tags {
+	if tags == nil {
+		return nil
+	}
+	result := make(element.Tags, len(tags))
+	for k, v := range tags {
+		result[k] = v
+	}
+	return result
+}
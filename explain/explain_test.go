@@ -0,0 +1,95 @@
+package explain
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/olehz/imposm3/cache"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/mapping"
+)
+
+func TestExplain(t *testing.T) {
+	cacheDir, _ := ioutil.TempDir("", "imposm3_test")
+	defer os.RemoveAll(cacheDir)
+
+	tagmapping, err := mapping.NewMapping("../mapping/test_mapping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	osmCache := cache.NewOSMCache(cacheDir)
+	if err := osmCache.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer osmCache.Close()
+
+	if err := osmCache.Nodes.PutNode(&element.Node{
+		OSMElem: element.OSMElem{Id: 1, Tags: element.Tags{"amenity": "school"}},
+		Long:    10, Lat: 53,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	osmCache.Coords.PutCoords([]element.Node{
+		{OSMElem: element.OSMElem{Id: 2}, Long: 10, Lat: 53},
+		{OSMElem: element.OSMElem{Id: 3}, Long: 10.001, Lat: 53},
+		{OSMElem: element.OSMElem{Id: 4}, Long: 10.001, Lat: 53.001},
+		{OSMElem: element.OSMElem{Id: 5}, Long: 10, Lat: 53.001},
+	})
+	for _, w := range []*element.Way{
+		{OSMElem: element.OSMElem{Id: 10, Tags: element.Tags{"building": "yes"}}, Refs: []int64{2, 3, 4, 5, 2}},
+		// untagged outer way of the relation
+		{OSMElem: element.OSMElem{Id: 11}, Refs: []int64{2, 3, 4, 2}},
+	} {
+		if err := osmCache.Ways.PutWay(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := osmCache.Relations.PutRelation(&element.Relation{
+		OSMElem: element.OSMElem{Id: 20, Tags: element.Tags{"type": "multipolygon", "leisure": "park"}},
+		Members: []element.Member{{Id: 11, Type: element.WAY, Role: "outer"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ex := &explainer{osmCache: osmCache, tagmapping: tagmapping, srid: 3857}
+
+	for _, test := range []struct {
+		e        *explanation
+		matcher  string
+		geometry string
+		table    string
+	}{
+		{e: ex.node(1), matcher: "point", geometry: "point", table: "amenities"},
+		{e: ex.way(10), matcher: "polygon", geometry: "polygon", table: "buildings"},
+		{e: ex.relation(20), matcher: "polygon", geometry: "polygon", table: "landusages"},
+	} {
+		e := test.e
+		if !e.Found {
+			t.Errorf("%s %d not found", e.Type, e.Id)
+			continue
+		}
+		if len(e.Errors) != 0 {
+			t.Errorf("%s %d: unexpected errors %v", e.Type, e.Id, e.Errors)
+		}
+		if m := e.Matches[test.matcher]; len(m) != 1 || m[0].Table != test.table {
+			t.Errorf("%s %d: unexpected matches %v", e.Type, e.Id, e.Matches)
+		}
+		if len(e.Inserts) != 1 {
+			t.Errorf("%s %d: unexpected inserts %v", e.Type, e.Id, e.Inserts)
+			continue
+		}
+		ins := e.Inserts[0]
+		if ins.Geometry != test.geometry || len(ins.Tables) != 1 || ins.Tables[0] != test.table || !ins.Valid {
+			t.Errorf("%s %d: unexpected insert %v", e.Type, e.Id, ins)
+		}
+	}
+
+	if e := ex.way(10); e.InsertedAsRelation == nil || *e.InsertedAsRelation {
+		t.Errorf("way 10 is not inserted as relation: %v", e.InsertedAsRelation)
+	}
+	if e := ex.node(99); e.Found || len(e.Inserts) != 0 {
+		t.Errorf("unexpected explanation for missing node %v", e)
+	}
+}
@@ -7,14 +7,49 @@ import (
 	"github.com/olehz/imposm3/geom/geos"
 	"github.com/olehz/imposm3/proj"
 	"io"
+	"math"
+	"strconv"
+	"strings"
 )
 
 type object struct {
 	Type        string                 `json:"type"`
 	Features    []object               `json:"features"`
 	Geometry    *object                `json:"geometry"`
+	Geometries  []object               `json:"geometries"`
 	Coordinates []interface{}          `json:"coordinates"`
 	Properties  map[string]interface{} `json:"properties"`
+	Crs         *crs                   `json:"crs"`
+}
+
+type crs struct {
+	Type       string `json:"type"`
+	Properties struct {
+		Name string `json:"name"`
+	} `json:"properties"`
+}
+
+// crsSrid returns 4326 or 3857 for the named CRS of a GeoJSON, e.g.
+// urn:ogc:def:crs:EPSG::4326 or EPSG:3857.
+func crsSrid(c *crs) (int, error) {
+	name := c.Properties.Name
+	if c.Type != "name" || name == "" {
+		return 0, errors.New("unsupported crs, only named crs are supported")
+	}
+	if strings.HasSuffix(name, "CRS84") {
+		return 4326, nil
+	}
+	code, err := strconv.Atoi(name[strings.LastIndex(name, ":")+1:])
+	if err != nil {
+		return 0, errors.New("unsupported crs: " + name)
+	}
+	switch code {
+	case 4326:
+		return 4326, nil
+	case 3857, 900913, 3785, 102100, 102113:
+		return 3857, nil
+	}
+	return 0, errors.New("unsupported crs " + name + ", only EPSG:4326 and EPSG:3857 are supported")
 }
 
 type geometry struct {
@@ -27,7 +62,10 @@ type point struct {
 	lat  float64
 }
 
-func newPointFromCoords(coords []interface{}) (point, error) {
+// newPointFromCoords returns the point in EPSG:3857. Points are
+// transformed if srid is 4326, or if srid is 0 and the point is within
+// the bounds of EPSG:4326.
+func newPointFromCoords(coords []interface{}, srid int) (point, error) {
 	p := point{}
 	if len(coords) != 2 && len(coords) != 3 {
 		return p, errors.New("point list length not 2 or 3")
@@ -42,15 +80,15 @@ func newPointFromCoords(coords []interface{}) (point, error) {
 		return p, errors.New("invalid lat")
 	}
 
-	if p.long >= -180.0 && p.long <= 180.0 && p.lat >= -90.0 && p.lat <= 90.0 {
-		p.long, p.lat = proj.WgsToMerc(p.long, p.lat)
+	if srid == 4326 || (srid == 0 && p.long >= -180.0 && p.long <= 180.0 && p.lat >= -90.0 && p.lat <= 90.0) {
+		p.long, p.lat = proj.WgsToMerc(p.long, math.Max(-85.0511, math.Min(85.0511, p.lat)))
 	}
 	return p, nil
 }
 
 type lineString []point
 
-func newLineStringFromCoords(coords []interface{}) (lineString, error) {
+func newLineStringFromCoords(coords []interface{}, srid int) (lineString, error) {
 	ls := lineString{}
 
 	for _, part := range coords {
@@ -58,7 +96,7 @@ func newLineStringFromCoords(coords []interface{}) (lineString, error) {
 		if !ok {
 			return ls, errors.New("point not a list")
 		}
-		p, err := newPointFromCoords(coord)
+		p, err := newPointFromCoords(coord, srid)
 		if err != nil {
 			return ls, err
 		}
@@ -87,7 +125,7 @@ func stringProperties(properties map[string]interface{}) map[string]string {
 	return result
 }
 
-func newPolygonFromCoords(coords []interface{}) (polygon, error) {
+func newPolygonFromCoords(coords []interface{}, srid int) (polygon, error) {
 	poly := polygon{}
 
 	for _, part := range coords {
@@ -95,7 +133,7 @@ func newPolygonFromCoords(coords []interface{}) (polygon, error) {
 		if !ok {
 			return poly, errors.New("polygon lineString not a list")
 		}
-		ls, err := newLineStringFromCoords(lsCoords)
+		ls, err := newLineStringFromCoords(lsCoords, srid)
 		if err != nil {
 			return poly, err
 		}
@@ -104,7 +142,7 @@ func newPolygonFromCoords(coords []interface{}) (polygon, error) {
 	return poly, nil
 }
 
-func newMultiPolygonFeaturesFromCoords(coords []interface{}) ([]polygonFeature, error) {
+func newMultiPolygonFeaturesFromCoords(coords []interface{}, srid int) ([]polygonFeature, error) {
 	features := []polygonFeature{}
 
 	for _, part := range coords {
@@ -112,7 +150,7 @@ func newMultiPolygonFeaturesFromCoords(coords []interface{}) ([]polygonFeature,
 		if !ok {
 			return features, errors.New("multipolygon polygon not a list")
 		}
-		poly, err := newPolygonFromCoords(polyCoords)
+		poly, err := newPolygonFromCoords(polyCoords, srid)
 		if err != nil {
 			return features, err
 		}
@@ -130,7 +168,17 @@ func ParseGeoJson(r io.Reader) ([]Feature, error) {
 		return nil, err
 	}
 
-	polygons, err := constructPolygonFeatures(obj)
+	// without crs, coordinates within the bounds of EPSG:4326 are
+	// transformed
+	srid := 0
+	if obj.Crs != nil {
+		srid, err = crsSrid(obj.Crs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	polygons, err := constructPolygonFeatures(obj, srid)
 
 	if err != nil {
 		return nil, err
@@ -151,20 +199,30 @@ func ParseGeoJson(r io.Reader) ([]Feature, error) {
 	return result, err
 }
 
-func constructPolygonFeatures(obj *object) ([]polygonFeature, error) {
+func constructPolygonFeatures(obj *object, srid int) ([]polygonFeature, error) {
 	switch obj.Type {
-	case "Point":
-		return nil, errors.New("only polygon or MultiPolygon are supported")
-	case "LineString":
-		return nil, errors.New("only polygon or MultiPolygon are supported")
 	case "Polygon":
-		poly, err := newPolygonFromCoords(obj.Coordinates)
+		poly, err := newPolygonFromCoords(obj.Coordinates, srid)
 		return []polygonFeature{{poly, nil}}, err
 	case "MultiPolygon":
-		poly, err := newMultiPolygonFeaturesFromCoords(obj.Coordinates)
+		poly, err := newMultiPolygonFeaturesFromCoords(obj.Coordinates, srid)
 		return poly, err
+	case "GeometryCollection":
+		features := make([]polygonFeature, 0)
+
+		for _, obj := range obj.Geometries {
+			f, err := constructPolygonFeatures(&obj, srid)
+			if err != nil {
+				return nil, err
+			}
+			features = append(features, f...)
+		}
+		return features, nil
 	case "Feature":
-		features, err := constructPolygonFeatures(obj.Geometry)
+		if obj.Geometry == nil {
+			return nil, errors.New("feature without geometry")
+		}
+		features, err := constructPolygonFeatures(obj.Geometry, srid)
 		if err != nil {
 			return nil, err
 		}
@@ -177,13 +235,15 @@ func constructPolygonFeatures(obj *object) ([]polygonFeature, error) {
 		features := make([]polygonFeature, 0)
 
 		for _, obj := range obj.Features {
-			f, err := constructPolygonFeatures(&obj)
+			f, err := constructPolygonFeatures(&obj, srid)
 			if err != nil {
 				return nil, err
 			}
 			features = append(features, f...)
 		}
 		return features, nil
+	case "Point", "MultiPoint", "LineString", "MultiLineString":
+		return nil, errors.New("unsupported geometry type " + obj.Type + ", only Polygon and MultiPolygon are supported")
 	default:
 		return nil, errors.New("unknown type: " + obj.Type)
 	}
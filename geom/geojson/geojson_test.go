@@ -165,3 +165,57 @@ func TestParseGeoJsonTransform(t *testing.T) {
 		t.Fatal(features[0].Geom.Area())
 	}
 }
+
+func TestParseGeoJsonCrs(t *testing.T) {
+	// coordinates are not transformed with EPSG:3857 crs
+	r := bytes.NewBufferString(`{"type": "Polygon",
+        "crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:EPSG::3857"}},
+        "coordinates": [[[8, 53], [9, 53], [9, 54], [8, 54], [8, 53]]]}`)
+	features, err := ParseGeoJson(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 1 || math.Abs(features[0].Geom.Area()-1) > 0.00001 {
+		t.Fatal(features)
+	}
+
+	r = bytes.NewBufferString(`{"type": "GeometryCollection",
+        "crs": {"type": "name", "properties": {"name": "urn:ogc:def:crs:OGC:1.3:CRS84"}},
+        "geometries": [{"type": "Polygon", "coordinates": [[[8, 53], [9, 53], [9, 54], [8, 54], [8, 53]]]}]}`)
+	features, err = ParseGeoJson(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 1 || math.Abs(features[0].Geom.Area()-20834374847.98027) > 0.01 {
+		t.Fatal(features)
+	}
+}
+
+func TestCrsSrid(t *testing.T) {
+	for name, srid := range map[string]int{
+		"EPSG:4326":                     4326,
+		"urn:ogc:def:crs:EPSG::4326":    4326,
+		"urn:ogc:def:crs:OGC:1.3:CRS84": 4326,
+		"EPSG:3857":                     3857,
+		"urn:ogc:def:crs:EPSG::900913":  3857,
+	} {
+		c := &crs{Type: "name"}
+		c.Properties.Name = name
+		if s, err := crsSrid(c); err != nil || s != srid {
+			t.Errorf("%s: %d %v", name, s, err)
+		}
+	}
+	c := &crs{Type: "name"}
+	c.Properties.Name = "EPSG:25832"
+	if _, err := crsSrid(c); err == nil {
+		t.Error("expected error for EPSG:25832")
+	}
+}
+
+func TestParseUnsupportedType(t *testing.T) {
+	r := bytes.NewBufferString(`{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[1000, 1000], [2000, 1000]]}}`)
+	_, err := ParseGeoJson(r)
+	if err == nil || err.Error() != "unsupported geometry type LineString, only Polygon and MultiPolygon are supported" {
+		t.Fatal(err)
+	}
+}
@@ -131,7 +131,7 @@ func Polygon(g *geos.Geos, nodes []element.Node) (*geos.Geom, error) {
 }
 
 func AsGeomElement(g *geos.Geos, geom *geos.Geom) (*element.Geometry, error) {
-	wkb := g.AsEwkbHex(geom)
+	wkb := g.AsEwkb(geom)
 	if wkb == nil {
 		return nil, errors.New("could not create wkb")
 	}
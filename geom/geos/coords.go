@@ -61,3 +61,32 @@ func (this *Geos) DestroyCoordSeq(coordSeq *CoordSeq) {
 		panic("double free?")
 	}
 }
+
+// CoordSeq returns the coordinates of a Point, LineString or LinearRing.
+// The CoordSeq belongs to geom and must not be destroyed.
+func (this *Geos) CoordSeq(geom *Geom) (*CoordSeq, error) {
+	result := C.GEOSGeom_getCoordSeq_r(this.v, geom.v)
+	if result == nil {
+		return nil, Error("unable to get CoordSeq")
+	}
+	return &CoordSeq{result}, nil
+}
+
+func (this *CoordSeq) Size(handle *Geos) (uint32, error) {
+	var size C.uint
+	if C.GEOSCoordSeq_getSize_r(handle.v, this.v, &size) == 0 {
+		return 0, Error("unable to get size")
+	}
+	return uint32(size), nil
+}
+
+func (this *CoordSeq) XY(handle *Geos, i uint32) (float64, float64, error) {
+	var x, y C.double
+	if C.GEOSCoordSeq_getX_r(handle.v, this.v, C.uint(i), &x) == 0 {
+		return 0, 0, Error("unable to get X")
+	}
+	if C.GEOSCoordSeq_getY_r(handle.v, this.v, C.uint(i), &y) == 0 {
+		return 0, 0, Error("unable to get Y")
+	}
+	return float64(x), float64(y), nil
+}
@@ -104,6 +104,11 @@ func (this *Geos) SetHandleSrid(srid int) {
 	this.srid = srid
 }
 
+// Srid returns the SRID of the geometry, e.g. from an EWKB, or 0.
+func (this *Geos) Srid(geom *Geom) int {
+	return int(C.GEOSGetSRID_r(this.v, geom.v))
+}
+
 func (this *Geos) NumGeoms(geom *Geom) int32 {
 	count := int32(C.GEOSGetNumGeometries_r(this.v, geom.v))
 	return count
@@ -130,6 +135,20 @@ func (this *Geos) ExteriorRing(geom *Geom) *Geom {
 	return &Geom{ring}
 }
 
+func (this *Geos) NumInteriorRings(geom *Geom) int32 {
+	return int32(C.GEOSGetNumInteriorRings_r(this.v, geom.v))
+}
+
+// InteriorRing returns the nth interior ring of a polygon. The ring
+// belongs to geom and must not be destroyed.
+func (this *Geos) InteriorRing(geom *Geom, n int32) *Geom {
+	ring := C.GEOSGetInteriorRingN_r(this.v, geom.v, C.int(n))
+	if ring == nil {
+		return nil
+	}
+	return &Geom{ring}
+}
+
 func (this *Geos) BoundsPolygon(bounds Bounds) *Geom {
 	coordSeq, err := this.CreateCoordSeq(5, 2)
 	if err != nil {
@@ -50,6 +50,16 @@ func (this *Geos) SimplifyPreserveTopology(geom *Geom, tolerance float64) *Geom
 	return &Geom{simplified}
 }
 
+// SnapToGrid snaps all coordinates to a grid with the given size.
+// Collapsed components are removed.
+func (this *Geos) SnapToGrid(geom *Geom, size float64) *Geom {
+	snapped := C.GEOSGeom_setPrecision_r(this.v, geom.v, C.double(size), 0)
+	if snapped == nil {
+		return nil
+	}
+	return &Geom{snapped}
+}
+
 // UnionPolygons tries to merge polygons.
 // Returns a single (Multi)Polygon.
 // Destroys polygons and returns new allocated (Multi)Polygon as necessary.
@@ -53,6 +53,29 @@ func (this *Geos) AsWkb(geom *Geom) []byte {
 	return result
 }
 
+// AsEwkb returns the geometry as EWKB, with the SRID of SetHandleSrid.
+func (this *Geos) AsEwkb(geom *Geom) []byte {
+	writer := C.GEOSWKBWriter_create_r(this.v)
+	if writer == nil {
+		return nil
+	}
+	defer C.GEOSWKBWriter_destroy_r(this.v, writer)
+
+	if this.srid != 0 {
+		C.GEOSWKBWriter_setIncludeSRID_r(this.v, writer, C.char(1))
+		C.GEOSSetSRID_r(this.v, geom.v, C.int(this.srid))
+	}
+
+	var size C.size_t
+	buf := C.GEOSWKBWriter_write_r(this.v, writer, geom.v, &size)
+	if buf == nil {
+		return nil
+	}
+	result := C.GoBytes(unsafe.Pointer(buf), C.int(size))
+	C.free(unsafe.Pointer(buf))
+	return result
+}
+
 func (this *Geos) AsEwkbHex(geom *Geom) []byte {
 	writer := C.GEOSWKBWriter_create_r(this.v)
 	if writer == nil {
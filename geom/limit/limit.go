@@ -5,6 +5,7 @@ import (
 	"github.com/olehz/imposm3/geom/geojson"
 	"github.com/olehz/imposm3/geom/geos"
 	"github.com/olehz/imposm3/logging"
+	"github.com/olehz/imposm3/proj"
 	"math"
 	"os"
 	"strings"
@@ -148,6 +149,61 @@ func NewFromGeoJsonWithBuffered(source string, buffer float64) (*Limiter, error)
 	g := geos.NewGeos()
 	defer g.Finish()
 
+	geoms := make([]*geos.Geom, 0, len(features))
+	for _, feature := range features {
+		geoms = append(geoms, feature.Geom)
+	}
+	return newLimiter(g, geoms, buffer)
+}
+
+// NewFromBboxWithBuffered returns a Limiter for the union of all bboxes.
+// Each bbox is a minx, miny, maxx, maxy in EPSG:4326, e.g. from
+// the header of a PBF file.
+func NewFromBboxWithBuffered(bboxes [][]float64, buffer float64) (*Limiter, error) {
+	g := geos.NewGeos()
+	defer g.Finish()
+
+	var geoms []*geos.Geom
+	for _, bbox := range bboxes {
+		geom, err := bboxGeom(g, bbox)
+		if err != nil {
+			return nil, err
+		}
+		geoms = append(geoms, geom)
+	}
+	if len(geoms) == 0 {
+		return nil, errors.New("missing limitto bbox")
+	}
+	return newLimiter(g, geoms, buffer)
+}
+
+// bboxGeom returns the polygon of a minx, miny, maxx, maxy bbox in
+// EPSG:4326, transformed to EPSG:3857.
+func bboxGeom(g *geos.Geos, bbox []float64) (*geos.Geom, error) {
+	if len(bbox) != 4 {
+		return nil, errors.New("invalid limitto bbox")
+	}
+	var bounds geos.Bounds
+	bounds.MinX, bounds.MinY = proj.WgsToMerc(bbox[0], clampLat(bbox[1]))
+	bounds.MaxX, bounds.MaxY = proj.WgsToMerc(bbox[2], clampLat(bbox[3]))
+	if bounds.MinX >= bounds.MaxX || bounds.MinY >= bounds.MaxY {
+		return nil, errors.New("invalid limitto bbox")
+	}
+	geom := g.BoundsPolygon(bounds)
+	if geom == nil {
+		return nil, errors.New("couldn't create limitto bbox")
+	}
+	return geom, nil
+}
+
+// clampLat limits lat to the bounds of EPSG:3857.
+func clampLat(lat float64) float64 {
+	return math.Max(-85.0511, math.Min(85.0511, lat))
+}
+
+// newLimiter returns a Limiter for the union of all polygons in
+// EPSG:3857.
+func newLimiter(g *geos.Geos, geoms []*geos.Geom, buffer float64) (*Limiter, error) {
 	index := g.CreateIndex()
 
 	var bufferedPolygons []*geos.Geom
@@ -158,9 +214,9 @@ func NewFromGeoJsonWithBuffered(source string, buffer float64) (*Limiter, error)
 		withBuffer = true
 	}
 
-	for _, feature := range features {
+	for _, geom := range geoms {
 		if withBuffer {
-			simplified := g.SimplifyPreserveTopology(feature.Geom, 1000)
+			simplified := g.SimplifyPreserveTopology(geom, 1000)
 			if simplified == nil {
 				return nil, errors.New("couldn't simplify limitto")
 			}
@@ -172,9 +228,9 @@ func NewFromGeoJsonWithBuffered(source string, buffer float64) (*Limiter, error)
 			// buffered gets destroyed in UnionPolygons
 			bufferedPolygons = append(bufferedPolygons, buffered)
 		}
-		polygons = append(polygons, feature.Geom)
+		polygons = append(polygons, geom)
 
-		parts, err := SplitPolygonAtAutoGrid(g, feature.Geom)
+		parts, err := SplitPolygonAtAutoGrid(g, geom)
 
 		if err != nil {
 			return nil, err
@@ -298,6 +354,25 @@ func (l *Limiter) Clip(geom *geos.Geom) ([]*geos.Geom, error) {
 	return mergeGeometries(g, intersections, geomType), nil
 }
 
+// IntersectsPoint returns whether the point x, y intersects the
+// limitto geometry (without the buffer).
+func (l *Limiter) IntersectsPoint(g *geos.Geos, x, y float64) bool {
+	p := g.Point(x, y)
+	if p == nil {
+		return false
+	}
+	defer g.Destroy(p)
+
+	l.geomPrepMu.Lock()
+	defer l.geomPrepMu.Unlock()
+	return g.PreparedIntersects(l.geomPrep, p)
+}
+
+// Bounds returns the bounds of the limitto geometry in EPSG:3857.
+func (l *Limiter) Bounds() geos.Bounds {
+	return l.geom.Bounds()
+}
+
 func (c *Limiter) IntersectsBuffer(g *geos.Geos, x, y float64) bool {
 	if c.bufferedPrep == nil {
 		return true
@@ -0,0 +1,234 @@
+package limit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/olehz/imposm3/geom/geos"
+)
+
+// ring of a shapefile polygon
+type shpRing [][2]float64
+
+// readShapefile returns all polygons of a polygon shapefile and the SRID
+// from the .prj file, or 0 if there is no .prj file.
+func readShapefile(g *geos.Geos, path string) ([]*geos.Geom, int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	records, err := parseShapefile(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	srid := 0
+	prj, err := ioutil.ReadFile(strings.TrimSuffix(path, ".shp") + ".prj")
+	if err == nil {
+		srid, err = prjSrid(string(prj))
+		if err != nil {
+			return nil, 0, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+
+	var geoms []*geos.Geom
+	for _, rings := range records {
+		for _, polygon := range assembleRings(rings) {
+			geom, err := shpPolygon(g, polygon)
+			if err != nil {
+				return nil, 0, err
+			}
+			geoms = append(geoms, geom)
+		}
+	}
+	return geoms, srid, nil
+}
+
+func shpPolygon(g *geos.Geos, rings []shpRing) (*geos.Geom, error) {
+	shell, err := linearRing(g, rings[0])
+	if err != nil {
+		return nil, err
+	}
+	var holes []*geos.Geom
+	for _, r := range rings[1:] {
+		hole, err := linearRing(g, r)
+		if err != nil {
+			return nil, err
+		}
+		holes = append(holes, hole)
+	}
+	polygon := g.Polygon(shell, holes)
+	if polygon == nil {
+		return nil, errors.New("unable to create polygon")
+	}
+	return polygon, nil
+}
+
+// Shape types of polygons, with Z and M values.
+const (
+	shpPolygonType  = 5
+	shpPolygonZType = 15
+	shpPolygonMType = 25
+)
+
+// parseShapefile returns the rings of each polygon record of a shapefile
+// (.shp). Z and M values are ignored.
+func parseShapefile(data []byte) ([][]shpRing, error) {
+	if len(data) < 100 || binary.BigEndian.Uint32(data[0:4]) != 9994 {
+		return nil, errors.New("invalid shapefile")
+	}
+	if err := checkShapeType(int32(binary.LittleEndian.Uint32(data[32:36]))); err != nil {
+		return nil, err
+	}
+
+	var records [][]shpRing
+	pos := 100
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos+4:pos+8])) * 2
+		pos += 8
+		if length < 4 || pos+length > len(data) {
+			return nil, errors.New("invalid shapefile record")
+		}
+		rings, err := parsePolygonRecord(data[pos : pos+length])
+		if err != nil {
+			return nil, err
+		}
+		if len(rings) > 0 {
+			records = append(records, rings)
+		}
+		pos += length
+	}
+	return records, nil
+}
+
+func checkShapeType(shapeType int32) error {
+	switch shapeType {
+	case shpPolygonType, shpPolygonZType, shpPolygonMType:
+		return nil
+	}
+	return fmt.Errorf("unsupported shape type %d, only polygons are supported", shapeType)
+}
+
+func parsePolygonRecord(rec []byte) ([]shpRing, error) {
+	shapeType := int32(binary.LittleEndian.Uint32(rec[0:4]))
+	if shapeType == 0 {
+		// null shape
+		return nil, nil
+	}
+	if err := checkShapeType(shapeType); err != nil {
+		return nil, err
+	}
+	// shape type, bbox, number of parts and points
+	if len(rec) < 44 {
+		return nil, errors.New("invalid shapefile polygon")
+	}
+	numParts := int(binary.LittleEndian.Uint32(rec[36:40]))
+	numPoints := int(binary.LittleEndian.Uint32(rec[40:44]))
+	pointsStart := 44 + 4*numParts
+	if numParts < 0 || numPoints < 0 || pointsStart+16*numPoints > len(rec) {
+		return nil, errors.New("invalid shapefile polygon")
+	}
+
+	parts := make([]int, numParts+1)
+	for i := 0; i < numParts; i++ {
+		parts[i] = int(binary.LittleEndian.Uint32(rec[44+4*i:]))
+	}
+	parts[numParts] = numPoints
+
+	var rings []shpRing
+	for i := 0; i < numParts; i++ {
+		if parts[i] < 0 || parts[i] > parts[i+1] || parts[i+1] > numPoints {
+			return nil, errors.New("invalid shapefile polygon parts")
+		}
+		ring := make(shpRing, 0, parts[i+1]-parts[i])
+		for j := parts[i]; j < parts[i+1]; j++ {
+			offset := pointsStart + 16*j
+			ring = append(ring, [2]float64{
+				math.Float64frombits(binary.LittleEndian.Uint64(rec[offset:])),
+				math.Float64frombits(binary.LittleEndian.Uint64(rec[offset+8:])),
+			})
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+// signedArea is negative for clockwise rings.
+func (r shpRing) signedArea() float64 {
+	var area float64
+	for i := 0; i < len(r)-1; i++ {
+		area += r[i][0]*r[i+1][1] - r[i+1][0]*r[i][1]
+	}
+	return area / 2
+}
+
+// contains returns whether p is inside the ring.
+func (r shpRing) contains(p [2]float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		if (r[i][1] > p[1]) != (r[j][1] > p[1]) &&
+			p[0] < (r[j][0]-r[i][0])*(p[1]-r[i][1])/(r[j][1]-r[i][1])+r[i][0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// assembleRings returns the polygons of the rings of a record, each with
+// the shell as first ring. Clockwise rings are shells and counter-clockwise
+// rings are holes of the smallest shell that contains them. Holes outside
+// of all shells are returned as shells.
+func assembleRings(rings []shpRing) [][]shpRing {
+	var polygons [][]shpRing
+	var holes []shpRing
+	for _, r := range rings {
+		if len(r) < 4 {
+			continue
+		}
+		if r.signedArea() < 0 {
+			polygons = append(polygons, []shpRing{r})
+		} else {
+			holes = append(holes, r)
+		}
+	}
+	for _, hole := range holes {
+		best := -1
+		for i, p := range polygons {
+			if !p[0].contains(hole[0]) {
+				continue
+			}
+			if best == -1 || math.Abs(p[0].signedArea()) < math.Abs(polygons[best][0].signedArea()) {
+				best = i
+			}
+		}
+		if best == -1 {
+			polygons = append(polygons, []shpRing{hole})
+		} else {
+			polygons[best] = append(polygons[best], hole)
+		}
+	}
+	return polygons
+}
+
+// prjSrid returns 4326 or 3857 for the WKT of a .prj file.
+func prjSrid(prj string) (int, error) {
+	prj = strings.TrimSpace(prj)
+	switch {
+	case strings.HasPrefix(prj, "GEOGCS") && strings.Contains(prj, "WGS"):
+		return 4326, nil
+	case strings.HasPrefix(prj, "PROJCS"):
+		for _, name := range []string{"Pseudo_Mercator", "Pseudo-Mercator", "Mercator_Auxiliary_Sphere", "Popular_Visualisation", "Web_Mercator"} {
+			if strings.Contains(prj, name) {
+				return 3857, nil
+			}
+		}
+	}
+	return 0, errors.New("unsupported projection in .prj, only EPSG:4326 and EPSG:3857 are supported")
+}
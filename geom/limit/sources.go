@@ -0,0 +1,307 @@
+package limit
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/olehz/imposm3/geom/geojson"
+	"github.com/olehz/imposm3/geom/geos"
+	"github.com/olehz/imposm3/proj"
+)
+
+// NewFromSources returns a Limiter for the union of all polygons of
+// sources. See NewFromSourcesWithBuffered.
+func NewFromSources(sources string) (*Limiter, error) {
+	return NewFromSourcesWithBuffered(sources, 0.0)
+}
+
+// NewFromSourcesWithBuffered returns a Limiter for the union of all
+// polygons of sources. sources is a comma separated list of WKT (.wkt),
+// WKB (.wkb, binary or hex, EWKB with SRID), shapefiles (.shp), GeoJSON
+// (all other files) and of bboxes (bbox=minx,miny,maxx,maxy in EPSG:4326).
+// Coordinates in EPSG:4326 are transformed to EPSG:3857.
+func NewFromSourcesWithBuffered(sources string, buffer float64) (*Limiter, error) {
+	parts, err := splitSources(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	g := geos.NewGeos()
+	defer g.Finish()
+
+	var geoms []*geos.Geom
+	for _, source := range parts {
+		polygons, err := readSource(g, source)
+		if err != nil {
+			return nil, fmt.Errorf("limitto %s: %s", source, err)
+		}
+		geoms = append(geoms, polygons...)
+	}
+	if len(geoms) == 0 {
+		return nil, errors.New("no polygons in limitto")
+	}
+	return newLimiter(g, geoms, buffer)
+}
+
+// splitSources splits the comma separated sources. bbox= sources take the
+// following three values.
+func splitSources(sources string) ([]string, error) {
+	parts := strings.Split(sources, ",")
+	var result []string
+	for i := 0; i < len(parts); i++ {
+		part := strings.TrimSpace(parts[i])
+		if part == "" {
+			continue
+		}
+		if strings.HasPrefix(part, "bbox=") {
+			if i+3 >= len(parts) {
+				return nil, errors.New("limitto bbox requires bbox=minx,miny,maxx,maxy: " + part)
+			}
+			part = strings.TrimSpace(strings.Join(parts[i:i+4], ","))
+			i += 3
+		}
+		result = append(result, part)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("missing limitto source")
+	}
+	return result, nil
+}
+
+func parseBbox(s string) ([]float64, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "bbox="), ",")
+	if len(parts) != 4 {
+		return nil, errors.New("invalid limitto bbox")
+	}
+	bbox := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, errors.New("invalid limitto bbox")
+		}
+		bbox[i] = v
+	}
+	return bbox, nil
+}
+
+// readSource returns all polygons of a single source in EPSG:3857.
+func readSource(g *geos.Geos, source string) ([]*geos.Geom, error) {
+	if strings.HasPrefix(source, "bbox=") {
+		bbox, err := parseBbox(source)
+		if err != nil {
+			return nil, err
+		}
+		geom, err := bboxGeom(g, bbox)
+		if err != nil {
+			return nil, err
+		}
+		return []*geos.Geom{geom}, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".wkt":
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		wkt, srid, err := splitEwkt(string(data))
+		if err != nil {
+			return nil, err
+		}
+		geom := g.FromWkt(wkt)
+		if geom == nil {
+			return nil, errors.New("invalid WKT")
+		}
+		return polygonGeoms(g, geom, srid)
+	case ".wkb":
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+		if decoded, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil {
+			data = decoded
+		}
+		geom := g.FromWkb(data)
+		if geom == nil {
+			return nil, errors.New("invalid WKB")
+		}
+		// SRID of EWKB, 0 for WKB
+		return polygonGeoms(g, geom, g.Srid(geom))
+	case ".shp":
+		geoms, srid, err := readShapefile(g, source)
+		if err != nil {
+			return nil, err
+		}
+		var result []*geos.Geom
+		for _, geom := range geoms {
+			polygons, err := polygonGeoms(g, geom, srid)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, polygons...)
+		}
+		return result, nil
+	}
+
+	// GeoJSON for .geojson, .json and all other files
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	features, err := geojson.ParseGeoJson(f)
+	if err != nil {
+		return nil, err
+	}
+	geoms := make([]*geos.Geom, 0, len(features))
+	for _, feature := range features {
+		geoms = append(geoms, feature.Geom)
+	}
+	return geoms, nil
+}
+
+// splitEwkt returns the WKT and the SRID of an EWKT (SRID=4326;POLYGON...)
+// or 0 for a WKT without SRID.
+func splitEwkt(ewkt string) (string, int, error) {
+	ewkt = strings.TrimSpace(ewkt)
+	if !strings.HasPrefix(strings.ToUpper(ewkt), "SRID=") {
+		return ewkt, 0, nil
+	}
+	idx := strings.Index(ewkt, ";")
+	if idx < 0 {
+		return "", 0, errors.New("invalid EWKT")
+	}
+	srid, err := strconv.Atoi(ewkt[len("SRID="):idx])
+	if err != nil {
+		return "", 0, errors.New("invalid EWKT SRID")
+	}
+	return ewkt[idx+1:], srid, nil
+}
+
+// polygonGeoms returns all polygons of a Polygon, MultiPolygon or
+// GeometryCollection of polygons in EPSG:3857. Polygons are transformed
+// if srid is 4326, or if srid is 0 and the polygons are within the bounds
+// of EPSG:4326. geom is destroyed.
+func polygonGeoms(g *geos.Geos, geom *geos.Geom, srid int) ([]*geos.Geom, error) {
+	defer g.Destroy(geom)
+
+	switch srid {
+	case 0:
+		b := geom.Bounds()
+		if b.MinX >= -180 && b.MaxX <= 180 && b.MinY >= -90 && b.MaxY <= 90 {
+			srid = 4326
+		}
+	case 4326:
+	case 3857, 900913:
+		srid = 0
+	default:
+		return nil, fmt.Errorf("unsupported SRID %d, only 4326 and 3857 are supported", srid)
+	}
+
+	var polygons []*geos.Geom
+	if err := collectPolygons(g, geom, &polygons); err != nil {
+		return nil, err
+	}
+
+	result := make([]*geos.Geom, 0, len(polygons))
+	for _, p := range polygons {
+		if srid == 4326 {
+			transformed, err := transformPolygon(g, p)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, transformed)
+		} else {
+			result = append(result, g.Clone(p))
+		}
+	}
+	return result, nil
+}
+
+// collectPolygons appends all polygons of geom (not cloned) to polygons.
+func collectPolygons(g *geos.Geos, geom *geos.Geom, polygons *[]*geos.Geom) error {
+	switch typ := g.Type(geom); typ {
+	case "Polygon":
+		*polygons = append(*polygons, geom)
+	case "MultiPolygon", "GeometryCollection":
+		for _, part := range g.Geoms(geom) {
+			if err := collectPolygons(g, part, polygons); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("unsupported geometry type " + typ + ", only Polygon and MultiPolygon are supported")
+	}
+	return nil
+}
+
+// transformPolygon returns a copy of the polygon with all coordinates
+// transformed from EPSG:4326 to EPSG:3857.
+func transformPolygon(g *geos.Geos, polygon *geos.Geom) (*geos.Geom, error) {
+	shell, err := transformRing(g, g.ExteriorRing(polygon))
+	if err != nil {
+		return nil, err
+	}
+	var holes []*geos.Geom
+	for i := int32(0); i < g.NumInteriorRings(polygon); i++ {
+		hole, err := transformRing(g, g.InteriorRing(polygon, i))
+		if err != nil {
+			g.Destroy(shell)
+			for _, h := range holes {
+				g.Destroy(h)
+			}
+			return nil, err
+		}
+		holes = append(holes, hole)
+	}
+	result := g.Polygon(shell, holes)
+	if result == nil {
+		return nil, errors.New("unable to create polygon")
+	}
+	return result, nil
+}
+
+func transformRing(g *geos.Geos, ring *geos.Geom) (*geos.Geom, error) {
+	if ring == nil {
+		return nil, errors.New("unable to get ring of polygon")
+	}
+	seq, err := g.CoordSeq(ring)
+	if err != nil {
+		return nil, err
+	}
+	size, err := seq.Size(g)
+	if err != nil {
+		return nil, err
+	}
+	points := make([][2]float64, size)
+	for i := uint32(0); i < size; i++ {
+		long, lat, err := seq.XY(g, i)
+		if err != nil {
+			return nil, err
+		}
+		x, y := proj.WgsToMerc(long, clampLat(lat))
+		points[i] = [2]float64{x, y}
+	}
+	return linearRing(g, points)
+}
+
+// linearRing returns a LinearRing of the points.
+func linearRing(g *geos.Geos, points [][2]float64) (*geos.Geom, error) {
+	coordSeq, err := g.CreateCoordSeq(uint32(len(points)), 2)
+	if err != nil {
+		return nil, err
+	}
+	// coordSeq inherited by LinearRing, no destroy
+	for i, p := range points {
+		if err := coordSeq.SetXY(g, uint32(i), p[0], p[1]); err != nil {
+			return nil, err
+		}
+	}
+	return coordSeq.AsLinearRing(g)
+}
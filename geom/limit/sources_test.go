@@ -0,0 +1,224 @@
+package limit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/olehz/imposm3/geom/geos"
+	"github.com/olehz/imposm3/proj"
+)
+
+func TestSplitSources(t *testing.T) {
+	sources, err := splitSources("a.geojson, bbox=8,53,9,54,b.wkt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sources, []string{"a.geojson", "bbox=8,53,9,54", "b.wkt"}) {
+		t.Fatal(sources)
+	}
+	bbox, err := parseBbox(sources[1])
+	if err != nil || !reflect.DeepEqual(bbox, []float64{8, 53, 9, 54}) {
+		t.Fatal(bbox, err)
+	}
+
+	for _, s := range []string{"", " , ", "a.geojson,bbox=8,53,9"} {
+		if _, err := splitSources(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+	if _, err := parseBbox("bbox=8,53,9,x"); err == nil {
+		t.Error("expected error for invalid bbox")
+	}
+}
+
+func TestSplitEwkt(t *testing.T) {
+	wkt, srid, err := splitEwkt(" SRID=4326;POLYGON((0 0, 1 0, 1 1, 0 0))\n")
+	if err != nil || srid != 4326 || wkt != "POLYGON((0 0, 1 0, 1 1, 0 0))" {
+		t.Fatal(wkt, srid, err)
+	}
+	wkt, srid, err = splitEwkt("POLYGON((0 0, 1 0, 1 1, 0 0))")
+	if err != nil || srid != 0 || wkt != "POLYGON((0 0, 1 0, 1 1, 0 0))" {
+		t.Fatal(wkt, srid, err)
+	}
+	if _, _, err := splitEwkt("SRID=x;POLYGON((0 0, 1 0, 1 1, 0 0))"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPrjSrid(t *testing.T) {
+	srid, err := prjSrid(`GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137,298.257223563]],PRIMEM["Greenwich",0],UNIT["Degree",0.017453292519943295]]`)
+	if err != nil || srid != 4326 {
+		t.Error(srid, err)
+	}
+	srid, err = prjSrid(`PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984"],PROJECTION["Mercator_Auxiliary_Sphere"]]`)
+	if err != nil || srid != 3857 {
+		t.Error(srid, err)
+	}
+	if _, err := prjSrid(`PROJCS["ETRS_1989_UTM_Zone_32N",GEOGCS["GCS_ETRS_1989"],PROJECTION["Transverse_Mercator"]]`); err == nil {
+		t.Error("expected error for UTM")
+	}
+}
+
+// shapefile returns a polygon shapefile with a record for each polygon.
+func shapefile(shapeType int32, polygons ...[]shpRing) []byte {
+	data := make([]byte, 100)
+	binary.BigEndian.PutUint32(data[0:], 9994)
+	binary.LittleEndian.PutUint32(data[28:], 1000)
+	binary.LittleEndian.PutUint32(data[32:], uint32(shapeType))
+	for i, rings := range polygons {
+		var points []([2]float64)
+		var parts []int
+		for _, r := range rings {
+			parts = append(parts, len(points))
+			points = append(points, r...)
+		}
+		rec := make([]byte, 44+4*len(parts)+16*len(points))
+		binary.LittleEndian.PutUint32(rec[0:], uint32(shapeType))
+		binary.LittleEndian.PutUint32(rec[36:], uint32(len(parts)))
+		binary.LittleEndian.PutUint32(rec[40:], uint32(len(points)))
+		for j, p := range parts {
+			binary.LittleEndian.PutUint32(rec[44+4*j:], uint32(p))
+		}
+		for j, p := range points {
+			offset := 44 + 4*len(parts) + 16*j
+			binary.LittleEndian.PutUint64(rec[offset:], math.Float64bits(p[0]))
+			binary.LittleEndian.PutUint64(rec[offset+8:], math.Float64bits(p[1]))
+		}
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header[0:], uint32(i+1))
+		binary.BigEndian.PutUint32(header[4:], uint32(len(rec)/2))
+		data = append(data, header...)
+		data = append(data, rec...)
+	}
+	binary.BigEndian.PutUint32(data[24:], uint32(len(data)/2))
+	return data
+}
+
+var (
+	// clockwise shells and counter-clockwise holes
+	shell1 = shpRing{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	hole1  = shpRing{{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}}
+	shell2 = shpRing{{20, 0}, {20, 10}, {30, 10}, {30, 0}, {20, 0}}
+	hole2  = shpRing{{22, 2}, {24, 2}, {24, 4}, {22, 4}, {22, 2}}
+)
+
+func TestParseShapefile(t *testing.T) {
+	data := shapefile(shpPolygonType, []shpRing{shell1, hole1}, []shpRing{shell2})
+	records, err := parseShapefile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatal(records)
+	}
+	if !reflect.DeepEqual(records[0], []shpRing{shell1, hole1}) {
+		t.Error(records[0])
+	}
+	if !reflect.DeepEqual(records[1], []shpRing{shell2}) {
+		t.Error(records[1])
+	}
+
+	if _, err := parseShapefile(shapefile(3)); err == nil || err.Error() != "unsupported shape type 3, only polygons are supported" {
+		t.Error(err)
+	}
+	if _, err := parseShapefile(data[:len(data)-8]); err == nil {
+		t.Error("expected error for truncated shapefile")
+	}
+}
+
+func TestAssembleRings(t *testing.T) {
+	polygons := assembleRings([]shpRing{hole2, shell1, shell2, hole1})
+	if len(polygons) != 2 {
+		t.Fatal(polygons)
+	}
+	if !reflect.DeepEqual(polygons[0], []shpRing{shell1, hole1}) {
+		t.Error(polygons[0])
+	}
+	if !reflect.DeepEqual(polygons[1], []shpRing{shell2, hole2}) {
+		t.Error(polygons[1])
+	}
+
+	// holes outside of all shells are shells
+	polygons = assembleRings([]shpRing{hole2})
+	if len(polygons) != 1 || !reflect.DeepEqual(polygons[0], []shpRing{hole2}) {
+		t.Error(polygons)
+	}
+}
+
+// ewkbPolygon returns a little endian EWKB polygon with a single ring.
+func ewkbPolygon(srid uint32, ring [][2]float64) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(1)
+	binary.Write(buf, binary.LittleEndian, []uint32{3 | 0x20000000, srid, 1, uint32(len(ring))})
+	binary.Write(buf, binary.LittleEndian, ring)
+	return buf.Bytes()
+}
+
+func TestNewFromSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imposm3_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// GeoJSON without known extension
+	data, err := ioutil.ReadFile("./hamburg_clip.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hamburg := filepath.Join(dir, "hamburg.limit")
+	if err := ioutil.WriteFile(hamburg, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wkt := filepath.Join(dir, "area.wkt")
+	if err := ioutil.WriteFile(wkt, []byte("SRID=3857;POLYGON((2000000 2000000, 2100000 2000000, 2100000 2100000, 2000000 2100000, 2000000 2000000))"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// EPSG:3857 coordinates within the bounds of EPSG:4326, only
+	// correct with the SRID of the EWKB
+	wkb := filepath.Join(dir, "area.wkb")
+	ring := [][2]float64{{150, 150}, {160, 150}, {160, 160}, {150, 160}, {150, 150}}
+	if err := ioutil.WriteFile(wkb, []byte(hex.EncodeToString(ewkbPolygon(3857, ring))), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	limiter, err := NewFromSources(hamburg + ",bbox=-10,-10,-9,-9," + wkt + "," + wkb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := geos.NewGeos()
+	defer g.Finish()
+	bboxX, bboxY := proj.WgsToMerc(-9.5, -9.5)
+	for _, test := range []struct {
+		x, y   float64
+		inside bool
+	}{
+		{1106543, 7082055, true}, // hamburg
+		{bboxX, bboxY, true},
+		{2050000, 2050000, true}, // wkt
+		{155, 155, true},         // wkb
+		{0, 0, false},
+		{-2050000, 2050000, false},
+	} {
+		result, err := limiter.Clip(g.FromWkt(fmt.Sprintf("POINT(%f %f)", test.x, test.y)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(result) == 1) != test.inside {
+			t.Errorf("POINT(%f %f): %v != %v", test.x, test.y, result, test.inside)
+		}
+	}
+
+	if _, err := NewFromSources(filepath.Join(dir, "missing.geojson")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
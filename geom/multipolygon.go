@@ -205,7 +205,7 @@ func BuildRelGeometry(rel *element.Relation, rings []*Ring, srid int) (*geos.Geo
 		}
 	}
 
-	wkb := g.AsEwkbHex(result)
+	wkb := g.AsEwkb(result)
 	if wkb == nil {
 		return nil, errors.New("unable to create WKB for relation")
 	}
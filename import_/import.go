@@ -4,8 +4,12 @@ Package import_ provides the import sub command initial imports.
 package import_
 
 import (
+	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/olehz/imposm3/cache"
 	"github.com/olehz/imposm3/config"
@@ -23,6 +27,28 @@ import (
 
 var log = logging.NewLogger("")
 
+// HeaderLimiter returns a limiter for the bboxes from the PBF headers that
+// are stored in the cache. Imports and diffs are limited to these bboxes,
+// unless -limitto is set or NONE. It returns nil if there are no bboxes.
+func HeaderLimiter(osmCache *cache.OSMCache) *limit.Limiter {
+	if config.BaseOptions.LimitTo != "" || config.BaseOptions.NoLimitTo {
+		return nil
+	}
+	bboxes, err := osmCache.HeaderBboxes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if bboxes == nil {
+		return nil
+	}
+	limiter, err := limit.NewFromBboxWithBuffered(bboxes, config.BaseOptions.LimitToCacheBuffer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("limiting to the bbox from the PBF header")
+	return limiter
+}
+
 func Import() {
 	if config.BaseOptions.Quiet {
 		logging.SetQuiet(true)
@@ -36,11 +62,15 @@ func Import() {
 		log.Fatal("-revertdeploy not compatible with -deployproduction/-removebackup")
 	}
 
+	if config.ImportOptions.DryRun && (config.ImportOptions.Write || config.ImportOptions.Read != "" || config.ImportOptions.Optimize) {
+		log.Fatal("-dryrun not compatible with -read/-write/-optimize")
+	}
+
 	var geometryLimiter *limit.Limiter
 	if (config.ImportOptions.Write || config.ImportOptions.Read != "") && config.BaseOptions.LimitTo != "" {
 		var err error
 		step := log.StartStep("Reading limitto geometries")
-		geometryLimiter, err = limit.NewFromGeoJsonWithBuffered(
+		geometryLimiter, err = limit.NewFromSourcesWithBuffered(
 			config.BaseOptions.LimitTo,
 			config.BaseOptions.LimitToCacheBuffer,
 		)
@@ -73,10 +103,19 @@ func Import() {
 			log.Fatal(err)
 		}
 		defer db.Close()
+
+		if config.ImportOptions.DryRun {
+			if db, ok := db.(database.Deployer); ok {
+				db.EnableDeployDryRun()
+			} else {
+				log.Fatal("database not deployable")
+			}
+		}
 	}
 
 	osmCache := cache.NewOSMCache(config.BaseOptions.CacheDir)
 
+	appendCache := false
 	if config.ImportOptions.Read != "" && osmCache.Exists() {
 		if config.ImportOptions.Overwritecache {
 			log.Printf("removing existing cache %s", config.BaseOptions.CacheDir)
@@ -86,6 +125,8 @@ func Import() {
 			}
 		} else if !config.ImportOptions.Appendcache {
 			log.Fatal("cache already exists use -appendcache or -overwritecache")
+		} else {
+			appendCache = true
 		}
 	}
 
@@ -101,29 +142,129 @@ func Import() {
 		}
 		progress := stats.NewStatsReporter()
 
-		pbfFile, err := pbf.Open(config.ImportOptions.Read)
+		filenames, err := readFilenames(config.ImportOptions.Read)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		if !config.ImportOptions.Appendcache {
-			// enable optimization if we don't append to existing cache
-			osmCache.Coords.SetLinearImport(true)
+		var pbfFiles []*pbf.Pbf
+		var bboxes [][]float64
+		history := false
+		locationsOnWays := true
+		for _, filename := range filenames {
+			pbfFile, err := pbf.Open(filename)
+			if err != nil {
+				log.Fatal(err)
+			}
+			pbfFiles = append(pbfFiles, pbfFile)
+			if !pbfFile.Header.LocationsOnWays {
+				locationsOnWays = false
+			}
+			if pbfFile.Header.Bbox != nil && !worldBbox(pbfFile.Header.Bbox) {
+				bboxes = append(bboxes, pbfFile.Header.Bbox)
+			}
+			if pbfFile.Header.History {
+				if config.ImportOptions.Timestamp == "" {
+					log.Fatalf("%s is a history file, use -timestamp to import a snapshot", filename)
+				}
+				history = true
+			}
+		}
+
+		var snapshot time.Time
+		if config.ImportOptions.Timestamp != "" {
+			if !history {
+				log.Fatal("-timestamp is only supported for history files")
+			}
+			snapshot, err = time.Parse(time.RFC3339, config.ImportOptions.Timestamp)
+			if err != nil {
+				log.Fatal("invalid -timestamp: ", err)
+			}
+		}
+
+		// skip duplicate elements from overlapping files and all
+		// other than the latest version from history files
+		var versions *cache.VersionsCache
+		if len(pbfFiles) > 1 || history {
+			versions = cache.NewVersionsCache(config.BaseOptions.CacheDir)
+			if err := versions.Remove(); err != nil {
+				log.Fatal(err)
+			}
+			if err := versions.Open(); err != nil {
+				log.Fatal(err)
+			}
+			versions.SetSnapshot(snapshot)
+		}
+
+		if len(bboxes) != len(pbfFiles) {
+			// at least one file is not limited to a bbox
+			bboxes = nil
+		}
+		if appendCache && bboxes != nil {
+			prevBboxes, err := osmCache.HeaderBboxes()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if prevBboxes == nil {
+				bboxes = nil
+			} else {
+				bboxes = append(prevBboxes, bboxes...)
+			}
+		}
+		if err := osmCache.SetHeaderBboxes(bboxes); err != nil {
+			log.Fatal(err)
+		}
+		if geometryLimiter == nil {
+			geometryLimiter = HeaderLimiter(osmCache)
+		}
+
+		if locationsOnWays && !history && !config.ImportOptions.Appendcache && !config.ImportOptions.Diff {
+			// ways contain all node locations, coords are not required.
+			// diff imports still need the coords for later updates
+			log.Printf("storing node locations of ways, skipping coords")
+			if err := osmCache.Ways.SetStoreLocations(true); err != nil {
+				log.Fatal(err)
+			}
+		} else if config.ImportOptions.Diff || !locationsOnWays || history {
+			// appended files need the coords of the existing cache
+			if err := osmCache.RequireCoords(); err != nil {
+				log.Fatal(err)
+			}
 		}
 
 		readLimiter := geometryLimiter
 		if config.BaseOptions.LimitToCacheBuffer == 0.0 {
 			readLimiter = nil
 		}
-		reader.ReadPbf(osmCache, progress, tagmapping,
-			pbfFile, readLimiter)
 
-		osmCache.Coords.SetLinearImport(false)
+		for i, pbfFile := range pbfFiles {
+			if i == 0 && !config.ImportOptions.Appendcache && !pbfFile.Header.History {
+				// enable optimization if we don't append to existing cache,
+				// only for the first file as the others can overlap
+				osmCache.Coords.SetLinearImport(true)
+			}
+
+			reader.ReadPbf(osmCache, progress, tagmapping,
+				pbfFile, readLimiter, versions)
+
+			osmCache.Coords.SetLinearImport(false)
+		}
+
+		if versions != nil {
+			if err := versions.Remove(); err != nil {
+				log.Print("unable to remove versions cache: ", err)
+			}
+		}
 		elementCounts = progress.Stop()
 		osmCache.Close()
 		log.StopStep(step)
 		if config.ImportOptions.Diff {
-			diffstate := state.FromPbf(pbfFile, config.ImportOptions.DiffStateBefore)
+			var diffstate *state.DiffState
+			if !snapshot.IsZero() {
+				diffstate = state.FromTimestamp(snapshot, config.ImportOptions.DiffStateBefore)
+			} else {
+				diffstate = state.FromPbfs(pbfFiles, config.ImportOptions.DiffStateBefore)
+			}
 			if diffstate != nil {
 				os.MkdirAll(config.BaseOptions.DiffDir, 0755)
 				err := diffstate.WriteToFile(path.Join(config.BaseOptions.DiffDir, "last.state.txt"))
@@ -169,6 +310,14 @@ func Import() {
 		if err != nil {
 			log.Fatal(err)
 		}
+		if diffCache != nil {
+			if err := osmCache.RequireCoords(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if geometryLimiter == nil && config.ImportOptions.Read == "" {
+			geometryLimiter = HeaderLimiter(osmCache)
+		}
 		if diffCache != nil {
 			diffCache.Coords.SetLinearImport(true)
 			diffCache.Ways.SetLinearImport(true)
@@ -287,3 +436,43 @@ func Import() {
 	log.StopStep(step)
 
 }
+
+// worldBbox returns whether bbox covers the whole world, e.g. for
+// planet files.
+func worldBbox(bbox []float64) bool {
+	return bbox[0] <= -180 && bbox[1] <= -85 && bbox[2] >= 180 && bbox[3] >= 85
+}
+
+// readFilenames returns all files from the comma separated list of file
+// names or glob patterns of the -read option.
+func readFilenames(read string) ([]string, error) {
+	var filenames []string
+	seen := make(map[string]bool)
+	for _, pattern := range strings.Split(read, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files found for %s", pattern)
+			}
+		}
+		for _, filename := range matches {
+			if !seen[filename] {
+				seen[filename] = true
+				filenames = append(filenames, filename)
+			}
+		}
+	}
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no files in -read %s", read)
+	}
+	return filenames, nil
+}
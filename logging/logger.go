@@ -1,8 +1,12 @@
 package logging
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,6 +21,51 @@ const (
 	DEBUG
 )
 
+var levelNames = map[Level]string{
+	FATAL:   "fatal",
+	ERROR:   "error",
+	WARNING: "warn",
+	INFO:    "info",
+	DEBUG:   "debug",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level for a level name (debug, info, warn,
+// error or fatal).
+func ParseLevel(name string) (Level, error) {
+	for level, n := range levelNames {
+		if n == strings.ToLower(name) {
+			return level, nil
+		}
+	}
+	if strings.ToLower(name) == "warning" {
+		return WARNING, nil
+	}
+	return INFO, fmt.Errorf("unknown log level %q", name)
+}
+
+// Format is the output format of the log records.
+type Format int
+
+const (
+	TEXT Format = iota
+	JSON
+)
+
+// ParseFormat returns the format for a format name (text or json).
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return TEXT, nil
+	case "json":
+		return JSON, nil
+	}
+	return TEXT, fmt.Errorf("unknown log format %q", name)
+}
+
 type Record struct {
 	Level     Level
 	Component string
@@ -57,6 +106,52 @@ func SetQuiet(quiet bool) {
 	defaultLogBroker.SetQuiet(quiet)
 }
 
+// SetLevel sets the maximum level of all printed records.
+func SetLevel(level Level) {
+	defaultLogBroker.SetLevel(level)
+}
+
+// SetFormat sets the output format. JSON prints each record and each
+// finished step as JSON line, without progress messages.
+func SetFormat(format Format) {
+	defaultLogBroker.SetFormat(format)
+}
+
+// Configure sets the log format and level by name. With the JSON format,
+// the output of the standard log package is printed as JSON records too.
+func Configure(format, level string) error {
+	f, err := ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	l, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	SetFormat(f)
+	SetLevel(l)
+	if f == JSON {
+		log.SetFlags(0)
+		log.SetOutput(&recordWriter{broker: &defaultLogBroker})
+	}
+	return nil
+}
+
+// recordWriter prints each written line as INFO record. The records are
+// printed before Write returns, as log.Fatal exits right after writing.
+type recordWriter struct {
+	broker *LogBroker
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.broker.mu.Lock()
+	defer w.broker.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.broker.printRecord(Record{INFO, "", line})
+	}
+	return len(p), nil
+}
+
 type Logger struct {
 	Component string
 }
@@ -129,10 +224,28 @@ type LogBroker struct {
 	wg           *sync.WaitGroup
 	newline      bool
 	lastProgress string
+	out          io.Writer
+	mu           sync.Mutex
+	level        Level
+	format       Format
 }
 
 func (l *LogBroker) SetQuiet(quiet bool) {
+	l.mu.Lock()
 	l.quiet = quiet
+	l.mu.Unlock()
+}
+
+func (l *LogBroker) SetLevel(level Level) {
+	l.mu.Lock()
+	l.level = level
+	l.mu.Unlock()
+}
+
+func (l *LogBroker) SetFormat(format Format) {
+	l.mu.Lock()
+	l.format = format
+	l.mu.Unlock()
 }
 
 func (l *LogBroker) loop() {
@@ -142,20 +255,34 @@ For:
 	for {
 		select {
 		case record := <-l.Records:
+			l.mu.Lock()
 			l.printRecord(record)
+			l.mu.Unlock()
 		case progress := <-l.Progress:
-			if !l.quiet {
+			l.mu.Lock()
+			if !l.quiet && l.format == TEXT {
 				l.printProgress(progress)
 			}
+			l.mu.Unlock()
 		case step := <-l.StepStart:
 			steps[step] = time.Now()
-			l.printProgress(step.Name)
+			l.mu.Lock()
+			if l.format == TEXT && l.level >= INFO {
+				l.printProgress(step.Name)
+			}
+			l.mu.Unlock()
 		case step := <-l.StepStop:
 			startTime := steps[step]
 			delete(steps, step)
 			duration := time.Since(startTime)
+			l.mu.Lock()
 			l.lastProgress = ""
-			l.printRecord(Record{INFO, step.Component, step.Name + " took: " + duration.String()})
+			if l.format == JSON {
+				l.printJSON(Record{INFO, step.Component, step.Name}, step.Name, duration)
+			} else {
+				l.printRecord(Record{INFO, step.Component, step.Name + " took: " + duration.String()})
+			}
+			l.mu.Unlock()
 		case <-l.quit:
 			break For
 		}
@@ -165,7 +292,9 @@ Flush:
 	for {
 		select {
 		case record := <-l.Records:
+			l.mu.Lock()
 			l.printRecord(record)
+			l.mu.Unlock()
 		default:
 			break Flush
 		}
@@ -174,53 +303,99 @@ Flush:
 }
 
 func (l *LogBroker) printPrefix() {
-	fmt.Print("[", time.Now().Format(time.Stamp), "] ")
+	fmt.Fprint(l.out, "[", time.Now().Format(time.Stamp), "] ")
 }
 func (l *LogBroker) printComponent(component string) {
 	if component != "" {
-		fmt.Print("[", component, "] ")
+		fmt.Fprint(l.out, "[", component, "] ")
 	}
 }
 
 func (l *LogBroker) printLevel(level Level) {
 	switch level {
 	case INFO:
-		fmt.Print("[INFO] ")
+		fmt.Fprint(l.out, "[INFO] ")
 	case WARNING:
-		fmt.Print("[WARN] ")
+		fmt.Fprint(l.out, "[WARN] ")
 	case ERROR:
-		fmt.Print("[ERR] ")
+		fmt.Fprint(l.out, "[ERR] ")
 	}
 }
 
 func (l *LogBroker) printRecord(record Record) {
+	if record.Level > l.level {
+		return
+	}
+	if l.format == JSON {
+		l.printJSON(record, "", 0)
+		return
+	}
 	if !l.newline {
-		fmt.Print(CLEARLINE)
+		fmt.Fprint(l.out, CLEARLINE)
 	}
 	l.printPrefix()
 	l.printLevel(record.Level)
 	l.printComponent(record.Component)
-	fmt.Println(record.Message)
+	fmt.Fprintln(l.out, record.Message)
 	l.newline = true
 	if l.lastProgress != "" {
 		l.printProgress(l.lastProgress)
 		l.newline = false
 	}
 }
+
+type jsonRecord struct {
+	Time      string  `json:"time"`
+	Level     string  `json:"level"`
+	Component string  `json:"component,omitempty"`
+	Message   string  `json:"message"`
+	Step      string  `json:"step,omitempty"`
+	Duration  float64 `json:"duration_seconds,omitempty"`
+}
+
+// printJSON prints the record as JSON line. step and duration are only
+// set for finished steps.
+func (l *LogBroker) printJSON(record Record, step string, duration time.Duration) {
+	if record.Level > l.level {
+		return
+	}
+	data, err := json.Marshal(jsonRecord{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Level:     record.Level.String(),
+		Component: record.Component,
+		Message:   record.Message,
+		Step:      step,
+		Duration:  duration.Seconds(),
+	})
+	if err != nil {
+		return
+	}
+	l.out.Write(append(data, '\n'))
+}
+
 func (l *LogBroker) printProgress(progress string) {
 	l.printPrefix()
-	fmt.Print(progress)
+	fmt.Fprint(l.out, progress)
 	if l.quiet {
-		fmt.Print("\n")
+		fmt.Fprint(l.out, "\n")
 		l.lastProgress = ""
 		l.newline = true
 	} else {
-		fmt.Print("\r")
+		fmt.Fprint(l.out, "\r")
 		l.lastProgress = progress
 		l.newline = false
 	}
 }
 
+// isTerminal returns whether f is a terminal (character device).
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
 func Shutdown() {
 	defaultLogBroker.quit <- true
 	defaultLogBroker.wg.Wait()
@@ -236,6 +411,10 @@ func init() {
 		StepStop:  make(chan Step),
 		quit:      make(chan bool),
 		wg:        &sync.WaitGroup{},
+		out:       os.Stdout,
+		level:     INFO,
+		// progress messages are only useful for terminals
+		quiet: !isTerminal(os.Stdout),
 	}
 	go defaultLogBroker.loop()
 }
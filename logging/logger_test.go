@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := &LogBroker{out: buf, level: INFO, format: JSON}
+
+	l.printRecord(Record{DEBUG, "diff", "hidden"})
+	l.printRecord(Record{WARNING, "diff", "missing state"})
+	l.printJSON(Record{INFO, "diff", "Writing"}, "Writing", 1500*time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	var rec jsonRecord
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Level != "warn" || rec.Component != "diff" || rec.Message != "missing state" || rec.Time == "" {
+		t.Errorf("unexpected record %v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Step != "Writing" || rec.Duration != 1.5 {
+		t.Errorf("unexpected step record %v", rec)
+	}
+}
+
+func TestRecordWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &recordWriter{broker: &LogBroker{out: buf, level: INFO, format: JSON}}
+
+	if n, err := w.Write([]byte("listening on :8080\nsecond line\n")); err != nil || n != 31 {
+		t.Fatalf("unexpected result %d %v", n, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	for i, expected := range []string{"listening on :8080", "second line"} {
+		var rec jsonRecord
+		if err := json.Unmarshal([]byte(lines[i]), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Level != "info" || rec.Message != expected {
+			t.Errorf("unexpected record %v", rec)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"debug": DEBUG, "INFO": INFO, "warn": WARNING, "warning": WARNING} {
+		if level, err := ParseLevel(name); err != nil || level != expected {
+			t.Errorf("unexpected level for %s: %v %v", name, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -3,7 +3,9 @@ package mapping
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/olehz/imposm3/element"
 )
@@ -25,13 +27,126 @@ type Table struct {
 	Fields       []*Field              `json:"columns"` // TODO rename Fields internaly to Columns
 	OldFields    []*Field              `json:"fields"`
 	Filters      *Filters              `json:"filters"`
+	Storage      *TableStorage         `json:"storage"`
+	Indexes      []*Index              `json:"indexes"`
+}
+
+// IndexMethod is the PostgreSQL index access method.
+type IndexMethod string
+
+const (
+	BTree IndexMethod = "btree"
+	Gist  IndexMethod = "gist"
+	Gin   IndexMethod = "gin"
+	Brin  IndexMethod = "brin"
+)
+
+// Index is an additional index of a table. The index is either on
+// Columns or on an SQL Expression. Indexes with expressions need a Name.
+type Index struct {
+	Name       string      `json:"name"`
+	Columns    []string    `json:"columns"`
+	Expression string      `json:"expression"`
+	Method     IndexMethod `json:"method"`
+	// Where creates a partial index for all rows that match the condition.
+	Where string `json:"where"`
+}
+
+// TableStorage configures how a table is stored in the database.
+type TableStorage struct {
+	Tablespace string `json:"tablespace"`
+	// Unlogged creates the table as UNLOGGED during the import. The table
+	// is changed to LOGGED after the import.
+	Unlogged   bool `json:"unlogged"`
+	Fillfactor int  `json:"fillfactor"`
+	// NoSerialId skips the additional id SERIAL PRIMARY KEY column.
+	NoSerialId bool            `json:"no_serial_id"`
+	Partition  *TablePartition `json:"partition"`
+}
+
+// PartitionMethod is the scheme used to split a table into partitions.
+type PartitionMethod string
+
+const (
+	// PartitionList creates a partition for each entry in Values and a
+	// default partition for all other values of Column.
+	PartitionList PartitionMethod = "list"
+	// PartitionHash creates Partitions partitions by the hash of Column.
+	PartitionHash PartitionMethod = "hash"
+	// PartitionGeohash creates a partition for each first character of
+	// the geohash of the geometry center.
+	PartitionGeohash PartitionMethod = "geohash"
+)
+
+type TablePartition struct {
+	Method PartitionMethod `json:"method"`
+	Column string          `json:"column"`
+	// Values are the column values of each list partition, by the
+	// suffix of the partition name.
+	Values     map[string][]string `json:"values"`
+	Partitions int                 `json:"partitions"`
 }
 
 type GeneralizedTable struct {
 	Name            string
-	SourceTableName string  `json:"source"`
-	Tolerance       float64 `json:"tolerance"`
-	SqlFilter       string  `json:"sql_filter"`
+	SourceTableName string           `json:"source"`
+	Tolerance       float64          `json:"tolerance"`
+	SqlFilter       string           `json:"sql_filter"`
+	Method          GeneralizeMethod `json:"method"`
+	// MinArea skips all source geometries with a smaller area.
+	MinArea float64 `json:"min_area"`
+	// MergeBy dissolves all adjacent geometries with the same values
+	// in these columns.
+	MergeBy []string `json:"merge_by"`
+	// Compute is either "sql" (default) to generalize all tables after
+	// the import, or "write" to generalize each geometry with GEOS during
+	// the write of the source table.
+	Compute string `json:"compute"`
+	// Type is either "generalize" (default) or "aggregate". Aggregated
+	// tables dissolve all geometries with the same GroupBy values
+	// within each cell of a grid with GridSize.
+	Type     string   `json:"type"`
+	GroupBy  []string `json:"group_by"`
+	GridSize float64  `json:"grid_size"`
+	// Indexes are created in addition to the indexes of the source table.
+	Indexes []*Index `json:"indexes"`
+}
+
+const (
+	GeneralizeTable = "generalize"
+	AggregateTable  = "aggregate"
+)
+
+// GeneralizeMethod is the algorithm used to simplify geometries
+// of generalized tables.
+type GeneralizeMethod string
+
+const (
+	// Simplify uses Douglas-Peucker, but avoids invalid geometries
+	// (ST_SimplifyPreserveTopology).
+	Simplify GeneralizeMethod = "simplify"
+	// Visvalingam uses the Visvalingam-Whyatt algorithm (ST_SimplifyVW),
+	// the tolerance is an area.
+	Visvalingam GeneralizeMethod = "visvalingam"
+	// SnapToGrid snaps all coordinates to a grid with tolerance as
+	// the cell size.
+	SnapToGrid GeneralizeMethod = "snap_to_grid"
+)
+
+const (
+	ComputeSQL   = "sql"
+	ComputeWrite = "write"
+)
+
+// InWrite returns true if the table is generalized during the write
+// of the source table.
+func (t *GeneralizedTable) InWrite() bool {
+	return t.Compute == ComputeWrite
+}
+
+// Aggregate returns true if the table dissolves the source geometries.
+func (t *GeneralizedTable) Aggregate() bool {
+	return t.Type == AggregateTable
 }
 
 type Filters struct {
@@ -150,9 +265,195 @@ func (m *Mapping) prepare() error {
 			t.Fields = t.OldFields
 		}
 	}
+	for _, t := range m.Tables {
+		if err := t.checkStorage(); err != nil {
+			return err
+		}
+		var columns []string
+		for _, f := range t.Fields {
+			columns = append(columns, f.Name)
+		}
+		if err := checkIndexes(t.Name, t.Indexes, columns); err != nil {
+			return err
+		}
+	}
 
 	for name, t := range m.GeneralizedTables {
 		t.Name = name
+		if t.Method == "" {
+			t.Method = Simplify
+		}
+		if t.Compute == "" {
+			t.Compute = ComputeSQL
+		}
+		if t.Type == "" {
+			t.Type = GeneralizeTable
+		}
+	}
+	for _, t := range m.GeneralizedTables {
+		if err := m.checkGeneralizedTable(t); err != nil {
+			return err
+		}
+		if err := checkIndexes(t.Name, t.Indexes, m.generalizedColumns(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkIndexes(tableName string, indexes []*Index, columns []string) error {
+	names := make(map[string]bool)
+	for _, idx := range indexes {
+		if idx.Method == "" {
+			idx.Method = BTree
+		}
+		switch idx.Method {
+		case BTree, Gist, Gin, Brin:
+		default:
+			return fmt.Errorf("unknown index method '%s' for table '%s'", idx.Method, tableName)
+		}
+		if (len(idx.Columns) == 0) == (idx.Expression == "") {
+			return fmt.Errorf("index of table '%s' requires either columns or expression", tableName)
+		}
+		if idx.Expression != "" && idx.Name == "" {
+			return fmt.Errorf("index with expression of table '%s' requires a name", tableName)
+		}
+	NextColumn:
+		for _, col := range idx.Columns {
+			for _, c := range columns {
+				if c == col {
+					continue NextColumn
+				}
+			}
+			return fmt.Errorf("index column '%s' not in table '%s'", col, tableName)
+		}
+		if names[idx.IndexName()] {
+			return fmt.Errorf("duplicate index '%s' for table '%s'", idx.IndexName(), tableName)
+		}
+		names[idx.IndexName()] = true
+	}
+	return nil
+}
+
+// IndexName returns the name of the index without the table name.
+func (idx *Index) IndexName() string {
+	if idx.Name != "" {
+		return idx.Name
+	}
+	return strings.Join(idx.Columns, "_") + "_idx"
+}
+
+// generalizedColumns returns the column names of a generalized table.
+func (m *Mapping) generalizedColumns(t *GeneralizedTable) []string {
+	if t.Aggregate() {
+		columns := append([]string{}, t.GroupBy...)
+		columns = append(columns, "cell_x", "cell_y")
+		if source, ok := m.Tables[t.SourceTableName]; ok {
+			for _, f := range source.Fields {
+				if f.Type == "geometry" || f.Type == "validated_geometry" {
+					columns = append(columns, f.Name)
+				}
+			}
+		}
+		return columns
+	}
+	// find the original source of generalized sources, stop on cycles
+	for i := 0; i <= len(m.GeneralizedTables); i++ {
+		if source, ok := m.Tables[t.SourceTableName]; ok {
+			var columns []string
+			for _, f := range source.Fields {
+				columns = append(columns, f.Name)
+			}
+			return columns
+		}
+		source, ok := m.GeneralizedTables[t.SourceTableName]
+		if !ok {
+			break
+		}
+		t = source
+	}
+	return nil
+}
+
+func (t *Table) checkStorage() error {
+	if t.Storage == nil {
+		return nil
+	}
+	if t.Storage.Fillfactor != 0 && (t.Storage.Fillfactor < 10 || t.Storage.Fillfactor > 100) {
+		return fmt.Errorf("fillfactor of table '%s' not between 10 and 100", t.Name)
+	}
+	p := t.Storage.Partition
+	if p == nil {
+		return nil
+	}
+	switch p.Method {
+	case PartitionList:
+		if len(p.Values) == 0 {
+			return fmt.Errorf("list partition of table '%s' without values", t.Name)
+		}
+	case PartitionHash:
+		if p.Partitions < 1 {
+			return fmt.Errorf("hash partition of table '%s' requires partitions", t.Name)
+		}
+	case PartitionGeohash:
+		for _, f := range t.Fields {
+			if f.Type == "geometry" || f.Type == "validated_geometry" {
+				return nil
+			}
+		}
+		return fmt.Errorf("geohash partition of table '%s' requires a geometry column", t.Name)
+	default:
+		return fmt.Errorf("unknown partition method '%s' for table '%s'", p.Method, t.Name)
+	}
+	for _, f := range t.Fields {
+		if f.Name == p.Column {
+			return nil
+		}
+	}
+	return fmt.Errorf("partition column '%s' not in table '%s'", p.Column, t.Name)
+}
+
+func (m *Mapping) checkGeneralizedTable(t *GeneralizedTable) error {
+	switch t.Method {
+	case Simplify, Visvalingam, SnapToGrid:
+	default:
+		return fmt.Errorf("unknown method '%s' for generalized table '%s'", t.Method, t.Name)
+	}
+	if source, ok := m.GeneralizedTables[t.SourceTableName]; ok && source.Aggregate() {
+		return fmt.Errorf("source '%s' of generalized table '%s' is an aggregated table",
+			t.SourceTableName, t.Name)
+	}
+
+	switch t.Type {
+	case GeneralizeTable:
+	case AggregateTable:
+		if err := m.checkAggregateTable(t); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown type '%s' for generalized table '%s'", t.Type, t.Name)
+	}
+
+	switch t.Compute {
+	case ComputeSQL:
+		return nil
+	case ComputeWrite:
+	default:
+		return fmt.Errorf("unknown compute '%s' for generalized table '%s'", t.Compute, t.Name)
+	}
+
+	if t.Method == Visvalingam {
+		return fmt.Errorf("method '%s' of generalized table '%s' requires compute 'sql'", t.Method, t.Name)
+	}
+	if t.SqlFilter != "" {
+		return fmt.Errorf("sql_filter of generalized table '%s' requires compute 'sql'", t.Name)
+	}
+	if len(t.MergeBy) > 0 {
+		return fmt.Errorf("merge_by of generalized table '%s' requires compute 'sql'", t.Name)
+	}
+	if source, ok := m.GeneralizedTables[t.SourceTableName]; ok && !source.InWrite() {
+		return fmt.Errorf("source '%s' of generalized table '%s' is not computed during write",
+			t.SourceTableName, t.Name)
 	}
 	return nil
 }
@@ -267,3 +568,31 @@ func (m *Mapping) ElementFilters() map[string][]ElementFilter {
 	}
 	return result
 }
+
+func (m *Mapping) checkAggregateTable(t *GeneralizedTable) error {
+	if t.GridSize <= 0 {
+		return fmt.Errorf("aggregated table '%s' requires grid_size", t.Name)
+	}
+	if t.Compute != ComputeSQL {
+		return fmt.Errorf("aggregated table '%s' requires compute 'sql'", t.Name)
+	}
+	if len(t.MergeBy) > 0 {
+		return fmt.Errorf("aggregated table '%s' does not support merge_by, use group_by", t.Name)
+	}
+	source, ok := m.Tables[t.SourceTableName]
+	if !ok {
+		return fmt.Errorf("source '%s' of aggregated table '%s' is not a table",
+			t.SourceTableName, t.Name)
+	}
+NextColumn:
+	for _, name := range t.GroupBy {
+		for _, field := range source.Fields {
+			if field.Name == name {
+				continue NextColumn
+			}
+		}
+		return fmt.Errorf("group_by column '%s' of aggregated table '%s' not in source '%s'",
+			name, t.Name, t.SourceTableName)
+	}
+	return nil
+}
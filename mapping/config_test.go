@@ -0,0 +1,176 @@
+package mapping
+
+import (
+	"testing"
+)
+
+func TestGeneralizedTableDefaults(t *testing.T) {
+	m := Mapping{
+		Tables: Tables{"roads": &Table{}},
+		GeneralizedTables: GeneralizedTables{
+			"roads_gen": &GeneralizedTable{SourceTableName: "roads", Tolerance: 10},
+		},
+	}
+	if err := m.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	gen := m.GeneralizedTables["roads_gen"]
+	if gen.Method != Simplify || gen.Compute != ComputeSQL || gen.InWrite() {
+		t.Errorf("unexpected defaults %#v", gen)
+	}
+}
+
+func TestGeneralizedTableCheck(t *testing.T) {
+	for _, test := range []struct {
+		gen   GeneralizedTable
+		valid bool
+	}{
+		{GeneralizedTable{Method: SnapToGrid}, true},
+		{GeneralizedTable{Method: Visvalingam, MergeBy: []string{"type"}}, true},
+		{GeneralizedTable{Method: "unknown"}, false},
+		{GeneralizedTable{Compute: "later"}, false},
+		{GeneralizedTable{Compute: ComputeWrite, MinArea: 100}, true},
+		{GeneralizedTable{Compute: ComputeWrite, Method: Visvalingam}, false},
+		{GeneralizedTable{Compute: ComputeWrite, SqlFilter: "type = 'forest'"}, false},
+		{GeneralizedTable{Compute: ComputeWrite, MergeBy: []string{"type"}}, false},
+		{GeneralizedTable{Compute: ComputeWrite, SourceTableName: "landuse_sql"}, false},
+		{GeneralizedTable{Compute: ComputeWrite, SourceTableName: "landuse_write"}, true},
+	} {
+		gen := test.gen
+		if gen.SourceTableName == "" {
+			gen.SourceTableName = "landuse"
+		}
+		m := Mapping{
+			Tables: Tables{"landuse": &Table{}},
+			GeneralizedTables: GeneralizedTables{
+				"landuse_sql":   &GeneralizedTable{SourceTableName: "landuse"},
+				"landuse_write": &GeneralizedTable{SourceTableName: "landuse", Compute: ComputeWrite},
+				"gen":           &gen,
+			},
+		}
+		err := m.prepare()
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %#v: %s", test.gen, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected error for %#v", test.gen)
+		}
+	}
+}
+
+func TestAggregateTableCheck(t *testing.T) {
+	for _, test := range []struct {
+		gen   GeneralizedTable
+		valid bool
+	}{
+		{GeneralizedTable{GridSize: 1000, GroupBy: []string{"type"}}, true},
+		{GeneralizedTable{GridSize: 1000}, true},
+		{GeneralizedTable{GroupBy: []string{"type"}}, false},
+		{GeneralizedTable{GridSize: 1000, GroupBy: []string{"name"}}, false},
+		{GeneralizedTable{GridSize: 1000, Compute: ComputeWrite}, false},
+		{GeneralizedTable{GridSize: 1000, MergeBy: []string{"type"}}, false},
+		{GeneralizedTable{GridSize: 1000, SourceTableName: "landuse_gen"}, false},
+	} {
+		gen := test.gen
+		gen.Type = AggregateTable
+		if gen.SourceTableName == "" {
+			gen.SourceTableName = "landuse"
+		}
+		m := Mapping{
+			Tables: Tables{"landuse": &Table{
+				Fields: []*Field{{Name: "type", Type: "mapping_value"}},
+			}},
+			GeneralizedTables: GeneralizedTables{
+				"landuse_gen": &GeneralizedTable{SourceTableName: "landuse"},
+				"agg":         &gen,
+			},
+		}
+		err := m.prepare()
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %#v: %s", test.gen, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected error for %#v", test.gen)
+		}
+	}
+
+	// generalized tables can not use aggregated tables as source
+	m := Mapping{
+		Tables: Tables{"landuse": &Table{}},
+		GeneralizedTables: GeneralizedTables{
+			"agg":     &GeneralizedTable{SourceTableName: "landuse", Type: AggregateTable, GridSize: 100},
+			"agg_gen": &GeneralizedTable{SourceTableName: "agg"},
+		},
+	}
+	if err := m.prepare(); err == nil {
+		t.Error("expected error for aggregated source")
+	}
+}
+
+func TestTableStorageCheck(t *testing.T) {
+	for _, test := range []struct {
+		storage TableStorage
+		valid   bool
+	}{
+		{TableStorage{Tablespace: "fast", Unlogged: true, Fillfactor: 90, NoSerialId: true}, true},
+		{TableStorage{Fillfactor: 5}, false},
+		{TableStorage{Partition: &TablePartition{Method: PartitionGeohash}}, true},
+		{TableStorage{Partition: &TablePartition{Method: PartitionHash, Column: "osm_id", Partitions: 8}}, true},
+		{TableStorage{Partition: &TablePartition{Method: PartitionHash, Column: "osm_id"}}, false},
+		{TableStorage{Partition: &TablePartition{Method: PartitionHash, Column: "name", Partitions: 8}}, false},
+		{TableStorage{Partition: &TablePartition{Method: PartitionList, Column: "type",
+			Values: map[string][]string{"major": {"motorway", "trunk"}}}}, true},
+		{TableStorage{Partition: &TablePartition{Method: PartitionList, Column: "type"}}, false},
+		{TableStorage{Partition: &TablePartition{Method: "range", Column: "type"}}, false},
+	} {
+		storage := test.storage
+		m := Mapping{
+			Tables: Tables{"roads": &Table{
+				Fields: []*Field{
+					{Name: "osm_id", Type: "id"},
+					{Name: "geometry", Type: "geometry"},
+					{Name: "type", Type: "mapping_value"},
+				},
+				Storage: &storage,
+			}},
+		}
+		err := m.prepare()
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %#v: %s", test.storage, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected error for %#v", test.storage)
+		}
+	}
+}
+
+func TestIndexCheck(t *testing.T) {
+	for _, test := range []struct {
+		idx   Index
+		valid bool
+	}{
+		{Index{Columns: []string{"type"}}, true},
+		{Index{Columns: []string{"type"}, Method: Brin, Where: "type = 'forest'"}, true},
+		{Index{Name: "lower_name", Expression: "lower(name)", Method: Gin}, true},
+		{Index{Expression: "lower(name)"}, false},
+		{Index{}, false},
+		{Index{Name: "both", Columns: []string{"type"}, Expression: "lower(name)"}, false},
+		{Index{Columns: []string{"unknown"}}, false},
+		{Index{Columns: []string{"type"}, Method: "hash"}, false},
+	} {
+		idx := test.idx
+		m := Mapping{
+			Tables: Tables{"landuse": &Table{
+				Fields:  []*Field{{Name: "type", Type: "mapping_value"}},
+				Indexes: []*Index{&idx},
+			}},
+		}
+		err := m.prepare()
+		if test.valid && err != nil {
+			t.Errorf("unexpected error for %#v: %s", test.idx, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected error for %#v", test.idx)
+		}
+	}
+}
@@ -164,7 +164,7 @@ func Direction(val string, elem *element.OSMElem, match Match) interface{} {
 }
 
 func Geometry(val string, elem *element.OSMElem, match Match) interface{} {
-	return string(elem.Geom.Wkb)
+	return elem.Geom.Wkb
 }
 
 func PseudoArea(val string, elem *element.OSMElem, match Match) interface{} {
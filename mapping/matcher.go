@@ -55,6 +55,14 @@ type RelWayMatcher interface {
 	RelationMatcher
 }
 
+// RejectedMatcher is implemented by matchers that report the matches
+// that are rejected by the filters of a table.
+type RejectedMatcher interface {
+	// RejectedMatches returns all matches of tags that are rejected by
+	// the filters of their table.
+	RejectedMatches(tags *element.Tags) []Match
+}
+
 type tagMatcher struct {
 	mappings   TagTables
 	tables     map[string]*TableFields
@@ -94,6 +102,18 @@ func (tm *tagMatcher) MatchRelation(rel *element.Relation) []Match {
 }
 
 func (tm *tagMatcher) match(tags *element.Tags) []Match {
+	matches, _ := tm.matchTags(tags)
+	return matches
+}
+
+func (tm *tagMatcher) RejectedMatches(tags *element.Tags) []Match {
+	_, rejected := tm.matchTags(tags)
+	return rejected
+}
+
+// matchTags returns all matches and all matches that are rejected by the
+// filters of their table.
+func (tm *tagMatcher) matchTags(tags *element.Tags) (matches []Match, rejected []Match) {
 	tables := make(map[DestTable]Match)
 
 	for k, v := range *tags {
@@ -111,7 +131,6 @@ func (tm *tagMatcher) match(tags *element.Tags) []Match {
 			}
 		}
 	}
-	var matches []Match
 	for t, match := range tables {
 		filters, ok := tm.filters[t.Name]
 		filteredOut := false
@@ -125,9 +144,11 @@ func (tm *tagMatcher) match(tags *element.Tags) []Match {
 		}
 		if !filteredOut {
 			matches = append(matches, match)
+		} else {
+			rejected = append(rejected, match)
 		}
 	}
-	return matches
+	return matches, rejected
 }
 
 // SelectRelationPolygons returns a slice of all members that are already
@@ -22,7 +22,7 @@ func BenchmarkTagMatch(b *testing.B) {
 
 func makeMember(id int64, tags element.Tags) element.Member {
 	way := &element.Way{
-		element.OSMElem{id, tags, nil},
+		element.OSMElem{Id: id, Tags: tags},
 		[]int64{0, 1, 2, 0}, // fake closed way, req. for SelectRelationPolygons
 		nil}
 	return element.Member{Id: id, Type: element.WAY, Role: "outer", Way: way}
@@ -127,3 +127,32 @@ func TestSelectRelationPolygonsMultipleTags(t *testing.T) {
 		t.Fatal(filtered)
 	}
 }
+
+func TestRejectedMatches(t *testing.T) {
+	mapping, err := NewMapping("test_mapping.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matcher := mapping.LineStringMatcher()
+	rm, ok := matcher.(RejectedMatcher)
+	if !ok {
+		t.Fatal("matcher does not implement RejectedMatcher")
+	}
+
+	tags := element.Tags{"highway": "primary"}
+	if m := matcher.MatchWay(&element.Way{OSMElem: element.OSMElem{Tags: tags}}); len(m) != 1 {
+		t.Fatal(m)
+	}
+	if m := rm.RejectedMatches(&tags); len(m) != 0 {
+		t.Fatal(m)
+	}
+
+	tags = element.Tags{"highway": "primary", "area": "yes"}
+	if m := matcher.MatchWay(&element.Way{OSMElem: element.OSMElem{Tags: tags}}); len(m) != 0 {
+		t.Fatal(m)
+	}
+	m := rm.RejectedMatches(&tags)
+	if len(m) != 1 || m[0].Table.Name != "roads" || m[0].Value != "primary" {
+		t.Fatal(m)
+	}
+}
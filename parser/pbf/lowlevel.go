@@ -27,7 +27,7 @@ func newParserError(message string, err error) *parserError {
 	return &parserError{message, err}
 }
 
-var supportedFeatured = map[string]bool{"OsmSchema-V0.6": true, "DenseNodes": true}
+var supportedFeatured = map[string]bool{"OsmSchema-V0.6": true, "DenseNodes": true, "HistoricalInformation": true, "LocationsOnWays": true}
 
 func readBlobData(pos Block) ([]byte, error) {
 	file, err := os.Open(pos.filename)
@@ -96,6 +96,28 @@ func readAndParseHeaderBlock(pos Block) (*pbfHeader, error) {
 	}
 
 	result := &pbfHeader{}
+	for _, feature := range header.RequiredFeatures {
+		if feature == "HistoricalInformation" {
+			result.History = true
+		}
+		if feature == "LocationsOnWays" {
+			result.LocationsOnWays = true
+		}
+	}
+	for _, feature := range header.OptionalFeatures {
+		if feature == "LocationsOnWays" {
+			result.LocationsOnWays = true
+		}
+	}
+	if bbox := header.GetBbox(); bbox != nil {
+		// bbox values are in nanodegrees
+		result.Bbox = []float64{
+			float64(bbox.GetLeft()) * 1e-9,
+			float64(bbox.GetBottom()) * 1e-9,
+			float64(bbox.GetRight()) * 1e-9,
+			float64(bbox.GetTop()) * 1e-9,
+		}
+	}
 	timestamp := header.GetOsmosisReplicationTimestamp()
 	result.Time = time.Unix(timestamp, 0 /* nanoseconds */)
 	return result, nil
@@ -110,6 +132,14 @@ type Pbf struct {
 
 type pbfHeader struct {
 	Time time.Time
+	// History is true for files with multiple versions of each element.
+	History bool
+	// LocationsOnWays is true for files with the node locations
+	// embedded in the ways.
+	LocationsOnWays bool
+	// Bbox is the minx, miny, maxx, maxy of the file in EPSG:4326,
+	// or nil if the header contains no bbox.
+	Bbox []float64
 }
 
 func Open(filename string) (f *Pbf, err error) {
@@ -389,6 +389,8 @@ type Way struct {
 	Vals             []uint32 `protobuf:"varint,3,rep,packed,name=vals" json:"vals,omitempty"`
 	Info             *Info    `protobuf:"bytes,4,opt,name=info" json:"info,omitempty"`
 	Refs             []int64  `protobuf:"zigzag64,8,rep,packed,name=refs" json:"refs,omitempty"`
+	Lat              []int64  `protobuf:"zigzag64,9,rep,packed,name=lat" json:"lat,omitempty"`
+	Lon              []int64  `protobuf:"zigzag64,10,rep,packed,name=lon" json:"lon,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
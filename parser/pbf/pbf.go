@@ -4,6 +4,7 @@ import (
 	"github.com/olehz/imposm3/element"
 	"github.com/olehz/imposm3/parser/pbf/osmpbf"
 	"log"
+	"time"
 )
 
 type Block struct {
@@ -25,7 +26,8 @@ func intToCoord(coord uint32) float64 {
 func readDenseNodes(
 	dense *osmpbf.DenseNodes,
 	block *osmpbf.PrimitiveBlock,
-	stringtable stringTable) (coords []element.Node, nodes []element.Node) {
+	stringtable stringTable,
+	withMetadata bool) (coords []element.Node, nodes []element.Node) {
 
 	var lastId int64
 	var lastLon, lastLat int64
@@ -37,6 +39,11 @@ func readDenseNodes(
 	coordScale := 0.000000001
 	lastKeyValPos := 0
 
+	var metadata []element.Metadata
+	if withMetadata {
+		metadata = readDenseMetadata(dense.GetDenseinfo(), block, len(coords))
+	}
+
 	for i := range coords {
 		lastId += dense.Id[i]
 		lastLon += dense.Lon[i]
@@ -44,12 +51,19 @@ func readDenseNodes(
 		coords[i].Id = lastId
 		coords[i].Long = (coordScale * float64(lonOffset+(granularity*lastLon)))
 		coords[i].Lat = (coordScale * float64(latOffset+(granularity*lastLat)))
+		if metadata != nil {
+			coords[i].Metadata = &metadata[i]
+		}
 		if stringtable != nil && len(dense.KeysVals) > 0 {
 			if dense.KeysVals[lastKeyValPos] != 0 {
 				tags := parseDenseNodeTags(stringtable, &dense.KeysVals, &lastKeyValPos)
 				if tags != nil {
 					if _, ok := tags["created_by"]; ok && len(tags) == 1 {
 						// don't add nodes with only created_by tag to nodes cache
+					} else if withMetadata {
+						// tagged nodes are only returned as coords, to
+						// handle all versions of a node at once
+						coords[i].Tags = tags
 					} else {
 						nd := coords[i]
 						nd.Tags = tags
@@ -65,6 +79,53 @@ func readDenseNodes(
 	return coords, nodes
 }
 
+// readDenseMetadata returns the metadata for n dense nodes. All nodes
+// are visible and the version and timestamp are empty if the file does
+// not contain the DenseInfo.
+func readDenseMetadata(info *osmpbf.DenseInfo, block *osmpbf.PrimitiveBlock, n int) []element.Metadata {
+	metadata := make([]element.Metadata, n)
+	for i := range metadata {
+		metadata[i].Visible = true
+	}
+	if info == nil {
+		return metadata
+	}
+	dateGranularity := int64(block.GetDateGranularity())
+	var lastTimestamp int64
+	for i := range metadata {
+		if i < len(info.Version) {
+			metadata[i].Version = info.Version[i]
+		}
+		if i < len(info.Timestamp) {
+			// timestamps are delta encoded
+			lastTimestamp += info.Timestamp[i]
+			metadata[i].Timestamp = timestampToTime(lastTimestamp, dateGranularity)
+		}
+		if i < len(info.Visible) {
+			metadata[i].Visible = info.Visible[i]
+		}
+	}
+	return metadata
+}
+
+// readMetadata returns the metadata from the info of a single element.
+func readMetadata(info *osmpbf.Info, block *osmpbf.PrimitiveBlock) *element.Metadata {
+	metadata := &element.Metadata{
+		Version: info.GetVersion(),
+		Visible: info == nil || info.Visible == nil || info.GetVisible(),
+	}
+	if info.GetTimestamp() != 0 {
+		metadata.Timestamp = timestampToTime(info.GetTimestamp(), int64(block.GetDateGranularity()))
+	}
+	return metadata
+}
+
+// timestampToTime returns the time of a timestamp in units of
+// dateGranularity milliseconds.
+func timestampToTime(timestamp, dateGranularity int64) time.Time {
+	return time.Unix(0, timestamp*dateGranularity*int64(time.Millisecond)).UTC()
+}
+
 func parseDenseNodeTags(stringtable stringTable, keysVals *[]int32, pos *int) map[string]string {
 	// make map later if needed
 	var result map[string]string
@@ -102,7 +163,8 @@ func parseTags(stringtable stringTable, keys []uint32, vals []uint32) map[string
 func readNodes(
 	nodes []*osmpbf.Node,
 	block *osmpbf.PrimitiveBlock,
-	stringtable stringTable) ([]element.Node, []element.Node) {
+	stringtable stringTable,
+	withMetadata bool) ([]element.Node, []element.Node) {
 
 	coords := make([]element.Node, len(nodes))
 	nds := make([]element.Node, 0, len(nodes)/8)
@@ -118,11 +180,16 @@ func readNodes(
 		coords[i].Id = id
 		coords[i].Long = (coordScale * float64(lonOffset+(granularity*lon)))
 		coords[i].Lat = (coordScale * float64(latOffset+(granularity*lat)))
+		if withMetadata {
+			coords[i].Metadata = readMetadata(nodes[i].GetInfo(), block)
+		}
 		if stringtable != nil {
 			tags := parseTags(stringtable, nodes[i].Keys, nodes[i].Vals)
 			if tags != nil {
 				if _, ok := tags["created_by"]; ok && len(tags) == 1 {
 					// don't add nodes with only created_by tag to nodes cache
+				} else if withMetadata {
+					coords[i].Tags = tags
 				} else {
 					nd := coords[i]
 					nd.Tags = tags
@@ -148,7 +215,8 @@ func parseDeltaRefs(refs []int64) []int64 {
 func readWays(
 	ways []*osmpbf.Way,
 	block *osmpbf.PrimitiveBlock,
-	stringtable stringTable) []element.Way {
+	stringtable stringTable,
+	withMetadata bool) []element.Way {
 
 	result := make([]element.Way, len(ways))
 
@@ -157,10 +225,36 @@ func readWays(
 		result[i].Id = id
 		result[i].Tags = parseTags(stringtable, ways[i].Keys, ways[i].Vals)
 		result[i].Refs = parseDeltaRefs(ways[i].Refs)
+		if len(ways[i].Lat) > 0 && len(ways[i].Lat) == len(ways[i].Refs) && len(ways[i].Lon) == len(ways[i].Refs) {
+			result[i].Nodes = readWayLocations(ways[i], result[i].Refs, block)
+		}
+		if withMetadata {
+			result[i].Metadata = readMetadata(ways[i].GetInfo(), block)
+		}
 	}
 	return result
 }
 
+// readWayLocations returns the nodes from the embedded locations of
+// a way (LocationsOnWays feature).
+func readWayLocations(way *osmpbf.Way, refs []int64, block *osmpbf.PrimitiveBlock) []element.Node {
+	granularity := int64(block.GetGranularity())
+	latOffset := block.GetLatOffset()
+	lonOffset := block.GetLonOffset()
+	coordScale := 0.000000001
+
+	nodes := make([]element.Node, len(refs))
+	var lastLon, lastLat int64
+	for i := range refs {
+		lastLon += way.Lon[i]
+		lastLat += way.Lat[i]
+		nodes[i].Id = refs[i]
+		nodes[i].Long = (coordScale * float64(lonOffset+(granularity*lastLon)))
+		nodes[i].Lat = (coordScale * float64(latOffset+(granularity*lastLat)))
+	}
+	return nodes
+}
+
 func parseRelationMembers(rel *osmpbf.Relation, stringtable stringTable) []element.Member {
 	result := make([]element.Member, len(rel.Memids))
 
@@ -177,7 +271,8 @@ func parseRelationMembers(rel *osmpbf.Relation, stringtable stringTable) []eleme
 func readRelations(
 	relations []*osmpbf.Relation,
 	block *osmpbf.PrimitiveBlock,
-	stringtable stringTable) []element.Relation {
+	stringtable stringTable,
+	withMetadata bool) []element.Relation {
 
 	result := make([]element.Relation, len(relations))
 
@@ -186,6 +281,9 @@ func readRelations(
 		result[i].Id = id
 		result[i].Tags = parseTags(stringtable, relations[i].Keys, relations[i].Vals)
 		result[i].Members = parseRelationMembers(relations[i], stringtable)
+		if withMetadata {
+			result[i].Metadata = readMetadata(relations[i].GetInfo(), block)
+		}
 	}
 	return result
 }
@@ -216,7 +314,7 @@ func (pos *Block) Parse(coords chan []element.Node, nodes chan []element.Node, w
 	for _, group := range block.Primitivegroup {
 		dense := group.GetDense()
 		if dense != nil {
-			parsedCoords, parsedNodes := readDenseNodes(dense, block, stringtable)
+			parsedCoords, parsedNodes := readDenseNodes(dense, block, stringtable, false)
 			if len(parsedCoords) > 0 {
 				coords <- parsedCoords
 			}
@@ -224,18 +322,18 @@ func (pos *Block) Parse(coords chan []element.Node, nodes chan []element.Node, w
 				nodes <- parsedNodes
 			}
 		}
-		parsedCoords, parsedNodes := readNodes(group.Nodes, block, stringtable)
+		parsedCoords, parsedNodes := readNodes(group.Nodes, block, stringtable, false)
 		if len(parsedCoords) > 0 {
 			coords <- parsedCoords
 		}
 		if len(parsedNodes) > 0 {
 			nodes <- parsedNodes
 		}
-		parsedWays := readWays(group.Ways, block, stringtable)
+		parsedWays := readWays(group.Ways, block, stringtable, false)
 		if len(parsedWays) > 0 {
 			ways <- parsedWays
 		}
-		parsedRelations := readRelations(group.Relations, block, stringtable)
+		parsedRelations := readRelations(group.Relations, block, stringtable, false)
 		if len(parsedRelations) > 0 {
 			relations <- parsedRelations
 		}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"github.com/golang/protobuf/proto"
 	"compress/zlib"
-	"fmt"
 	"github.com/olehz/imposm3/parser/pbf/osmpbf"
 	"io"
 	"log"
+	"math"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/olehz/imposm3/element"
 )
 
 func BenchmarkHello(b *testing.B) {
@@ -20,7 +23,7 @@ func BenchmarkHello(b *testing.B) {
 	}
 
 	for pos := range pbf.BlockPositions() {
-		fmt.Println(pos.size, pos.offset)
+		b.Log(pos.size, pos.offset)
 		b.StartTimer()
 		for i := 0; i < b.N; i++ {
 			readPrimitiveBlock(pos)
@@ -77,3 +80,60 @@ func BenchmarkPrimitiveBlock(b *testing.B) {
 		}
 	}
 }
+
+func TestReadDenseMetadata(t *testing.T) {
+	block := &osmpbf.PrimitiveBlock{DateGranularity: proto.Int32(1000)}
+	info := &osmpbf.DenseInfo{
+		Version:   []int32{1, 4, 2},
+		Timestamp: []int64{1420070400, 60, -120},
+		Visible:   []bool{true, false, true},
+	}
+	metadata := readDenseMetadata(info, block, 3)
+	for i, expected := range []element.Metadata{
+		{Version: 1, Timestamp: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), Visible: true},
+		{Version: 4, Timestamp: time.Date(2015, 1, 1, 0, 1, 0, 0, time.UTC), Visible: false},
+		{Version: 2, Timestamp: time.Date(2014, 12, 31, 23, 59, 0, 0, time.UTC), Visible: true},
+	} {
+		if metadata[i] != expected {
+			t.Errorf("unexpected metadata %v != %v", metadata[i], expected)
+		}
+	}
+
+	// files without history are visible
+	metadata = readDenseMetadata(&osmpbf.DenseInfo{Version: []int32{1}}, block, 1)
+	if !metadata[0].Visible || metadata[0].Version != 1 {
+		t.Errorf("unexpected metadata %v", metadata[0])
+	}
+}
+
+func TestReadWaysLocations(t *testing.T) {
+	block := &osmpbf.PrimitiveBlock{}
+	ways := []*osmpbf.Way{
+		{
+			Id:   proto.Int64(1),
+			Refs: []int64{10, 1, 1},
+			Lat:  []int64{530000000, 1000000, -2000000},
+			Lon:  []int64{100000000, 500000, 0},
+		},
+		// without locations
+		{Id: proto.Int64(2), Refs: []int64{10, 1}},
+	}
+	result := readWays(ways, block, stringTable{""}, false)
+
+	if len(result[0].Nodes) != 3 {
+		t.Fatalf("unexpected nodes %v", result[0].Nodes)
+	}
+	for i, expected := range []element.Node{
+		{OSMElem: element.OSMElem{Id: 10}, Long: 10.0, Lat: 53.0},
+		{OSMElem: element.OSMElem{Id: 11}, Long: 10.05, Lat: 53.1},
+		{OSMElem: element.OSMElem{Id: 12}, Long: 10.05, Lat: 52.9},
+	} {
+		nd := result[0].Nodes[i]
+		if nd.Id != expected.Id || math.Abs(nd.Long-expected.Long) > 1e-9 || math.Abs(nd.Lat-expected.Lat) > 1e-9 {
+			t.Errorf("unexpected node %v != %v", nd, expected)
+		}
+	}
+	if result[1].Nodes != nil {
+		t.Errorf("unexpected nodes %v", result[1].Nodes)
+	}
+}
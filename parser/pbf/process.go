@@ -17,6 +17,7 @@ type parser struct {
 	wg        sync.WaitGroup
 	waySync   *util.SyncPoint
 	relSync   *util.SyncPoint
+	metadata  bool
 }
 
 func NewParser(pbf *Pbf, coords chan []element.Node, nodes chan []element.Node, ways chan []element.Way, relations chan []element.Relation) *parser {
@@ -54,6 +55,11 @@ func (p *parser) Close() {
 	p.wg.Wait()
 }
 
+// ParseMetadata enables the parsing of the element metadata.
+func (p *parser) ParseMetadata() {
+	p.metadata = true
+}
+
 func (p *parser) NotifyWays(cb func()) {
 	p.waySync = util.NewSyncPoint(p.nParser, cb)
 }
@@ -69,7 +75,7 @@ func (p *parser) parseBlock(pos Block) {
 	for _, group := range block.Primitivegroup {
 		dense := group.GetDense()
 		if dense != nil {
-			parsedCoords, parsedNodes := readDenseNodes(dense, block, stringtable)
+			parsedCoords, parsedNodes := readDenseNodes(dense, block, stringtable, p.metadata)
 			if len(parsedCoords) > 0 {
 				p.coords <- parsedCoords
 			}
@@ -77,21 +83,21 @@ func (p *parser) parseBlock(pos Block) {
 				p.nodes <- parsedNodes
 			}
 		}
-		parsedCoords, parsedNodes := readNodes(group.Nodes, block, stringtable)
+		parsedCoords, parsedNodes := readNodes(group.Nodes, block, stringtable, p.metadata)
 		if len(parsedCoords) > 0 {
 			p.coords <- parsedCoords
 		}
 		if len(parsedNodes) > 0 {
 			p.nodes <- parsedNodes
 		}
-		parsedWays := readWays(group.Ways, block, stringtable)
+		parsedWays := readWays(group.Ways, block, stringtable, p.metadata)
 		if len(parsedWays) > 0 {
 			if p.waySync != nil {
 				p.waySync.Sync()
 			}
 			p.ways <- parsedWays
 		}
-		parsedRelations := readRelations(group.Relations, block, stringtable)
+		parsedRelations := readRelations(group.Relations, block, stringtable, p.metadata)
 		if len(parsedRelations) > 0 {
 			if p.waySync != nil {
 				p.waySync.Sync()
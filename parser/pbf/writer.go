@@ -0,0 +1,313 @@
+package pbf
+
+import (
+	"bytes"
+	"compress/zlib"
+	structs "encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/olehz/imposm3/element"
+	"github.com/olehz/imposm3/parser/pbf/osmpbf"
+)
+
+// maxBlockElements is the max number of elements of each written
+// primitive block. The spec recommends 8000 elements.
+const maxBlockElements = 8000
+
+// granularity of the written coordinates in nanodegrees
+const writeGranularity = 100
+
+// Writer writes nodes, ways and relations into a PBF file. The elements
+// need to be written sorted by type and id: all nodes before all ways
+// and all ways before all relations.
+type Writer struct {
+	w       io.Writer
+	header  *osmpbf.HeaderBlock
+	written bool
+	// type and id of the last written element, to check the order
+	// across flushed blocks
+	started   bool
+	lastType  element.MemberType
+	lastId    int64
+	nodes     []element.Node
+	ways      []element.Way
+	relations []element.Relation
+}
+
+// NewWriter returns a Writer for w. bbox is the minx, miny, maxx, maxy
+// in EPSG:4326 for the file header and is optional.
+func NewWriter(w io.Writer, bbox []float64) *Writer {
+	header := &osmpbf.HeaderBlock{
+		RequiredFeatures: []string{"OsmSchema-V0.6", "DenseNodes"},
+		OptionalFeatures: []string{"Sort.Type_then_ID"},
+		Writingprogram:   proto.String("imposm3"),
+	}
+	if len(bbox) == 4 {
+		header.Bbox = &osmpbf.HeaderBBox{
+			Left:   proto.Int64(int64(bbox[0] * 1e9)),
+			Bottom: proto.Int64(int64(bbox[1] * 1e9)),
+			Right:  proto.Int64(int64(bbox[2] * 1e9)),
+			Top:    proto.Int64(int64(bbox[3] * 1e9)),
+		}
+	}
+	return &Writer{w: w, header: header}
+}
+
+func (w *Writer) WriteNode(nd *element.Node) error {
+	if err := w.checkOrder(element.NODE, nd.Id); err != nil {
+		return err
+	}
+	w.nodes = append(w.nodes, *nd)
+	if len(w.nodes) >= maxBlockElements {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *Writer) WriteWay(way *element.Way) error {
+	if err := w.checkOrder(element.WAY, way.Id); err != nil {
+		return err
+	}
+	if len(w.nodes) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	w.ways = append(w.ways, *way)
+	if len(w.ways) >= maxBlockElements {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *Writer) WriteRelation(rel *element.Relation) error {
+	if err := w.checkOrder(element.RELATION, rel.Id); err != nil {
+		return err
+	}
+	if len(w.nodes) > 0 || len(w.ways) > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	w.relations = append(w.relations, *rel)
+	if len(w.relations) >= maxBlockElements {
+		return w.flush()
+	}
+	return nil
+}
+
+// checkOrder returns an error if the element is not sorted after the
+// last written element.
+func (w *Writer) checkOrder(typ element.MemberType, id int64) error {
+	if w.started {
+		if typ < w.lastType {
+			switch typ {
+			case element.NODE:
+				return errors.New("nodes need to be written before ways and relations")
+			default:
+				return errors.New("ways need to be written before relations")
+			}
+		}
+		if typ == w.lastType && id <= w.lastId {
+			return fmt.Errorf("elements need to be sorted by id, %d written after %d", id, w.lastId)
+		}
+	}
+	w.started = true
+	w.lastType = typ
+	w.lastId = id
+	return nil
+}
+
+// Close writes all pending elements. It does not close the
+// underlying io.Writer.
+func (w *Writer) Close() error {
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	if !w.written {
+		if err := w.writeBlob("OSMHeader", w.header); err != nil {
+			return err
+		}
+		w.written = true
+	}
+	if len(w.nodes) == 0 && len(w.ways) == 0 && len(w.relations) == 0 {
+		return nil
+	}
+
+	st := newStringTableBuilder()
+	group := &osmpbf.PrimitiveGroup{}
+	switch {
+	case len(w.nodes) > 0:
+		group.Dense = denseNodes(w.nodes, st)
+	case len(w.ways) > 0:
+		group.Ways = pbfWays(w.ways, st)
+	default:
+		group.Relations = pbfRelations(w.relations, st)
+	}
+	block := &osmpbf.PrimitiveBlock{
+		Stringtable:    &osmpbf.StringTable{S: st.strings},
+		Primitivegroup: []*osmpbf.PrimitiveGroup{group},
+		Granularity:    proto.Int32(writeGranularity),
+	}
+	w.nodes = w.nodes[:0]
+	w.ways = w.ways[:0]
+	w.relations = w.relations[:0]
+	return w.writeBlob("OSMData", block)
+}
+
+// writeBlob writes msg as zlib compressed blob with the blob header.
+func (w *Writer) writeBlob(blobType string, msg proto.Message) error {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	if _, err := zw.Write(raw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	blob, err := proto.Marshal(&osmpbf.Blob{
+		RawSize:  proto.Int32(int32(len(raw))),
+		ZlibData: buf.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+	header, err := proto.Marshal(&osmpbf.BlobHeader{
+		Type:     proto.String(blobType),
+		Datasize: proto.Int32(int32(len(blob))),
+	})
+	if err != nil {
+		return err
+	}
+	if err := structs.Write(w.w, structs.BigEndian, int32(len(header))); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.w.Write(blob)
+	return err
+}
+
+// stringTableBuilder collects all strings of a block. The first
+// string is always empty, as index 0 is the delimiter of dense tags.
+type stringTableBuilder struct {
+	strings [][]byte
+	index   map[string]int
+}
+
+func newStringTableBuilder() *stringTableBuilder {
+	return &stringTableBuilder{
+		strings: [][]byte{[]byte("")},
+		index:   map[string]int{"": 0},
+	}
+}
+
+func (st *stringTableBuilder) add(s string) int {
+	if idx, ok := st.index[s]; ok {
+		return idx
+	}
+	idx := len(st.strings)
+	st.strings = append(st.strings, []byte(s))
+	st.index[s] = idx
+	return idx
+}
+
+// sortedKeys returns the keys of tags in a stable order.
+func sortedKeys(tags element.Tags) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func pbfCoord(coord float64) int64 {
+	return int64(math.Floor(coord*1e9/writeGranularity + 0.5))
+}
+
+func denseNodes(nodes []element.Node, st *stringTableBuilder) *osmpbf.DenseNodes {
+	dense := &osmpbf.DenseNodes{
+		Id:  make([]int64, len(nodes)),
+		Lat: make([]int64, len(nodes)),
+		Lon: make([]int64, len(nodes)),
+	}
+	withTags := false
+	for i := range nodes {
+		if len(nodes[i].Tags) > 0 {
+			withTags = true
+			break
+		}
+	}
+
+	var lastId, lastLat, lastLon int64
+	for i, nd := range nodes {
+		lat, lon := pbfCoord(nd.Lat), pbfCoord(nd.Long)
+		dense.Id[i] = nd.Id - lastId
+		dense.Lat[i] = lat - lastLat
+		dense.Lon[i] = lon - lastLon
+		lastId, lastLat, lastLon = nd.Id, lat, lon
+		if withTags {
+			for _, k := range sortedKeys(nd.Tags) {
+				dense.KeysVals = append(dense.KeysVals, int32(st.add(k)), int32(st.add(nd.Tags[k])))
+			}
+			dense.KeysVals = append(dense.KeysVals, 0)
+		}
+	}
+	return dense
+}
+
+func pbfTags(tags element.Tags, st *stringTableBuilder) (keys, vals []uint32) {
+	for _, k := range sortedKeys(tags) {
+		keys = append(keys, uint32(st.add(k)))
+		vals = append(vals, uint32(st.add(tags[k])))
+	}
+	return keys, vals
+}
+
+func pbfWays(ways []element.Way, st *stringTableBuilder) []*osmpbf.Way {
+	result := make([]*osmpbf.Way, len(ways))
+	for i, way := range ways {
+		pw := &osmpbf.Way{Id: proto.Int64(way.Id)}
+		pw.Keys, pw.Vals = pbfTags(way.Tags, st)
+		pw.Refs = make([]int64, len(way.Refs))
+		var lastRef int64
+		for j, ref := range way.Refs {
+			pw.Refs[j] = ref - lastRef
+			lastRef = ref
+		}
+		result[i] = pw
+	}
+	return result
+}
+
+func pbfRelations(rels []element.Relation, st *stringTableBuilder) []*osmpbf.Relation {
+	result := make([]*osmpbf.Relation, len(rels))
+	for i, rel := range rels {
+		pr := &osmpbf.Relation{Id: proto.Int64(rel.Id)}
+		pr.Keys, pr.Vals = pbfTags(rel.Tags, st)
+		pr.RolesSid = make([]int32, len(rel.Members))
+		pr.Memids = make([]int64, len(rel.Members))
+		pr.Types = make([]osmpbf.Relation_MemberType, len(rel.Members))
+		var lastId int64
+		for j, m := range rel.Members {
+			pr.RolesSid[j] = int32(st.add(m.Role))
+			pr.Memids[j] = m.Id - lastId
+			lastId = m.Id
+			pr.Types[j] = osmpbf.Relation_MemberType(m.Type)
+		}
+		result[i] = pr
+	}
+	return result
+}
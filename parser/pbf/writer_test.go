@@ -0,0 +1,120 @@
+package pbf
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/olehz/imposm3/element"
+)
+
+func TestWriter(t *testing.T) {
+	f, err := ioutil.TempFile("", "imposm3_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	w := NewWriter(f, []float64{9.5, 53.0, 10.5, 54.0})
+	for i := int64(1); i <= maxBlockElements+1; i++ {
+		nd := &element.Node{OSMElem: element.OSMElem{Id: i}, Long: 10.0 + float64(i)*1e-5, Lat: 53.5}
+		if i == 2 {
+			nd.Tags = element.Tags{"amenity": "cafe", "name": "Café"}
+		}
+		if err := w.WriteNode(nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.WriteWay(&element.Way{OSMElem: element.OSMElem{Id: 5, Tags: element.Tags{"highway": "primary"}}, Refs: []int64{3, 1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRelation(&element.Relation{
+		OSMElem: element.OSMElem{Id: 7, Tags: element.Tags{"type": "multipolygon"}},
+		Members: []element.Member{{Id: 5, Type: element.WAY, Role: "outer"}, {Id: 2, Type: element.NODE, Role: ""}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteNode(&element.Node{}); err == nil {
+		t.Error("expected error for unsorted node")
+	}
+	if err := w.WriteRelation(&element.Relation{OSMElem: element.OSMElem{Id: 7}}); err == nil {
+		t.Error("expected error for duplicate relation")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	pbf, err := Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bbox := pbf.Header.Bbox; len(bbox) != 4 || math.Abs(bbox[0]-9.5) > 1e-9 || math.Abs(bbox[3]-54.0) > 1e-9 {
+		t.Errorf("unexpected bbox %v", bbox)
+	}
+
+	var nodes []element.Node
+	var ways []element.Way
+	var rels []element.Relation
+	for pos := range pbf.BlockPositions() {
+		block := readPrimitiveBlock(pos)
+		stringtable := newStringTable(block.GetStringtable())
+		for _, group := range block.Primitivegroup {
+			if dense := group.GetDense(); dense != nil {
+				coords, nds := readDenseNodes(dense, block, stringtable, false)
+				nodes = append(nodes, coords...)
+				for _, nd := range nds {
+					if nd.Id != 2 || nd.Tags["name"] != "Café" || len(nd.Tags) != 2 {
+						t.Errorf("unexpected tagged node %v", nd)
+					}
+				}
+			}
+			ways = append(ways, readWays(group.Ways, block, stringtable, false)...)
+			rels = append(rels, readRelations(group.Relations, block, stringtable, false)...)
+		}
+	}
+
+	if len(nodes) != maxBlockElements+1 {
+		t.Fatalf("unexpected number of nodes %d", len(nodes))
+	}
+	for i, nd := range nodes {
+		if nd.Id != int64(i+1) || math.Abs(nd.Long-(10.0+float64(i+1)*1e-5)) > 1e-7 || math.Abs(nd.Lat-53.5) > 1e-7 {
+			t.Fatalf("unexpected node %v", nd)
+		}
+	}
+	if len(ways) != 1 || ways[0].Id != 5 || ways[0].Tags["highway"] != "primary" ||
+		len(ways[0].Refs) != 3 || ways[0].Refs[0] != 3 || ways[0].Refs[2] != 2 {
+		t.Errorf("unexpected ways %v", ways)
+	}
+	if len(rels) != 1 || rels[0].Id != 7 || len(rels[0].Members) != 2 ||
+		rels[0].Members[0].Role != "outer" || rels[0].Members[1].Type != element.NODE || rels[0].Members[1].Id != 2 {
+		t.Errorf("unexpected relations %v", rels)
+	}
+}
+
+func TestWriterOrderAfterFlush(t *testing.T) {
+	w := NewWriter(ioutil.Discard, nil)
+	for i := int64(1); i <= maxBlockElements; i++ {
+		if err := w.WriteNode(&element.Node{OSMElem: element.OSMElem{Id: i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the nodes are already flushed
+	if err := w.WriteNode(&element.Node{OSMElem: element.OSMElem{Id: 1}}); err == nil {
+		t.Error("expected error for unsorted node")
+	}
+	if err := w.WriteWay(&element.Way{OSMElem: element.OSMElem{Id: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRelation(&element.Relation{OSMElem: element.OSMElem{Id: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	// the ways are already flushed
+	if err := w.WriteWay(&element.Way{OSMElem: element.OSMElem{Id: 2}}); err == nil {
+		t.Error("expected error for way after relation")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
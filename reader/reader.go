@@ -52,9 +52,13 @@ func readersForCpus(cpus int) (int64, int64, int64, int64, int64) {
 	return int64(math.Ceil(cpuf * 0.75)), int64(math.Ceil(cpuf * 0.25)), int64(math.Ceil(cpuf * 0.25)), int64(math.Ceil(cpuf * 0.25)), int64(math.Ceil(cpuf * 0.25))
 }
 
+// ReadPbf reads all elements from pbfFile into the cache. Elements that
+// are not newer than the elements from previously read files, or from
+// the same history file, are skipped if versions is not nil. versions is
+// required for history files.
 func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 	tagmapping *mapping.Mapping, pbfFile *pbf.Pbf,
-	limiter *limit.Limiter,
+	limiter *limit.Limiter, versions *osmcache.VersionsCache,
 ) {
 	nodes := make(chan []element.Node, 4)
 	coords := make(chan []element.Node, 4)
@@ -70,7 +74,17 @@ func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 		log.Printf("reading %s with data till %v", pbfFile.Filename, pbfFile.Header.Time.Local())
 	}
 
+	nWays, nRels, nNodes, nCoords := nWays, nRels, nNodes, nCoords
+	if pbfFile.Header.History {
+		// all versions of an element need to be handled by the same
+		// worker, as the versions can be in different blocks
+		nWays, nRels, nNodes, nCoords = 1, 1, 1, 1
+	}
+
 	parser := pbf.NewParser(pbfFile, coords, nodes, ways, relations)
+	if versions != nil {
+		parser.ParseMetadata()
+	}
 
 	coordsSynced := make(chan bool)
 	coordsSync := util.NewSyncPoint(int(nCoords+nNodes), func() {
@@ -101,10 +115,15 @@ func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 
 	waitWriter := sync.WaitGroup{}
 
+	// coords are not required if all ways contain the node locations
+	storeCoords := !cache.Ways.StoresLocations()
+
 	for i := 0; int64(i) < nWays; i++ {
 		waitWriter.Add(1)
 		go func() {
 			var skip, hit int
+			g := geos.NewGeos()
+			defer g.Finish()
 
 			m := tagmapping.WayTagFilter()
 			for ws := range ways {
@@ -117,7 +136,16 @@ func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 				}
 				for i, _ := range ws {
 					m.Filter(&ws[i].Tags)
-					if withLimiter {
+					if withLimiter && len(ws[i].Nodes) > 0 {
+						nd := element.Node{Long: ws[i].Nodes[0].Long, Lat: ws[i].Nodes[0].Lat}
+						proj.NodeToMerc(&nd)
+						if !limiter.IntersectsBuffer(g, nd.Long, nd.Lat) {
+							ws[i].Id = osmcache.SKIP
+							skip += 1
+						} else {
+							hit += 1
+						}
+					} else if withLimiter {
 						if !cache.Coords.FirstRefIsCached(ws[i].Refs) {
 							ws[i].Id = osmcache.SKIP
 							skip += 1
@@ -127,6 +155,15 @@ func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 						}
 					}
 				}
+				if versions != nil {
+					replaced, err := versions.SkipOlderWays(ws)
+					if err != nil {
+						log.Fatal(err)
+					}
+					for _, id := range replaced {
+						cache.Ways.DeleteWay(id)
+					}
+				}
 				cache.Ways.PutWays(ws)
 				progress.AddWays(len(ws))
 			}
@@ -159,6 +196,15 @@ func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 						}
 					}
 				}
+				if versions != nil {
+					replaced, err := versions.SkipOlderRelations(rels)
+					if err != nil {
+						log.Fatal(err)
+					}
+					for _, id := range replaced {
+						cache.Relations.DeleteRelation(id)
+					}
+				}
 				cache.Relations.PutRelations(rels)
 				progress.AddRelations(numWithTags)
 			}
@@ -173,6 +219,7 @@ func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 			var skip, hit int
 			g := geos.NewGeos()
 			defer g.Finish()
+			m := tagmapping.NodeTagFilter()
 			for nds := range coords {
 				if nds == nil {
 					coordsSync.Sync()
@@ -190,7 +237,33 @@ func ReadPbf(cache *osmcache.OSMCache, progress *stats.Statistics,
 						}
 					}
 				}
-				cache.Coords.PutCoords(nds)
+				if versions != nil {
+					replaced, err := versions.SkipOlderCoords(nds)
+					if err != nil {
+						log.Fatal(err)
+					}
+					for _, id := range replaced {
+						cache.Coords.DeleteCoord(id)
+						cache.Nodes.DeleteNode(id)
+					}
+					// the parser returns tagged nodes as coords if
+					// it reads the metadata
+					numWithTags := 0
+					for i, _ := range nds {
+						if len(nds[i].Tags) == 0 {
+							continue
+						}
+						m.Filter(&nds[i].Tags)
+						if len(nds[i].Tags) > 0 {
+							numWithTags += 1
+						}
+					}
+					cache.Nodes.PutNodes(nds)
+					progress.AddNodes(numWithTags)
+				}
+				if storeCoords {
+					cache.Coords.PutCoords(nds)
+				}
 				progress.AddCoords(len(nds))
 			}
 			waitWriter.Done()
@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is a counter or gauge in the Prometheus text format. Each
+// metric has a value for each combination of label values.
+type Metric struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// registry contains metrics and collectors. The package functions use
+// defaultRegistry.
+type registry struct {
+	mu         sync.Mutex
+	metrics    map[string]*Metric
+	collectors []func()
+}
+
+func newRegistry() *registry {
+	return &registry{metrics: make(map[string]*Metric)}
+}
+
+var defaultRegistry = newRegistry()
+
+func (r *registry) newMetric(typ, name, help string, labels []string) *Metric {
+	m := &Metric{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("duplicate metric " + name)
+	}
+	r.metrics[name] = m
+	return m
+}
+
+func (r *registry) addCollector(f func()) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, f)
+	r.mu.Unlock()
+}
+
+// NewCounterMetric registers a new counter with the label names.
+func NewCounterMetric(name, help string, labels ...string) *Metric {
+	return defaultRegistry.newMetric("counter", name, help, labels)
+}
+
+// NewGaugeMetric registers a new gauge with the label names.
+func NewGaugeMetric(name, help string, labels ...string) *Metric {
+	return defaultRegistry.newMetric("gauge", name, help, labels)
+}
+
+// AddMetricsCollector adds a function that is called before the metrics
+// are written, e.g. to set metrics that are computed from other values.
+func AddMetricsCollector(f func()) {
+	defaultRegistry.addCollector(f)
+}
+
+// labelKey returns the formatted labels for the label values.
+func (m *Metric) labelKey(values []string) string {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s requires %d label values", m.name, len(m.labels)))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = m.labels[i] + `="` + escapeLabelValue(v) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+// Set sets the value for the label values.
+func (m *Metric) Set(value float64, labelValues ...string) {
+	key := m.labelKey(labelValues)
+	m.mu.Lock()
+	m.values[key] = value
+	m.mu.Unlock()
+}
+
+// Add adds value to the value for the label values.
+func (m *Metric) Add(value float64, labelValues ...string) {
+	key := m.labelKey(labelValues)
+	m.mu.Lock()
+	m.values[key] += value
+	m.mu.Unlock()
+}
+
+func (m *Metric) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.values) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, key, strconv.FormatFloat(m.values[key], 'g', -1, 64))
+	}
+}
+
+// WriteMetrics writes all metrics with values in the Prometheus text
+// format.
+func WriteMetrics(w io.Writer) error {
+	return defaultRegistry.write(w)
+}
+
+func (r *registry) write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.collectors {
+		f()
+	}
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		r.metrics[name].write(buf)
+	}
+	return buf.Flush()
+}
+
+// StartHttpMetrics serves all metrics on /metrics.
+func StartHttpMetrics(bind string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+	go func() {
+		log.Println(http.ListenAndServe(bind, mux))
+	}()
+}
@@ -0,0 +1,39 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	// private registry, the metrics of the default registry can only
+	// be registered once
+	r := newRegistry()
+	counter := r.newMetric("counter", "test_rows_total", "Test rows.", []string{"table"})
+	counter.Add(2, `osm_"roads"`)
+	counter.Add(1, `osm_"roads"`)
+	counter.Add(5, "osm_buildings")
+	gauge := r.newMetric("gauge", "test_ratio", "Test ratio.", nil)
+	r.addCollector(func() { gauge.Set(0.5) })
+	r.newMetric("gauge", "test_unset", "Not written without values.", nil)
+
+	buf := &bytes.Buffer{}
+	if err := r.write(buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_ratio Test ratio.
+# TYPE test_ratio gauge
+test_ratio 0.5
+# HELP test_rows_total Test rows.
+# TYPE test_rows_total counter
+test_rows_total{table="osm_\"roads\""} 3
+test_rows_total{table="osm_buildings"} 5
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected metrics\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "test_unset") {
+		t.Errorf("unexpected metric without values\n%s", buf.String())
+	}
+}
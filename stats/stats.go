@@ -3,6 +3,7 @@ package stats
 import (
 	"fmt"
 	"github.com/olehz/imposm3/logging"
+	"sync/atomic"
 	"time"
 )
 
@@ -71,15 +72,62 @@ const (
 	QUIT
 )
 
-func (s *Statistics) AddCoords(n int)    { s.counter.Coords.Add(n) }
-func (s *Statistics) AddNodes(n int)     { s.counter.Nodes.Add(n) }
-func (s *Statistics) AddWays(n int)      { s.counter.Ways.Add(n) }
-func (s *Statistics) AddRelations(n int) { s.counter.Relations.Add(n) }
+var (
+	elementsTotal = NewCounterMetric("imposm3_elements_total",
+		"Number of processed elements.", "type")
+	elementsRate = NewGaugeMetric("imposm3_elements_per_second",
+		"Current rate of processed elements.", "type")
+)
+
+// processed elements of all reporters, elementsTotal is set from these
+// counts when the metrics are written
+var processedCoords, processedNodes, processedWays, processedRelations int64
+
+func init() {
+	AddMetricsCollector(func() {
+		elementsTotal.Set(float64(atomic.LoadInt64(&processedCoords)), "coords")
+		elementsTotal.Set(float64(atomic.LoadInt64(&processedNodes)), "nodes")
+		elementsTotal.Set(float64(atomic.LoadInt64(&processedWays)), "ways")
+		elementsTotal.Set(float64(atomic.LoadInt64(&processedRelations)), "relations")
+	})
+}
+
+func (s *Statistics) AddCoords(n int) {
+	s.counter.Coords.Add(n)
+	atomic.AddInt64(&processedCoords, int64(n))
+}
+func (s *Statistics) AddNodes(n int) {
+	s.counter.Nodes.Add(n)
+	atomic.AddInt64(&processedNodes, int64(n))
+}
+func (s *Statistics) AddWays(n int) {
+	s.counter.Ways.Add(n)
+	atomic.AddInt64(&processedWays, int64(n))
+}
+func (s *Statistics) AddRelations(n int) {
+	s.counter.Relations.Add(n)
+	atomic.AddInt64(&processedRelations, int64(n))
+}
 func (s *Statistics) Stop() *ElementCounts {
 	s.done <- true
 	return s.counter.CurrentCount()
 }
 
+// setRates sets the elements rate metrics to the rates since the last
+// tick.
+func (c *Counter) setRates() {
+	elementsRate.Set(c.Coords.LastRps(), "coords")
+	elementsRate.Set(c.Nodes.LastRps(), "nodes")
+	elementsRate.Set(c.Ways.LastRps(), "ways")
+	elementsRate.Set(c.Relations.LastRps(), "relations")
+}
+
+func resetRates() {
+	for _, typ := range []string{"coords", "nodes", "ways", "relations"} {
+		elementsRate.Set(0, typ)
+	}
+}
+
 func NewStatsReporter() *Statistics {
 	s := Statistics{}
 	s.counter = NewCounter()
@@ -111,11 +159,13 @@ func (s *Statistics) loop() {
 			tick.Stop()
 			tock.Stop()
 			s.counter.PrintStats()
+			resetRates()
 			return
 		case <-tock.C:
 			s.counter.PrintStats()
 		case <-tick.C:
 			s.counter.PrintTick()
+			s.counter.setRates()
 			s.counter.Tick()
 		}
 	}
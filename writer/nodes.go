@@ -54,33 +54,31 @@ func (nw *NodeWriter) loop() {
 			}
 			point, err := geom.Point(geos, *n)
 			if err != nil {
-				if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-					log.Warn(err)
-				}
+				nw.logGeomError(err)
 				continue
 			}
 
 			n.Geom, err = geom.AsGeomElement(geos, point)
 			if err != nil {
-				log.Warn(err)
+				nw.logError(err)
 				continue
 			}
 
 			if nw.limiter != nil {
 				parts, err := nw.limiter.Clip(n.Geom.Geom)
 				if err != nil {
-					log.Warn(err)
+					nw.logError(err)
 					continue
 				}
 				if len(parts) >= 1 {
 					if err := nw.inserter.InsertPoint(n.OSMElem, matches); err != nil {
-						log.Warn(err)
+						nw.logError(err)
 						continue
 					}
 				}
 			} else {
 				if err := nw.inserter.InsertPoint(n.OSMElem, matches); err != nil {
-					log.Warn(err)
+					nw.logError(err)
 					continue
 				}
 			}
@@ -105,9 +105,7 @@ NextRel:
 				rel.Id = rw.relId(r.Id)
 				err := rw.inserter.InsertPoint(rel.OSMElem, matches)
 				if err != nil {
-					if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-						log.Warn(err)
-					}
+					rw.logGeomError(err)
 					continue
 				}
 			}
@@ -121,7 +119,7 @@ NextRel:
 		err := rw.osmCache.Ways.FillMembers(r.Members)
 		if err != nil {
 			if err != cache.NotFound {
-				log.Warn(err)
+				rw.logError(err)
 			}
 			continue NextRel
 		}
@@ -146,7 +144,7 @@ NextRel:
 			err := rw.osmCache.Coords.FillWay(m.Way)
 			if err != nil {
 				if err != cache.NotFound {
-					log.Warn(err)
+					rw.logError(err)
 				}
 				continue NextRel
 			}
@@ -167,9 +165,7 @@ NextRel:
 		// relation tags)
 		prepedRel, err := geom.PrepareRelation(r, rw.srid, rw.maxGap)
 		if err != nil {
-			if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-				log.Warn(err)
-			}
+			rw.logGeomError(err)
 			continue NextRel
 		}
 
@@ -185,9 +181,7 @@ NextRel:
 			if r.Geom != nil && r.Geom.Geom != nil {
 				geos.Destroy(r.Geom.Geom)
 			}
-			if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-				log.Warn(err)
-			}
+			rw.logGeomError(err)
 			continue NextRel
 		}
 
@@ -195,7 +189,7 @@ NextRel:
 			start := time.Now()
 			parts, err := rw.limiter.Clip(r.Geom.Geom)
 			if err != nil {
-				log.Warn(err)
+				rw.logError(err)
 				continue NextRel
 			}
 			if duration := time.Now().Sub(start); duration > time.Minute {
@@ -204,12 +198,10 @@ NextRel:
 			for _, g := range parts {
 				rel := element.Relation(*r)
 				rel.Id = rw.relId(r.Id)
-				rel.Geom = &element.Geometry{Geom: g, Wkb: geos.AsEwkbHex(g)}
+				rel.Geom = &element.Geometry{Geom: g, Wkb: geos.AsEwkb(g)}
 				err := rw.inserter.InsertPolygon(rel.OSMElem, matches)
 				if err != nil {
-					if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-						log.Warn(err)
-					}
+					rw.logGeomError(err)
 					continue
 				}
 			}
@@ -218,17 +210,17 @@ NextRel:
 			rel.Id = rw.relId(r.Id)
 			err := rw.inserter.InsertPolygon(rel.OSMElem, matches)
 			if err != nil {
-				if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-					log.Warn(err)
-				}
+				rw.logGeomError(err)
 				continue
 			}
 		}
 
-		for _, m := range mapping.SelectRelationPolygons(rw.polygonMatcher, r) {
-			err = rw.osmCache.InsertedWays.PutWay(m.Way)
-			if err != nil {
-				log.Warn(err)
+		if !rw.noCacheUpdates {
+			for _, m := range mapping.SelectRelationPolygons(rw.polygonMatcher, r) {
+				err = rw.osmCache.InsertedWays.PutWay(m.Way)
+				if err != nil {
+					rw.logError(err)
+				}
 			}
 		}
 		if rw.diffCache != nil {
@@ -74,7 +74,7 @@ func (ww *WayWriter) loop() {
 		}
 		insertedAsRelation, err := ww.osmCache.InsertedWays.IsInserted(w.Id)
 		if err != nil {
-			log.Warn(err)
+			ww.logError(err)
 			continue
 		}
 
@@ -90,9 +90,7 @@ func (ww *WayWriter) loop() {
 		if matches := ww.lineMatcher.MatchWay(w); len(matches) > 0 {
 			err := ww.buildAndInsert(geos, w, matches, false)
 			if err != nil {
-				if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-					log.Warn(err)
-				}
+				ww.logGeomError(err)
 				continue
 			}
 			inserted = true
@@ -102,9 +100,7 @@ func (ww *WayWriter) loop() {
 			if matches := ww.polygonMatcher.MatchWay(w); len(matches) > 0 {
 				err := ww.buildAndInsert(geos, w, matches, true)
 				if err != nil {
-					if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
-						log.Warn(err)
-					}
+					ww.logGeomError(err)
 					continue
 				}
 				inserted = true
@@ -148,7 +144,7 @@ func (ww *WayWriter) buildAndInsert(g *geos.Geos, w *element.Way, matches []mapp
 		}
 		for _, p := range parts {
 			way := element.Way(*w)
-			way.Geom = &element.Geometry{Geom: p, Wkb: g.AsEwkbHex(p)}
+			way.Geom = &element.Geometry{Geom: p, Wkb: g.AsEwkb(p)}
 			if isPolygon {
 				if err := ww.inserter.InsertPolygon(way.OSMElem, matches); err != nil {
 					return err
@@ -35,6 +35,10 @@ type OsmElemWriter struct {
 	srid       int
 	expireor   expire.Expireor
 	concurrent bool
+	// errorHandler receives all errors instead of the log, if set
+	errorHandler func(error)
+	// noCacheUpdates disables all changes of the caches
+	noCacheUpdates bool
 }
 
 func (writer *OsmElemWriter) SetLimiter(limiter *limit.Limiter) {
@@ -64,6 +68,38 @@ func (writer *OsmElemWriter) Wait() {
 	writer.wg.Wait()
 }
 
+// SetErrorHandler passes all errors of elements that are not inserted to
+// f instead of logging them.
+func (writer *OsmElemWriter) SetErrorHandler(f func(error)) {
+	writer.errorHandler = f
+}
+
+// DisableCacheUpdates disables all changes of the OSM cache, e.g. of the
+// InsertedWays. The diff cache is only updated if it is set.
+func (writer *OsmElemWriter) DisableCacheUpdates() {
+	writer.noCacheUpdates = true
+}
+
+func (writer *OsmElemWriter) logError(err error) {
+	if writer.errorHandler != nil {
+		writer.errorHandler(err)
+		return
+	}
+	log.Warn(err)
+}
+
+// logGeomError logs err, unless it is an expected error (with level 0,
+// e.g. for ways with too few nodes).
+func (writer *OsmElemWriter) logGeomError(err error) {
+	if writer.errorHandler != nil {
+		writer.errorHandler(err)
+		return
+	}
+	if errl, ok := err.(ErrorLevel); !ok || errl.Level() > 0 {
+		log.Warn(err)
+	}
+}
+
 func (writer *OsmElemWriter) NodesToSrid(nodes []element.Node) {
 	if writer.srid == 4326 {
 		return